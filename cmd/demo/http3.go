@@ -0,0 +1,38 @@
+//go:build http3
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
+
+	"trailer_header/trailerhttp"
+)
+
+// With the http3 tag the demo can run over HTTP/3 as well:
+//
+//	go run -tags http3 ./cmd/demo -h3
+func init() {
+	flag.BoolVar(useHTTP3, "h3", false, "serve and send over HTTP/3 (QUIC) on the UDP port of -addr; implies -tls")
+	serveHTTP3 = func(ctx context.Context, opts trailerhttp.ServerOptions, tlsConfig *tls.Config, addr string) func() error {
+		opts.Addr = addr
+		server := trailerhttp.NewHTTP3Server(opts, tlsConfig)
+		served := make(chan error, 1)
+		go func() {
+			logger.Info("Server starting HTTP/3", "addr", "udp://"+addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Server failed to serve HTTP/3", "err", err)
+			}
+		}()
+		context.AfterFunc(ctx, func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+			defer cancel()
+			served <- server.Shutdown(shutdownCtx)
+		})
+		return func() error { return <-served }
+	}
+	newHTTP3Client = trailerhttp.NewHTTP3Client
+}
@@ -0,0 +1,902 @@
+// Description: This Go program demonstrates how to use HTTP trailer headers
+// to send metadata about the request body after the body has been sent.
+// It includes a simple HTTP server that reads the request body and checks
+// the trailer header for the body length. The client sends a request with
+// a trailer header indicating the length of the body. The server verifies
+// the length and logs the results. The program uses io.Pipe to stream
+// the request body, allowing the client to send data without knowing
+// the size in advance. The server and client run concurrently, and the
+// program logs the interactions between them. The "server" and "client"
+// subcommands run either side on its own, e.g.
+//
+//	go run ./cmd/demo server -addr :8080
+//	go run ./cmd/demo client -url http://localhost:8080/ -file upload.bin
+//
+// or talk over a Unix domain socket with -network unix -addr /tmp/trailer.sock. The "bridge"
+// subcommand forwards uploads to a server, keeping their trailers when the HTTP version changes:
+//
+//	go run ./cmd/demo bridge -addr :8081 -upstream http://localhost:8080/ -upstream-proto http2
+//
+// The trailer logic itself lives in the trailerhttp package.
+//
+// HTTP Trailer Fields spec: https://www.rfc-editor.org/rfc/rfc9110.html#trailer.fields
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// listenAddr is where the demo server listens; use port 0 for any free port
+var listenAddr = flag.String("addr", trailerhttp.DefaultAddr, "address for the demo server to listen on")
+
+// network is the network of -addr; with "unix", the server listens on and the client dials the socket -addr
+var network = flag.String("network", "tcp", "network of -addr: tcp, or unix for a Unix domain socket path (also dialed by the client)")
+
+// handlerPath is where the demo server mounts the trailer-verifying handler
+var handlerPath = flag.String("path", "/", "path the demo server mounts the trailer handler at")
+
+// maxBodyBytes caps the request body size the demo server accepts
+var maxBodyBytes = flag.Int64("max-body", 0, "maximum request body size in bytes; larger uploads get 413 (0 means no limit)")
+
+// drainOversized lets the demo server read this far past -max-body to report an oversized upload's trailers
+var drainOversized = flag.Int64("drain-oversized", 0, "bytes past -max-body the server discards to reach an oversized upload's trailers for its 413 report")
+
+// serverTiming makes the demo server report its timings in a Server-Timing trailer
+var serverTiming = flag.Bool("server-timing", false, "make the server send a Server-Timing response trailer with its read, verification and handler durations")
+
+// traceResponse makes the demo server name its span in a Traceresponse trailer
+var traceResponse = flag.Bool("trace-response", false, "make the server send a Traceresponse response trailer with the trace context of its span")
+
+// maxTrailerBytes caps the trailer section size the demo server accepts
+var maxTrailerBytes = flag.Int("max-trailer-bytes", 0, "maximum trailer section size in bytes; larger ones get 431 (0 means no limit)")
+
+// readTimeout bounds how long the server waits for an entire request; see trailerhttp.ServerOptions.ReadTimeout
+var readTimeout = flag.Duration("read-timeout", trailerhttp.DefaultReadTimeout, "maximum duration for reading an entire request, including body and trailers")
+
+// readHeaderTimeout and idleTimeout bound reading request headers and idle keep-alive connections
+var (
+	readHeaderTimeout = flag.Duration("read-header-timeout", 0, "maximum duration for reading the request headers (0 means -read-timeout)")
+	idleTimeout       = flag.Duration("idle-timeout", 0, "maximum wait for the next request on a keep-alive connection (0 means -read-timeout)")
+)
+
+// shutdownTimeout bounds how long the server drains in-flight uploads on SIGINT or SIGTERM
+var shutdownTimeout = flag.Duration("shutdown-timeout", trailerhttp.DefaultShutdownTimeout, "maximum wait for in-flight requests when the server shuts down")
+
+// trailerTimeout bounds the wait for the trailer section, of requests on the server and of
+// responses on the client; see trailerhttp.ServerOptions.TrailerTimeout and Client.TrailerTimeout
+var trailerTimeout = flag.Duration("trailer-timeout", 0, "maximum wait for the trailer section after the last body bytes, of requests (server) or responses (client); 0 means no limit")
+
+// verificationPolicy decides whether the demo server rejects uploads that did not verify
+var verificationPolicy trailerhttp.VerificationPolicy
+
+// trailerFallback decides what the demo server does with response trailers a client cannot receive
+var trailerFallback trailerhttp.TrailerFallback
+
+func init() {
+	flag.TextVar(&verificationPolicy, "policy", trailerhttp.PolicyWarn, "what the server does with an upload that did not verify: warn, strict or ignore")
+	flag.TextVar(&trailerFallback, "trailer-fallback", trailerhttp.FallbackOmit, "what the server does with response trailers for a client without TE: trailers: omit, or header to name them in X-Trailer-Fallback")
+}
+
+// metadataJSON and metadataSchema exercise the X-Body-Metadata trailer
+var (
+	metadataJSON   = flag.String("metadata", "", "JSON for the client to send in the X-Body-Metadata trailer")
+	metadataSchema = flag.String("metadata-schema", "", "JSON Schema file the server checks the X-Body-Metadata trailer against")
+)
+
+// rejectUnannounced and allowTrailers make the server refuse trailer fields the client did not announce
+var (
+	rejectUnannounced = flag.Bool("reject-unannounced", false, "make the server reject uploads whose trailer section has fields the Trailer header did not announce")
+	allowTrailers     = flag.String("allow-trailers", "", "comma-separated unannounced trailer fields -reject-unannounced tolerates")
+)
+
+// requireTrailers makes the server refuse, before the body, uploads that do not announce these trailers
+var requireTrailers = flag.String("require-trailers", "", "comma-separated trailer fields every upload must announce; others are rejected before their body (see -expect-continue)")
+
+// rejectStatus and problemDetails shape the answer to an upload rejected under -policy strict
+var (
+	rejectStatus   = flag.Int("reject-status", 0, "status code -policy strict rejects an unverified upload with (0 means 422)")
+	problemDetails = flag.Bool("problem", false, "answer rejected uploads with an application/problem+json body naming the failing trailers")
+)
+
+// useTLS, certFile and keyFile serve the demo over TLS; without -cert/-key a self-signed certificate is generated
+var (
+	useTLS   = flag.Bool("tls", false, "serve and send over TLS with a generated self-signed certificate")
+	certFile = flag.String("cert", "", "TLS certificate file for the server (implies -tls)")
+	keyFile  = flag.String("key", "", "TLS private key file for the server")
+)
+
+// useMTLS makes the demo server require a client certificate, which the demo client presents
+var useMTLS = flag.Bool("mtls", false, "require mutual TLS with a generated client certificate (combined demo only; implies -tls)")
+
+// clientCert is the throwaway client certificate generated for -mtls
+var clientCert *tls.Certificate
+
+// useH2C makes the demo client talk HTTP/2 over cleartext instead of HTTP/1.1
+var useH2C = flag.Bool("h2c", false, "send the client request over HTTP/2 cleartext (h2c) instead of HTTP/1.1")
+
+// useHTTP3 serves and sends the demo over HTTP/3 as well; the -h3 flag is only registered
+// in builds with the http3 tag, see http3.go
+var useHTTP3 = new(bool)
+
+// serveHTTP3 serves the trailer handler over HTTP/3 in a goroutine, on the UDP port of addr, until
+// ctx is cancelled, returning a function that waits for it to shut down,
+// and newHTTP3Client returns a client sending over HTTP/3; http3.go sets both
+var (
+	serveHTTP3     func(ctx context.Context, opts trailerhttp.ServerOptions, tlsConfig *tls.Config, addr string) (wait func() error)
+	newHTTP3Client func(tlsConfig *tls.Config) *http.Client
+)
+
+// useGzip makes the demo client gzip its body; the trailers still describe the uncompressed bytes
+var useGzip = flag.Bool("gzip", false, "gzip the client request body (Content-Encoding: gzip)")
+
+// clientAlgorithms selects which integrity trailers the demo client sends
+var clientAlgorithms = flag.String("algs", "length", "comma-separated integrity trailers for the client to send: length, char-count, line-count, crc32, crc32c, sha256, hmac-sha256, content-digest, repr-digest, amz-crc32, amz-crc32c, amz-sha1, amz-sha256")
+
+// trailerNamesFlag renames the trailer fields on both ends; see parseTrailerNames
+var trailerNamesFlag = flag.String("trailer-names", "", "comma-separated algorithm=field renames of the integrity trailers, for the client and the server, e.g. length=X-Content-Length; status-code= and status-message= rename the status trailers")
+
+// parseTrailerNames splits -trailer-names into the renamed integrity trailers and status trailers
+func parseTrailerNames() (map[string]string, trailerhttp.StatusTrailers, error) {
+	var status trailerhttp.StatusTrailers
+	if *trailerNamesFlag == "" {
+		return nil, status, nil
+	}
+	names := map[string]string{}
+	for _, item := range strings.Split(*trailerNamesFlag, ",") {
+		algorithm, field, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || algorithm == "" || field == "" {
+			return nil, status, fmt.Errorf("%q is not algorithm=field", item)
+		}
+		switch algorithm {
+		case "status-code":
+			status.Code = field
+		case "status-message":
+			status.Message = field
+		default:
+			if !slices.Contains(trailerhttp.Algorithms(), algorithm) {
+				return nil, status, fmt.Errorf("unknown trailer algorithm %q", algorithm)
+			}
+			names[algorithm] = field
+		}
+	}
+	return names, status, nil
+} // parseTrailerNames() func
+
+// digestEncoding is how the demo client writes its hex digest trailers
+var digestEncoding = flag.String("digest-encoding", "hex", "encoding of the client's hex digest trailers: hex, base64 or sf-binary (the server accepts any)")
+
+// expectContinue makes the demo client wait for "100 Continue" before sending its body
+var expectContinue = flag.Bool("expect-continue", false, "send the client request with Expect: 100-continue")
+
+// negotiate makes the demo client ask the server with OPTIONS which trailers it verifies before uploading
+var negotiate = flag.Bool("negotiate", false, "ask the server with an OPTIONS request which trailers it verifies, and send only those, or digest headers if it accepts none")
+
+// prePass makes the demo client hash the file first and send its digests as headers, with a Content-Length
+var prePass = flag.Bool("prepass", false, "read the file once to compute its digests and send them as request headers instead of trailers")
+
+// headerFallback makes the demo client resend the file with digest headers if its trailers do not reach the server
+var headerFallback = flag.Bool("header-fallback", false, "resend the file with its digests as request headers if the server reports its trailers were not carried")
+
+// clientAttempts bounds how often the demo client retries on connection errors and 5xx responses
+var clientAttempts = flag.Int("attempts", 3, "maximum number of attempts for the client request")
+
+// retryMismatch makes the demo client also retry uploads the server could not verify
+var retryMismatch = flag.Bool("retry-mismatch", false, "also retry the client request when its trailers did not verify (up to -attempts)")
+
+// hmacKeyFlag is the shared secret for the X-Body-HMAC trailer; see hmacKey
+var hmacKeyFlag = flag.String("hmac-key", "", "shared secret for the hmac-sha256 trailer (default $TRAILER_HMAC_KEY)")
+
+// requireHMAC makes the demo server reject uploads without an X-Body-HMAC trailer
+var requireHMAC = flag.Bool("require-hmac", false, "make the server reject uploads that do not send the hmac-sha256 trailer (needs -hmac-key)")
+
+// signUploads makes the demo client sign its upload with signingKey, which the demo server trusts
+var signUploads = flag.Bool("sign", false, "sign the upload with an RFC 9421 message signature covering the Content-Digest trailer (combined demo only)")
+
+// bodyToken makes the demo client authorize its upload with a JWT trailer signed by tokenKey, which the demo server requires
+var bodyToken = flag.Bool("body-token", false, "authorize the upload with a JWT in the X-Body-Token trailer, binding its Content-Digest (combined demo only)")
+
+// timestampUploads makes the demo client send the HMAC'd completion time of its upload, which the demo server checks
+var timestampUploads = flag.Bool("timestamp", false, "send the time the upload finished streaming in an X-Body-Timestamp trailer, HMAC'd over its Content-Digest (needs -hmac-key)")
+
+// nonceUploads makes the demo client send a nonce trailer, and the demo server refuse the replays of an upload
+var nonceUploads = flag.Bool("nonce", false, "send a random X-Body-Nonce trailer HMAC'd over the Content-Digest (client), refuse uploads without one and replays (server); needs -hmac-key")
+
+// showProgress makes the demo client report its upload as the body streams, then the trailers it sent
+var showProgress = flag.Bool("progress", false, "print the bytes sent to stderr as the body streams, then the trailers sent (client only)")
+
+// audit makes the demo client and server log every trailer they receive and every integrity failure through their hooks
+var audit = flag.Bool("audit", false, "log every received trailer field and every integrity failure from the OnTrailerReceived and OnIntegrityFailure hooks")
+
+// keepETags makes the demo server remember the ETags of verified uploads and refuse identical ones
+var keepETags = flag.Bool("etags", false, "make the server remember the ETag of every verified upload and answer 412 to an If-None-Match upload of the same content")
+
+// storeDir makes the demo server a blob service: verified uploads to /blobs/ are kept in the directory under their digest
+var storeDir = flag.String("store", "", "keep verified uploads to /blobs/ in this directory under their SHA-256 and serve them back at /blobs/<digest> (server only)")
+
+// filesDir makes the demo server serve the files of a directory at /files/, each with digest trailers computed as it streams
+var filesDir = flag.String("files", "", "serve the files of this directory at /files/, streamed with Content-Digest and length trailers (server only)")
+
+// compressFiles makes the demo server compress the files of -files for clients that accept it
+var compressFiles = flag.Bool("compress", false, "send the files of -files gzip-compressed (zstd with -tags zstd) to clients whose Accept-Encoding allows it, the trailers still describing the files (server only)")
+
+// debugTrailers makes the demo server keep its last requests for /debug/trailers
+var debugTrailers = flag.Int("debug-trailers", 0, "keep the trailers and checks of the last N requests and list them as JSON at /debug/trailers (server only)")
+
+// s3Double makes the demo server an in-memory S3 stand-in at /s3/ that verifies x-amz-checksum headers and trailers
+var s3Double = flag.Bool("s3", false, "serve an in-memory S3 test double at /s3/<bucket>/<key>, verifying x-amz-checksum headers and aws-chunked trailers on PUT (server only)")
+
+// upstreamURL, upstreamProtocol and verifyUpstream configure the bridge subcommand, a proxy keeping trailers across HTTP versions
+var (
+	upstreamURL      = flag.String("upstream", "", "URL the bridge subcommand forwards requests to, trailers and all")
+	upstreamProtocol = flag.String("upstream-proto", "", "HTTP version the bridge speaks upstream: http1, http2 (cleartext with prior knowledge for http://), or empty to negotiate")
+	verifyUpstream   = flag.Bool("verify-responses", false, "make the bridge check the integrity trailers of upstream responses, cutting off those that fail")
+)
+
+// ifNoneMatch makes the demo client skip uploading a file the server already holds
+var ifNoneMatch = flag.Bool("if-none-match", false, "send the client's file with If-None-Match: its SHA-256 ETag, and not at all if the server already holds it (client only)")
+
+// fault deliberately damages the client's upload, to watch the server's policy fire; see trailerhttp.Fault
+var fault trailerhttp.Fault
+
+func init() {
+	flag.StringVar(&fault.CorruptTrailer, "fault-corrupt", "", "corrupt the value of this trailer field in the client's upload")
+	flag.StringVar(&fault.OmitTrailer, "fault-omit", "", "announce this trailer field in the client's upload but leave it out")
+	flag.Int64Var(&fault.TruncateAfter, "fault-truncate", 0, "send only this many body bytes, with trailers describing the whole body")
+	flag.Int64Var(&fault.AbortAfter, "fault-abort", 0, "cut the client's upload off after this many body bytes, before the trailers")
+}
+
+// chunkSize and flushEvery shape the chunks of the client's upload; see trailerhttp.Client.ChunkSize
+var (
+	chunkSize  = flag.Int("chunk-size", 0, "gather the client's upload into chunks of this many bytes before sending them")
+	flushEvery = flag.Duration("flush-every", 0, "send a partial chunk of the client's upload once it has waited this long")
+)
+
+// throttle slows the client's upload down as a poor network would, or with throttleServer the
+// server's reading of it, to watch the trailers and the timeouts; see trailerhttp.Throttle
+var (
+	throttle       trailerhttp.Throttle
+	throttleServer = flag.Bool("throttle-server", false, "apply the -throttle flags to the server's reading of request bodies instead of the client's upload")
+)
+
+func init() {
+	flag.Int64Var(&throttle.BytesPerSecond, "throttle-rate", 0, "pace the upload at this many bytes per second")
+	flag.Float64Var(&throttle.Jitter, "throttle-jitter", 0, "vary each pause of the paced upload at random by up to this fraction")
+	flag.Float64Var(&throttle.StallProbability, "throttle-stall", 0, "chance, from 0 to 1, that the upload stalls before each chunk of up to 16 KiB")
+	flag.DurationVar(&throttle.StallDuration, "throttle-stall-for", time.Second, "how long each stall of -throttle-stall lasts")
+}
+
+// throttled reports whether the -throttle flags slow anything down
+func throttled() bool {
+	return throttle.BytesPerSecond > 0 || throttle.StallProbability > 0
+} // throttled() func
+
+// wireDump makes the combined demo print the raw bytes of its connection, chunk framing and trailer section included
+var wireDump = flag.Bool("wire", false, "print the raw HTTP/1.1 exchange of the combined demo, chunk by chunk, to stderr")
+
+// wireCapture records the demo client's connections for -wire
+var wireCapture *trailerhttp.WireCapture
+
+// signingKey is a throwaway Ed25519 key generated for -sign
+var signingKey ed25519.PrivateKey
+
+// tokenKey is a throwaway Ed25519 key generated for -body-token
+var tokenKey ed25519.PrivateKey
+
+// hmacKey returns the shared HMAC secret used by both the demo client and the server.
+// The environment variable keeps the secret out of the process list.
+func hmacKey() []byte {
+	if *hmacKeyFlag != "" {
+		return []byte(*hmacKeyFlag)
+	}
+	return []byte(os.Getenv("TRAILER_HMAC_KEY"))
+} // hmacKey() func
+
+// clientURL and clientFile configure the client subcommand: the body of the upload is read from the file,
+// or with clientOut the response to a GET of the URL is verified into that file instead
+var (
+	clientURL  = flag.String("url", "", "server URL for the client subcommand")
+	clientFile = flag.String("file", "", "file to upload with the client subcommand")
+	clientOut  = flag.String("out", "", "file to download the URL into, verifying its response trailers, instead of uploading (client only)")
+	clientWait = flag.Duration("wait", 0, "how long the client subcommand waits for the server to accept connections")
+)
+
+// multipartUpload makes the client subcommand send -file as the part of a multipart/form-data form
+var multipartUpload = flag.Bool("multipart", false, "send -file as a multipart/form-data part with X-Part-Digest-file and X-Part-Length-file trailers (client only)")
+
+// resumeDownload makes -out continue a partial file with a Range request instead of starting over
+var resumeDownload = flag.Bool("resume", false, "continue the partial -out file from its end with a Range request, verifying the range and, with a Repr-Digest trailer, the whole file (client only)")
+
+// jsonSummary switches on the machine-readable per-request summary on stdout
+var jsonSummary = flag.Bool("json", false, "write a JSON summary of every request the server handles")
+
+// verbose enables header dumps and full body logging; by default only verification outcomes and errors are logged
+var verbose = flag.Bool("v", false, "verbose logging: dump headers, trailers and request bodies")
+
+// logReads logs every read from the request body, to diagnose how it was chunked on the wire
+var logReads = flag.Bool("log-reads", false, "log the size of every read the server makes from the request body")
+
+// logJSON switches the log output from text lines to one JSON object per line
+var logJSON = flag.Bool("log-json", false, "log as JSON lines instead of text")
+
+// logger receives all server and client output; main sets it up from -v and -log-json
+var logger = slog.Default()
+
+// httpClient sends the client requests; main builds it from the transport flags, speaking HTTP/2 with -h2c
+var httpClient = http.DefaultClient
+
+// dialTimeout, proxyURL and maxConnsPerHost configure the transport of the demo client
+var (
+	dialTimeout     = flag.Duration("dial-timeout", 0, "maximum duration of establishing a connection to the server (0 means 30s)")
+	proxyURL        = flag.String("proxy", "", "send the client requests through this HTTP proxy instead of the one the environment names")
+	maxConnsPerHost = flag.Int("max-conns-per-host", 0, "maximum connections the client opens to the server (0 means no limit)")
+)
+
+// socketTransport is the transport of the demo client with -network unix; main sets it from the flags
+var socketTransport trailerhttp.TransportOptions
+
+// transportOptions returns the client transport configuration of the flags
+func transportOptions() trailerhttp.TransportOptions {
+	opts := trailerhttp.TransportOptions{DialTimeout: *dialTimeout, MaxConnsPerHost: *maxConnsPerHost, H2C: *useH2C}
+	if proxy, err := url.Parse(*proxyURL); *proxyURL != "" && err == nil {
+		opts.Proxy = http.ProxyURL(proxy)
+	}
+	return opts
+} // transportOptions() func
+
+// newLogger returns the logger for the command-line flags: debug output only with -v
+func newLogger() *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if *verbose {
+		opts.Level = slog.LevelDebug
+	}
+	if *logJSON {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+} // newLogger() func
+
+// fatal logs msg at error level and exits with status 1
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+} // fatal() func
+
+// logResult reports the server's verdict as seen by the client
+func logResult(result *trailerhttp.UploadResult) {
+	if result.Error != "" {
+		logger.Warn("Server rejected the upload", "reason", result.Error, "body_withheld", result.BodyWithheld)
+		return
+	}
+	reported := "none"
+	if result.ReportedLength != nil {
+		reported = strconv.FormatInt(*result.ReportedLength, 10)
+	}
+	logger.Info("Server verification", "request_id", result.RequestID, "matched", result.Matched,
+		"server_bytes", result.BodyLength, "proto", result.Proto, "reported_length", reported, "checks", len(result.Checks))
+	if timing := result.ResponseTrailer.Get(trailerhttp.ServerTimingTrailer); timing != "" {
+		logger.Info("Server timing", "request_id", result.RequestID, "server_timing", timing)
+	}
+	if result.ServerTrace.IsValid() {
+		logger.Info("Server trace", "request_id", result.RequestID, "traceresponse", result.ServerTrace.String())
+	}
+} // logResult() func
+
+// flagClient returns a Client configured from the command-line flags
+func flagClient() *trailerhttp.Client {
+	encoding, _ := trailerhttp.ParseDigestEncoding(*digestEncoding) // validated by parseCommand
+	names, status, _ := parseTrailerNames()
+	client := &trailerhttp.Client{
+		HTTPClient:     httpClient,
+		Algorithms:     strings.Split(*clientAlgorithms, ","),
+		Gzip:           *useGzip,
+		HMACKey:        hmacKey(),
+		ExpectContinue: *expectContinue,
+		Negotiate:      *negotiate,
+		PrePass:        *prePass,
+		HeaderFallback: *headerFallback,
+		DigestEncoding: encoding,
+		TrailerNames:   names,
+		StatusTrailers: status,
+		TrailerTimeout: *trailerTimeout,
+		OnBodyComplete: func(length int64, digest []byte) {
+			logger.Debug("Body complete", "bytes", length, "digest", fmt.Sprintf("%x", digest))
+		},
+		Logger:  logger,
+		Verbose: *verbose,
+	}
+	if *network == "unix" {
+		client.HTTPClient = nil // dial the socket below instead, over the transport of the flags
+		client.Network, client.Addr = *network, *listenAddr
+		client.TransportOptions = &socketTransport
+	}
+	if signingKey != nil {
+		client.Signer = &trailerhttp.Signer{KeyID: "demo", Key: signingKey}
+	}
+	if tokenKey != nil {
+		client.BodyToken = &trailerhttp.TokenSigner{KeyID: "demo", Key: tokenKey, Subject: "demo-client"}
+	}
+	if *showProgress {
+		client.ProgressFunc = func(sent int64) { fmt.Fprintf(os.Stderr, "\rsent %d bytes", sent) }
+		client.OnTrailersSent = func(sent int64, trailer http.Header) {
+			fmt.Fprintf(os.Stderr, "\rsent %d bytes\n", sent)
+			for _, name := range slices.Sorted(maps.Keys(trailer)) {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", name, strings.Join(trailer[name], ", "))
+			}
+		}
+	}
+	client.Timestamp = *timestampUploads
+	client.Nonce = *nonceUploads
+	if fault != (trailerhttp.Fault{}) {
+		client.Fault = &fault
+	}
+	if throttled() && !*throttleServer {
+		client.Throttle = &throttle
+	}
+	client.ChunkSize, client.FlushInterval = *chunkSize, *flushEvery
+	if *metadataJSON != "" {
+		client.Metadata = func() (any, error) { return json.RawMessage(*metadataJSON), nil }
+	}
+	if *audit {
+		client.OnTrailerReceived = auditTrailer("client")
+		client.OnIntegrityFailure = auditFailure("client")
+	}
+	return client
+} // flagClient() func
+
+// auditTrailer returns an OnTrailerReceived hook logging each field for -audit
+func auditTrailer(side string) func(field, value string) {
+	return func(field, value string) {
+		logger.Info("Audit: trailer received", "side", side, "field", field, "value", value)
+	}
+} // auditTrailer() func
+
+// auditFailure returns an OnIntegrityFailure hook logging each failure for -audit
+func auditFailure(side string) func(err error, report *trailerhttp.UploadResult) {
+	return func(err error, report *trailerhttp.UploadResult) {
+		logger.Warn("Audit: integrity failure", "side", side, "request_id", report.RequestID, "err", err)
+	}
+} // auditFailure() func
+
+// flagServerOptions configures the demo server from the command-line flags
+func flagServerOptions() trailerhttp.ServerOptions {
+	opts := trailerhttp.ServerOptions{
+		Addr:                      *listenAddr,
+		Network:                   *network,
+		Path:                      *handlerPath,
+		ReadTimeout:               *readTimeout,
+		TrailerTimeout:            *trailerTimeout,
+		ReadHeaderTimeout:         *readHeaderTimeout,
+		IdleTimeout:               *idleTimeout,
+		ShutdownTimeout:           *shutdownTimeout,
+		MaxBodyBytes:              *maxBodyBytes,
+		DrainOversizedBytes:       *drainOversized,
+		ServerTiming:              *serverTiming,
+		TraceResponse:             *traceResponse,
+		MaxTrailerBytes:           *maxTrailerBytes,
+		Policy:                    verificationPolicy,
+		TrailerFallback:           trailerFallback,
+		RejectStatus:              *rejectStatus,
+		ProblemDetails:            *problemDetails,
+		RejectUnannouncedTrailers: *rejectUnannounced,
+		HMACKey:                   hmacKey(),
+		RequireHMAC:               *requireHMAC,
+		Logger:                    logger,
+		Verbose:                   *verbose,
+		LogReads:                  *logReads,
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = -1 // -read-timeout 0 disables the limit
+	}
+	if *metadataSchema != "" {
+		schema, err := os.ReadFile(*metadataSchema)
+		if err != nil {
+			fatal("Error reading metadata schema", "err", err)
+		}
+		opts.MetadataSchema = schema
+	}
+	opts.TrailerNames, opts.StatusTrailers, _ = parseTrailerNames() // validated by parseCommand
+	if *allowTrailers != "" {
+		opts.AllowedUnannouncedTrailers = strings.Split(*allowTrailers, ",")
+	}
+	if *requireTrailers != "" {
+		opts.Admit = trailerhttp.RequireTrailers(strings.Split(*requireTrailers, ",")...)
+	}
+	if *jsonSummary {
+		opts.SummaryOutput = os.Stdout
+	}
+	if clientCert != nil {
+		opts.ClientCAs = x509.NewCertPool()
+		opts.ClientCAs.AddCert(clientCert.Leaf)
+	}
+	if signingKey != nil {
+		opts.SignatureKeys = map[string]crypto.PublicKey{"demo": signingKey.Public()}
+	}
+	if tokenKey != nil {
+		opts.TokenKeys = map[string]crypto.PublicKey{"demo": tokenKey.Public()}
+	}
+	if *nonceUploads {
+		opts.Nonces = new(trailerhttp.MemoryNonceStore)
+	}
+	if *keepETags {
+		opts.ETags = new(trailerhttp.MemoryETagIndex)
+	}
+	if *debugTrailers > 0 {
+		opts.TrailerLog = trailerhttp.NewTrailerLog(*debugTrailers)
+	}
+	if throttled() && *throttleServer {
+		opts.Throttle = &throttle
+	}
+	if *audit {
+		opts.OnTrailerReceived = auditTrailer("server")
+		opts.OnIntegrityFailure = auditFailure("server")
+	}
+	return opts
+} // flagServerOptions() func
+
+// commands lists the subcommands; without one the program runs the combined demo
+var commands = []string{"demo", "server", "client", "bridge"}
+
+// parseCommand splits off the optional subcommand and parses the flags that follow it
+func parseCommand(fs *flag.FlagSet, args []string) (string, error) {
+	command := "demo"
+	if len(args) > 0 && slices.Contains(commands, args[0]) {
+		command, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() > 0 {
+		return "", fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *wireDump && (*useTLS || *certFile != "" || *useMTLS || *useH2C || *useHTTP3 || *network == "unix") {
+		return "", errors.New("-wire only captures cleartext HTTP/1.1 over TCP")
+	}
+	if *network == "unix" && (*useTLS || *certFile != "" || *useMTLS || *useH2C || *useHTTP3) {
+		return "", errors.New("-network unix does not combine with -tls, -cert, -mtls, -h2c or -h3")
+	}
+	if _, err := url.Parse(*proxyURL); err != nil {
+		return "", fmt.Errorf("invalid -proxy: %w", err)
+	}
+	if command == "client" && (*clientURL == "" || (*clientFile == "") == (*clientOut == "")) {
+		return "", errors.New("the client subcommand needs -url and either -file or -out")
+	}
+	if command == "bridge" && *upstreamURL == "" {
+		return "", errors.New("the bridge subcommand needs -upstream")
+	}
+	if !slices.Contains([]string{"", "http1", "http2"}, *upstreamProtocol) {
+		return "", fmt.Errorf("invalid -upstream-proto %q: want http1 or http2", *upstreamProtocol)
+	}
+	if _, err := trailerhttp.ParseDigestEncoding(*digestEncoding); err != nil {
+		return "", fmt.Errorf("invalid -digest-encoding: %w", err)
+	}
+	if (*timestampUploads || *nonceUploads) && len(hmacKey()) == 0 {
+		return "", errors.New("-timestamp and -nonce need -hmac-key")
+	}
+	if _, _, err := parseTrailerNames(); err != nil {
+		return "", fmt.Errorf("invalid -trailer-names: %w", err)
+	}
+	return command, nil
+} // parseCommand() func
+
+// startServer listens on the configured address and serves in a goroutine until ctx is cancelled.
+// It returns the URL of the trailer handler and a function waiting for the server to shut down;
+// with TLS, httpClient is switched to one that trusts exactly the server's (possibly self-signed) certificate.
+func startServer(ctx context.Context) (string, func() error) {
+	opts := flagServerOptions()
+	var blobs http.Handler
+	if *storeDir != "" {
+		store, err := trailerhttp.NewFSStore(*storeDir)
+		if err != nil {
+			fatal("Server failed to open its blob store", "dir", *storeDir, "err", err)
+		}
+		blobs = http.StripPrefix("/blobs", trailerhttp.NewBlobHandler(trailerhttp.BlobOptions{Store: store, HMACKey: hmacKey(), Logger: logger}))
+		if opts.ETags == nil {
+			opts.ETags = store // the trailer handler then also refuses uploads of stored blobs
+		}
+	}
+	server := trailerhttp.NewServer(opts)
+	if blobs != nil {
+		server.Mux.Handle("/blobs/", blobs)
+	}
+	if *filesDir != "" {
+		server.Mux.Handle("/files/", http.StripPrefix("/files", trailerhttp.NewFileServer(trailerhttp.FileServerOptions{Root: os.DirFS(*filesDir), Compress: *compressFiles, Logger: logger})))
+	}
+	if *s3Double {
+		server.Mux.Handle("/s3/", http.StripPrefix("/s3", trailerhttp.NewS3Handler(trailerhttp.S3Options{Logger: logger})))
+	}
+	scheme := "http"
+	if *useTLS || *certFile != "" || *useHTTP3 || *useMTLS {
+		cert, err := serverCertificate()
+		if err != nil {
+			fatal("Server failed to load its TLS certificate", "err", err)
+		}
+		// With TLSConfig set, the server uses TLS and sets up ALPN, so TLS clients can negotiate HTTP/2
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		httpClient = newTLSClient(cert.Leaf)
+		scheme = "https"
+	}
+	// Start listens before returning, so a client request can't race the server's startup,
+	// and returns the port picked for -addr :0
+	addr, err := server.Start(ctx)
+	if err != nil {
+		fatal("Server failed to listen", "err", err)
+	}
+	host := addr.String()
+	if *network == "unix" {
+		host = "localhost" // the client dials the socket; the host only goes in the Host header
+	}
+	logger.Info("Server starting", "url", scheme+"://"+host, "network", *network, "addr", server.Addr, "read_timeout", server.ReadTimeout)
+	wait := server.Wait
+	if *useHTTP3 {
+		waitHTTP3 := serveHTTP3(ctx, flagServerOptions(), server.TLSConfig, server.Addr)
+		httpClient = newHTTP3Client(trustingTLSConfig(server.TLSConfig.Certificates[0].Leaf))
+		wait = func() error { return errors.Join(server.Wait(), waitHTTP3()) }
+	}
+	return scheme + "://" + host + *handlerPath, wait
+} // startServer() func
+
+// waitForServer polls addr on network with short dial attempts until it accepts a connection or timeout elapses
+func waitForServer(network, addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout(network, addr, 100*time.Millisecond)
+		if err == nil {
+			return conn.Close()
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server at %s not reachable after %v: %w", addr, timeout, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+} // waitForServer() func
+
+// waitForURL waits for the server of an http or https URL, or for the -addr socket with -network unix; see waitForServer
+func waitForURL(rawURL string, timeout time.Duration) error {
+	if *network == "unix" {
+		return waitForServer(*network, *listenAddr, timeout)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+	return waitForServer("tcp", addr, timeout)
+} // waitForURL() func
+
+// retryPolicy returns the client's RetryPolicy from -attempts and -retry-mismatch
+func retryPolicy() trailerhttp.RetryPolicy {
+	return trailerhttp.RetryPolicy{MaxAttempts: *clientAttempts, RetryOnMismatch: *retryMismatch}
+} // retryPolicy() func
+
+// runDemo starts the server and sends it one request with trailers from the same process
+func runDemo(ctx context.Context) {
+	serverCtx, stopServer := context.WithCancel(ctx)
+	serverURL, waitServer := startServer(serverCtx)
+
+	// --- Client side ---
+	logger.Debug("Client preparing request with trailer")
+
+	// Define the request body content and stream it with its integrity trailers
+	requestBodyContent := "abcde"
+	newBody := func() io.Reader { return strings.NewReader(requestBodyContent) }
+	result, err := flagClient().SendWithRetry(ctx, serverURL, newBody, retryPolicy())
+	if err != nil {
+		fatal("Client failed to send request", "err", err)
+	}
+	logger.Info("Client received response", "request_id", result.RequestID, "status", result.StatusCode)
+	logResult(result)
+
+	logger.Debug("Client finished")
+	if wireCapture != nil {
+		if _, err := wireCapture.WriteTo(os.Stderr); err != nil {
+			logger.Warn("Failed to print the wire capture", "err", err)
+		}
+	}
+	stopServer()
+	if err := waitServer(); err != nil {
+		fatal("Server failed to shut down", "err", err)
+	}
+} // runDemo() func
+
+// runServer serves until ctx is cancelled, then drains the in-flight uploads within -shutdown-timeout
+func runServer(ctx context.Context) {
+	_, waitServer := startServer(ctx)
+	<-ctx.Done()
+	logger.Info("Server shutting down", "timeout", *shutdownTimeout)
+	if err := waitServer(); err != nil {
+		fatal("Server failed to shut down", "err", err)
+	}
+	logger.Info("Server stopped")
+} // runServer() func
+
+// runBridge forwards the requests arriving on -addr, over HTTP/1.1 or h2c, to -upstream until ctx
+// is cancelled, keeping their trailers and those of the responses across the change of HTTP version
+func runBridge(ctx context.Context) {
+	upstream, err := url.Parse(*upstreamURL)
+	if err != nil {
+		fatal("Bridge got an invalid upstream URL", "url", *upstreamURL, "err", err)
+	}
+	server := trailerhttp.NewServer(trailerhttp.ServerOptions{Addr: *listenAddr, Network: *network, ShutdownTimeout: *shutdownTimeout, Logger: logger})
+	// The proxy replaces the trailer handler; the server still speaks HTTP/1.1 and h2c
+	server.Handler = trailerhttp.NewProxy(trailerhttp.ProxyOptions{
+		Upstream:         upstream,
+		UpstreamProtocol: trailerhttp.UpstreamProtocol(*upstreamProtocol),
+		HMACKey:          hmacKey(),
+		VerifyResponses:  *verifyUpstream,
+		Logger:           logger,
+	})
+	addr, err := server.Start(ctx)
+	if err != nil {
+		fatal("Bridge failed to listen", "err", err)
+	}
+	logger.Info("Bridge starting", "addr", addr, "network", *network, "upstream", upstream.Redacted(), "upstream_proto", *upstreamProtocol)
+	<-ctx.Done()
+	if err := server.Wait(); err != nil {
+		fatal("Bridge failed to shut down", "err", err)
+	}
+	logger.Info("Bridge stopped")
+} // runBridge() func
+
+// runClient uploads -file to -url and prints the server's verification result as JSON.
+// It exits with status 1 when the upload did not verify; cancelling ctx aborts the upload.
+func runClient(ctx context.Context) {
+	if *clientWait > 0 {
+		if err := waitForURL(*clientURL, *clientWait); err != nil {
+			fatal("Client could not reach the server", "err", err)
+		}
+	}
+	if *clientOut != "" {
+		download(ctx)
+		return
+	}
+	var result *trailerhttp.UploadResult
+	var err error
+	switch {
+	case *ifNoneMatch:
+		result, err = sendIfNoneMatch(ctx)
+	case *multipartUpload:
+		result, err = sendMultipart(ctx)
+	default:
+		// Every attempt re-sends the file from the start
+		result, err = flagClient().UploadFile(ctx, *clientURL, *clientFile, trailerhttp.WithRetry(retryPolicy()))
+	}
+	if err != nil {
+		fatal("Client failed to send request", "err", err)
+	}
+	logger.Info("Client received response", "request_id", result.RequestID, "status", result.StatusCode)
+	if result.Unchanged {
+		logger.Info("Server already holds the file; it was not sent", "etag", result.ETag)
+		return
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fatal("Client failed to encode result", "err", err)
+	}
+	fmt.Println(string(out))
+	if !result.Matched {
+		os.Exit(1)
+	}
+} // runClient() func
+
+// sendMultipart uploads the client's file as the "file" part of a form, for -multipart
+func sendMultipart(ctx context.Context) (*trailerhttp.UploadResult, error) {
+	file, err := os.Open(*clientFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return flagClient().SendMultipart(ctx, *clientURL, trailerhttp.FormPart{Name: "file", FileName: filepath.Base(*clientFile), Body: file})
+} // sendMultipart() func
+
+// sendIfNoneMatch uploads the client's file unless the server already holds it, for -if-none-match
+func sendIfNoneMatch(ctx context.Context) (*trailerhttp.UploadResult, error) {
+	file, err := os.Open(*clientFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return flagClient().SendIfNoneMatch(ctx, *clientURL, file)
+} // sendIfNoneMatch() func
+
+// download fetches the client's URL into the -out file, which is removed again unless every trailer check passed
+func download(ctx context.Context) {
+	if *resumeDownload {
+		results, err := flagClient().ResumeDownload(ctx, *clientURL, *clientOut)
+		if err != nil {
+			fatal("Client resumed download failed", "url", *clientURL, "err", err) // the file keeps what verified, for the next -resume
+		}
+		for _, result := range results {
+			logger.Info("Client verified response trailer", "trailer", result.TrailerName, "matched", result.Matched)
+		}
+		logger.Info("Client resumed download", "file", *clientOut, "complete", len(results) == 0)
+		return
+	}
+	file, err := os.Create(*clientOut)
+	if err != nil {
+		fatal("Client failed to create file", "file", *clientOut, "err", err)
+	}
+	results, err := flagClient().Download(ctx, *clientURL, file)
+	if err = errors.Join(err, file.Close()); err != nil {
+		os.Remove(*clientOut)
+		fatal("Client download failed", "url", *clientURL, "err", err)
+	}
+	for _, result := range results {
+		logger.Info("Client verified response trailer", "trailer", result.TrailerName, "matched", result.Matched)
+	}
+	logger.Info("Client downloaded file", "file", *clientOut)
+} // download() func
+
+func main() {
+	command, err := parseCommand(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\nusage: %s [demo|server|client|bridge] [flags]\n", err, os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	logger = newLogger()
+	httpClient = trailerhttp.NewHTTPClient(transportOptions())
+	socketTransport = transportOptions()
+	if *signUploads {
+		_, signingKey, _ = ed25519.GenerateKey(nil)
+	}
+	if *bodyToken {
+		_, tokenKey, _ = ed25519.GenerateKey(nil)
+	}
+	if *useMTLS {
+		cert, err := selfSignedCertificate(x509.ExtKeyUsageClientAuth)
+		if err != nil {
+			fatal("Failed to generate the client certificate", "err", err)
+		}
+		clientCert = &cert
+	}
+	if *useHTTP3 {
+		httpClient = newHTTP3Client(nil) // the demo server replaces it with one trusting its certificate
+	}
+	if *wireDump {
+		wireCapture = new(trailerhttp.WireCapture)
+		httpClient = wireCapture.Client()
+	}
+
+	// SIGINT or SIGTERM shuts the server down gracefully, or cancels the client's upload;
+	// once ctx is done, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+
+	switch command {
+	case "server":
+		runServer(ctx)
+	case "client":
+		runClient(ctx)
+	case "bridge":
+		runBridge(ctx)
+	default:
+		runDemo(ctx)
+	}
+} // main
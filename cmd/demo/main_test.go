@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// demoFlags returns a flag set sharing the program's flags, with every one back at its default
+func demoFlags(t *testing.T) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("demo", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatalf("resetting -%s: %v", f.Name, err)
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+} // demoFlags() func
+
+func TestParseCommand(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		command string // "" means an error
+	}{
+		{nil, "demo"},
+		{[]string{"-v"}, "demo"},
+		{[]string{"server"}, "server"},
+		{[]string{"server", "-addr", "127.0.0.1:0", "-policy", "strict"}, "server"},
+		{[]string{"server", "extra"}, ""},
+		{[]string{"server", "-policy", "lenient"}, ""},
+		{[]string{"client", "-url", "http://127.0.0.1:8080/", "-file", "body.bin"}, "client"},
+		{[]string{"client", "-url", "http://127.0.0.1:8080/", "-out", "copy.bin"}, "client"},
+		{[]string{"client", "-url", "http://127.0.0.1:8080/"}, ""},
+		{[]string{"client", "-file", "body.bin"}, ""},
+		{[]string{"client", "-url", "http://127.0.0.1:8080/", "-file", "body.bin", "-out", "copy.bin"}, ""},
+		{[]string{"client", "-no-such-flag"}, ""},
+	} {
+		command, err := parseCommand(demoFlags(t), tc.args)
+		if command != tc.command || (err != nil) != (tc.command == "") {
+			t.Errorf("parseCommand(%q) = %q, %v; want %q", tc.args, command, err, tc.command)
+		}
+	}
+	if command, _ := parseCommand(demoFlags(t), []string{"client", "-url", "http://127.0.0.1:8080/", "-file", "body.bin"}); command != "client" || *clientURL != "http://127.0.0.1:8080/" || *clientFile != "body.bin" {
+		t.Errorf("client subcommand: -url %q, -file %q; want the parsed values", *clientURL, *clientFile)
+	}
+}
+
+func TestWaitForServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close() // free the port, to listen on it again after a delay
+
+	const delay = 200 * time.Millisecond
+	listening := make(chan net.Listener, 1)
+	time.AfterFunc(delay, func() {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+		}
+		listening <- l
+	})
+	start := time.Now()
+	err = waitForServer("tcp", addr, 5*time.Second)
+	elapsed := time.Since(start)
+	delayed := <-listening
+	if delayed == nil {
+		t.FailNow()
+	}
+	defer delayed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed < delay || elapsed > delay+time.Second {
+		t.Errorf("waited %s for a listener that started after %s", elapsed, delay)
+	}
+
+	delayed.Close()
+	if err := waitForServer("tcp", addr, 100*time.Millisecond); err == nil {
+		t.Error("no error waiting for an address nothing listens on")
+	}
+}
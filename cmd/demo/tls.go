@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// selfSignedCertificate generates a throwaway ECDSA certificate for usage, valid for the given host names and IPs
+func selfSignedCertificate(usage x509.ExtKeyUsage, hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"trailer_header demo"}},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+} // selfSignedCertificate() func
+
+// serverCertificate loads the -cert/-key pair, or generates a self-signed certificate when none was given
+func serverCertificate() (tls.Certificate, error) {
+	if *certFile != "" || *keyFile != "" {
+		return tls.LoadX509KeyPair(*certFile, *keyFile) // also parses Leaf
+	}
+	return selfSignedCertificate(x509.ExtKeyUsageServerAuth, "localhost", "127.0.0.1", "::1")
+} // serverCertificate() func
+
+// newTLSClient returns a client that trusts only the server's certificate.
+// Trailers behave the same over TLS; the client negotiates HTTP/2 via ALPN when the server offers it.
+func newTLSClient(serverCert *x509.Certificate) *http.Client {
+	opts := transportOptions()
+	opts.TLSConfig, opts.H2C = trustingTLSConfig(serverCert), false // TLS negotiates HTTP/2 by ALPN
+	return trailerhttp.NewHTTPClient(opts)
+} // newTLSClient() func
+
+// trustingTLSConfig returns a client TLS configuration whose only root is serverCert,
+// presenting the -mtls client certificate if there is one
+func trustingTLSConfig(serverCert *x509.Certificate) *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	cfg := &tls.Config{RootCAs: roots}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{*clientCert}
+	}
+	return cfg
+} // trustingTLSConfig() func
@@ -0,0 +1,165 @@
+// trailerbench measures what integrity trailers cost: it uploads bodies of several sizes to
+// a server on a loopback port, streamed with trailers, buffered whole and sent with
+// Content-Length and a digest header, and streamed without any check, and prints the latency,
+// throughput, time to the first body byte and allocations of each:
+//
+//	trailerbench
+//	trailerbench -sizes 4k,1m,256m -n 3 -algs length,crc32c
+//	trailerbench -h2c -json > bench.json
+//
+// With -digests it instead measures computing the trailers in memory, in this package's single
+// pass through a pooled buffer against a chain of io.TeeReaders read with io.ReadAll:
+//
+//	trailerbench -digests -sizes 1m,1g -algs sha256,crc32c,content-digest
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// sizes are the body sizes to measure
+var sizes = flag.String("sizes", "1k,64k,1m,16m", "comma-separated body sizes, with an optional k, m or g suffix")
+
+// iterations is the number of measured uploads per mode and size
+var iterations = flag.Int("n", 10, "measured uploads per mode and size")
+
+// algorithms selects the trailers of the streamed mode
+var algorithms = flag.String("algs", "sha256", "comma-separated integrity trailers of the trailers mode and of -digests: "+strings.Join(trailerhttp.Algorithms(), ", "))
+
+// h2c uploads over cleartext HTTP/2
+var h2c = flag.Bool("h2c", false, "upload over cleartext HTTP/2 instead of HTTP/1.1")
+
+// jsonOutput prints the results as JSON
+var jsonOutput = flag.Bool("json", false, "print the results as JSON")
+
+// digests measures the digest pipeline instead of uploads
+var digests = flag.Bool("digests", false, "measure computing the trailers in memory, single pass against an io.TeeReader chain, instead of uploads")
+
+// timeout bounds the whole benchmark
+var timeout = flag.Duration("timeout", 10*time.Minute, "maximum duration of the benchmark")
+
+// logger receives diagnostics; stdout is reserved for the results
+var logger = log.New(os.Stderr, "trailerbench: ", 0)
+
+// parseSizes parses the -sizes flag
+func parseSizes(list string) ([]int64, error) {
+	var sizes []int64
+	for _, field := range strings.Split(list, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		unit := int64(1)
+		switch {
+		case strings.HasSuffix(field, "k"):
+			unit = 1 << 10
+		case strings.HasSuffix(field, "m"):
+			unit = 1 << 20
+		case strings.HasSuffix(field, "g"):
+			unit = 1 << 30
+		}
+		n, err := strconv.ParseInt(strings.TrimRight(field, "kmg"), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, n*unit)
+	}
+	return sizes, nil
+} // parseSizes() func
+
+// formatSize writes n bytes with the largest binary unit that divides it
+func formatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if n >= unit.size && n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + " " + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + " B"
+} // formatSize() func
+
+// printResults writes the results as a table, one row per mode and size
+func printResults(results []trailerhttp.BenchResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "size\tmode\tlatency\tfirst byte\tthroughput\talloc/op\tallocs/op\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%.1f MiB/s\t%s\t%d\t\n", formatSize(r.Size), r.Mode,
+			r.Latency.Round(time.Microsecond), r.FirstByte.Round(time.Microsecond),
+			r.Throughput/(1<<20), formatSize(int64(r.AllocBytes)), r.Allocs)
+	}
+	tw.Flush()
+} // printResults() func
+
+// printDigestResults writes the results of -digests as a table, one row per method and size
+func printDigestResults(results []trailerhttp.DigestBenchResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "size\tmethod\tthroughput\talloc/op\tallocs/op\tallocs/read\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%.1f MiB/s\t%s\t%d\t%.3f\t\n", formatSize(r.Size), r.Method,
+			r.Throughput/(1<<20), formatSize(int64(r.AllocBytes)), r.Allocs, r.AllocsPerRead)
+	}
+	tw.Flush()
+} // printDigestResults() func
+
+// runDigests runs the -digests benchmark for every size and prints it
+func runDigests(opts trailerhttp.BenchOptions) {
+	var results []trailerhttp.DigestBenchResult
+	for _, size := range opts.Sizes {
+		measured, err := trailerhttp.RunDigestBenchmark(size, opts.Algorithms, opts.Iterations)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		results = append(results, measured...)
+	}
+	if *jsonOutput {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	printDigestResults(results)
+} // runDigests() func
+
+func main() {
+	flag.Parse()
+	opts := trailerhttp.BenchOptions{Iterations: *iterations, Algorithms: strings.Split(*algorithms, ","), H2C: *h2c}
+	var err error
+	if opts.Sizes, err = parseSizes(*sizes); err != nil {
+		logger.Fatal(err)
+	}
+	if opts.Iterations <= 0 {
+		logger.Fatal("-n must be positive")
+	}
+	if *digests {
+		runDigests(opts)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	results, err := trailerhttp.RunBenchmark(ctx, opts)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if *jsonOutput {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	printResults(results)
+} // main
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// downloadBody is the body of /download, sent with its SHA-256 as a response trailer
+var downloadBody = bytes.Repeat([]byte("trailer conformance\n"), 4<<10)
+
+// curlBodySize is the size of the chunked upload curl sends to the echo endpoint
+const curlBodySize = 64 << 10
+
+// proxyStartTimeout bounds the wait for a proxy to accept connections
+const proxyStartTimeout = 10 * time.Second
+
+// proxy is an nginx or haproxy process in front of the endpoint
+type proxy struct {
+	addr    string
+	cmd     *exec.Cmd
+	dir     string
+	done    chan struct{}
+	waitErr error
+}
+
+// stop kills the proxy and removes its configuration
+func (px *proxy) stop() {
+	px.cmd.Process.Kill()
+	<-px.done
+	os.RemoveAll(px.dir)
+} // stop() func
+
+// lookBinary resolves name in $PATH; an empty name means the binary is not to be tested
+func lookBinary(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("skipped")
+	}
+	binary, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s not installed", filepath.Base(name))
+	}
+	return binary, nil
+} // lookBinary() func
+
+// binaryVersion returns the first line binary prints with args, to stdout or stderr
+func binaryVersion(ctx context.Context, binary string, args ...string) string {
+	out, _ := exec.CommandContext(ctx, binary, args...).CombinedOutput()
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line)
+} // binaryVersion() func
+
+// goVersion names the Go client
+func goVersion() string {
+	return "Go net/http " + runtime.Version()
+} // goVersion() func
+
+// freeAddr returns a loopback address with a port that was free a moment ago
+func freeAddr() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+} // freeAddr() func
+
+// startProxy writes config to a temporary directory, runs binary with the arguments args
+// returns for that directory, and waits until it accepts connections on addr
+func startProxy(ctx context.Context, binary, addr, configName, config string, args func(dir string) []string) (*proxy, error) {
+	dir, err := os.MkdirTemp("", "trailerconform-*")
+	if err != nil {
+		return nil, err
+	}
+	config = strings.ReplaceAll(config, "$DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, configName), []byte(config), 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	var output bytes.Buffer
+	px := &proxy{addr: addr, cmd: exec.CommandContext(ctx, binary, args(dir)...), dir: dir, done: make(chan struct{})}
+	px.cmd.Stdout, px.cmd.Stderr = &output, &output
+	if err := px.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	go func() {
+		px.waitErr = px.cmd.Wait()
+		close(px.done)
+	}()
+	deadline := time.Now().Add(proxyStartTimeout)
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return px, nil
+		}
+		select {
+		case <-px.done:
+			os.RemoveAll(dir)
+			err := fmt.Errorf("%s exited without listening on %s", filepath.Base(binary), addr)
+			if px.waitErr != nil {
+				err = fmt.Errorf("%s exited: %w", filepath.Base(binary), px.waitErr)
+			}
+			if msg := bytes.TrimSpace(output.Bytes()); len(msg) > 0 {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			return nil, err
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			px.stop()
+			return nil, fmt.Errorf("%s did not listen on %s within %v", filepath.Base(binary), addr, proxyStartTimeout)
+		}
+	}
+} // startProxy() func
+
+// nginxConfig is a stock reverse proxy configuration, streaming both ways as trailers need
+const nginxConfig = `daemon off;
+master_process off;
+pid $DIR/nginx.pid;
+error_log stderr error;
+events {}
+http {
+	access_log off;
+	client_body_temp_path $DIR/client_body;
+	proxy_temp_path $DIR/proxy;
+	fastcgi_temp_path $DIR/fastcgi;
+	uwsgi_temp_path $DIR/uwsgi;
+	scgi_temp_path $DIR/scgi;
+	server {
+		listen %s;
+		location / {
+			proxy_pass http://%s;
+			proxy_http_version 1.1;
+			proxy_request_buffering off;
+			proxy_buffering off;
+		}
+	}
+}
+`
+
+// startNginx runs nginx in front of upstream
+func startNginx(ctx context.Context, binary, upstream string) (*proxy, error) {
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+	return startProxy(ctx, binary, addr, "nginx.conf", fmt.Sprintf(nginxConfig, addr, upstream), func(dir string) []string {
+		return []string{"-p", dir, "-c", filepath.Join(dir, "nginx.conf"), "-e", "stderr"}
+	})
+} // startNginx() func
+
+// haproxyConfig is a stock HTTP mode configuration
+const haproxyConfig = `global
+	pidfile $DIR/haproxy.pid
+defaults
+	mode http
+	timeout connect 5s
+	timeout client 30s
+	timeout server 30s
+frontend conform
+	bind %s
+	default_backend endpoint
+backend endpoint
+	server endpoint %s
+`
+
+// startHAProxy runs haproxy in front of upstream
+func startHAProxy(ctx context.Context, binary, upstream string) (*proxy, error) {
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+	return startProxy(ctx, binary, addr, "haproxy.cfg", fmt.Sprintf(haproxyConfig, addr, upstream), func(dir string) []string {
+		return []string{"-db", "-f", filepath.Join(dir, "haproxy.cfg")}
+	})
+} // startHAProxy() func
+
+// checkCurl sends a chunked upload to the echo endpoint at base with curl, and downloads a body
+// with response trailers, which curl writes with the headers it dumps
+func checkCurl(ctx context.Context, curl, base string, r *Result) {
+	upload := exec.CommandContext(ctx, curl, "-sS", "-T", "-", "-H", "Content-Type: application/octet-stream", base+"/echo")
+	upload.Stdin = bytes.NewReader(bytes.Repeat([]byte{'c'}, curlBodySize))
+	out, err := upload.Output()
+	var echo trailerhttp.ProbeEcho
+	if err == nil {
+		err = json.Unmarshal(out, &echo)
+	}
+	if err != nil {
+		r.Note = fmt.Sprintf("upload: %v", curlError(err))
+	} else {
+		r.Framing = outcome(echo.CanCarryTrailers && echo.BodyLength == curlBodySize)
+	}
+
+	dump, err := exec.CommandContext(ctx, curl, "-sS", "-H", "TE: trailers", "-D", "-", "-o", os.DevNull, base+"/download").Output()
+	if err != nil {
+		r.Note = strings.TrimPrefix(r.Note+"; download: "+curlError(err).Error(), "; ")
+		return
+	}
+	want := trailerhttp.ComputeTrailers(downloadBody, trailerhttp.AlgoSHA256)
+	trailer := dumpedTrailers(dump)
+	r.ResponseTrailers = outcome(trailer.Get("X-Body-SHA256") == want.Get("X-Body-SHA256"))
+	if r.Note == "" {
+		r.Note = "curl cannot send request trailers"
+	}
+} // checkCurl() func
+
+// curlError adds what curl printed to stderr to err
+func curlError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return err
+} // curlError() func
+
+// dumpedTrailers parses the trailer section from what curl -D dumps: the header blocks of any
+// interim and the final response, each starting with a status line, then the trailer fields
+func dumpedTrailers(dump []byte) http.Header {
+	trailer := http.Header{}
+	blocks := strings.Split(strings.ReplaceAll(string(dump), "\r\n", "\n"), "\n\n")
+	if last := strings.TrimSpace(blocks[len(blocks)-1]); len(blocks) > 1 && !strings.HasPrefix(last, "HTTP/") {
+		for _, line := range strings.Split(last, "\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				trailer.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		}
+	}
+	return trailer
+} // dumpedTrailers() func
@@ -0,0 +1,249 @@
+// trailerconform checks which trailer behaviors survive real HTTP software: it serves the probe
+// echo endpoint on a loopback port, puts nginx and haproxy in front of it with stock reverse
+// proxy configurations when their binaries are installed, and sends through every path with Go's
+// client and with curl, then prints a compatibility report:
+//
+//	trailerconform
+//	trailerconform -json > compat.json
+//	trailerconform -nginx /usr/local/sbin/nginx -haproxy "" -strict
+//
+// For every path and client it reports whether a streamed request kept a framing that can
+// carry trailers, whether its trailers arrived intact, and whether response trailers made it
+// back. The curl command line has no option to send trailers, so curl is checked as a sender of
+// chunked bodies and as a receiver of response trailers. Missing binaries skip their rows. It
+// exits with status 1 if a check fails on the direct path, where nothing stands between the
+// client and the endpoint; with -strict also if one fails behind a proxy.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// curlPath, nginxPath and haproxyPath name the binaries under test; empty skips one
+var (
+	curlPath    = flag.String("curl", "curl", "curl binary, looked up in $PATH; empty skips curl")
+	nginxPath   = flag.String("nginx", "nginx", "nginx binary, looked up in $PATH; empty skips nginx")
+	haproxyPath = flag.String("haproxy", "haproxy", "haproxy binary, looked up in $PATH; empty skips haproxy")
+)
+
+// strict fails the run on trailers lost behind a proxy too
+var strict = flag.Bool("strict", false, "exit with status 1 if any check fails, behind a proxy too")
+
+// jsonOutput prints the report as JSON
+var jsonOutput = flag.Bool("json", false, "print the report as JSON")
+
+// timeout bounds the whole run
+var timeout = flag.Duration("timeout", 2*time.Minute, "maximum duration of the run")
+
+// logger receives diagnostics; stdout is reserved for the report
+var logger = log.New(os.Stderr, "trailerconform: ", 0)
+
+// Outcome is the result of one check
+type Outcome string
+
+// Outcomes
+const (
+	Pass     Outcome = "pass"
+	Fail     Outcome = "fail"
+	Untested Outcome = "n/a" // the client cannot exercise it, or the path did not start
+)
+
+// outcome returns Pass when ok holds
+func outcome(ok bool) Outcome {
+	if ok {
+		return Pass
+	}
+	return Fail
+} // outcome() func
+
+// Result is what one client saw over one path
+type Result struct {
+	Path             string  `json:"path"` // direct, nginx or haproxy
+	PathVersion      string  `json:"path_version,omitempty"`
+	Client           string  `json:"client"` // go or curl
+	ClientVersion    string  `json:"client_version,omitempty"`
+	Framing          Outcome `json:"framing"`           // the streamed request arrived chunked or over HTTP/2
+	RequestTrailers  Outcome `json:"request_trailers"`  // its trailers arrived intact
+	ResponseTrailers Outcome `json:"response_trailers"` // response trailers arrived intact
+	Note             string  `json:"note,omitempty"`
+}
+
+// failed reports whether a check of r failed
+func (r Result) failed() bool {
+	return slices.Contains([]Outcome{r.Framing, r.RequestTrailers, r.ResponseTrailers}, Fail)
+} // failed() func
+
+// Report is the compatibility report of a run
+type Report struct {
+	Go      string   `json:"go"`
+	Results []Result `json:"results"`
+}
+
+// serveEndpoint serves the echo endpoint at /echo and a body with response trailers at
+// /download on a loopback port, over HTTP/1.1 and cleartext HTTP/2, and returns its address
+func serveEndpoint() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/echo", trailerhttp.EchoHandler())
+	mux.Handle("/download", trailerhttp.ResponseTrailers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(downloadBody)
+	}), trailerhttp.AlgoSHA256))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, Protocols: new(http.Protocols)}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	go server.Serve(listener)
+	return listener.Addr().String(), nil
+} // serveEndpoint() func
+
+// path is a way to the endpoint: direct, or through a proxy started for the run
+type path struct {
+	name    string
+	binary  *string
+	version []string                                                           // arguments printing the version
+	start   func(ctx context.Context, binary, upstream string) (*proxy, error) // nil for the direct path
+}
+
+var paths = []path{
+	{name: "direct"},
+	{name: "nginx", binary: nginxPath, version: []string{"-v"}, start: startNginx},
+	{name: "haproxy", binary: haproxyPath, version: []string{"-v"}, start: startHAProxy},
+}
+
+// run checks every client over every path
+func run(ctx context.Context, upstream string) *Report {
+	report := &Report{Go: goVersion()}
+	curl, curlErr := lookBinary(*curlPath)
+	curlVersion := ""
+	if curlErr == nil {
+		curlVersion = binaryVersion(ctx, curl, "--version")
+	}
+	for _, p := range paths {
+		base, version := "http://"+upstream, ""
+		var skipped string
+		if p.start != nil {
+			binary, err := lookBinary(*p.binary)
+			switch {
+			case err != nil:
+				skipped = err.Error()
+			default:
+				version = binaryVersion(ctx, binary, p.version...)
+				px, err := p.start(ctx, binary, upstream)
+				if err != nil {
+					skipped = err.Error()
+					break
+				}
+				defer px.stop()
+				base = "http://" + px.addr
+			}
+		}
+		results := []Result{{Path: p.name, PathVersion: version, Client: "go", ClientVersion: report.Go}}
+		if curlErr == nil {
+			results = append(results, Result{Path: p.name, PathVersion: version, Client: "curl", ClientVersion: curlVersion})
+		} else {
+			results = append(results, Result{Path: p.name, PathVersion: version, Client: "curl", Note: curlErr.Error()})
+		}
+		for i := range results {
+			r := &results[i]
+			switch {
+			case skipped != "":
+				r.Note = skipped
+			case r.Client == "go":
+				checkGo(ctx, base, r)
+			case curlErr == nil:
+				checkCurl(ctx, curl, base, r)
+			}
+			for _, o := range []*Outcome{&r.Framing, &r.RequestTrailers, &r.ResponseTrailers} {
+				if *o == "" {
+					*o = Untested
+				}
+			}
+		}
+		report.Results = append(report.Results, results...)
+	}
+	return report
+} // run() func
+
+// checkGo probes base with Go's HTTP client, over HTTP/1.1
+func checkGo(ctx context.Context, base string, r *Result) {
+	probe, err := trailerhttp.Probe(ctx, http.DefaultClient, base+"/echo")
+	if err != nil {
+		r.Note = err.Error()
+		return
+	}
+	r.Framing = outcome(probe.ArrivedFraming && probe.BodyIntact)
+	r.RequestTrailers = outcome(!slices.ContainsFunc(probe.Fields, func(f trailerhttp.ProbeField) bool { return !f.Intact }))
+	r.ResponseTrailers = outcome(probe.ResponseTrailerOK)
+	if probe.ArrivedProto != "" {
+		r.Note = "arrived as " + probe.ArrivedProto
+	}
+} // checkGo() func
+
+// printReport writes the report as a table, one row per path and client
+func printReport(report *Report) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "path\tclient\tframing\trequest trailers\tresponse trailers\tnote")
+	for _, r := range report.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Path, r.Client, r.Framing, r.RequestTrailers, r.ResponseTrailers, r.Note)
+	}
+	tw.Flush()
+	fmt.Println()
+	seen := map[string]bool{}
+	for _, r := range report.Results {
+		for _, v := range []string{r.PathVersion, r.ClientVersion} {
+			if v != "" && !seen[v] {
+				seen[v] = true
+				fmt.Println(v)
+			}
+		}
+	}
+} // printReport() func
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	upstream, err := serveEndpoint()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	report := run(ctx, upstream)
+
+	if *jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		printReport(report)
+	}
+	for _, r := range report.Results {
+		if r.failed() && (*strict || r.Path == "direct") {
+			cancel()
+			os.Exit(1)
+		}
+	}
+} // main
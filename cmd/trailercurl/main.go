@@ -0,0 +1,301 @@
+// trailercurl streams a request body to a URL with chunked encoding and appends integrity
+// trailers computed while the body is sent, plus any custom trailers given with -T:
+//
+//	trailercurl -algs length,sha256 -T X-Upload-Id:42 https://example.com/upload < big.bin
+//	trailercurl -f big.bin -i http://localhost:8080/
+//	trailercurl -f big.bin -har session.har http://localhost:8080/
+//	trailercurl -replay session.har [URL]
+//	trailercurl -raw -violate unterminated,fold -v http://localhost:8080/ < small.bin
+//
+// The response body is written to stdout. Response trailers the server computed with
+// trailerhttp are verified against the bytes received.
+//
+// With -raw the request bypasses net/http: it is written by hand over a TCP connection, with
+// the framing violations -violate, -chunk-size-delta and -chunk-ext ask for, to see how a
+// server takes a malformed chunked body or trailer section. Where the server's own response
+// breaks the framing rules is logged to stderr.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"trailer_header/trailerhttp"
+)
+
+// bodyFile is the file to send; "-" reads the body from stdin
+var bodyFile = flag.String("f", "-", "file to send as the request body; - reads stdin")
+
+// method is the request method
+var method = flag.String("X", http.MethodPost, "request method")
+
+// algorithms selects the computed integrity trailers
+var algorithms = flag.String("algs", "length,sha256", "comma-separated integrity trailers to compute: "+strings.Join(trailerhttp.Algorithms(), ", "))
+
+// hmacKeyFlag is the shared secret for the hmac-sha256 trailer
+var hmacKeyFlag = flag.String("hmac-key", "", "shared secret for the hmac-sha256 trailer (default $TRAILER_HMAC_KEY)")
+
+// include, verbose, insecure and failOnError mirror the curl flags of the same name
+var (
+	include     = flag.Bool("i", false, "include the response status, headers and trailers in the output")
+	verbose     = flag.Bool("v", false, "log the request and response headers and trailers to stderr")
+	insecure    = flag.Bool("k", false, "skip TLS certificate verification")
+	failOnError = flag.Bool("fail", false, "exit with status 22 when the server answers with a status of 400 or above")
+)
+
+// harFile and replayFile record the session to a HAR file, and re-send a recorded one;
+// harSecrets keeps the credentials and keyed trailers the HAR file redacts otherwise
+var (
+	harFile    = flag.String("har", "", "record the exchange, trailers included, to this HAR `file`")
+	harSecrets = flag.Bool("har-secrets", false, "record credentials and keyed trailers such as X-Body-HMAC in the -har file as sent, so -replay authenticates; [redacted] otherwise")
+	replayFile = flag.String("replay", "", "re-send every request of this HAR `file` with its original body and trailers, to URL if given")
+)
+
+// headers and trailers collect the repeatable -H and -T flags
+var headers, trailers http.Header = http.Header{}, http.Header{}
+
+func init() {
+	flag.Func("H", "extra request header `name:value` (repeatable)", fieldFlag(headers))
+	flag.Func("T", "custom request trailer `name:value` (repeatable); computed trailers of the same name win", fieldFlag(trailers))
+}
+
+// fieldFlag returns a flag.Func parser adding "name:value" arguments to h
+func fieldFlag(h http.Header) func(string) error {
+	return func(arg string) error {
+		name, value, ok := strings.Cut(arg, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return fmt.Errorf("%q is not of the form name:value", arg)
+		}
+		h.Add(name, strings.TrimSpace(value))
+		return nil
+	}
+} // fieldFlag() func
+
+// logger receives diagnostics; stdout is reserved for the response
+var logger = log.New(os.Stderr, "trailercurl: ", 0)
+
+// hmacKey returns the shared HMAC secret; the environment variable keeps it out of the process list
+func hmacKey() []byte {
+	if *hmacKeyFlag != "" {
+		return []byte(*hmacKeyFlag)
+	}
+	return []byte(os.Getenv("TRAILER_HMAC_KEY"))
+} // hmacKey() func
+
+// parseAlgos resolves the -algs list, refusing names that are not registered
+func parseAlgos(list string) ([]trailerhttp.TrailerAlgo, error) {
+	var algos []trailerhttp.TrailerAlgo
+	for _, name := range strings.Split(list, ",") {
+		switch name = strings.TrimSpace(name); {
+		case name == "":
+		case name == "hmac-sha256":
+			key := hmacKey()
+			if len(key) == 0 {
+				return nil, errors.New("hmac-sha256 needs -hmac-key or $TRAILER_HMAC_KEY")
+			}
+			algos = append(algos, trailerhttp.AlgoHMACSHA256(key))
+		case slices.Contains(trailerhttp.Algorithms(), name):
+			algos = append(algos, trailerhttp.Algo(name))
+		default:
+			return nil, fmt.Errorf("unknown trailer algorithm %q", name)
+		}
+	}
+	return algos, nil
+} // parseAlgos() func
+
+// openBody opens the -f file, or stdin
+func openBody(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+} // openBody() func
+
+// send streams src to url and sets the trailers once src is exhausted
+func send(ctx context.Context, client *http.Client, url string, src io.Reader, algos []trailerhttp.TrailerAlgo) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, *method, url, pr)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("TE", "trailers") // so the server may answer with trailers of its own
+
+	// Custom trailers have fixed values, so they are set up front; the computed ones are set on Close
+	req.Trailer = trailers.Clone()
+	tw := trailerhttp.NewTrailerWriter(req, pw, algos...)
+	if *verbose {
+		logger.Printf("> %s %s", req.Method, req.URL)
+		dumpHeader("> ", req.Header)
+	}
+
+	go func() {
+		if _, err := io.Copy(tw, src); err != nil {
+			tw.CloseWithError(err) // fail the request rather than send trailers for a partial body
+			return
+		}
+		tw.Close()
+		if *verbose {
+			logger.Printf("> (%d bytes)", tw.Written())
+			dumpHeader("> ", req.Trailer)
+		}
+	}()
+	return client.Do(req)
+} // send() func
+
+// dumpHeader logs every field of h behind prefix
+func dumpHeader(prefix string, h http.Header) {
+	for name, values := range h {
+		for _, value := range values {
+			logger.Printf("%s%s: %s", prefix, name, value)
+		}
+	}
+} // dumpHeader() func
+
+// replay re-sends every request recorded in the -replay file, to target when it is set,
+// verifying each response
+func replay(ctx context.Context, client *http.Client, target string) error {
+	f, err := os.Open(*replayFile)
+	if err != nil {
+		return err
+	}
+	har, err := trailerhttp.ReadHAR(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *replayFile, err)
+	}
+	for i := range har.Log.Entries {
+		entry := &har.Log.Entries[i]
+		req, err := entry.NewRequest(ctx)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		if target != "" {
+			u, err := url.Parse(target)
+			if err != nil {
+				return err
+			}
+			req.URL.Scheme, req.URL.Host, req.Host = u.Scheme, u.Host, ""
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		_, err = io.Copy(os.Stdout, trailerhttp.NewVerifiedResponse(resp, hmacKey()))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("entry %d: reading response: %w", i, err)
+		}
+		logger.Printf("replayed %s %s (%d trailers): %s, recorded %d", req.Method, req.URL, len(entry.Request.Trailers), resp.Status, entry.Response.Status)
+		if *verbose {
+			dumpHeader("< ", resp.Trailer)
+		}
+	}
+	return nil
+} // replay() func
+
+// writeHAR writes what rec recorded to the -har file
+func writeHAR(rec *trailerhttp.HARRecorder) {
+	f, err := os.Create(*harFile)
+	if err == nil {
+		err = errors.Join(rec.WriteHAR(f), f.Close())
+	}
+	if err != nil {
+		logger.Fatalf("writing %s: %v", *harFile, err)
+	}
+} // writeHAR() func
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] URL\n       %s -replay file [flags] [URL]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 && (*replayFile == "" || flag.NArg() > 1) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := http.DefaultClient
+	if *insecure {
+		client = &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+	}
+	var rec *trailerhttp.HARRecorder
+	if *harFile != "" {
+		rec = &trailerhttp.HARRecorder{Base: client.Transport, KeepSecrets: *harSecrets}
+		client = &http.Client{Transport: rec}
+	}
+	if *replayFile != "" {
+		err := replay(context.Background(), client, flag.Arg(0))
+		if rec != nil {
+			writeHAR(rec)
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	algos, err := parseAlgos(*algorithms)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	src, err := openBody(*bodyFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer src.Close()
+	if *rawMode {
+		if err := sendRaw(flag.Arg(0), src, algos); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	resp, err := send(context.Background(), client, flag.Arg(0), src, algos)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if *verbose {
+		logger.Printf("< %s %s", resp.Proto, resp.Status)
+		dumpHeader("< ", resp.Header)
+	}
+	if *include {
+		fmt.Printf("%s %s\r\n", resp.Proto, resp.Status)
+		resp.Header.Write(os.Stdout)
+		fmt.Print("\r\n")
+	}
+
+	body := trailerhttp.NewVerifiedResponse(resp, hmacKey())
+	if _, err := io.Copy(os.Stdout, body); err != nil {
+		logger.Fatalf("reading response: %v", err)
+	}
+	if *verbose {
+		dumpHeader("< ", resp.Trailer)
+	}
+	if *include {
+		resp.Trailer.Write(os.Stdout)
+	}
+	if rec != nil {
+		writeHAR(rec)
+	}
+	if *failOnError && resp.StatusCode >= http.StatusBadRequest {
+		os.Exit(22)
+	}
+} // main
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// rawMode writes the request by hand over a TCP connection instead of through net/http
+var rawMode = flag.Bool("raw", false, "write the request line, headers, chunked body and trailers by hand over a raw TCP connection (http:// only)")
+
+// violations and the flags after it break the framing of a -raw request on purpose
+var violations = flag.String("violate", "", "comma-separated framing violations of a -raw request: "+strings.Join(slices.Sorted(maps.Keys(violationFlags)), ", "))
+
+var (
+	rawChunkSize   = flag.Int("raw-chunk-size", 0, "body bytes per chunk of a -raw request (0 sends the body in one chunk)")
+	chunkSizeDelta = flag.Int("chunk-size-delta", 0, "declare every chunk of a -raw request this many bytes longer (negative: shorter) than it is")
+	chunkExtension = flag.String("chunk-ext", "", "append this to every chunk size line of a -raw request, e.g. ';name=value'")
+	writeSize      = flag.Int("write-size", 0, "write a -raw request in pieces of this many bytes (1: byte by byte)")
+	writeDelay     = flag.Duration("write-delay", 0, "pause between the pieces of -write-size")
+	rawTimeout     = flag.Duration("raw-timeout", 30*time.Second, "maximum wait for the server to answer a -raw request")
+)
+
+// violationFlags maps the -violate names to the violation they switch on
+var violationFlags = map[string]func(*trailerhttp.RawViolations){
+	"bare-lf":            func(v *trailerhttp.RawViolations) { v.BareLF = true },
+	"unannounced":        func(v *trailerhttp.RawViolations) { v.Unannounced = true },
+	"no-last-chunk":      func(v *trailerhttp.RawViolations) { v.OmitLastChunk = true },
+	"space-before-colon": func(v *trailerhttp.RawViolations) { v.SpaceBeforeColon = true },
+	"fold":               func(v *trailerhttp.RawViolations) { v.FoldTrailers = true },
+	"unterminated":       func(v *trailerhttp.RawViolations) { v.Unterminated = true },
+}
+
+// rawRequest builds the -raw request to target from the flags, body and computed trailers
+func rawRequest(target *url.URL, body []byte, algos []trailerhttp.TrailerAlgo) (*trailerhttp.RawRequest, error) {
+	req := &trailerhttp.RawRequest{
+		Method:     *method,
+		Target:     target.RequestURI(),
+		Host:       target.Host,
+		Body:       body,
+		ChunkSize:  *rawChunkSize,
+		WriteSize:  *writeSize,
+		WriteDelay: *writeDelay,
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			req.Header = append(req.Header, trailerhttp.RawField{Name: name, Value: value})
+		}
+	}
+	req.Header = append(req.Header, trailerhttp.RawField{Name: "TE", Value: "trailers"})
+	computed := trailerhttp.ComputeTrailers(body, algos...)
+	for name, values := range trailers {
+		if computed.Get(name) == "" {
+			computed[name] = values
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(computed)) {
+		for _, value := range computed[name] {
+			req.Trailer = append(req.Trailer, trailerhttp.RawField{Name: name, Value: value})
+		}
+	}
+	req.Violations = trailerhttp.RawViolations{ChunkSizeDelta: *chunkSizeDelta, ChunkExtension: *chunkExtension}
+	for _, name := range strings.Split(*violations, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		set, ok := violationFlags[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown violation %q", name)
+		}
+		set(&req.Violations)
+	}
+	return req, nil
+} // rawRequest() func
+
+// sendRaw sends src to rawURL with -raw, writes the response body to stdout and logs where the
+// server's framing is wrong
+func sendRaw(rawURL string, src io.Reader, algos []trailerhttp.TrailerAlgo) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if target.Scheme != "http" {
+		return fmt.Errorf("-raw needs an http:// URL, not %q", rawURL)
+	}
+	addr := target.Host
+	if target.Port() == "" {
+		addr = net.JoinHostPort(target.Hostname(), "80")
+	}
+	body, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	req, err := rawRequest(target, body, algos)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *rawTimeout)
+	defer cancel()
+	raw, err := trailerhttp.SendRaw(ctx, addr, req)
+	if *verbose && raw != nil {
+		trailerhttp.FormatHTTP1(os.Stderr, "> ", raw.Sent)
+		for _, ferr := range trailerhttp.CheckHTTP1Framing(raw.Sent) {
+			logger.Printf("> violation: %v", &ferr)
+		}
+		trailerhttp.FormatHTTP1(os.Stderr, "< ", raw.Received)
+	}
+	if raw != nil {
+		for _, ferr := range raw.Framing {
+			logger.Printf("response framing: %v", &ferr)
+		}
+	}
+	if err != nil {
+		if raw != nil && len(raw.Received) == 0 {
+			return fmt.Errorf("the server sent nothing back: %w", err)
+		}
+		return err
+	}
+	resp := raw.Response
+	if *include {
+		fmt.Printf("%s %s\r\n", resp.Proto, resp.Status)
+		resp.Header.Write(os.Stdout)
+		fmt.Print("\r\n")
+	}
+	os.Stdout.Write(raw.Body)
+	if *include {
+		resp.Trailer.Write(os.Stdout)
+	}
+	if *failOnError && resp.StatusCode >= http.StatusBadRequest {
+		os.Exit(22)
+	}
+	return nil
+} // sendRaw() func
@@ -0,0 +1,202 @@
+// trailerload fires concurrent streamed uploads with integrity trailers at a trailer-verifying
+// endpoint and reports their latency percentiles and how many failed verification:
+//
+//	trailerload -n 1000 -c 50 http://localhost:8080/
+//	trailerload -n 500 -rate 100 -size 4k-1m -algs length,sha256 https://example.com/upload
+//	trailerload -size exp:256k -corrupt X-Body-SHA256 -json http://localhost:8080/
+//
+// A body size is a number of bytes with an optional k, m or g suffix; "lo-hi" draws sizes
+// uniformly between two, and "exp:mean" from an exponential distribution. It exits with
+// status 1 if any upload failed verification or got no response.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// load configures the uploads
+var (
+	requests    = flag.Int("n", 100, "uploads to send in total")
+	concurrency = flag.Int("c", 10, "uploads in flight at once")
+	rate        = flag.Float64("rate", 0, "uploads started per second at most; 0 means as fast as they complete")
+	size        = flag.String("size", "64k", "body size: N, lo-hi (uniform) or exp:mean, with an optional k, m or g suffix")
+	seed        = flag.Uint64("seed", 1, "seed of the body sizes and contents, to repeat a run")
+)
+
+// algorithms selects the computed integrity trailers
+var algorithms = flag.String("algs", "length,sha256", "comma-separated integrity trailers to send: "+strings.Join(trailerhttp.Algorithms(), ", "))
+
+// hmacKeyFlag is the shared secret for the hmac-sha256 trailer
+var hmacKeyFlag = flag.String("hmac-key", "", "shared secret for the hmac-sha256 trailer (default $TRAILER_HMAC_KEY)")
+
+// corrupt deliberately damages a trailer of every upload, to watch the failures get counted
+var corrupt = flag.String("corrupt", "", "alter the value of this trailer field in every upload, e.g. X-Body-SHA256")
+
+// h2c and insecure select the transport
+var (
+	h2c      = flag.Bool("h2c", false, "upload over cleartext HTTP/2 with prior knowledge")
+	insecure = flag.Bool("k", false, "skip TLS certificate verification")
+)
+
+// jsonOutput prints the report as JSON
+var jsonOutput = flag.Bool("json", false, "print the report as JSON")
+
+// timeout bounds the whole run
+var timeout = flag.Duration("timeout", 10*time.Minute, "maximum duration of the run")
+
+// logger receives diagnostics; stdout is reserved for the report
+var logger = log.New(os.Stderr, "trailerload: ", 0)
+
+// hmacKey returns the shared HMAC secret; the environment variable keeps it out of the process list
+func hmacKey() []byte {
+	if *hmacKeyFlag != "" {
+		return []byte(*hmacKeyFlag)
+	}
+	return []byte(os.Getenv("TRAILER_HMAC_KEY"))
+} // hmacKey() func
+
+// parseSize parses a byte count with an optional k, m or g suffix
+func parseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		unit = 1 << 30
+	}
+	n, err := strconv.ParseInt(strings.TrimRight(s, "kmg"), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+} // parseSize() func
+
+// parseDistribution parses the -size flag
+func parseDistribution(spec string) (trailerhttp.SizeDistribution, error) {
+	if mean, ok := strings.CutPrefix(spec, "exp:"); ok {
+		n, err := parseSize(mean)
+		if err != nil {
+			return nil, err
+		}
+		return trailerhttp.ExponentialSize(n), nil
+	}
+	if lo, hi, ok := strings.Cut(spec, "-"); ok {
+		a, err := parseSize(lo)
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseSize(hi)
+		if err != nil {
+			return nil, err
+		}
+		if a > b {
+			return nil, fmt.Errorf("size range %q ends below its start", spec)
+		}
+		return trailerhttp.UniformSize(a, b), nil
+	}
+	n, err := parseSize(spec)
+	if err != nil {
+		return nil, err
+	}
+	return trailerhttp.FixedSize(n), nil
+} // parseDistribution() func
+
+// httpClient returns the HTTP client of the -h2c and -k flags
+func httpClient() *http.Client {
+	if *h2c {
+		return trailerhttp.NewH2CClient()
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency
+	if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport}
+} // httpClient() func
+
+// printReport writes a human-readable summary of report
+func printReport(url string, report *trailerhttp.LoadReport) {
+	fmt.Printf("target:      %s\n", url)
+	fmt.Printf("uploads:     %d in %v (%.1f/s, %.1f MiB/s)\n", report.Requests, report.Elapsed.Round(time.Millisecond), report.Rate, report.Throughput/(1<<20))
+	fmt.Printf("verified:    %d\n", report.Verified)
+	fmt.Printf("failed:      %d verification, %d rejected otherwise, %d without a response\n", report.VerificationFailures, report.Rejected, report.Errors)
+	if report.FirstError != "" {
+		fmt.Printf("first error: %s\n", report.FirstError)
+	}
+	for _, outcome := range slices.Sorted(maps.Keys(report.Outcomes)) {
+		fmt.Printf("  %-26s %d\n", outcome, report.Outcomes[outcome])
+	}
+	l := report.Latency
+	fmt.Printf("latency:     mean %v, p50 %v, p90 %v, p99 %v, max %v\n", l.Mean.Round(time.Microsecond),
+		l.P50.Round(time.Microsecond), l.P90.Round(time.Microsecond), l.P99.Round(time.Microsecond), l.Max.Round(time.Microsecond))
+} // printReport() func
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] URL\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	url := flag.Arg(0)
+	if *requests <= 0 || *concurrency <= 0 || *rate < 0 {
+		logger.Fatal(errors.New("-n and -c must be positive, and -rate not negative"))
+	}
+	dist, err := parseDistribution(*size)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	client := &trailerhttp.Client{
+		HTTPClient: httpClient(),
+		Algorithms: strings.Split(*algorithms, ","),
+		HMACKey:    hmacKey(),
+	}
+	if *corrupt != "" {
+		client.Fault = &trailerhttp.Fault{CorruptTrailer: *corrupt}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	report := client.LoadTest(ctx, url, trailerhttp.LoadOptions{
+		Requests:    *requests,
+		Concurrency: *concurrency,
+		Rate:        *rate,
+		Size:        dist,
+		Seed:        *seed,
+	})
+
+	if *jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		printReport(url, report)
+	}
+	if report.VerificationFailures > 0 || report.Errors > 0 {
+		cancel()
+		os.Exit(1)
+	}
+} // main
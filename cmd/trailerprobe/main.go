@@ -0,0 +1,182 @@
+// trailerprobe checks whether the load balancers, CDNs and proxies in front of a service
+// pass HTTP trailers through. Run the echo endpoint behind them, then probe it from outside:
+//
+//	trailerprobe -serve :8081
+//	trailerprobe https://edge.example.com/trailer-echo
+//
+// The probe sends a streamed body with integrity trailers and a token trailer, and prints
+// which fields arrived, in each direction. It exits with status 1 if any was lost or altered.
+//
+// With -reuse it instead checks, against a server of its own on a loopback port, that
+// trailers fully leave a keep-alive connection before the next request takes it, whatever
+// the handler read of the body; it exits with status 1 if a scenario failed:
+//
+//	trailerprobe -reuse
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"trailer_header/trailerhttp"
+)
+
+// serveAddr runs the echo endpoint instead of probing
+var serveAddr = flag.String("serve", "", "serve the echo endpoint on this address instead of probing a URL")
+
+// reuse checks keep-alive connection reuse with trailers instead of probing
+var reuse = flag.Bool("reuse", false, "check that trailers never corrupt the next request on a keep-alive connection, instead of probing a URL")
+
+// jsonOutput prints the report as JSON
+var jsonOutput = flag.Bool("json", false, "print the report as JSON")
+
+// insecure skips certificate checks, for targets with self-signed certificates
+var insecure = flag.Bool("k", false, "skip TLS certificate verification")
+
+// timeout bounds the whole probe
+var timeout = flag.Duration("timeout", 30*time.Second, "maximum duration of the probe")
+
+// logger receives diagnostics; stdout is reserved for the report
+var logger = log.New(os.Stderr, "trailerprobe: ", 0)
+
+// serve runs the echo endpoint until the process is killed
+func serve(addr string) {
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     trailerhttp.EchoHandler(),
+		ReadTimeout: trailerhttp.DefaultReadTimeout,
+		Protocols:   new(http.Protocols),
+	}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	logger.Printf("serving the echo endpoint on %s", addr)
+	logger.Fatal(server.ListenAndServe())
+} // serve() func
+
+// printReport writes a human-readable summary of report
+func printReport(report *trailerhttp.ProbeReport) {
+	fmt.Printf("target:  %s\n", report.URL)
+	fmt.Printf("framing: sent streamed, arrived as %s (can carry trailers: %v, body intact: %v)\n",
+		report.ArrivedProto, report.ArrivedFraming, report.BodyIntact)
+	fmt.Println("request trailers:")
+	for _, field := range report.Fields {
+		status := "ok"
+		switch {
+		case !field.Arrived:
+			status = "STRIPPED"
+		case !field.Intact:
+			status = fmt.Sprintf("ALTERED (received %q)", field.Received)
+		}
+		fmt.Printf("  %-20s %s\n", field.Name, status)
+	}
+	fmt.Println("request trailers mirrored back as response trailers:")
+	for _, field := range report.Fields {
+		status := "ok"
+		if !field.Mirrored {
+			status = "STRIPPED or ALTERED"
+		}
+		fmt.Printf("  %-20s %s\n", field.Name, status)
+	}
+	fmt.Printf("response trailer (over %s):\n", report.Proto)
+	switch {
+	case report.ResponseTrailerOK:
+		fmt.Println("  X-Probe-Echo         ok")
+	case report.ResponseTrailerSent:
+		fmt.Println("  X-Probe-Echo         STRIPPED or ALTERED (announced, value lost)")
+	default:
+		fmt.Println("  X-Probe-Echo         STRIPPED (not announced)")
+	}
+} // printReport() func
+
+// checkReuse runs trailerhttp.CheckConnectionReuse, prints its results and exits with status 1
+// if one is not OK
+func checkReuse(ctx context.Context) {
+	results, err := trailerhttp.CheckConnectionReuse(ctx)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *jsonOutput {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "scenario\treused\tfollow-up verified\tstatus")
+		for _, r := range results {
+			status := "ok"
+			switch {
+			case r.Error != "":
+				status = r.Error
+			case !r.OK():
+				status = fmt.Sprintf("WRONG (connection should be reused: %v)", r.WantReused)
+			}
+			fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n", r.Scenario, r.Reused, r.Verified, status)
+		}
+		tw.Flush()
+	}
+	for _, r := range results {
+		if !r.OK() {
+			os.Exit(1)
+		}
+	}
+} // checkReuse() func
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] URL\n       %s -serve ADDR\n       %s -reuse\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *serveAddr != "" {
+		serve(*serveAddr)
+		return
+	}
+	if *reuse {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		checkReuse(ctx)
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := http.DefaultClient
+	if *insecure {
+		client = &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	report, err := trailerhttp.Probe(ctx, client, flag.Arg(0))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if *jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		printReport(report)
+	}
+	if !report.Survived() {
+		cancel()
+		os.Exit(1)
+	}
+} // main
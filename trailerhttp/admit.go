@@ -0,0 +1,82 @@
+package trailerhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RequireTrailers returns an Admit hook rejecting with 400 Bad Request any upload that does not
+// announce every one of names, before its body is read; a client that sent
+// "Expect: 100-continue" then never transmits the body.
+func RequireTrailers(names ...string) func(r *http.Request) (status int, reason string) {
+	return func(r *http.Request) (int, string) {
+		announced := AnnouncedTrailers(r)
+		var missing []string
+		for _, name := range names {
+			if !slices.Contains(announced, http.CanonicalHeaderKey(name)) {
+				missing = append(missing, http.CanonicalHeaderKey(name))
+			}
+		}
+		if len(missing) > 0 {
+			return http.StatusBadRequest, fmt.Sprintf("request does not announce the required trailers %s", strings.Join(missing, ", "))
+		}
+		return 0, ""
+	}
+} // RequireTrailers() func
+
+// continueGate is the body of an "Expect: 100-continue" request. The transport reads it only once
+// the server has answered "100 Continue", and closes it unread when the server rejects the
+// upload first, so the body source is left untouched until the server wants the body.
+type continueGate struct {
+	*io.PipeReader
+	read, closed         chan struct{}
+	readOnce, closedOnce sync.Once
+}
+
+func newContinueGate(pr *io.PipeReader) *continueGate {
+	return &continueGate{PipeReader: pr, read: make(chan struct{}), closed: make(chan struct{})}
+} // newContinueGate() func
+
+func (g *continueGate) Read(p []byte) (int, error) {
+	g.readOnce.Do(func() { close(g.read) })
+	return g.PipeReader.Read(p)
+}
+
+func (g *continueGate) Close() error {
+	g.closedOnce.Do(func() { close(g.closed) })
+	return g.PipeReader.Close()
+}
+
+// wait blocks until the transport asks for the body and reports whether it did
+func (g *continueGate) wait(ctx context.Context) bool {
+	select {
+	case <-g.read:
+		return true
+	case <-g.closed:
+	case <-ctx.Done():
+	}
+	return false
+} // wait() func
+
+// withheld reports whether the body of req was never asked for
+func withheld(req *http.Request) bool {
+	body := req.Body
+	if cb, ok := body.(*chunkBody); ok {
+		body = cb.ReadCloser
+	}
+	g, ok := body.(*continueGate)
+	if !ok {
+		return false
+	}
+	select {
+	case <-g.read:
+		return false
+	default:
+		return true
+	}
+} // withheld() func
@@ -0,0 +1,248 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// S3's aws-chunked encoding (STREAMING-UNSIGNED-PAYLOAD-TRAILER) frames the body itself:
+//
+//	10000\r\n<65536 bytes>\r\n ... 0\r\nx-amz-checksum-crc32c:<base64>\r\n\r\n
+//
+// so the checksum "trailer" travels inside a plain Content-Length body, announced by the
+// x-amz-trailer header. Signed variants add ";chunk-signature=..." to each size line.
+const (
+	awsChunkedEncoding     = "aws-chunked"
+	awsUnsignedTrailer     = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	awsChunkSize           = 64 << 10
+	awsMaxTrailerBytes     = 8 << 10 // bounds the trailer section a client can make the server buffer
+	awsChecksumFieldPrefix = "X-Amz-Checksum-"
+	awsDecodedLengthHeader = "X-Amz-Decoded-Content-Length"
+	awsTrailerHeader       = "X-Amz-Trailer"
+	awsContentSHA256Header = "X-Amz-Content-Sha256"
+)
+
+// awsChecksums are the x-amz-checksum algorithms, by the name used in x-amz-checksum-<name>
+var awsChecksums = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// errMalformedAWSChunked reports an aws-chunked body that does not follow the framing
+var errMalformedAWSChunked = errors.New("malformed aws-chunked body")
+
+// base64Digest is a hashDigest whose trailer value is the base64 sum, as S3 checksums are
+type base64Digest struct {
+	hash.Hash
+}
+
+func (d *base64Digest) Value() string { return base64.StdEncoding.EncodeToString(d.Sum(nil)) }
+
+func (d *base64Digest) Matches(reported string) (bool, error) {
+	sum, err := decodeDigest(reported, d.Size())
+	if err != nil {
+		return false, err
+	}
+	return string(sum) == string(d.Sum(nil)), nil
+}
+
+// isAWSChunked reports whether r carries an aws-chunked body
+func isAWSChunked(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if strings.EqualFold(strings.TrimSpace(coding), awsChunkedEncoding) {
+			return true
+		}
+	}
+	return strings.HasPrefix(r.Header.Get(awsContentSHA256Header), "STREAMING-")
+} // isAWSChunked() func
+
+// announceAWSTrailers declares the fields listed in x-amz-trailer on r.Trailer, so the
+// handler treats them like trailers announced with a Trailer header
+func announceAWSTrailers(r *http.Request) {
+	for _, name := range strings.Split(r.Header.Get(awsTrailerHeader), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if r.Trailer == nil {
+				r.Trailer = http.Header{}
+			}
+			r.Trailer[http.CanonicalHeaderKey(name)] = nil
+		}
+	}
+} // announceAWSTrailers() func
+
+// awsChunkedReader decodes an aws-chunked body. At the final chunk it stores the trailer
+// section in *trailer and checks x-amz-decoded-content-length, before returning io.EOF.
+type awsChunkedReader struct {
+	br        *bufio.Reader
+	trailer   *http.Header
+	declared  int64 // x-amz-decoded-content-length, or -1
+	remaining int64 // bytes left in the current chunk
+	decoded   int64
+	done      bool
+}
+
+// newAWSChunkedReader decodes body, the aws-chunked body of r
+func newAWSChunkedReader(r *http.Request, body io.Reader) *awsChunkedReader {
+	declared, err := strconv.ParseInt(r.Header.Get(awsDecodedLengthHeader), 10, 64)
+	if err != nil {
+		declared = -1
+	}
+	return &awsChunkedReader{br: bufio.NewReader(body), trailer: &r.Trailer, declared: declared}
+} // newAWSChunkedReader() func
+
+func (ar *awsChunkedReader) Read(p []byte) (int, error) {
+	if ar.done {
+		return 0, io.EOF
+	}
+	if ar.remaining == 0 {
+		if err := ar.nextChunk(); err != nil {
+			return 0, err
+		}
+		if ar.done {
+			return 0, io.EOF
+		}
+	}
+	n, err := ar.br.Read(p[:min(int64(len(p)), ar.remaining)])
+	ar.remaining -= int64(n)
+	ar.decoded += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && ar.remaining == 0 {
+		err = ar.expectCRLF()
+	}
+	return n, err
+} // Read() func
+
+// nextChunk reads a chunk size line, and the trailer section after the final chunk
+func (ar *awsChunkedReader) nextChunk() error {
+	line, err := ar.line()
+	if err != nil {
+		return err
+	}
+	sizeField, _, _ := strings.Cut(line, ";") // chunk-signature extensions are not checked
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w: bad chunk size line %q", errMalformedAWSChunked, line)
+	}
+	if size > 0 {
+		ar.remaining = size
+		return nil
+	}
+
+	ar.done = true
+	for read := 0; ; {
+		line, err := ar.line()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		if read += len(line); read > awsMaxTrailerBytes {
+			return fmt.Errorf("%w: trailer section exceeds %d bytes", errMalformedAWSChunked, awsMaxTrailerBytes)
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("%w: bad trailer line %q", errMalformedAWSChunked, line)
+		}
+		if *ar.trailer == nil {
+			*ar.trailer = http.Header{}
+		}
+		ar.trailer.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if ar.declared >= 0 && ar.declared != ar.decoded {
+		return fmt.Errorf("%w: %d bytes decoded, %s says %d", errMalformedAWSChunked, ar.decoded, awsDecodedLengthHeader, ar.declared)
+	}
+	return nil
+} // nextChunk() func
+
+// line reads one CRLF-terminated line, without the line ending
+func (ar *awsChunkedReader) line() (string, error) {
+	line, err := ar.br.ReadString('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+} // line() func
+
+// expectCRLF consumes the line ending after a chunk's data
+func (ar *awsChunkedReader) expectCRLF() error {
+	line, err := ar.line()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return fmt.Errorf("%w: chunk data longer than its size", errMalformedAWSChunked)
+	}
+	return nil
+} // expectCRLF() func
+
+// NewAWSChunkedRequest returns a request uploading size bytes from src in S3's aws-chunked
+// encoding with an unsigned payload and an x-amz-checksum-<checksum> trailer, where checksum
+// is "crc32", "crc32c", "sha1" or "sha256". Unlike a chunked HTTP/1.1 body it has a
+// Content-Length, as S3 requires; the checksum is computed while the body streams. The
+// request still needs SigV4 header signing before it is sent to S3 itself.
+func NewAWSChunkedRequest(ctx context.Context, method, url string, src io.Reader, size int64, checksum string) (*http.Request, error) {
+	newHash, ok := awsChecksums[checksum]
+	if !ok {
+		return nil, fmt.Errorf("unknown S3 checksum %q (want crc32, crc32c, sha1 or sha256)", checksum)
+	}
+	trailerName := strings.ToLower(awsChecksumFieldPrefix) + checksum
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, method, url, pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Encoding", awsChunkedEncoding)
+	req.Header.Set(awsContentSHA256Header, awsUnsignedTrailer)
+	req.Header.Set(awsDecodedLengthHeader, strconv.FormatInt(size, 10))
+	req.Header.Set(awsTrailerHeader, trailerName)
+
+	// The encoded length is known upfront: each chunk adds its hex size line and two CRLFs
+	h := newHash()
+	encoded := int64(0)
+	for left := size; left > 0; left -= awsChunkSize {
+		n := min(left, awsChunkSize)
+		encoded += int64(len(strconv.FormatInt(n, 16))) + 2 + n + 2
+	}
+	encoded += int64(len("0\r\n" + trailerName + ":" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + "\r\n\r\n"))
+	req.ContentLength = encoded
+
+	go func() {
+		bw := bufio.NewWriterSize(pw, awsChunkSize+64)
+		buf := make([]byte, awsChunkSize)
+		var sent int64
+		for sent < size {
+			n, err := io.ReadFull(src, buf[:min(size-sent, awsChunkSize)])
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("%w: %w", ErrBodyStream, err))
+				return
+			}
+			h.Write(buf[:n])
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(buf[:n])
+			bw.WriteString("\r\n")
+			sent += int64(n)
+		}
+		fmt.Fprintf(bw, "0\r\n%s:%s\r\n\r\n", trailerName, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+		pw.CloseWithError(bw.Flush()) // a nil error closes the pipe normally
+	}()
+	return req, nil
+} // NewAWSChunkedRequest() func
@@ -0,0 +1,333 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// BenchMode is a way of sending an upload that RunBenchmark measures
+type BenchMode string
+
+const (
+	// BenchTrailers streams the body with integrity trailers, as Client.SendStream does, to a Handler
+	BenchTrailers BenchMode = "trailers"
+	// BenchBuffered reads the whole body into memory and hashes it first, then sends it with
+	// Content-Length and its SHA-256 in an X-Body-SHA256 header, the way to get integrity without trailers
+	BenchBuffered BenchMode = "buffered"
+	// BenchNone streams the body without any integrity check, the baseline
+	BenchNone BenchMode = "none"
+)
+
+// BenchModes lists the modes RunBenchmark measures, in the order it reports them
+var BenchModes = []BenchMode{BenchTrailers, BenchBuffered, BenchNone}
+
+// DefaultBenchSizes are the body sizes RunBenchmark measures by default
+var DefaultBenchSizes = []int64{1 << 10, 64 << 10, 1 << 20, 16 << 20}
+
+// BenchOptions configures RunBenchmark
+type BenchOptions struct {
+	Sizes      []int64  // body sizes to measure; nil means DefaultBenchSizes
+	Iterations int      // measured uploads per mode and size, after one warm-up; 0 means 10
+	Algorithms []string // trailers of BenchTrailers; nil means "sha256", the digest BenchBuffered sends
+	H2C        bool     // upload over cleartext HTTP/2 instead of HTTP/1.1
+}
+
+// BenchResult is the measurement of one mode at one body size. The allocations count both
+// ends, as the server runs in the same process.
+type BenchResult struct {
+	Mode       BenchMode     `json:"mode"`
+	Size       int64         `json:"size"`
+	Iterations int           `json:"iterations"`
+	Latency    time.Duration `json:"latency_ns"`    // mean time from the start of an upload to its response
+	FirstByte  time.Duration `json:"first_byte_ns"` // mean time until the first body byte went to the transport
+	Throughput float64       `json:"throughput"`    // body bytes per second
+	AllocBytes uint64        `json:"alloc_bytes"`   // bytes allocated per upload
+	Allocs     uint64        `json:"allocs"`        // allocations per upload
+}
+
+// RunBenchmark uploads generated bodies of every size, in every BenchMode, to a server it runs
+// on a loopback port for the purpose, and reports what each cost. The bodies are produced while
+// being sent, as a stream from a file or a pipe would be, so BenchBuffered pays for holding one
+// whole and sends its first byte only after the last was read, where the streamed modes start at once.
+func RunBenchmark(ctx context.Context, opts BenchOptions) ([]BenchResult, error) {
+	if opts.Sizes == nil {
+		opts.Sizes = DefaultBenchSizes
+	}
+	if opts.Iterations == 0 {
+		opts.Iterations = 10
+	}
+	if opts.Algorithms == nil {
+		opts.Algorithms = []string{"sha256"}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	quiet := slog.New(slog.DiscardHandler)
+	key := []byte("trailerhttp benchmark") // for "hmac-sha256", both ends being here
+	mux := http.NewServeMux()
+	mux.Handle("/trailers", NewHandler(ServerOptions{HMACKey: key, Logger: quiet}))
+	mux.HandleFunc("/buffered", benchBufferedHandler)
+	mux.HandleFunc("/none", benchNoneHandler)
+	server := &http.Server{Handler: mux, Protocols: serverProtocols(), ErrorLog: slog.NewLogLogger(quiet.Handler(), slog.LevelError)}
+	go server.Serve(ln)
+	defer server.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	if opts.H2C {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		transport.Protocols.SetHTTP1(true)
+	}
+	defer transport.CloseIdleConnections()
+	b := &bench{
+		base:   "http://" + ln.Addr().String(),
+		http:   &http.Client{Transport: transport},
+		client: Client{HTTPClient: &http.Client{Transport: transport}, Algorithms: opts.Algorithms, HMACKey: key, Logger: quiet},
+	}
+
+	var results []BenchResult
+	for _, size := range opts.Sizes {
+		for _, mode := range BenchModes {
+			result, err := b.measure(ctx, mode, size, opts.Iterations)
+			if err != nil {
+				return results, fmt.Errorf("%s upload of %d bytes: %w", mode, size, err)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+} // RunBenchmark() func
+
+// bench uploads to the server of a RunBenchmark
+type bench struct {
+	base   string
+	http   *http.Client
+	client Client
+}
+
+// measure runs a warm-up upload and then iterations measured ones of mode and size
+func (b *bench) measure(ctx context.Context, mode BenchMode, size int64, iterations int) (BenchResult, error) {
+	if _, err := b.upload(ctx, mode, size); err != nil {
+		return BenchResult{}, err
+	}
+	result := BenchResult{Mode: mode, Size: size, Iterations: iterations}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for range iterations {
+		firstByte, err := b.upload(ctx, mode, size)
+		if err != nil {
+			return result, err
+		}
+		result.FirstByte += firstByte
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	n := time.Duration(iterations)
+	result.Latency, result.FirstByte = elapsed/n, result.FirstByte/n
+	result.Throughput = float64(size) * float64(iterations) / elapsed.Seconds()
+	result.AllocBytes = (after.TotalAlloc - before.TotalAlloc) / uint64(iterations)
+	result.Allocs = (after.Mallocs - before.Mallocs) / uint64(iterations)
+	return result, nil
+} // measure() func
+
+// upload sends one generated body of size in mode and returns the time its first byte took
+func (b *bench) upload(ctx context.Context, mode BenchMode, size int64) (time.Duration, error) {
+	start := time.Now()
+	src := io.LimitReader(rand.NewChaCha8([32]byte{}), size)
+	var firstByte atomic.Int64
+	mark := func() { firstByte.CompareAndSwap(0, int64(time.Since(start))) }
+	switch mode {
+	case BenchTrailers:
+		client := b.client
+		client.ProgressFunc = func(int64) { mark() }
+		result, err := client.SendStream(ctx, b.base+"/trailers", src)
+		if err == nil && !result.Matched {
+			err = fmt.Errorf("server reported %s: %s", result.Outcome, result.Error)
+		}
+		return time.Duration(firstByte.Load()), err
+	case BenchBuffered:
+		body, err := io.ReadAll(src)
+		if err != nil {
+			return 0, err
+		}
+		sum := sha256.Sum256(body)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.base+"/buffered", &firstRead{bytes.NewReader(body), mark})
+		if err != nil {
+			return 0, err
+		}
+		req.ContentLength = size
+		req.Header.Set("X-Body-SHA256", hex.EncodeToString(sum[:]))
+		err = b.do(req)
+		return time.Duration(firstByte.Load()), err
+	default:
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.base+"/none", &firstRead{src, mark})
+		if err != nil {
+			return 0, err
+		}
+		err = b.do(req)
+		return time.Duration(firstByte.Load()), err
+	}
+} // upload() func
+
+// do sends req and drains the response, which must be 204 No Content
+func (b *bench) do(req *http.Request) error {
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+} // do() func
+
+// firstRead calls mark on every read of r that returns bytes. The transport reads a request
+// body as it sends it, so the first call tells when the first byte went out.
+type firstRead struct {
+	r    io.Reader
+	mark func()
+}
+
+func (f *firstRead) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 {
+		f.mark()
+	}
+	return n, err
+}
+
+// benchBufferedHandler checks the body of a BenchBuffered upload against the SHA-256 of its header
+func benchBufferedHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hex.EncodeToString(h.Sum(nil)) != r.Header.Get("X-Body-SHA256") {
+		http.Error(w, "body does not match X-Body-SHA256", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+} // benchBufferedHandler() func
+
+// benchNoneHandler reads the body of a BenchNone upload and checks nothing
+func benchNoneHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+} // benchNoneHandler() func
+
+// DigestBenchResult measures one way of computing the integrity trailers of a body as it streams
+type DigestBenchResult struct {
+	Method        string  `json:"method"` // "pipeline" or "tee-chain"
+	Size          int64   `json:"size"`
+	Iterations    int     `json:"iterations"`
+	Throughput    float64 `json:"throughput"`      // body bytes digested per second
+	AllocBytes    uint64  `json:"alloc_bytes"`     // bytes allocated per body
+	Allocs        uint64  `json:"allocs"`          // allocations per body
+	AllocsPerRead float64 `json:"allocs_per_read"` // allocations per read of the source
+}
+
+// RunDigestBenchmark computes the trailers of algorithms, with the length, over iterations bodies
+// of size bytes in memory, the way this package streams a body: every digest a writer of one
+// pass through a pooled buffer ("pipeline"); and the way a handler often does it: an io.TeeReader
+// per digest, read with io.ReadAll ("tee-chain"). The pipeline allocates nothing per read, so its
+// allocations stay the same whatever the size.
+func RunDigestBenchmark(size int64, algorithms []string, iterations int) ([]DigestBenchResult, error) {
+	if iterations == 0 {
+		iterations = 10
+	}
+	if algorithms == nil {
+		algorithms = []string{"sha256"}
+	}
+	var algos []TrailerAlgo
+	for _, name := range algorithms {
+		if _, err := lookupVerifier(name); err != nil {
+			return nil, err
+		}
+		algos = append(algos, TrailerAlgo{algorithm: name, key: []byte("trailerhttp benchmark")})
+	}
+	block := make([]byte, 32<<10)
+	rand.NewChaCha8([32]byte{}).Read(block)
+
+	methods := []struct {
+		name   string
+		digest func(src io.Reader) error
+	}{
+		{"pipeline", func(src io.Reader) error {
+			_, err := streamBody(newTrailerSet(algos), src)
+			return err
+		}},
+		{"tee-chain", func(src io.Reader) error {
+			set := newTrailerSet(algos)
+			for _, d := range set.digests {
+				src = io.TeeReader(src, d)
+			}
+			_, err := io.ReadAll(src)
+			return err
+		}},
+	}
+	var results []DigestBenchResult
+	for _, method := range methods {
+		result := DigestBenchResult{Method: method.name, Size: size, Iterations: iterations}
+		var reads int64
+		if err := method.digest(&repeatReader{block: block, left: size, reads: new(int64)}); err != nil {
+			return nil, err // the warm-up, filling the buffer pool
+		}
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		for range iterations {
+			src := &repeatReader{block: block, left: size, reads: &reads}
+			if err := method.digest(src); err != nil {
+				return results, err
+			}
+		}
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+		result.Throughput = float64(size) * float64(iterations) / elapsed.Seconds()
+		result.AllocBytes = (after.TotalAlloc - before.TotalAlloc) / uint64(iterations)
+		result.Allocs = (after.Mallocs - before.Mallocs) / uint64(iterations)
+		result.AllocsPerRead = float64(after.Mallocs-before.Mallocs) / float64(max(reads, 1))
+		results = append(results, result)
+	}
+	return results, nil
+} // RunDigestBenchmark() func
+
+// repeatReader reads left bytes of block over and over, counting its reads
+type repeatReader struct {
+	block []byte
+	left  int64
+	reads *int64
+}
+
+func (rr *repeatReader) Read(p []byte) (int, error) {
+	if rr.left == 0 {
+		return 0, io.EOF
+	}
+	*rr.reads++
+	n := copy(p, rr.block[:min(int64(len(rr.block)), rr.left)])
+	rr.left -= int64(n)
+	return n, nil
+}
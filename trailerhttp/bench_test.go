@@ -0,0 +1,60 @@
+package trailerhttp
+
+import (
+	"io"
+	"strconv"
+	"testing"
+)
+
+// benchAlgos are the trailers the digest benchmarks compute, with the length
+var benchAlgos = []TrailerAlgo{AlgoCRC32, AlgoSHA256, AlgoHMACSHA256([]byte("benchmark key"))}
+
+// digestPipeline feeds every digest from one pass through a pooled buffer, as the Handler does
+func digestPipeline(src io.Reader) error {
+	_, err := streamBody(newTrailerSet(benchAlgos), src)
+	return err
+} // digestPipeline() func
+
+// digestTeeChain stacks an io.TeeReader per digest and reads the body with io.ReadAll
+func digestTeeChain(src io.Reader) error {
+	set := newTrailerSet(benchAlgos)
+	for _, d := range set.digests {
+		src = io.TeeReader(src, d)
+	}
+	_, err := io.ReadAll(src)
+	return err
+} // digestTeeChain() func
+
+func benchmarkDigests(b *testing.B, digest func(io.Reader) error) {
+	block := make([]byte, 32<<10)
+	for _, size := range []int64{64 << 10, 1 << 20, 16 << 20} {
+		b.Run(strconv.FormatInt(size>>10, 10)+"KiB", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+			for b.Loop() {
+				if err := digest(&repeatReader{block: block, left: size, reads: new(int64)}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+} // benchmarkDigests() func
+
+func BenchmarkDigestPipeline(b *testing.B) { benchmarkDigests(b, digestPipeline) }
+
+func BenchmarkDigestTeeChain(b *testing.B) { benchmarkDigests(b, digestTeeChain) }
+
+func TestDigestPipelineAllocations(t *testing.T) {
+	block := make([]byte, 32<<10)
+	allocs := func(size int64) float64 {
+		return testing.AllocsPerRun(5, func() {
+			digestPipeline(&repeatReader{block: block, left: size, reads: new(int64)})
+		})
+	}
+	// An allocation per read would add 512 for the larger body; sync.Pool, which drops buffers
+	// at random under the race detector, accounts for the odd one
+	small, large := allocs(64<<10), allocs(16<<20)
+	if large > small+4 {
+		t.Errorf("%v allocations for 64 KiB, %v for 16 MiB; want none per read", small, large)
+	}
+}
@@ -0,0 +1,109 @@
+package trailerhttp
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultChunkSize is the size of the chunks Client.FlushInterval gathers without Client.ChunkSize,
+// the size the transport copies a request body in by default
+const DefaultChunkSize = 32 << 10
+
+// chunkWriter gathers what is written to it into chunks of len(buf) bytes before writing them
+// to w, and writes a partial chunk once it has waited interval, when that is set. Writes larger
+// than a chunk go out in whole chunks without being copied.
+type chunkWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      []byte
+	n        int // bytes of buf in use
+	interval time.Duration
+	timer    *time.Timer // flushes the partial chunk; nil when none waits
+	err      error       // the first error writing to w; every later write fails with it
+}
+
+// newChunkWriter returns a chunkWriter of size-byte chunks over w
+func newChunkWriter(w io.Writer, size int, interval time.Duration) *chunkWriter {
+	return &chunkWriter{w: w, buf: make([]byte, size), interval: interval}
+} // newChunkWriter() func
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	written := 0
+	for len(p) > 0 && cw.err == nil {
+		if cw.n == 0 && len(p) >= len(cw.buf) {
+			var n int
+			n, cw.err = cw.w.Write(p[:len(cw.buf)])
+			written += n
+			p = p[n:]
+			continue
+		}
+		n := copy(cw.buf[cw.n:], p)
+		cw.n += n
+		written += n
+		p = p[n:]
+		if cw.n == len(cw.buf) {
+			cw.flush()
+		}
+	}
+	if cw.n > 0 && cw.interval > 0 && cw.timer == nil {
+		cw.timer = time.AfterFunc(cw.interval, func() {
+			cw.mu.Lock()
+			defer cw.mu.Unlock()
+			cw.flush()
+		})
+	}
+	return written, cw.err
+}
+
+// flush writes the partial chunk, if any; cw.mu must be held
+func (cw *chunkWriter) flush() {
+	if cw.timer != nil {
+		cw.timer.Stop()
+		cw.timer = nil
+	}
+	if cw.n == 0 || cw.err != nil {
+		return
+	}
+	_, cw.err = cw.w.Write(cw.buf[:cw.n])
+	cw.n = 0
+}
+
+// Close writes the last, partial chunk and stops the flush timer; it does not close w
+func (cw *chunkWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.flush()
+	return cw.err
+}
+
+// chunkBody is the request body a chunkWriter feeds through a pipe. It copies every chunk to
+// the transport in one write, where the transport's own copy buffer would split larger ones,
+// so over HTTP/1.1 every chunk of the chunkWriter becomes one chunk of the request.
+type chunkBody struct {
+	io.ReadCloser
+	size int
+}
+
+func (cb *chunkBody) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, cb.size)
+	var written int64
+	for {
+		n, err := cb.Read(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package chunked encodes and decodes the HTTP/1.1 chunked transfer coding (RFC 9112, section
+// 7.1) with its trailer section, over any io.Writer or io.Reader rather than through net/http:
+// a pipe, a file, a raw net.Conn or a test buffer. It frames bodies only; the request line and
+// the header section are up to the caller.
+//
+//	cw := chunked.NewWriter(conn)
+//	io.Copy(cw, src) // one chunk per write
+//	cw.Trailer = textproto.MIMEHeader{"X-Body-Sha256": {sum}}
+//	err := cw.Close() // the last chunk and the trailer section
+//
+//	cr := chunked.NewReader(br)
+//	body, err := io.ReadAll(cr)
+//	sum := cr.Trailer().Get("X-Body-SHA256")
+//
+// A textproto.MIMEHeader converts to and from an http.Header.
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Encoding and decoding failures, for errors.Is
+var (
+	ErrMalformed        = errors.New("chunked: malformed chunked encoding")
+	ErrTrailerTooLarge  = errors.New("chunked: trailer section exceeds the limit")
+	ErrInvalidField     = errors.New("chunked: invalid trailer field")
+	ErrWriteAfterClose  = errors.New("chunked: write after Close")
+	errLineTooLong      = fmt.Errorf("%w: line too long", ErrMalformed)
+	errMissingChunkCRLF = fmt.Errorf("%w: chunk data not followed by CRLF", ErrMalformed)
+)
+
+// DefaultMaxTrailerBytes bounds the trailer section a Reader accepts without MaxTrailerBytes
+const DefaultMaxTrailerBytes = 64 << 10
+
+// maxLineBytes bounds a chunk size line, extensions included
+const maxLineBytes = 4 << 10
+
+// Writer encodes what is written to it as chunks, one per Write, and ends the body with the
+// last chunk and the trailer section on Close. Each chunk goes out to the underlying writer
+// whole, size line and CRLF included, before Write returns.
+type Writer struct {
+	// Trailer holds the trailer fields Close writes; it may be filled in until then, e.g.
+	// with a digest of everything written
+	Trailer textproto.MIMEHeader
+
+	bw     *bufio.Writer
+	closed bool
+}
+
+// NewWriter returns a Writer encoding a chunked body onto w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{bw: bufio.NewWriter(w)}
+} // NewWriter() func
+
+// Write writes p as one chunk. An empty p writes nothing, as a chunk of size 0 would end the body.
+func (cw *Writer) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, ErrWriteAfterClose
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	fmt.Fprintf(cw.bw, "%x\r\n", len(p))
+	n, _ := cw.bw.Write(p)
+	cw.bw.WriteString("\r\n")
+	if err := cw.bw.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Close writes the last chunk and the trailer section with the fields of Trailer, in the order
+// of their names, and does not close the underlying writer. It fails with ErrInvalidField,
+// writing nothing, when a name is not a token or a value contains a line break.
+func (cw *Writer) Close() error {
+	if cw.closed {
+		return ErrWriteAfterClose
+	}
+	names := slices.Sorted(maps.Keys(cw.Trailer))
+	for _, name := range names {
+		if !isToken(name) {
+			return fmt.Errorf("%w: name %q", ErrInvalidField, name)
+		}
+		for _, value := range cw.Trailer[name] {
+			if strings.ContainsAny(value, "\r\n\x00") {
+				return fmt.Errorf("%w: value of %s contains a line break", ErrInvalidField, name)
+			}
+		}
+	}
+	cw.closed = true
+	cw.bw.WriteString("0\r\n")
+	for _, name := range names {
+		for _, value := range cw.Trailer[name] {
+			fmt.Fprintf(cw.bw, "%s: %s\r\n", name, strings.TrimSpace(value))
+		}
+	}
+	cw.bw.WriteString("\r\n")
+	return cw.bw.Flush()
+}
+
+// Reader decodes a chunked body, returning its data and io.EOF after the trailer section,
+// whose fields Trailer then returns. Chunk extensions are ignored, as RFC 9112 has recipients
+// do with the ones they do not recognize. Lines may end in a bare LF, as net/http accepts.
+type Reader struct {
+	// MaxTrailerBytes bounds the trailer section, field lines included; 0 means
+	// DefaultMaxTrailerBytes. A longer one fails with ErrTrailerTooLarge.
+	MaxTrailerBytes int
+
+	br        *bufio.Reader
+	remaining int64 // bytes left in the current chunk
+	trailer   textproto.MIMEHeader
+	done      bool
+	err       error // the first error; every later Read fails with it
+}
+
+// NewReader returns a Reader decoding the chunked body at the start of r. When r is a
+// *bufio.Reader it reads from it directly, so whatever follows the body, e.g. the next
+// message on a keep-alive connection, is left in it; otherwise it may read past the body.
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{br: br}
+} // NewReader() func
+
+func (cr *Reader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.remaining == 0 {
+		if cr.err = cr.nextChunk(); cr.err != nil {
+			return 0, cr.err
+		}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := cr.br.Read(p[:min(int64(len(p)), cr.remaining)])
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && cr.remaining == 0 {
+		err = cr.expectCRLF()
+	}
+	cr.err = err
+	return n, err
+}
+
+// Trailer returns the fields of the trailer section, once Read has returned io.EOF; nil before
+func (cr *Reader) Trailer() textproto.MIMEHeader {
+	if !cr.done {
+		return nil
+	}
+	return cr.trailer
+} // Trailer() func
+
+// nextChunk reads a chunk size line, and the trailer section after the last chunk, which
+// ends the body with io.EOF
+func (cr *Reader) nextChunk() error {
+	line, err := cr.line(maxLineBytes)
+	if err != nil {
+		return err
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	sizeField = strings.TrimRight(sizeField, " \t")
+	size, err := strconv.ParseUint(sizeField, 16, 63)
+	if err != nil || sizeField == "" || strings.ContainsAny(sizeField[:1], "+-") {
+		return fmt.Errorf("%w: bad chunk size line %q", ErrMalformed, line)
+	}
+	if size > 0 {
+		cr.remaining = int64(size)
+		return nil
+	}
+	if err := cr.readTrailer(); err != nil {
+		return err
+	}
+	cr.done = true
+	return io.EOF
+} // nextChunk() func
+
+// readTrailer reads the trailer section up to the empty line ending it
+func (cr *Reader) readTrailer() error {
+	limit := cr.MaxTrailerBytes
+	if limit <= 0 {
+		limit = DefaultMaxTrailerBytes
+	}
+	cr.trailer = textproto.MIMEHeader{}
+	for read := 0; ; {
+		line, err := cr.line(limit - read + len("\r\n"))
+		if err == errLineTooLong || err == nil && read+len(line) > limit {
+			return fmt.Errorf("%w: more than %d bytes", ErrTrailerTooLarge, limit)
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		read += len(line)
+		if line[0] == ' ' || line[0] == '\t' {
+			return fmt.Errorf("%w: obsolete line folding in trailer line %q", ErrMalformed, line)
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !isToken(name) {
+			return fmt.Errorf("%w: bad trailer line %q", ErrMalformed, line)
+		}
+		cr.trailer.Add(textproto.CanonicalMIMEHeaderKey(name), strings.Trim(value, " \t"))
+	}
+} // readTrailer() func
+
+// line reads one line of at most max bytes, line ending included, and returns it without its
+// CRLF or LF
+func (cr *Reader) line(max int) (string, error) {
+	var line []byte
+	for {
+		frag, err := cr.br.ReadSlice('\n')
+		if line = append(line, frag...); len(line) > max {
+			return "", errLineTooLong
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF:
+			return "", io.ErrUnexpectedEOF
+		case err != nil:
+			return "", err
+		}
+		return string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))), nil
+	}
+} // line() func
+
+// expectCRLF consumes the line ending after a chunk's data
+func (cr *Reader) expectCRLF() error {
+	line, err := cr.line(maxLineBytes)
+	if err == errLineTooLong || err == nil && line != "" {
+		return errMissingChunkCRLF
+	}
+	return err
+} // expectCRLF() func
+
+// isToken reports whether s is an RFC 9110 token, as a field name must be
+func isToken(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(c rune) bool {
+		return c > 0x7e || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c)
+	})
+} // isToken() func
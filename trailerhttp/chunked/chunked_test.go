@@ -0,0 +1,136 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httputil"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewWriter(&buf)
+	io.WriteString(cw, "hello, ")
+	cw.Write(nil)
+	io.WriteString(cw, "chunked world")
+	cw.Trailer = textproto.MIMEHeader{"X-Body-Byte-Length": {"20"}, "X-A": {" first ", "second"}}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "7\r\nhello, \r\nd\r\nchunked world\r\n0\r\nX-A: first\r\nX-A: second\r\nX-Body-Byte-Length: 20\r\n\r\n"
+	if buf.String() != want {
+		t.Errorf("encoded %q, want %q", buf.String(), want)
+	}
+	if _, err := cw.Write([]byte("x")); !errors.Is(err, ErrWriteAfterClose) {
+		t.Errorf("Write after Close: %v, want ErrWriteAfterClose", err)
+	}
+
+	// net/http's own decoder reads the same body
+	body, err := io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(buf.Bytes())))
+	if err != nil || string(body) != "hello, chunked world" {
+		t.Errorf("httputil decoded %q, %v", body, err)
+	}
+}
+
+func TestWriterInvalidField(t *testing.T) {
+	for _, trailer := range []textproto.MIMEHeader{{"Bad Name": {"1"}}, {"X-Split": {"a\r\nX-Injected: 1"}}} {
+		var buf bytes.Buffer
+		cw := NewWriter(&buf)
+		cw.Trailer = trailer
+		if err := cw.Close(); !errors.Is(err, ErrInvalidField) || buf.Len() != 0 {
+			t.Errorf("trailer %q: error %v, wrote %q; want ErrInvalidField and nothing written", trailer, err, buf.String())
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewWriter(&buf)
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	for p := data; len(p) > 0; p = p[min(len(p), 4099):] {
+		cw.Write(p[:min(len(p), 4099)])
+	}
+	cw.Trailer = textproto.MIMEHeader{"X-Body-Sha256": {"abc"}}
+	cw.Close()
+	buf.WriteString("NEXT MESSAGE")
+
+	br := bufio.NewReader(&buf)
+	cr := NewReader(br)
+	if cr.Trailer() != nil {
+		t.Error("Trailer before EOF is not nil")
+	}
+	body, err := io.ReadAll(cr)
+	if err != nil || !bytes.Equal(body, data) {
+		t.Fatalf("decoded %d bytes, %v; want the %d written", len(body), err, len(data))
+	}
+	if got := cr.Trailer().Get("X-Body-SHA256"); got != "abc" {
+		t.Errorf("trailer X-Body-SHA256 = %q, want abc", got)
+	}
+	if rest, _ := io.ReadAll(br); string(rest) != "NEXT MESSAGE" {
+		t.Errorf("left %q after the body, want the next message", rest)
+	}
+}
+
+func TestReader(t *testing.T) {
+	for _, tc := range []struct {
+		name, wire, body string
+		trailer          textproto.MIMEHeader
+		err              error
+	}{
+		{"no trailers", "5\r\nhello\r\n0\r\n\r\n", "hello", textproto.MIMEHeader{}, nil},
+		{"extensions and bare LF", "5;name=value\nhello\n0\nX-Len: 5\n\n", "hello", textproto.MIMEHeader{"X-Len": {"5"}}, nil},
+		{"empty body", "0\r\nX-Body-Byte-Length: 0\r\n\r\n", "", textproto.MIMEHeader{"X-Body-Byte-Length": {"0"}}, nil},
+		{"bad size", "zz\r\nhello\r\n0\r\n\r\n", "", nil, ErrMalformed},
+		{"signed size", "+5\r\nhello\r\n0\r\n\r\n", "", nil, ErrMalformed},
+		{"missing chunk CRLF", "5\r\nhelloX\r\n0\r\n\r\n", "hello", nil, ErrMalformed},
+		{"bad trailer line", "0\r\nno colon\r\n\r\n", "", nil, ErrMalformed},
+		{"folded trailer line", "0\r\nX-A: 1\r\n 2\r\n\r\n", "", nil, ErrMalformed},
+		{"truncated", "5\r\nhel", "hel", nil, io.ErrUnexpectedEOF},
+		{"no end of trailer section", "0\r\nX-A: 1\r\n", "", nil, io.ErrUnexpectedEOF},
+	} {
+		cr := NewReader(strings.NewReader(tc.wire))
+		body, err := io.ReadAll(cr)
+		if string(body) != tc.body || !errors.Is(err, tc.err) && err != tc.err {
+			t.Errorf("%s: decoded %q, %v; want %q, %v", tc.name, body, err, tc.body, tc.err)
+			continue
+		}
+		if tc.err == nil && !equalHeader(cr.Trailer(), tc.trailer) {
+			t.Errorf("%s: trailer %v, want %v", tc.name, cr.Trailer(), tc.trailer)
+		}
+		if tc.err != nil {
+			if _, again := cr.Read(make([]byte, 1)); again == nil || again == io.EOF {
+				t.Errorf("%s: Read after %v returned %v", tc.name, err, again)
+			}
+		}
+	}
+}
+
+func TestReaderMaxTrailerBytes(t *testing.T) {
+	wire := "0\r\nX-Big: " + strings.Repeat("v", 100) + "\r\n\r\n"
+	cr := NewReader(strings.NewReader(wire))
+	cr.MaxTrailerBytes = 50
+	if _, err := io.ReadAll(cr); !errors.Is(err, ErrTrailerTooLarge) {
+		t.Errorf("error %v, want ErrTrailerTooLarge", err)
+	}
+	cr = NewReader(strings.NewReader(wire))
+	if _, err := io.ReadAll(cr); err != nil || len(cr.Trailer().Get("X-Big")) != 100 {
+		t.Errorf("error %v, trailer %v; want the field read under the default limit", err, cr.Trailer())
+	}
+}
+
+// equalHeader reports whether a and b hold the same fields
+func equalHeader(a, b textproto.MIMEHeader) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, values := range a {
+		if strings.Join(values, "\n") != strings.Join(b[name], "\n") {
+			return false
+		}
+	}
+	return true
+} // equalHeader() func
@@ -0,0 +1,857 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Client streams request bodies with integrity trailers computed on the fly.
+// The zero value sends a length trailer with http.DefaultClient.
+type Client struct {
+	HTTPClient *http.Client // nil means http.DefaultClient, or a client dialing Network
+	Algorithms []string     // trailer algorithms to send, e.g. "length", "sha256"; nil means just "length"
+	Gzip       bool         // gzip the body on the wire; the trailers still describe the uncompressed bytes, bar the RFC 9530 ones
+	HMACKey    []byte       // shared secret for the "hmac-sha256" algorithm; never logged
+
+	// Network and Addr, when Network is set, make the client dial Addr over Network, e.g. "unix"
+	// and a socket path, whatever the host of the URL; see NewSocketClient. They are ignored
+	// when HTTPClient is set.
+	Network, Addr string
+
+	// TransportOptions, when HTTPClient is nil, configures the transport the uploads go over:
+	// dial and TLS timeouts, TLS settings, a proxy, connection pool sizes or a base transport
+	// to start from. Clients sharing the same *TransportOptions share its connections, so the
+	// options must not change once used.
+	TransportOptions *TransportOptions
+
+	// Fault, when set, deliberately damages every upload as described, so tests and demos
+	// can check that a server's verification policies fire; see FaultTransport.
+	Fault *Fault
+
+	// ChunkSize, when above 0, gathers the body into pieces of this many bytes before they go
+	// to the transport, so each HTTP/1.1 chunk carries that much rather than whatever each read
+	// of the source returned: larger chunks mean fewer writes and less framing, smaller ones
+	// less latency. FlushInterval, when above 0, passes a partial chunk on once it has waited
+	// that long, for sources that produce data slowly; alone it gathers DefaultChunkSize chunks.
+	ChunkSize     int
+	FlushInterval time.Duration
+
+	// Throttle, when set, paces every upload into the transport as a slow or flaky link
+	// would, to see how the trailers and the server's timeouts behave on one
+	Throttle *Throttle
+
+	// Negotiate asks the server with an OPTIONS request before every upload which trailers it
+	// verifies (Client.Capabilities): the upload then sends those of Algorithms the server
+	// checks, or every one it does when it checks none of them. A server advertising no trailer
+	// support gets the body buffered with a Content-Length and its integrity fields as headers.
+	Negotiate bool
+
+	// PrePass sends a body that is an io.ReadSeeker, such as an *os.File or a *bytes.Reader,
+	// without trailers: it is read once to compute the integrity fields, rewound, and sent with
+	// a Content-Length and those fields as headers, which any server or intermediary keeps and
+	// a Handler checks as it does trailers. Other bodies, and uploads that need trailers
+	// anyway (Gzip, Signer, BodyToken, Metadata, Timestamp or Nonce) or are throttled, stream
+	// with trailers as usual, the choice being made per upload; so do the PATCH requests of
+	// ResumeUpload. A Client sends io.Readers, so seeking is what tells a body can be read
+	// twice; for an *http.Request with a GetBody, see Transport.PrePass.
+	PrePass bool
+
+	// HeaderFallback resends an upload whose trailers the server reports it did not receive,
+	// because a hop on the way downgraded the request to HTTP/1.0 or re-framed its body with a
+	// Content-Length, with its integrity fields as headers, as Negotiate does for a server that
+	// accepts no trailers. Only a body that is an io.ReadSeeker can be sent again; without
+	// HeaderFallback, or for other bodies, such uploads fail with ErrTrailersNotCarried.
+	HeaderFallback bool
+
+	// ExpectContinue sends "Expect: 100-continue" so the server can reject the upload
+	// from its headers, e.g. its announced trailers, before the body is transmitted; the
+	// body source is not even read until the server asks for the body, and a rejected
+	// upload's result has BodyWithheld set. The transport must have a non-zero
+	// ExpectContinueTimeout, as http.DefaultTransport does.
+	ExpectContinue bool
+
+	// OnBodyComplete, when set, is called once the whole body has streamed, right before
+	// the trailers are set and the pipe is closed. length is the number of body bytes
+	// written; digest is the raw sum of the first hash algorithm in Algorithms, or nil
+	// when only the length is sent. It lets callers reconcile what they sent with what
+	// the server reports.
+	OnBodyComplete func(length int64, digest []byte)
+
+	// ProgressFunc, when set, is called after every write into the pipe with the cumulative
+	// number of body bytes sent. Its last value is the length sent in the length trailer
+	// (the uncompressed length with Gzip).
+	ProgressFunc func(bytesWritten int64)
+
+	// OnTrailersSent, when set, is called once per attempt after the last ProgressFunc call,
+	// with the same total and a copy of the trailer values going out, overrides included, as
+	// the transport reads the end of the body: the final progress callback, to finish a
+	// progress bar with the digests sent. A body buffered for a server without trailer support
+	// (Negotiate) reports the integrity fields it sent as headers instead.
+	OnTrailersSent func(bytesWritten int64, trailer http.Header)
+
+	// Metadata, when set, is called once the whole body has streamed, and its result is sent
+	// as compact JSON in the X-Body-Metadata trailer (MetadataTrailer): a record count, a
+	// summary computed while streaming, anything known only at the end. An error aborts the upload.
+	Metadata func() (any, error)
+
+	// TrailerOverride replaces the computed values of these trailer fields, and adds any
+	// field not computed, regardless of the body actually sent. It exists for negative
+	// testing, e.g. a correct body with a wrong X-Body-Byte-Length. A nil or empty value
+	// announces the field but sends it without a value.
+	TrailerOverride http.Header
+
+	// Signer, when set, signs each upload with an RFC 9421 message signature covering the
+	// Content-Digest trailer, sent in Signature and Signature-Input trailers. The content-digest
+	// algorithm is added to Algorithms if missing.
+	Signer *Signer
+
+	// BodyToken, when set, signs a JWT once the body has streamed and sends it in the
+	// X-Body-Token trailer (BodyTokenTrailer), binding the Content-Digest trailer, for a server
+	// that authorizes uploads by their content; see ServerOptions.TokenKeys. The content-digest
+	// algorithm is added to Algorithms if missing.
+	BodyToken *TokenSigner
+
+	// Timestamp, when set, sends the time the body finished streaming in the X-Body-Timestamp
+	// trailer (TimestampTrailer), HMAC'd with HMACKey over that time and the Content-Digest
+	// trailer, for a server that keeps a verifiable completion time of each upload; see
+	// ServerOptions.TimestampSkew. The content-digest algorithm is added to Algorithms if missing.
+	Timestamp bool
+
+	// Nonce, when set, sends a random nonce drawn for each attempt in the X-Body-Nonce trailer
+	// (NonceTrailer), HMAC'd with HMACKey over the nonce and the Content-Digest trailer, for a
+	// server that refuses an upload it has seen before; see ServerOptions.Nonces. The
+	// content-digest algorithm is added to Algorithms if missing.
+	Nonce bool
+
+	// DigestEncoding writes the values of the hex digest trailers, such as X-Body-SHA256 and
+	// X-Body-CRC32C, in another form, for receivers that expect it; the Handler and VerifiedBody
+	// accept any. The zero value means DigestHex.
+	DigestEncoding DigestEncoding
+
+	// TrailerNames renames the trailer field an algorithm is sent in, and read from in the
+	// responses the client verifies, keyed by algorithm name, e.g. {"length": "X-Content-Length"},
+	// for servers that standardized on other names; give a Handler the same
+	// ServerOptions.TrailerNames. Algorithms not listed keep their default field.
+	TrailerNames map[string]string
+
+	// StatusTrailers names the status trailers Download reads the server's outcome from; the
+	// zero value means X-Status-Code and X-Status-Message
+	StatusTrailers StatusTrailers
+
+	// TrailerTimeout, when set, fails reading a response body whose trailer section does not
+	// arrive within this long of the last body bytes, with an ErrTrailerTimeout error, apart
+	// from the deadline of the whole request in its context. As with ServerOptions.TrailerTimeout
+	// the clock restarts whenever body bytes arrive. Only responses that can carry trailers, over
+	// HTTP/2 or chunked, are watched.
+	TrailerTimeout time.Duration
+
+	// OnTrailerReceived, when set, is called with every field line of the response's trailer
+	// section once its body has been read, fields in name order.
+	OnTrailerReceived func(field, value string)
+
+	// OnIntegrityFailure, when set, is called when an upload fails verification, on the server,
+	// which reported it in its result, or on the way back, when the response's own integrity
+	// trailers do not match: err joins a *VerificationError for each, and report is the
+	// upload's result. The server's errors arrive as text, so errors.Is does not match them with
+	// ErrHashMismatch and the like; its checks are in the VerificationError's Results.
+	OnIntegrityFailure func(err error, report *UploadResult)
+
+	Logger  *slog.Logger // receives the client's output, with the request's fields; nil means slog.Default()
+	Verbose bool         // also log the trailers and the progress of each upload, at slog.LevelDebug
+
+	records     RecordFormat // set by Ingest: the body's Content-Type, whose records are counted
+	ifNoneMatch string       // set by SendIfNoneMatch: the If-None-Match header of the upload
+	contentType string       // set by SendMultipart: the Content-Type of the body, with its boundary
+
+	customTrailers []customTrailer // set by Upload and UploadFile: the fields of WithTrailer
+
+	headerDigests bool // set by Negotiate: the server accepts no trailers, so the integrity fields go in the headers
+}
+
+// NewH2CClient returns an HTTP client that speaks HTTP/2 over cleartext TCP with prior knowledge,
+// for use as Client.HTTPClient against a NewServer listening without TLS. Over HTTP/2 the
+// trailers travel in a trailing HEADERS frame after the last DATA frame instead of after
+// the last chunk; the Client and Handler APIs are the same for both versions.
+func NewH2CClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols, ExpectContinueTimeout: time.Second}}
+} // NewH2CClient() func
+
+// logger returns the logger the client writes to
+func (c *Client) logger() *slog.Logger {
+	return orDefaultLogger(c.Logger).With("component", "client")
+} // logger() func
+
+// requestLogger returns the client's logger with the fields identifying req
+func (c *Client) requestLogger(req *http.Request) *slog.Logger {
+	return c.logger().With("request_id", req.Header.Get(requestIDHeader), "method", req.Method, "url", req.URL.Redacted())
+} // requestLogger() func
+
+// debug logs at slog.LevelDebug, only when the client is verbose
+func (c *Client) debug(log *slog.Logger, msg string, args ...any) {
+	if c.Verbose {
+		log.Debug(msg, args...)
+	}
+} // debug() func
+
+// verifiers resolves c.Algorithms
+func (c *Client) verifiers() ([]trailerVerifier, error) {
+	algorithms := c.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"length"}
+	}
+	var verifiers []trailerVerifier
+	for _, algorithm := range algorithms {
+		v, err := lookupVerifier(strings.TrimSpace(algorithm))
+		if err != nil {
+			return nil, err
+		}
+		if v.Keyed && len(c.HMACKey) == 0 {
+			return nil, fmt.Errorf("trailer algorithm %q: %w", v.Algorithm, errNoHMACKey)
+		}
+		verifiers = append(verifiers, v.renamed(c.TrailerNames))
+	}
+	if c.Timestamp && len(c.HMACKey) == 0 {
+		return nil, fmt.Errorf("timestamp trailer: %w", errNoHMACKey)
+	}
+	if c.Nonce && len(c.HMACKey) == 0 {
+		return nil, fmt.Errorf("nonce trailer: %w", errNoHMACKey)
+	}
+	if (c.Signer != nil || c.BodyToken != nil || c.Timestamp || c.Nonce) && !slices.ContainsFunc(verifiers, func(v trailerVerifier) bool { return v.Algorithm == "content-digest" }) {
+		v, err := lookupVerifier("content-digest")
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, v)
+	}
+	if c.records != "" {
+		verifiers = append(verifiers, recordVerifier(c.records))
+	}
+	return verifiers, nil
+} // verifiers() func
+
+// SendWithTrailer sends an in-memory body with a length trailer using the zero Client; see Client.SendStream
+func SendWithTrailer(ctx context.Context, url string, body []byte) (*UploadResult, error) {
+	return new(Client).Send(ctx, url, body)
+} // SendWithTrailer() func
+
+// SendStreamWithTrailer streams src with a length trailer using the zero Client; see Client.SendStream
+func SendStreamWithTrailer(ctx context.Context, url string, src io.Reader) (*UploadResult, error) {
+	return new(Client).SendStream(ctx, url, src)
+} // SendStreamWithTrailer() func
+
+// Send sends an in-memory body; see SendStream
+func (c *Client) Send(ctx context.Context, url string, body []byte) (*UploadResult, error) {
+	return c.SendStream(ctx, url, bytes.NewReader(body))
+} // Send() func
+
+// SendStream POSTs src to url as a streamed (chunked) body and returns the server's verification result.
+// The integrity trailers are computed as the bytes flow through the pipe
+// and attached right before the pipe is closed, so src never has to be
+// buffered and its size never has to be known upfront.
+// A rejected upload is not an error: check UploadResult.Matched and UploadResult.Error.
+// A response announcing trailers of its own is verified too; when its integrity trailers do not
+// match, or an announced trailer never arrives, the result comes with a *VerificationError.
+func (c *Client) SendStream(ctx context.Context, url string, src io.Reader) (result *UploadResult, err error) {
+	if c.Negotiate {
+		if c, err = c.negotiated(ctx, url); err != nil {
+			return nil, err
+		}
+	}
+	if traceUpload != nil {
+		var endSpan func(*UploadResult, error)
+		ctx, endSpan = traceUpload(ctx, c, url)
+		defer func() { endSpan(result, err) }()
+	}
+	rewind := c.rewinder(src)
+	resp, err := c.stream(ctx, http.MethodPost, url, nil, src)
+	if err != nil {
+		return nil, err
+	}
+	defer drainBody(resp) // whatever the result decoding leaves, response trailers included
+	c.watchTrailers(resp)
+	log := c.requestLogger(resp.Request)
+	c.debug(log, "Received response", "status", resp.Status, "proto", resp.Proto)
+	if vb := verifyResponse(resp, c.HMACKey, c.TrailerNames); vb.announces() {
+		resp.Body = vb
+	}
+	result, err = decodeResult(resp)
+	if omitted := resp.Header.Get(TrailerFallbackHeader); omitted != "" {
+		c.debug(log, "Server omitted its response trailers", "trailers", omitted)
+	}
+	if result != nil && withheld(resp.Request) {
+		result.BodyWithheld = true
+		c.debug(log, "Upload rejected before its body was sent", "status", resp.Status)
+	}
+	// resp.Trailer is only populated once the response body has been read to EOF
+	_, drainErr := io.Copy(io.Discard, resp.Body)
+	var failed *VerificationError
+	switch {
+	case errors.Is(drainErr, ErrTrailerTimeout) && err == nil:
+		err = fmt.Errorf("response: %w", drainErr)
+	case errors.As(drainErr, &failed) && err == nil:
+		err = fmt.Errorf("response: %w", drainErr)
+		fallthrough
+	case drainErr == nil && result != nil:
+		result.ResponseTrailer = resp.Trailer
+		c.debug(log, "Received response trailers", "trailer", resp.Trailer)
+		gotResponseTrailers(ctx, resp.Trailer)
+		if tc, err := ResponseTrace(resp); err == nil {
+			result.ServerTrace = tc
+			c.debug(log, "Server traced the upload", "traceresponse", tc.String())
+		}
+		if c.OnTrailerReceived != nil {
+			receivedTrailers(resp.Trailer, c.OnTrailerReceived)
+		}
+	}
+	if result != nil && result.Inconclusive && err == nil {
+		err = trailersNotCarried(resp, result)
+		if rewind != nil && !c.headerDigests && rewind() == nil {
+			log.Warn("Server received no trailers; resending with integrity headers", "err", err)
+			fallback := *c
+			fallback.headerDigests = true
+			return fallback.SendStream(ctx, url, src)
+		}
+	}
+	if c.OnIntegrityFailure != nil && result != nil {
+		var errs []error
+		if rejected := result.verificationError(); rejected != nil {
+			errs = append(errs, fmt.Errorf("upload: %w", rejected))
+		}
+		if failed != nil {
+			errs = append(errs, fmt.Errorf("response: %w", failed))
+		}
+		if len(errs) > 0 {
+			c.OnIntegrityFailure(errors.Join(errs...), result)
+		}
+	}
+	return result, err
+} // SendStream() func
+
+// SendIfNoneMatch uploads body as SendStream does, unless the server already holds the same
+// content. It computes the ETag of body locally (ContentETag), rewinds it, and sends it with
+// If-None-Match and "Expect: 100-continue", so a server keeping ServerOptions.ETags answers
+// 412 Precondition Failed from the headers alone and the body is never transmitted; the result
+// then has Unchanged set. The upload carries the sha256 trailer the server derives its ETag from.
+func (c *Client) SendIfNoneMatch(ctx context.Context, url string, body io.ReadSeeker) (*UploadResult, error) {
+	etag, err := ContentETag(body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	upload := *c
+	upload.ifNoneMatch, upload.ExpectContinue = etag, true
+	if len(upload.Algorithms) == 0 {
+		upload.Algorithms = []string{"length"}
+	}
+	if !slices.Contains(upload.Algorithms, "sha256") {
+		upload.Algorithms = append(slices.Clone(upload.Algorithms), "sha256")
+	}
+	result, err := upload.SendStream(ctx, url, body)
+	if result != nil && result.StatusCode == http.StatusPreconditionFailed {
+		result.Unchanged = true
+		upload.debug(upload.logger(), "Server already holds the content", "etag", etag)
+	}
+	return result, err
+} // SendIfNoneMatch() func
+
+// RetryPolicy controls how SendWithRetry backs off between attempts
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first; 0 means 3
+	BaseDelay   time.Duration // wait before the first retry, doubled after every attempt; 0 means 100ms
+	MaxDelay    time.Duration // upper bound on the wait; 0 means 5s
+
+	// RetryOnMismatch also retries uploads that arrived but did not verify: a trailer check
+	// failed on the server, an announced trailer never reached it, or the response's own
+	// integrity trailers did not match, as after corruption in transit. It is off by default,
+	// since a mismatch the sender causes, e.g. with TrailerOverride, repeats on every attempt.
+	RetryOnMismatch bool
+}
+
+// SendWithRetry sends a streamed body like SendStream, retrying with exponential
+// backoff on connection errors and 5xx responses, and on integrity mismatches with
+// RetryPolicy.RetryOnMismatch. A streamed body is consumed by the first attempt, so
+// newBody is called once per attempt to rebuild it; the trailers are recomputed from
+// the fresh stream every time.
+func (c *Client) SendWithRetry(ctx context.Context, url string, newBody func() io.Reader, policy RetryPolicy) (*UploadResult, error) {
+	return c.sendWithRetry(ctx, url, func() (io.Reader, error) { return newBody(), nil }, policy)
+} // SendWithRetry() func
+
+// SendSeekerWithRetry is SendWithRetry for a body that can be rewound: every attempt
+// seeks body back to the position it had when SendSeekerWithRetry was called, e.g. for
+// an *os.File or a *bytes.Reader.
+func (c *Client) SendSeekerWithRetry(ctx context.Context, url string, body io.ReadSeeker, policy RetryPolicy) (*UploadResult, error) {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBodyStream, err)
+	}
+	return c.sendWithRetry(ctx, url, func() (io.Reader, error) {
+		_, err := body.Seek(start, io.SeekStart)
+		return body, err
+	}, policy)
+} // SendSeekerWithRetry() func
+
+// sendWithRetry runs the attempts of SendWithRetry; getBody failing ends them
+func (c *Client) sendWithRetry(ctx context.Context, url string, getBody func() (io.Reader, error), policy RetryPolicy) (*UploadResult, error) {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 100 * time.Millisecond
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = 5 * time.Second
+	}
+
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		body, err := getBody()
+		if err != nil {
+			return nil, fmt.Errorf("%w: rewinding for attempt %d: %w", ErrBodyStream, attempt, err)
+		}
+		result, err := c.SendStream(ctx, url, body)
+		mismatch := policy.RetryOnMismatch && integrityFailed(result, err)
+		retryable := (err != nil && result == nil) || (result != nil && result.StatusCode >= 500) || mismatch
+		if !retryable || attempt == policy.MaxAttempts || ctx.Err() != nil {
+			return result, err
+		}
+		switch {
+		case err != nil:
+		case mismatch:
+			err = fmt.Errorf("upload did not verify (%s)", result.Outcome)
+			if result.Error != "" {
+				err = fmt.Errorf("%w: %s", err, result.Error)
+			}
+		default:
+			err = fmt.Errorf("server responded %d: %s", result.StatusCode, result.Error)
+		}
+		c.logger().Warn("Upload attempt failed; retrying", "url", url, "attempt", attempt, "max_attempts", policy.MaxAttempts, "err", err, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay = min(2*delay, policy.MaxDelay)
+	}
+} // sendWithRetry() func
+
+// integrityFailed reports whether an upload arrived but did not verify: a server-side check
+// failed or missed its trailer, or, as err, the response trailers did not match
+func integrityFailed(result *UploadResult, err error) bool {
+	var failed *VerificationError
+	if errors.As(err, &failed) {
+		return true
+	}
+	return result != nil && (len(result.MissingTrailers) > 0 || slices.ContainsFunc(result.Checks, func(check CheckSummary) bool { return !check.Matched }))
+} // integrityFailed() func
+
+// decodeResult reads the server's JSON verification result from resp
+func decodeResult(resp *http.Response) (*UploadResult, error) {
+	result := &UploadResult{StatusCode: resp.StatusCode}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" {
+		var problem Problem
+		if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+			return result, fmt.Errorf("decoding problem details (%s): %w", resp.Status, err)
+		}
+		resultFromProblem(result, &problem)
+		return result, nil
+	}
+	if mediaType != "application/json" {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return result, fmt.Errorf("unexpected response %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return result, fmt.Errorf("decoding verification result (%s): %w", resp.Status, err)
+	}
+	return result, nil
+} // decodeResult() func
+
+// stream sends src with its trailers, and the extra header fields, and returns the raw response;
+// the caller must close its body
+func (c *Client) stream(ctx context.Context, method, url string, header http.Header, src io.Reader) (*http.Response, error) {
+	seeker, seekable := src.(io.ReadSeeker)
+	switch {
+	case c.headerDigests && seekable:
+		return c.sendPrePassed(ctx, method, url, header, seeker)
+	case c.headerDigests:
+		return c.sendBuffered(ctx, method, url, header, src)
+	case c.PrePass && seekable && !c.mustStream():
+		return c.sendPrePassed(ctx, method, url, header, seeker)
+	}
+	verifiers, err := c.verifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Create an io.Pipe. This allows streaming the body.
+	pr, pw := io.Pipe()
+
+	// 2. Create the HTTP request with the reader end of the pipe as the body.
+	// The context lets callers apply deadlines or cancel a hung upload.
+	// This signals to the Go client that the body is being streamed
+	// and its size is not known upfront, triggering chunked encoding.
+	var trace *uploadTrace
+	reqCtx := ctx
+	if ct := ContextClientTrace(ctx); ct != nil {
+		trace = &uploadTrace{trace: ct}
+		reqCtx = trace.withHTTPTrace(ctx)
+	}
+	body := io.ReadCloser(pr)
+	var gate *continueGate
+	var started *startedBody
+	if c.ExpectContinue {
+		gate = newContinueGate(pr)
+		body = gate
+	} else {
+		started = newStartedBody(pr)
+		body = started
+	}
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 && c.FlushInterval > 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize > 0 {
+		body = &chunkBody{ReadCloser: body, size: chunkSize}
+	}
+	req, err := http.NewRequestWithContext(reqCtx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.records != "" {
+		req.Header.Set("Content-Type", string(c.records))
+	}
+	if c.contentType != "" {
+		req.Header.Set("Content-Type", c.contentType)
+	}
+	if c.ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", c.ifNoneMatch)
+	}
+	if trace != nil {
+		trace.req = req
+	}
+
+	// Tell the server we accept trailers in the response (RFC 9110, Section 10.1.4)
+	req.Header.Set("TE", "trailers")
+	req.Header.Set(requestIDHeader, newRequestID())
+	if injectTrace != nil {
+		injectTrace(ctx, req.Header)
+	}
+	log := c.requestLogger(req)
+
+	if c.ExpectContinue {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	// 3. Announce the trailer names in the initial Trailer header and declare
+	// the keys on the request's Trailer map. Their values are set in Step-4.
+	// Go's http client will automatically handle Transfer-Encoding: chunked
+	// when you provide a request body reader and don't set Content-Length,
+	// and you have the Trailer header set.
+	req.Trailer = http.Header{} // Initialize the map
+	trailerNames := make([]string, len(verifiers))
+	digests := make([]bodyDigest, len(verifiers))
+	digestWriters := []io.Writer{}
+	encodedWriters := []io.Writer{pw}
+	if c.Throttle != nil {
+		encodedWriters[0] = &throttledWriter{w: pw, t: &throttle{Throttle: *c.Throttle, ctx: ctx}}
+	}
+	var chunker *chunkWriter
+	if chunkSize > 0 {
+		chunker = newChunkWriter(encodedWriters[0], chunkSize, c.FlushInterval)
+		encodedWriters[0] = chunker
+	}
+	for i, v := range verifiers {
+		trailerNames[i] = v.TrailerName
+		req.Trailer[http.CanonicalHeaderKey(v.TrailerName)] = nil
+		digests[i] = withEncoding(v.NewDigest(c.HMACKey), c.DigestEncoding)
+		if v.Encoded {
+			encodedWriters = append(encodedWriters, digests[i])
+		} else {
+			digestWriters = append(digestWriters, digests[i])
+		}
+	}
+
+	// With Gzip the pipe carries the compressed stream, while most digests still see
+	// the original bytes; the Encoded ones (RFC 9530 fields) see what goes on the wire.
+	wire := io.MultiWriter(encodedWriters...)
+	var gz *gzip.Writer
+	if c.Gzip {
+		gz = gzip.NewWriter(wire)
+		wire = gz
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	digestWriters = append([]io.Writer{wire}, digestWriters...)
+	if c.Signer != nil {
+		c.Signer.announce(req)
+		trailerNames = append(trailerNames, signatureInputTrailer, signatureTrailer)
+	}
+	if c.BodyToken != nil {
+		trailerNames = append(trailerNames, BodyTokenTrailer)
+		req.Trailer[BodyTokenTrailer] = nil
+	}
+	if c.Timestamp {
+		trailerNames = append(trailerNames, TimestampTrailer)
+		req.Trailer[TimestampTrailer] = nil
+	}
+	if c.Nonce {
+		trailerNames = append(trailerNames, NonceTrailer)
+		req.Trailer[NonceTrailer] = nil
+	}
+	if c.Metadata != nil {
+		trailerNames = append(trailerNames, MetadataTrailer)
+		req.Trailer[MetadataTrailer] = nil
+	}
+	for _, custom := range c.customTrailers {
+		if _, declared := req.Trailer[http.CanonicalHeaderKey(custom.name)]; !declared {
+			trailerNames = append(trailerNames, custom.name)
+			req.Trailer[http.CanonicalHeaderKey(custom.name)] = nil
+		}
+	}
+	for name := range c.TrailerOverride {
+		if _, declared := req.Trailer[http.CanonicalHeaderKey(name)]; !declared {
+			trailerNames = append(trailerNames, name)
+			req.Trailer[http.CanonicalHeaderKey(name)] = nil
+		}
+	}
+	if c.ProgressFunc != nil {
+		digestWriters = append(digestWriters, &progressWriter{report: c.ProgressFunc})
+	}
+	if err := validateTrailerNames(trailerNames); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Trailer", strings.Join(trailerNames, ","))
+	forceChunked(req)
+	c.debug(log, "Sending streamed request", "trailers", req.Header.Get("Trailer"))
+
+	// 4. Copy src into the writer end of the pipe in a goroutine, teeing it into the digests.
+	// This allows the client.Do call (which reads from the reader end) in Step-5 to proceed concurrently.
+	// Closing the writer signals the end of the body stream.
+	srcErr := make(chan error, 1) // receives the error reading src, or nil, once the goroutine is done
+	go func() {
+		// Cancelling ctx closes the pipe with ctx.Err(), which fails a pending pw.Write,
+		// and contextReader stops pulling from src, so this goroutine cannot leak.
+		stop := context.AfterFunc(ctx, func() { pw.CloseWithError(ctx.Err()) })
+		defer stop()
+
+		if gate != nil && !gate.wait(ctx) {
+			c.debug(log, "Server answered before asking for the body; not sending it")
+			srcErr <- nil
+			return
+		}
+		c.debug(log, "Starting to write body to pipe")
+		body := &readErrRecorder{r: contextReader{ctx: ctx, r: src}}
+		n, copyErr := streamBody(io.MultiWriter(digestWriters...), body)
+		srcErr <- body.err
+		if copyErr == nil && gz != nil {
+			copyErr = gz.Close() // flush the compressed tail before the trailers
+		}
+		if chunker != nil {
+			if err := chunker.Close(); copyErr == nil {
+				copyErr = err // and the last chunk; closing also stops its flush timer
+			}
+		}
+		if copyErr != nil {
+			log.Error("Error writing body to pipe", "bytes", n, "err", copyErr)
+			// ClosingWithError causes the reader side (client.Do) to receive this error
+			pw.CloseWithError(copyErr)
+			return
+		}
+		c.debug(log, "Finished writing body to pipe", "bytes", n)
+		if trace != nil {
+			trace.wroteBody(n)
+		}
+		if c.OnBodyComplete != nil {
+			c.OnBodyComplete(n, firstSum(digests))
+		}
+		// The trailer values must be set before closing the pipe:
+		// the transport sends req.Trailer as soon as it reads the end of the body.
+		// They must not be set before it starts reading it either, as it checks the trailer
+		// names first; an empty body reaches this point without a write to wait on.
+		if started != nil { // the continue gate already waited for that
+			<-started.started
+		}
+		for i, v := range verifiers {
+			req.Trailer.Set(v.TrailerName, digests[i].Value())
+		}
+		if c.Metadata != nil {
+			metadata, err := c.Metadata()
+			if err == nil {
+				err = SetMetadata(req.Trailer, metadata)
+			}
+			if err != nil {
+				log.Error("Error encoding metadata trailer", "err", err)
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if c.Signer != nil {
+			if err := c.Signer.sign(req); err != nil {
+				log.Error("Error signing request", "err", err)
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if c.BodyToken != nil {
+			token, err := c.BodyToken.sign(req.Trailer.Get("Content-Digest"))
+			if err != nil {
+				log.Error("Error signing body token", "err", err)
+				pw.CloseWithError(err)
+				return
+			}
+			req.Trailer.Set(BodyTokenTrailer, token)
+		}
+		if c.Timestamp {
+			req.Trailer.Set(TimestampTrailer, timestampValue(c.HMACKey, time.Now(), req.Trailer.Get("Content-Digest")))
+		}
+		if c.Nonce {
+			req.Trailer.Set(NonceTrailer, nonceValue(c.HMACKey, req.Trailer.Get("Content-Digest")))
+		}
+		for _, custom := range c.customTrailers {
+			req.Trailer.Set(custom.name, custom.value())
+		}
+		for name, values := range c.TrailerOverride {
+			req.Trailer[http.CanonicalHeaderKey(name)] = values
+		}
+		c.debug(log, "Computed trailers", "trailer", req.Trailer)
+		if c.OnTrailersSent != nil {
+			c.OnTrailersSent(n, req.Trailer.Clone())
+		}
+		// **Crucially:** Close the pipe writer when done writing.
+		// This signals the end of the body to the HTTP client.
+		if err := pw.Close(); err != nil {
+			log.Error("Error closing pipe writer", "err", err)
+		}
+	}()
+
+	// 5. Send the request using the client.
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		// The transport only sees a failing pipe; report what made the body fail, if anything.
+		// Do closes the pipe before returning an error, so a goroutine writing to it finishes
+		// at once, but one blocked reading src, such as stdin, may never: wait only briefly.
+		select {
+		case readErr := <-srcErr:
+			if readErr != nil {
+				err = errors.Join(err, fmt.Errorf("%w: %w", ErrBodyStream, readErr))
+			}
+		case <-ctx.Done():
+		case <-time.After(bodyErrWait):
+		}
+	}
+	return resp, err
+} // stream() func
+
+// bodyErrWait is how long a request that failed waits to learn whether reading its body
+// source failed first
+const bodyErrWait = 100 * time.Millisecond
+
+// ErrBodyStream marks errors caused by reading the request body source, as opposed to
+// connection or protocol failures; errors.Is(err, ErrBodyStream) tells them apart, and
+// the original error from the source is wrapped as well.
+var ErrBodyStream = errors.New("streaming request body failed")
+
+// readErrRecorder remembers the first non-EOF error returned by r
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (rr *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if err != nil && err != io.EOF && rr.err == nil {
+		rr.err = err
+	}
+	return n, err
+}
+
+// rewinder returns a function seeking src back to where it is now, for HeaderFallback to send
+// it again, or nil if it cannot
+func (c *Client) rewinder(src io.Reader) func() error {
+	seeker, ok := src.(io.Seeker)
+	if !c.HeaderFallback || !ok {
+		return nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}
+} // rewinder() func
+
+// trailersNotCarried returns the error of an upload the server got without its trailers, with
+// the reason the server gave
+func trailersNotCarried(resp *http.Response, result *UploadResult) error {
+	detail := strings.TrimPrefix(result.Error, ErrTrailersNotCarried.Error()+": ")
+	if detail == "" {
+		detail = "the server received the body without its trailers"
+	}
+	if !resp.ProtoAtLeast(1, 1) {
+		detail += "; the response came over " + resp.Proto
+	}
+	return fmt.Errorf("upload: %w (%s)", ErrTrailersNotCarried, detail)
+} // trailersNotCarried() func
+
+// mustStream reports whether an upload carries fields only known once its body went out, or
+// computed over the body as sent, which only trailers can carry, or is throttled on the wire
+func (c *Client) mustStream() bool {
+	return c.Gzip || c.Signer != nil || c.BodyToken != nil || c.Metadata != nil || c.Timestamp || c.Nonce || c.Throttle != nil
+} // mustStream() func
+
+// progressWriter reports the running total of the bytes written through it
+type progressWriter struct {
+	report func(bytesWritten int64)
+	n      int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.n += int64(len(p))
+	pw.report(pw.n)
+	return len(p), nil
+}
+
+// firstSum returns the raw sum of the first hash-based digest, or nil if there is none
+func firstSum(digests []bodyDigest) []byte {
+	for _, d := range digests {
+		if h, ok := d.(interface{ Sum([]byte) []byte }); ok {
+			return h.Sum(nil)
+		}
+	}
+	return nil
+} // firstSum() func
+
+// contextReader stops reading from r once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
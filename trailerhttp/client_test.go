@@ -0,0 +1,398 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingReader returns its data, then err instead of io.EOF
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestSendStreamReportsBodyError(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	injected := errors.New("disk read failed")
+	c := &Client{Logger: discardLogger()}
+	_, err := c.SendStream(t.Context(), srv.URL, &failingReader{data: strings.NewReader(strings.Repeat("x", 1<<16)), err: injected})
+	if !errors.Is(err, ErrBodyStream) || !errors.Is(err, injected) {
+		t.Fatalf("error %v, want it to wrap ErrBodyStream and the source error", err)
+	}
+}
+
+func TestSendStreamConnectionErrorWithBlockedSource(t *testing.T) {
+	pr, pw := io.Pipe() // never written: reading it blocks
+	defer pw.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := SendStreamWithTrailer(t.Context(), "http://127.0.0.1:1/", pr)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || errors.Is(err, ErrBodyStream) {
+			t.Fatalf("error %v, want the connection error alone", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("SendStreamWithTrailer still blocked on its source after the connection failed")
+	}
+}
+
+func TestClientPrePass(t *testing.T) {
+	rs := newRecordingServer(t)
+	data := bytes.Repeat([]byte("pre-pass "), 5000)
+	c := &Client{Algorithms: []string{"length", "sha256"}, PrePass: true, Logger: discardLogger()}
+	for _, src := range []io.Reader{bytes.NewReader(data), io.MultiReader(bytes.NewReader(data))} {
+		_, seekable := src.(io.Seeker)
+		result, err := c.SendStream(t.Context(), rs.URL, src)
+		if err != nil || !result.Matched {
+			t.Fatalf("seekable %v: %+v, %v; want a verified upload", seekable, result, err)
+		}
+		if seekable == (rs.trailer != "" || rs.chunked || rs.shaField == "") {
+			t.Errorf("seekable %v: chunked %v, Trailer %q, X-Body-SHA256 %q", seekable, rs.chunked, rs.trailer, rs.shaField)
+		}
+	}
+	c.Gzip = true // needs trailers, whatever the body
+	if _, err := c.SendStream(t.Context(), rs.URL, bytes.NewReader(data)); err != nil || rs.trailer == "" {
+		t.Errorf("gzip upload: Trailer %q, %v; want it streamed with trailers", rs.trailer, err)
+	}
+}
+
+func TestSendStreamWithTrailerLargeFile(t *testing.T) {
+	const size = 32 << 20
+	path := filepath.Join(t.TempDir(), "large.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 4<<10)
+	for written := 0; written < size; written += len(chunk) {
+		if _, err := f.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	result, err := SendStreamWithTrailer(t.Context(), srv.URL, io.MultiReader(f)) // hides the file's size
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matched || result.BodyLength != size || result.ReportedLength == nil || *result.ReportedLength != size {
+		t.Errorf("matched %v, body length %d, reported %v; want the %d bytes of the file verified", result.Matched, result.BodyLength, result.ReportedLength, size)
+	}
+}
+
+// endlessReader yields zeros forever, slowly, counting its reads
+type endlessReader struct{ reads atomic.Int64 }
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	r.reads.Add(1)
+	time.Sleep(time.Millisecond)
+	clear(p)
+	return len(p), nil
+}
+
+func TestSendStreamCancel(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	src := new(endlessReader)
+	start := time.Now()
+	_, err := (&Client{Logger: discardLogger()}).SendStream(ctx, srv.URL, src)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned %s after the upload began, want promptly after its cancellation", elapsed)
+	}
+	time.Sleep(50 * time.Millisecond) // lets a read under way finish
+	reads := src.reads.Load()
+	time.Sleep(100 * time.Millisecond)
+	if more := src.reads.Load() - reads; more > 0 {
+		t.Errorf("the source was read %d more times after SendStream returned; the writer goroutine leaked", more)
+	}
+}
+
+func TestSendWithTrailerRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	result, err := SendWithTrailer(t.Context(), srv.URL, []byte("hello, trailers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != http.StatusOK || !result.Matched || result.Outcome != "trailer-verified-ok" {
+		t.Errorf("status %d, matched %v, outcome %q; want the length trailer verified", result.StatusCode, result.Matched, result.Outcome)
+	}
+}
+
+func TestSendStreamGzip(t *testing.T) {
+	h := NewHandler(ServerOptions{Logger: discardLogger()})
+	var encoding string
+	wire := &countingWriter{w: io.Discard}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, wire), r.Body}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	body := bytes.Repeat([]byte("a highly compressible line\n"), 10000)
+	result, err := (&Client{Gzip: true, Logger: discardLogger()}).Send(t.Context(), srv.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "gzip" || wire.n >= int64(len(body))/10 {
+		t.Errorf("Content-Encoding %q, %d bytes on the wire; want a gzip body much smaller than %d", encoding, wire.n, len(body))
+	}
+	if !result.Matched || result.BodyLength != int64(len(body)) || result.ReportedLength == nil || *result.ReportedLength != int64(len(body)) {
+		t.Errorf("matched %v, body length %d, reported %v; want the decompressed length %d", result.Matched, result.BodyLength, result.ReportedLength, len(body))
+	}
+}
+
+func TestSendStreamMismatch(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	c := &Client{TrailerOverride: http.Header{"X-Body-Byte-Length": {"999"}}, Logger: discardLogger()}
+	result, err := c.Send(t.Context(), srv.URL, []byte("eleven byte"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Matched || len(result.Checks) != 1 || result.Checks[0].Computed != "11" || result.Checks[0].Reported != "999" {
+		t.Errorf("matched %v, checks %+v; want Matched false with the computed and reported lengths", result.Matched, result.Checks)
+	}
+	if result.BodyLength != 11 || result.ReportedLength == nil || *result.ReportedLength != 999 {
+		t.Errorf("body length %d, reported %v; want 11 and 999", result.BodyLength, result.ReportedLength)
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	h := NewHandler(ServerOptions{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()})
+	var attempts atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			io.Copy(io.Discard, r.Body)
+			http.Error(w, "briefly unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	var built int
+	newBody := func() io.Reader {
+		built++
+		return strings.NewReader("the body, sent twice")
+	}
+	c := &Client{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}
+	result, err := c.SendWithRetry(t.Context(), srv.URL, newBody, RetryPolicy{BaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 2 || built != 2 {
+		t.Errorf("%d attempts, %d bodies built; want the body rebuilt for a second attempt", attempts.Load(), built)
+	}
+	if !result.Matched || len(result.Checks) != 2 || result.BodyLength != int64(len("the body, sent twice")) {
+		t.Errorf("matched %v, checks %+v, body length %d; want the re-sent body and trailers verified", result.Matched, result.Checks, result.BodyLength)
+	}
+}
+
+// flippingBody flips the first byte of a request body, as corruption in transit would
+type flippingBody struct {
+	io.ReadCloser
+	flipped bool
+}
+
+func (b *flippingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.flipped {
+		p[0] ^= 0xff
+		b.flipped = true
+	}
+	return n, err
+}
+
+// unseekable is a *bytes.Reader that cannot be rewound for a second attempt
+type unseekable struct {
+	*bytes.Reader
+	seeks int
+}
+
+func (r *unseekable) Seek(offset int64, whence int) (int64, error) {
+	if r.seeks++; r.seeks > 2 { // SendSeekerWithRetry's start position, and its first rewind
+		return 0, errors.New("cannot rewind")
+	}
+	return r.Reader.Seek(offset, whence)
+}
+
+func TestSendWithRetryOnMismatch(t *testing.T) {
+	h := NewHandler(ServerOptions{Logger: discardLogger()})
+	var attempts atomic.Int64
+	var corrupted atomic.Int64 // attempts corrupted in transit
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= corrupted.Load() {
+			r.Body = &flippingBody{ReadCloser: r.Body}
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	body := []byte("corrupted on the way")
+	c := &Client{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}
+	for _, tc := range []struct {
+		name      string
+		corrupted int64
+		policy    RetryPolicy
+		attempts  int64
+		matched   bool
+	}{
+		{"always corrupted", 10, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryOnMismatch: true}, 3, false},
+		{"corrupted once", 1, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryOnMismatch: true}, 2, true},
+		{"not retried on mismatch", 10, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}, 1, false},
+	} {
+		attempts.Store(0)
+		corrupted.Store(tc.corrupted)
+		result, _ := c.SendSeekerWithRetry(t.Context(), srv.URL, bytes.NewReader(body), tc.policy)
+		if attempts.Load() != tc.attempts || result == nil || result.Matched != tc.matched {
+			t.Errorf("%s: %d attempts, result %+v; want %d attempts, matched %v", tc.name, attempts.Load(), result, tc.attempts, tc.matched)
+		}
+	}
+
+	attempts.Store(0)
+	corrupted.Store(10)
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryOnMismatch: true}
+	_, err := c.SendSeekerWithRetry(t.Context(), srv.URL, &unseekable{Reader: bytes.NewReader(body)}, policy)
+	if !errors.Is(err, ErrBodyStream) || attempts.Load() != 1 {
+		t.Errorf("body that cannot be rewound: %d attempts, error %v; want 1 and ErrBodyStream", attempts.Load(), err)
+	}
+}
+
+func TestSendStreamExpectContinueRejected(t *testing.T) {
+	h := NewHandler(ServerOptions{
+		Admit:  func(r *http.Request) (int, string) { return http.StatusForbidden, "no uploads today" },
+		Logger: discardLogger(),
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	src := new(endlessReader)
+	result, err := (&Client{ExpectContinue: true, Logger: discardLogger()}).SendStream(t.Context(), srv.URL, src)
+	if result == nil {
+		t.Fatalf("no result, error %v", err)
+	}
+	if result.StatusCode != http.StatusForbidden || !result.BodyWithheld {
+		t.Errorf("status %d, body withheld %v; want the early 403 before the body was sent", result.StatusCode, result.BodyWithheld)
+	}
+	if reads := src.reads.Load(); reads > 0 {
+		t.Errorf("the body was read %d times, want it never sent", reads)
+	}
+}
+
+func TestSendStreamOnBodyComplete(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	body := []byte("a body of known length")
+	for _, algorithms := range [][]string{nil, {"length", "sha256"}} {
+		var length int64 = -1
+		var digest []byte
+		c := &Client{Algorithms: algorithms, Logger: discardLogger(), OnBodyComplete: func(n int64, sum []byte) { length, digest = n, sum }}
+		if _, err := c.Send(t.Context(), srv.URL, body); err != nil {
+			t.Fatal(err)
+		}
+		var want []byte
+		if algorithms != nil {
+			sum := sha256.Sum256(body)
+			want = sum[:]
+		}
+		if length != int64(len(body)) || !bytes.Equal(digest, want) {
+			t.Errorf("algorithms %q: OnBodyComplete(%d, %x), want (%d, %x)", algorithms, length, digest, len(body), want)
+		}
+	}
+}
+
+func TestTrailerOverride(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Policy: PolicyStrict, Logger: discardLogger()}))
+	defer srv.Close()
+	body := []byte("a correct body")
+	for _, tc := range []struct {
+		name     string
+		override http.Header
+		status   int
+		missing  bool
+	}{
+		{"none", nil, http.StatusOK, false},
+		{"wrong length", http.Header{"X-Body-Byte-Length": {"13"}}, http.StatusUnprocessableEntity, false},
+		{"announced, no value", http.Header{"X-Body-Byte-Length": nil}, http.StatusBadRequest, true},
+	} {
+		c := &Client{TrailerOverride: tc.override, Logger: discardLogger()}
+		result, _ := c.Send(t.Context(), srv.URL, body)
+		if result == nil || result.StatusCode != tc.status || (len(result.MissingTrailers) > 0) != tc.missing {
+			t.Errorf("%s: result %+v, want status %d", tc.name, result, tc.status)
+		}
+	}
+}
+
+func TestSendStreamProgress(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	pr, pw := io.Pipe()
+	go func() {
+		for range 10 {
+			pw.Write(bytes.Repeat([]byte("p"), 1000))
+		}
+		pw.Close()
+	}()
+	var calls []int64
+	c := &Client{ProgressFunc: func(n int64) { calls = append(calls, n) }, Logger: discardLogger()}
+	result, err := c.SendStream(t.Context(), srv.URL, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) < 2 || !slices.IsSorted(calls) {
+		t.Fatalf("progress %v, want several calls with a growing count", calls)
+	}
+	if last := calls[len(calls)-1]; last != 10000 || result.ReportedLength == nil || *result.ReportedLength != last {
+		t.Errorf("last progress %d, length trailer %v; want both 10000", last, result.ReportedLength)
+	}
+}
+
+func TestSendStreamEmptyBody(t *testing.T) {
+	rs := newRecordingServer(t)
+	c := &Client{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}
+	result, err := c.SendStream(t.Context(), rs.URL, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.chunked || rs.trailer != "X-Body-Byte-Length,X-Body-Sha256" {
+		t.Errorf("chunked %v, Trailer %q; want the empty body sent chunked with its trailers", rs.chunked, rs.trailer)
+	}
+	if !result.Matched || result.Outcome != "trailer-verified-ok" || len(result.Checks) != 2 || result.ReportedLength == nil || *result.ReportedLength != 0 {
+		t.Errorf("result %+v; want length 0 and the sha256 of no bytes verified", result)
+	}
+}
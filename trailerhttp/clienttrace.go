@@ -0,0 +1,92 @@
+package trailerhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// ClientTrace is a set of hooks run at the trailer stages of a Client upload or download,
+// in the manner of httptrace.ClientTrace. Any hook may be nil. Attach it to the context
+// passed to the Client with WithClientTrace; an httptrace.ClientTrace already in that
+// context keeps receiving its own events.
+type ClientTrace struct {
+	// WroteBody is called when the last body byte has been written into the request,
+	// before the trailer values are computed. length is the number of body bytes sent
+	// (the uncompressed length with Gzip).
+	WroteBody func(length int64)
+
+	// WroteTrailers is called once the transport has written the whole request, trailer
+	// section included, or failed to. Over HTTP/3 the transport does not report it.
+	WroteTrailers func(info WroteTrailersInfo)
+
+	// GotResponseTrailers is called with the trailers of the response once its body has
+	// been read to the end.
+	GotResponseTrailers func(trailer http.Header)
+}
+
+// WroteTrailersInfo is the argument of ClientTrace.WroteTrailers
+type WroteTrailersInfo struct {
+	Trailer http.Header // the trailer fields sent
+	Err     error       // why writing the request failed, if it did
+
+	// TrailerLatency is the time from the last body byte to the end of the request:
+	// computing the trailer values (and signing them), then writing and flushing the
+	// trailer section. It is zero when the body never completed.
+	TrailerLatency time.Duration
+}
+
+// clientTraceKey is the context key of a *ClientTrace
+type clientTraceKey struct{}
+
+// WithClientTrace returns a new context based on ctx, whose Client uploads and downloads run the hooks of trace
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+} // WithClientTrace() func
+
+// ContextClientTrace returns the ClientTrace associated with ctx, or nil
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+} // ContextClientTrace() func
+
+// uploadTrace runs the hooks of a ClientTrace for one upload
+type uploadTrace struct {
+	trace    *ClientTrace
+	req      *http.Request
+	bodyDone atomic.Pointer[time.Time] // when the last body byte was written
+}
+
+// withHTTPTrace returns ctx with the httptrace hook reporting the end of the request to t
+func (t *uploadTrace) withHTTPTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{WroteRequest: t.wroteRequest})
+} // withHTTPTrace() func
+
+// wroteBody records the end of the body
+func (t *uploadTrace) wroteBody(length int64) {
+	now := time.Now()
+	t.bodyDone.Store(&now)
+	if t.trace.WroteBody != nil {
+		t.trace.WroteBody(length)
+	}
+} // wroteBody() func
+
+func (t *uploadTrace) wroteRequest(info httptrace.WroteRequestInfo) {
+	if t.trace.WroteTrailers == nil {
+		return
+	}
+	result := WroteTrailersInfo{Trailer: t.req.Trailer, Err: info.Err}
+	if bodyDone := t.bodyDone.Load(); bodyDone != nil {
+		result.TrailerLatency = time.Since(*bodyDone)
+	}
+	t.trace.WroteTrailers(result)
+} // wroteRequest() func
+
+// gotResponseTrailers reports the trailers of a response read to the end to the ClientTrace of ctx, if any
+func gotResponseTrailers(ctx context.Context, trailer http.Header) {
+	if trace := ContextClientTrace(ctx); trace != nil && trace.GotResponseTrailers != nil {
+		trace.GotResponseTrailers(trailer)
+	}
+} // gotResponseTrailers() func
@@ -0,0 +1,94 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Failures of a gzip-encoded body that carries both a digest of the bytes as sent (an RFC 9530
+// Content-Digest or Repr-Digest) and one of the decoded content (e.g. X-Body-SHA256), which
+// together tell where it went wrong. Results wrap them around the mismatch sentinels.
+var (
+	// ErrTransportCorruption reports a body whose bytes as received differ from the ones sent
+	ErrTransportCorruption = errors.New("body corrupted in transit")
+	// ErrEncodingMismatch reports a body that arrived intact but does not decode to the content
+	// its trailers describe: the sender's encoder or the receiver's decoder is at fault
+	ErrEncodingMismatch = errors.New("body arrived intact but decodes to different content")
+)
+
+// isEncodedCheck reports whether a result comes from a digest of the body as sent
+func isEncodedCheck(result VerificationResult) bool {
+	v, err := lookupVerifier(result.Algorithm)
+	return err == nil && v.Encoded
+} // isEncodedCheck() func
+
+// isMismatch reports whether a result failed because the body disagrees with a well-formed trailer
+func isMismatch(result VerificationResult) bool {
+	return errors.Is(result.Err, ErrLengthMismatch) || errors.Is(result.Err, ErrHashMismatch) || errors.Is(result.Err, ErrRecordCountMismatch)
+} // isMismatch() func
+
+// diagnoseCoding refines the mismatches among the results of a decoded body. If a digest of the
+// bytes as sent fails, every mismatch becomes an ErrTransportCorruption; if those all pass, a
+// mismatch of the decoded content becomes an ErrEncodingMismatch. Without a digest of each kind
+// there is nothing to compare and the results are left alone.
+func diagnoseCoding(results []VerificationResult) {
+	wireChecked, wireCorrupt, contentMismatch := false, false, false
+	for _, result := range results {
+		switch {
+		case isEncodedCheck(result):
+			wireChecked = wireChecked || result.Matched || isMismatch(result)
+			wireCorrupt = wireCorrupt || isMismatch(result)
+		case isMismatch(result):
+			contentMismatch = true
+		}
+	}
+	if !wireChecked || !contentMismatch && !wireCorrupt {
+		return
+	}
+	cause := ErrEncodingMismatch
+	if wireCorrupt {
+		cause = ErrTransportCorruption
+	}
+	for i, result := range results {
+		if isMismatch(result) {
+			results[i].Err = fmt.Errorf("%w: %w", cause, result.Err)
+		}
+	}
+} // diagnoseCoding() func
+
+// wireChecks drains raw, the rest of a gzip body that failed to decode as read by the decoder,
+// and runs the checks over the bytes as sent, so the failure can be blamed on the transfer or on
+// the sender's encoder. digests holds the digest of each verifier by trailer name. It returns
+// no results if no trailer covers the bytes as sent.
+func wireChecks(raw io.Reader, trailer http.Header, verifiers []trailerVerifier, digests map[string]bodyDigest) []VerificationResult {
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return nil
+	}
+	var results []VerificationResult
+	for _, v := range verifiers {
+		d, computed := digests[v.TrailerName]
+		if reported, ok := v.reported(trailer); v.Encoded && computed && ok {
+			result := v.verify(d, reported)
+			if isMismatch(result) {
+				result.Err = fmt.Errorf("%w: %w", ErrTransportCorruption, result.Err)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+} // wireChecks() func
+
+// corruptCodingError explains a gzip body that failed to decode, given the wireChecks results
+func corruptCodingError(err error, results []VerificationResult) error {
+	for _, result := range results {
+		if !result.Matched {
+			return fmt.Errorf("%w: %s does not match: %w", ErrTransportCorruption, result.TrailerName, err)
+		}
+	}
+	if len(results) > 0 {
+		return fmt.Errorf("%w: the sender's gzip stream is malformed: %w", ErrEncodingMismatch, err)
+	}
+	return err
+} // corruptCodingError() func
@@ -0,0 +1,84 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerDiagnosesCorruption(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	content := make([]byte, 9<<20) // three Merkle segments
+	rand.NewChaCha8([32]byte{}).Read(content)
+	const damagedAt = 5 << 20 // in segment 1
+	gz := func(b []byte) []byte {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.NoCompression) // stored blocks: content bytes appear as they are
+		zw.Write(b)
+		zw.Close()
+		return buf.Bytes()
+	}
+	contentDigest := func(b []byte) string {
+		field, _ := FormatDigestField(Digest{Algorithm: "sha256", Sum: sha256Sum(b)})
+		return field
+	}
+	merkle := newMerkleDigest(DefaultMerkleSegmentSize)
+	merkle.Write(content)
+	damaged := bytes.Clone(content)
+	damaged[damagedAt] ^= 0xff
+
+	encoderBug := gz(damaged) // the sender compressed other bytes than it hashed
+	inTransit := gz(content)
+	inTransit[bytes.Index(inTransit, content[damagedAt:damagedAt+64])] ^= 0xff // flipped on the way, after the Content-Digest was taken
+	for _, tc := range []struct {
+		name          string
+		wire, digests []byte // the body sent, and the one Content-Digest covers
+		cause         error
+		segments      string // where the Merkle check locates the damage, "" when the body does not decode
+	}{
+		{"encoder bug", encoderBug, encoderBug, ErrEncodingMismatch, "segments 1 of 3 differ"},
+		{"transport corruption", inTransit, gz(content), ErrTransportCorruption, ""},
+	} {
+		req := &RawRequest{
+			Header:    []RawField{{"Content-Encoding", "gzip"}},
+			Body:      tc.wire,
+			ChunkSize: 64 << 10,
+			Trailer: []RawField{
+				{"Content-Digest", contentDigest(tc.digests)},
+				{"X-Body-SHA256", hex.EncodeToString(sha256Sum(content))},
+				{"X-Body-Merkle-SHA256", merkle.Value()},
+			},
+		}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var result UploadResult
+		if err := json.Unmarshal(raw.Body, &result); err != nil {
+			t.Fatalf("%s: %v: %s", tc.name, err, raw.Body)
+		}
+		var failed []string
+		for _, check := range result.Checks {
+			if check.Error != "" {
+				failed = append(failed, check.Error)
+			}
+		}
+		all := strings.Join(failed, "; ")
+		if result.Matched || !strings.Contains(all, tc.cause.Error()) || !strings.Contains(all, tc.segments) {
+			t.Errorf("%s: matched %v, failed checks %q; want %q and %q", tc.name, result.Matched, all, tc.cause, tc.segments)
+		}
+	}
+}
+
+// sha256Sum returns the SHA-256 of b as a slice
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+} // sha256Sum() func
@@ -0,0 +1,172 @@
+package trailerhttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// contentCoding is a content coding a compressed response can be sent with
+type contentCoding struct {
+	name      string
+	newWriter func(io.Writer) contentEncoder
+	newReader func(io.Reader) (io.ReadCloser, error)
+}
+
+// contentEncoder compresses a response body; Flush sends what it holds so a streamed
+// response keeps moving
+type contentEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// contentCodings are the codings responses are compressed with and decompressed from, in the
+// server's order of preference; building with the zstd tag puts zstd ahead of gzip
+var contentCodings = []contentCoding{{
+	name:      "gzip",
+	newWriter: func(w io.Writer) contentEncoder { return gzip.NewWriter(w) },
+	newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+}}
+
+// acceptEncoding is the Accept-Encoding header of a client that decompresses every coding
+// in contentCodings
+func acceptEncoding() string {
+	names := make([]string, len(contentCodings))
+	for i, coding := range contentCodings {
+		names[i] = coding.name
+	}
+	return strings.Join(names, ", ")
+} // acceptEncoding() func
+
+// lookupCoding returns the content coding called name
+func lookupCoding(name string) (contentCoding, bool) {
+	i := slices.IndexFunc(contentCodings, func(c contentCoding) bool { return strings.EqualFold(c.name, name) })
+	if i < 0 {
+		return contentCoding{}, false
+	}
+	return contentCodings[i], true
+} // lookupCoding() func
+
+// negotiateCoding picks the content coding of the response to r from its Accept-Encoding
+// (RFC 9110, Section 12.5.3): the one with the highest weight, the server's preference
+// among equals, with "*" standing for any coding not listed; ok is false for identity
+func negotiateCoding(r *http.Request) (coding contentCoding, ok bool) {
+	weights := map[string]float64{}
+	for _, field := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(field, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			weight := 1.0
+			if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				w, err := strconv.ParseFloat(q, 64)
+				if err != nil {
+					continue
+				}
+				weight = w
+			}
+			weights[name] = weight
+		}
+	}
+	best := 0.0
+	for _, c := range contentCodings {
+		weight, listed := weights[c.name]
+		if !listed {
+			weight = weights["*"]
+		}
+		if weight > best {
+			coding, ok, best = c, true, weight
+		}
+	}
+	return coding, ok
+} // negotiateCoding() func
+
+// compressible reports whether a response of contentType is worth compressing: not images,
+// audio or video, which their own formats compress already, nor archives
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	major, minor, _ := strings.Cut(mediaType, "/")
+	switch {
+	case major == "image":
+		return minor == "svg+xml" || minor == "bmp"
+	case major == "audio" || major == "video":
+		return false
+	}
+	return !slices.Contains([]string{"zip", "gzip", "x-gzip", "zstd", "x-bzip2", "x-xz", "x-7z-compressed", "x-rar-compressed"}, minor)
+} // compressible() func
+
+// bodyAllowed reports whether a response of status, 0 before the handler wrote one, has a body
+func bodyAllowed(status int) bool {
+	return status == 0 || status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+} // bodyAllowed() func
+
+// NewCompressedResponseWriter is NewTrailerResponseWriterFallback for a response body
+// compressed with the best content coding r accepts in its Accept-Encoding: gzip, or zstd
+// when built with the zstd tag. It sets Content-Encoding, and Vary: Accept-Encoding whatever
+// it picks. The trailers still describe the uncompressed body, so a client checks what it
+// decompressed (Client.Download and Transport.VerifyResponses do, as does a body from
+// NewDecodedVerifiedResponse); only the RFC 9530 digests cover the compressed bytes as sent.
+// A Content-Length the handler sets is dropped from a compressed response.
+func NewCompressedResponseWriter(w http.ResponseWriter, r *http.Request, fallback TrailerFallback, algos ...TrailerAlgo) *TrailerResponseWriter {
+	tw := NewTrailerResponseWriterFallback(w, r, fallback, algos...)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if coding, ok := negotiateCoding(r); ok {
+		tw.coding = &coding
+		w.Header().Set("Content-Encoding", coding.name)
+	}
+	return tw
+} // NewCompressedResponseWriter() func
+
+// CompressedResponseTrailers is ResponseTrailersFallback with the response bodies compressed
+// for the clients that accept it, as NewCompressedResponseWriter does
+func CompressedResponseTrailers(next http.Handler, fallback TrailerFallback, algos ...TrailerAlgo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := NewCompressedResponseWriter(w, r, fallback, algos...)
+		next.ServeHTTP(tw, r)
+		tw.Finish()
+	})
+} // CompressedResponseTrailers() func
+
+// NewDecodedVerifiedResponse is NewVerifiedResponse for a response that may be compressed:
+// the body is read decompressed per its Content-Encoding, gzip or, when built with the zstd
+// tag, zstd, and checked as NewGzipVerifiedBody checks a request. A response without a
+// content coding is read as it is. It fails for a coding it cannot decode.
+func NewDecodedVerifiedResponse(resp *http.Response, key []byte) (*VerifiedBody, error) {
+	return verifyDecodedResponse(resp, key, nil)
+} // NewDecodedVerifiedResponse() func
+
+// verifyDecodedResponse is NewDecodedVerifiedResponse with the trailer fields renamed per names
+func verifyDecodedResponse(resp *http.Response, key []byte, names map[string]string) (*VerifiedBody, error) {
+	vb := verifyResponse(resp, key, names)
+	name := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	if name == "" || strings.EqualFold(name, "identity") {
+		return vb, nil
+	}
+	coding, ok := lookupCoding(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported response Content-Encoding %q", name)
+	}
+	if err := vb.decode(coding, resp.Body); err != nil {
+		return nil, fmt.Errorf("invalid %s response body: %w", coding.name, err)
+	}
+	return vb, nil
+} // verifyDecodedResponse() func
+
+// uncompressed marks resp, whose body a decoding VerifiedBody now reads, as decompressed the
+// way net/http's transparent gzip does
+func uncompressed(resp *http.Response) {
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+} // uncompressed() func
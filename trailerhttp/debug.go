@@ -0,0 +1,119 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redactedTrailers are the trailer fields a TrailerLog does not show the value of, as they are credentials
+var redactedTrailers = []string{BodyTokenTrailer}
+
+// TrailerLogEntry is what a TrailerLog keeps of a handled request
+type TrailerLogEntry struct {
+	Time       time.Time     `json:"time"` // when the request arrived
+	Duration   time.Duration `json:"duration"`
+	RemoteAddr string        `json:"remote_addr"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	Path       string        `json:"path"`
+	Trailer    http.Header   `json:"trailer"` // the trailer fields received, credentials redacted
+	Result     *UploadResult `json:"result"`  // the checks with their reported and computed values, and the outcome
+}
+
+// TrailerLog keeps the last requests a Handler verified, newest first, for an operator to see
+// why the uploads of a client keep failing: the trailer fields that arrived, the value each
+// check computed against the one reported, the outcome and the timing. Set it as
+// ServerOptions.TrailerLog; RegisterHandlers serves it at ServerOptions.DebugPath. It holds
+// trailer values, so keep the endpoint away from the public, as /debug/vars.
+type TrailerLog struct {
+	mu      sync.Mutex
+	entries []TrailerLogEntry // a ring of the last len(entries) requests
+	next    int               // the slot the next entry is written to
+	full    bool
+}
+
+// NewTrailerLog returns a TrailerLog of the last n requests. It panics if n is not positive.
+func NewTrailerLog(n int) *TrailerLog {
+	if n <= 0 {
+		panic("NewTrailerLog: n must be positive")
+	}
+	return &TrailerLog{entries: make([]TrailerLogEntry, n)}
+} // NewTrailerLog() func
+
+// record adds the request r, handled from start into result, once its trailers have arrived
+func (l *TrailerLog) record(r *http.Request, start time.Time, result *UploadResult) {
+	trailer := r.Trailer.Clone()
+	for _, name := range redactedTrailers {
+		if values, ok := lookupField(trailer, name); ok && len(values) > 0 {
+			trailer[http.CanonicalHeaderKey(name)] = []string{"[redacted]"}
+		}
+	}
+	entry := TrailerLogEntry{
+		Time:       start,
+		Duration:   time.Since(start),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Path:       r.URL.Path,
+		Trailer:    trailer,
+		Result:     result,
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	l.full = l.full || l.next == 0
+} // record() func
+
+// Entries returns the requests kept, newest first
+func (l *TrailerLog) Entries() []TrailerLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	entries := make([]TrailerLogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return entries
+} // Entries() func
+
+// ServeHTTP lists the requests kept as JSON, newest first. The query narrows them down:
+// remote=<host> to the requests of one client address, outcome=<class> to one outcome
+// (trailer-failed, trailer-announced-missing, ...), failed=1 to those that did not verify,
+// and n=<count> to the latest ones.
+func (l *TrailerLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("n"))
+	if q.Has("n") && (err != nil || limit < 0) {
+		http.Error(w, "n must be a count", http.StatusBadRequest)
+		return
+	}
+	entries := []TrailerLogEntry{}
+	for _, entry := range l.Entries() {
+		if q.Has("n") && len(entries) == limit {
+			break
+		}
+		host, _, err := net.SplitHostPort(entry.RemoteAddr)
+		if err != nil {
+			host = entry.RemoteAddr
+		}
+		switch {
+		case q.Has("remote") && q.Get("remote") != host && q.Get("remote") != entry.RemoteAddr:
+		case q.Has("outcome") && !strings.EqualFold(q.Get("outcome"), entry.Result.Outcome):
+		case q.Get("failed") == "1" && entry.Result.Outcome != outcomeFailed && entry.Result.Outcome != outcomeAnnouncedMissing:
+		default:
+			entries = append(entries, entry)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(entries)
+} // ServeHTTP() func
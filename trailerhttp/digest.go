@@ -0,0 +1,179 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Digest is a hash of content in the canonical "alg:hex" form of OCI and Docker, such as
+//
+//	sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+//
+// so the digests of trailers can be handed to container registries and content-addressed
+// stores as they are. Algorithm is "sha256" or "sha512", the hashes RFC 9530 digest fields
+// have; DigestsFromField and FormatDigestField convert from and to those.
+type Digest struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// errDigest reports a malformed "alg:hex" digest
+var errDigest = errors.New("invalid digest")
+
+// ParseDigest parses a digest in "alg:hex" form; the hex must be lowercase, as OCI requires,
+// and as long as the algorithm's sums
+func ParseDigest(s string) (Digest, error) {
+	name, encoded, ok := strings.Cut(s, ":")
+	if !ok {
+		return Digest{}, fmt.Errorf("%w: %q is not in alg:hex form", errDigest, s)
+	}
+	i := digestAlgorithm(name)
+	if i < 0 {
+		return Digest{}, fmt.Errorf("%w: %q: unsupported algorithm %q (want sha256 or sha512)", errDigest, s, name)
+	}
+	size := digestFieldAlgorithms[i].newHash().Size()
+	if len(encoded) != 2*size || strings.ToLower(encoded) != encoded {
+		return Digest{}, fmt.Errorf("%w: %q: want %d lowercase hex digits", errDigest, s, 2*size)
+	}
+	sum, err := hex.DecodeString(encoded)
+	if err != nil {
+		return Digest{}, fmt.Errorf("%w: %q: %w", errDigest, s, err)
+	}
+	return Digest{Algorithm: name, Sum: sum}, nil
+} // ParseDigest() func
+
+// digestAlgorithm returns the index in digestFieldAlgorithms of the algorithm of name in
+// "alg:hex" digests, or -1
+func digestAlgorithm(name string) int {
+	for i, algo := range digestFieldAlgorithms {
+		if algo.name == name {
+			return i
+		}
+	}
+	return -1
+} // digestAlgorithm() func
+
+// String formats d in "alg:hex" form, or returns "" for the zero Digest
+func (d Digest) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Algorithm + ":" + hex.EncodeToString(d.Sum)
+} // String() func
+
+// MarshalText returns d in "alg:hex" form, so digests can be used in JSON or with flag.TextVar
+func (d Digest) MarshalText() ([]byte, error) {
+	if _, err := d.FieldMember(); err != nil { // an algorithm and sum that go together
+		return nil, err
+	}
+	return []byte(d.String()), nil
+} // MarshalText() func
+
+// UnmarshalText parses a digest in "alg:hex" form, as ParseDigest does
+func (d *Digest) UnmarshalText(text []byte) error {
+	parsed, err := ParseDigest(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+} // UnmarshalText() func
+
+// IsZero reports whether d holds no digest
+func (d Digest) IsZero() bool {
+	return d.Algorithm == "" && len(d.Sum) == 0
+} // IsZero() func
+
+// Equal reports whether d and other are the same digest: the same algorithm and sum, the sums
+// compared in constant time
+func (d Digest) Equal(other Digest) bool {
+	return d.Algorithm == other.Algorithm && subtle.ConstantTimeCompare(d.Sum, other.Sum) == 1
+} // Equal() func
+
+// MatchesField reports whether the RFC 9530 digest field value field, a Content-Digest or
+// Repr-Digest, carries d; a field without d's algorithm is an error, since it tells nothing
+func (d Digest) MatchesField(field string) (bool, error) {
+	digests, err := DigestsFromField(field)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range digests {
+		if other.Algorithm == d.Algorithm {
+			return d.Equal(other), nil
+		}
+	}
+	return false, fmt.Errorf("digest field has no %s digest", d.Algorithm)
+} // MatchesField() func
+
+// FieldMember returns d as a member of an RFC 9530 digest field, such as sha-256=:47DE...VQ=:
+func (d Digest) FieldMember() (string, error) {
+	i := digestAlgorithm(d.Algorithm)
+	if i < 0 || len(d.Sum) != digestFieldAlgorithms[i].newHash().Size() {
+		return "", fmt.Errorf("%w: %s", errDigest, d)
+	}
+	return digestFieldAlgorithms[i].key + "=:" + base64.StdEncoding.EncodeToString(d.Sum) + ":", nil
+} // FieldMember() func
+
+// FormatDigestField returns the RFC 9530 digest field value, for Content-Digest or Repr-Digest,
+// carrying digests
+func FormatDigestField(digests ...Digest) (string, error) {
+	members := make([]string, len(digests))
+	for i, d := range digests {
+		member, err := d.FieldMember()
+		if err != nil {
+			return "", err
+		}
+		members[i] = member
+	}
+	return strings.Join(members, ", "), nil
+} // FormatDigestField() func
+
+// DigestsFromField returns the digests an RFC 9530 digest field value carries, such as a
+// Content-Digest trailer, strongest last; members of other algorithms are skipped, but one
+// at least must be supported
+func DigestsFromField(field string) ([]Digest, error) {
+	dict, err := parseDigestDictionary(field)
+	if err != nil {
+		return nil, err
+	}
+	var digests []Digest
+	for _, algo := range digestFieldAlgorithms {
+		if sum, ok := dict[algo.key]; ok {
+			if len(sum) != algo.newHash().Size() {
+				return nil, fmt.Errorf("%w: %s digest of %d bytes", errDigest, algo.key, len(sum))
+			}
+			digests = append(digests, Digest{Algorithm: algo.name, Sum: sum})
+		}
+	}
+	if len(digests) == 0 {
+		return nil, errNoSupportedDigest
+	}
+	return digests, nil
+} // DigestsFromField() func
+
+// ResultDigest returns the digest of the body a check computed, for the checks by SHA-256 or
+// an RFC 9530 digest field (its strongest digest), or false for other checks
+func ResultDigest(result VerificationResult) (Digest, bool) {
+	var sum []byte
+	switch result.Algorithm {
+	case "sha256":
+		sum, _ = hex.DecodeString(result.Computed)
+	case "amz-sha256":
+		sum, _ = base64.StdEncoding.DecodeString(result.Computed)
+	case "content-digest", "repr-digest":
+		digests, err := DigestsFromField(result.Computed)
+		if err != nil {
+			return Digest{}, false
+		}
+		return digests[len(digests)-1], true
+	}
+	if len(sum) != sha256.Size {
+		return Digest{}, false
+	}
+	return Digest{Algorithm: "sha256", Sum: sum}, true
+} // ResultDigest() func
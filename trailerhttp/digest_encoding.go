@@ -0,0 +1,79 @@
+package trailerhttp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DigestEncoding is how the value of a digest trailer such as X-Body-SHA256 or X-Body-CRC32C is
+// written, for receivers that expect a particular form. Verifiers accept all of them whatever
+// the sender chose; the RFC 9530 fields are always byte sequences, the S3 checksums base64.
+type DigestEncoding string
+
+const (
+	DigestHex          DigestEncoding = "hex"       // lowercase hex, the default
+	DigestBase64       DigestEncoding = "base64"    // standard base64, padded
+	DigestByteSequence DigestEncoding = "sf-binary" // RFC 8941 byte sequence: base64 between colons
+)
+
+// errDigestEncoding reports a digest trailer value in none of the DigestEncodings
+var errDigestEncoding = errors.New("not a hex, base64 or byte sequence digest")
+
+// ParseDigestEncoding returns the DigestEncoding named name: "hex", "base64" or "sf-binary"
+func ParseDigestEncoding(name string) (DigestEncoding, error) {
+	switch enc := DigestEncoding(strings.ToLower(strings.TrimSpace(name))); enc {
+	case DigestHex, DigestBase64, DigestByteSequence:
+		return enc, nil
+	}
+	return "", fmt.Errorf("unknown digest encoding %q (want hex, base64 or sf-binary)", name)
+} // ParseDigestEncoding() func
+
+// encode writes sum in enc; the zero value is DigestHex
+func (enc DigestEncoding) encode(sum []byte) string {
+	switch enc {
+	case DigestBase64:
+		return base64.StdEncoding.EncodeToString(sum)
+	case DigestByteSequence:
+		return ":" + base64.StdEncoding.EncodeToString(sum) + ":"
+	default:
+		return hex.EncodeToString(sum)
+	}
+} // encode() func
+
+// decodeDigest decodes a digest trailer value in any DigestEncoding, telling them apart by the
+// colons of a byte sequence and by size, the length of the digest in bytes: a value that is
+// both valid hex and valid base64 means the one that decodes to size bytes. Hex of another
+// length decodes too, so that it compares as a mismatch rather than as malformed. A size of 0
+// means unknown, preferring hex.
+func decodeDigest(value string, size int) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if inner, ok := strings.CutPrefix(value, ":"); ok && strings.HasSuffix(inner, ":") {
+		return base64.StdEncoding.DecodeString(strings.TrimSuffix(inner, ":"))
+	}
+	if size <= 0 || len(value) == hex.EncodedLen(size) {
+		if sum, err := hex.DecodeString(value); err == nil {
+			return sum, nil
+		}
+	}
+	if sum, err := base64.StdEncoding.DecodeString(value); err == nil && (size <= 0 || len(sum) == size) {
+		return sum, nil
+	}
+	if sum, err := hex.DecodeString(value); err == nil {
+		return sum, nil
+	}
+	return nil, errDigestEncoding
+} // decodeDigest() func
+
+// withEncoding makes d, if it is a digest whose value can take any DigestEncoding, write it in enc
+func withEncoding(d bodyDigest, enc DigestEncoding) bodyDigest {
+	switch hd := d.(type) {
+	case *hashDigest:
+		hd.encoding = enc
+	case *hmacDigest:
+		hd.encoding = enc
+	}
+	return d
+} // withEncoding() func
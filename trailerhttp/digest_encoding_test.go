@@ -0,0 +1,61 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDecodeDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	for _, enc := range []DigestEncoding{DigestHex, DigestBase64, DigestByteSequence, ""} {
+		value := enc.encode(sum[:])
+		for _, size := range []int{sha256.Size, 0} {
+			if got, err := decodeDigest(value, size); err != nil || !bytes.Equal(got, sum[:]) {
+				t.Errorf("%q encoding %s, size %d: decoded %x, %v", enc, value, size, got, err)
+			}
+		}
+	}
+
+	deadbeef, _ := hex.DecodeString("deadbeef")
+	for _, tc := range []struct {
+		name  string
+		value string
+		size  int
+		want  []byte // nil for errDigestEncoding
+	}{
+		{"hex and base64, 4-byte sum", "deadbeef", 4, deadbeef},
+		{"hex and base64, 6-byte sum", "deadbeef", 6, []byte{0x75, 0xe6, 0x9d, 0x6d, 0xe7, 0x9f}},
+		{"hex and base64, size unknown", "deadbeef", 0, deadbeef},
+		{"hex of another length", "abcd", sha256.Size, []byte{0xab, 0xcd}},
+		{"base64 padded", "3q2+7w==", 4, deadbeef},
+		{"byte sequence", ":3q2+7w==:", 0, deadbeef},
+		{"surrounding space", " deadbeef ", 4, deadbeef},
+		{"neither", "not a digest!", 4, nil},
+		{"bad byte sequence", ":3q2+7w:", 4, nil},
+	} {
+		got, err := decodeDigest(tc.value, tc.size)
+		switch {
+		case tc.want == nil && err == nil:
+			t.Errorf("%s: decoded %x, want an error", tc.name, got)
+		case tc.want != nil && (err != nil || !bytes.Equal(got, tc.want)):
+			t.Errorf("%s: decoded %x, %v; want %x", tc.name, got, err, tc.want)
+		}
+	}
+	if _, err := decodeDigest("not a digest!", 0); !errors.Is(err, errDigestEncoding) {
+		t.Errorf("error %v, want errDigestEncoding", err)
+	}
+}
+
+func TestParseDigestEncoding(t *testing.T) {
+	for name, want := range map[string]DigestEncoding{"hex": DigestHex, " Base64 ": DigestBase64, "sf-binary": DigestByteSequence} {
+		if enc, err := ParseDigestEncoding(name); err != nil || enc != want {
+			t.Errorf("%q: %q, %v; want %q", name, enc, err, want)
+		}
+	}
+	if enc, err := ParseDigestEncoding("base32"); err == nil {
+		t.Errorf("base32: %q, want an error", enc)
+	}
+}
@@ -0,0 +1,98 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// digestFieldAlgorithms are the RFC 9530 hash algorithms sent and checked, in the order they are
+// sent, weakest first
+var digestFieldAlgorithms = []struct {
+	key     string // key in the Content-Digest / Repr-Digest dictionary
+	name    string // name of the algorithm in the "alg:hex" form of Digest
+	newHash func() hash.Hash
+}{
+	{"sha-256", "sha256", sha256.New},
+	{"sha-512", "sha512", sha512.New},
+}
+
+// errNoSupportedDigest reports a digest field that carries only algorithms this package does not implement
+var errNoSupportedDigest = errors.New("no supported digest algorithm (want sha-256 or sha-512)")
+
+// digestFieldDigest computes an RFC 9530 integrity field (Content-Digest or Repr-Digest):
+// a structured-field dictionary mapping algorithm keys to byte sequences, such as
+//
+//	sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:, sha-512=:...:
+//
+// Both fields cover the bytes as sent, content coding applied. For a complete,
+// unranged body the two are equal.
+type digestFieldDigest struct {
+	hashes []hash.Hash
+}
+
+func newDigestFieldDigest([]byte) bodyDigest {
+	d := &digestFieldDigest{}
+	for _, algo := range digestFieldAlgorithms {
+		d.hashes = append(d.hashes, algo.newHash())
+	}
+	return d
+} // newDigestFieldDigest() func
+
+func (d *digestFieldDigest) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *digestFieldDigest) Value() string {
+	members := make([]string, len(d.hashes))
+	for i, h := range d.hashes {
+		members[i] = digestFieldAlgorithms[i].key + "=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":"
+	}
+	return strings.Join(members, ", ")
+}
+
+// Matches compares the strongest algorithm of the reported dictionary that is also supported
+// here; unknown ones are ignored (RFC 9530, Section 2), but at least one must be supported.
+// The dictionary may be the field lines of a repeated field combined (see CombineFieldValues).
+func (d *digestFieldDigest) Matches(reported string) (bool, error) {
+	dict, err := parseDigestDictionary(reported)
+	if err != nil {
+		return false, err
+	}
+	for i := len(digestFieldAlgorithms) - 1; i >= 0; i-- {
+		if sum, ok := dict[digestFieldAlgorithms[i].key]; ok {
+			return subtle.ConstantTimeCompare(sum, d.hashes[i].Sum(nil)) == 1, nil
+		}
+	}
+	return false, errNoSupportedDigest
+}
+
+// parseDigestDictionary parses an RFC 9530 dictionary of digests: members of the form
+// key=:base64: with optional parameters, which are ignored. An algorithm may repeat, as when
+// a field is split over several lines, but only with the same digest.
+func parseDigestDictionary(field string) (map[string][]byte, error) {
+	if err := checkDuplicateMembers(field); err != nil {
+		return nil, err
+	}
+	members, err := ParseDictionary(field)
+	if err != nil {
+		return nil, err
+	}
+	dict := make(map[string][]byte, len(members))
+	for _, member := range members {
+		sum, ok := member.Value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("digest for %q is not a byte sequence", member.Key)
+		}
+		dict[member.Key] = sum
+	}
+	return dict, nil
+} // parseDigestDictionary() func
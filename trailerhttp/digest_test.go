@@ -0,0 +1,73 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseDigest(t *testing.T) {
+	empty256 := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	empty512 := "sha512:cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"
+	for _, s := range []string{empty256, empty512} {
+		d, err := ParseDigest(s)
+		if err != nil || d.String() != s {
+			t.Errorf("%s: parsed %v, %v; want it back as it was", s, d, err)
+		}
+		b, err := json.Marshal(d)
+		var back Digest
+		if err != nil || json.Unmarshal(b, &back) != nil || !back.Equal(d) {
+			t.Errorf("%s: JSON %s, %v; want a round trip", s, b, err)
+		}
+	}
+	for _, tc := range []struct{ name, s string }{
+		{"no colon", strings.Replace(empty256, ":", "", 1)},
+		{"unknown algorithm", strings.Replace(empty256, "sha256", "md5", 1)},
+		{"uppercase hex", strings.ToUpper(empty256[:10]) + empty256[10:]},
+		{"short", empty256[:len(empty256)-2]},
+		{"not hex", empty256[:len(empty256)-2] + "zz"},
+		{"empty", ""},
+	} {
+		if d, err := ParseDigest(tc.s); !errors.Is(err, errDigest) {
+			t.Errorf("%s: parsed %v, %v; want errDigest", tc.name, d, err)
+		}
+	}
+
+	var zero Digest
+	if s := zero.String(); s != "" {
+		t.Errorf("zero Digest formats as %q, want \"\"", s)
+	}
+	if _, err := zero.MarshalText(); err == nil {
+		t.Error("zero Digest marshals without an error")
+	}
+}
+
+func TestDigestField(t *testing.T) {
+	d256, _ := ParseDigest("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	field, err := FormatDigestField(d256)
+	if err != nil || field != "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:" {
+		t.Fatalf("field %q, %v", field, err)
+	}
+	digests, err := DigestsFromField(field + ", md5=:1B2M2Y8AsgTpgAmY7PhCfg==:")
+	if err != nil || len(digests) != 1 || !digests[0].Equal(d256) {
+		t.Errorf("parsed %v, %v; want the sha-256 digest back, md5 skipped", digests, err)
+	}
+	if ok, err := d256.MatchesField(field); !ok || err != nil {
+		t.Errorf("MatchesField: %v, %v", ok, err)
+	}
+	for _, tc := range []struct{ name, field string }{
+		{"missing colons", "sha-256=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{"bad base64", "sha-256=:47DEQpj8HBSa+/TImW+5JCeu!!!km5NMpJWZG3hSuFU=:"},
+		{"wrong length", "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZ:"},
+		{"unknown algorithm only", "md5=:1B2M2Y8AsgTpgAmY7PhCfg==:"},
+		{"empty", ""},
+	} {
+		if digests, err := DigestsFromField(tc.field); err == nil {
+			t.Errorf("%s: parsed %v, want an error", tc.name, digests)
+		}
+	}
+	if _, err := FormatDigestField(Digest{Algorithm: "sha256", Sum: []byte{1, 2, 3}}); !errors.Is(err, errDigest) {
+		t.Errorf("short sum formatted: %v", err)
+	}
+}
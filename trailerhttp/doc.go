@@ -0,0 +1,18 @@
+// Package trailerhttp sends and verifies HTTP request bodies whose integrity
+// metadata (byte length, CRC32, SHA-256, HMAC) travels in trailer fields after
+// the body. Client computes the trailers while it streams the body through an
+// io.Pipe, so neither the size nor the digest has to be known upfront; Handler
+// recomputes them while it reads the body and compares once the trailers arrive.
+//
+// HTTP Trailer Fields spec: https://www.rfc-editor.org/rfc/rfc9110.html#trailer.fields
+package trailerhttp
+
+import "log/slog"
+
+// orDefaultLogger returns l, or slog.Default() for the Clients, Handlers and Proxies not given a logger of their own
+func orDefaultLogger(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	return slog.Default()
+} // orDefaultLogger() func
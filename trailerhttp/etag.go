@@ -0,0 +1,106 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ETagIndex remembers the ETags of the uploads a server keeps, for the If-None-Match pre-check
+// of ServerOptions.ETags; a store of the uploads can implement it next to its storage
+type ETagIndex interface {
+	HasETag(etag string) bool
+	AddETag(etag string)
+}
+
+// MemoryETagIndex is an ETagIndex in memory, safe for concurrent use; its zero value is empty
+type MemoryETagIndex struct {
+	mu    sync.RWMutex
+	etags map[string]struct{}
+}
+
+func (ix *MemoryETagIndex) HasETag(etag string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.etags[etag]
+	return ok
+}
+
+func (ix *MemoryETagIndex) AddETag(etag string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.etags == nil {
+		ix.etags = make(map[string]struct{})
+	}
+	ix.etags[etag] = struct{}{}
+}
+
+// ContentETag returns the strong ETag a Handler derives for an upload of the bytes of r: the
+// lowercase hex SHA-256 of the content, prefixed "sha256-" and quoted
+func ContentETag(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return sha256ETag(h.Sum(nil)), nil
+} // ContentETag() func
+
+// sha256ETag formats a SHA-256 sum of the content as an entity tag
+func sha256ETag(sum []byte) string {
+	return `"sha256-` + hex.EncodeToString(sum) + `"`
+} // sha256ETag() func
+
+// uploadETag derives the ETag of a verified upload from the SHA-256 the server computed for one
+// of its matched checks, or returns "" when none has one. encoded tells the body arrived with a
+// content coding, when the RFC 9530 digests cover other bytes than the content.
+func uploadETag(checks []CheckSummary, encoded bool) string {
+	for _, check := range checks {
+		if !check.Matched {
+			continue
+		}
+		var sum []byte
+		switch check.Algorithm {
+		case "sha256":
+			sum, _ = hex.DecodeString(check.Computed)
+		case "amz-sha256":
+			sum, _ = base64.StdEncoding.DecodeString(check.Computed)
+		case "content-digest", "repr-digest":
+			if dict, err := parseDigestDictionary(check.Computed); err == nil && !encoded {
+				sum = dict["sha-256"]
+			}
+		}
+		if len(sum) == sha256.Size {
+			return sha256ETag(sum)
+		}
+	}
+	return ""
+} // uploadETag() func
+
+// ifNoneMatch returns the entity tags listed by the If-None-Match header of r, without "W/":
+// the comparison is weak, as RFC 9110, Section 13.1.2 wants for If-None-Match. "*" is left out,
+// as an upload has no current representation to speak of.
+func ifNoneMatch(r *http.Request) []string {
+	var etags []string
+	for _, field := range r.Header.Values("If-None-Match") {
+		for _, etag := range strings.Split(field, ",") {
+			if etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/"); etag != "" && etag != "*" {
+				etags = append(etags, etag)
+			}
+		}
+	}
+	return etags
+} // ifNoneMatch() func
+
+// matchingETag returns the first ETag listed by the If-None-Match header of r that index holds, or ""
+func matchingETag(r *http.Request, index ETagIndex) string {
+	for _, etag := range ifNoneMatch(r) {
+		if index.HasETag(etag) {
+			return etag
+		}
+	}
+	return ""
+} // matchingETag() func
@@ -0,0 +1,55 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// countingBody counts the bytes a handler reads from a request body
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+func TestSendIfNoneMatch(t *testing.T) {
+	var read atomic.Int64
+	h := NewHandler(ServerOptions{ETags: new(MemoryETagIndex), Logger: discardLogger()})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = countingBody{r.Body, &read}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	body := bytes.Repeat([]byte("stored once "), 1000)
+	etag, err := ContentETag(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Logger: discardLogger()}
+
+	result, err := c.SendIfNoneMatch(t.Context(), srv.URL, bytes.NewReader(body))
+	if err != nil || result.StatusCode != http.StatusOK || result.ETag != etag || result.Unchanged {
+		t.Fatalf("first upload: %+v, %v; want 200 with ETag %s", result, err, etag)
+	}
+	read.Store(0)
+	result, err = c.SendIfNoneMatch(t.Context(), srv.URL, bytes.NewReader(body))
+	if err != nil || result.StatusCode != http.StatusPreconditionFailed || !result.Unchanged {
+		t.Errorf("second upload: %+v, %v; want 412, unchanged", result, err)
+	}
+	if n := read.Load(); n != 0 {
+		t.Errorf("the server read %d bytes of the second upload, want none", n)
+	}
+	result, err = c.SendIfNoneMatch(t.Context(), srv.URL, bytes.NewReader(append(body, '!')))
+	if err != nil || result.StatusCode != http.StatusOK || result.Unchanged {
+		t.Errorf("other content: %+v, %v; want it uploaded", result, err)
+	}
+}
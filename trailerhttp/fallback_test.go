@@ -0,0 +1,61 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// reframe buffers request bodies and hands them to next with a Content-Length and without
+// their trailer section, as an intermediary that re-frames uploads does; the Trailer header
+// stays. It counts the requests in n.
+func reframe(next http.Handler, n *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+		announced := AnnouncedTrailers(r)
+		body, _ := io.ReadAll(r.Body)
+		r.Body, r.ContentLength, r.TransferEncoding, r.Trailer = io.NopCloser(bytes.NewReader(body)), int64(len(body)), nil, nil
+		if len(announced) > 0 {
+			r.Header.Set("Trailer", strings.Join(announced, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+} // reframe() func
+
+func TestClientHeaderFallback(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(reframe(NewHandler(ServerOptions{Policy: PolicyStrict, Logger: discardLogger()}), &requests))
+	defer srv.Close()
+	body := bytes.Repeat([]byte("re-framed on the way "), 100)
+	for _, tc := range []struct {
+		name     string
+		fallback bool
+		src      io.Reader
+		requests int32 // 2 when resent with the integrity fields as headers
+	}{
+		{"fallback", true, bytes.NewReader(body), 2},
+		{"no fallback", false, bytes.NewReader(body), 1},
+		{"fallback, body not seekable", true, io.MultiReader(bytes.NewReader(body)), 1},
+	} {
+		requests.Store(0)
+		c := &Client{Algorithms: []string{"length", "sha256"}, HeaderFallback: tc.fallback, Logger: discardLogger()}
+		result, err := c.SendStream(t.Context(), srv.URL, tc.src)
+		if n := requests.Load(); n != tc.requests {
+			t.Errorf("%s: %d requests, want %d", tc.name, n, tc.requests)
+		}
+		if tc.requests == 2 {
+			if err != nil || result == nil || !result.Matched || len(result.Checks) != 2 {
+				t.Errorf("%s: %+v, %v; want the header-carried fields verified", tc.name, result, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrTrailersNotCarried) || result == nil || result.Matched {
+			t.Errorf("%s: %+v, %v; want ErrTrailersNotCarried", tc.name, result, err)
+		}
+	}
+}
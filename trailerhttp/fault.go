@@ -0,0 +1,169 @@
+package trailerhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Fault describes the damage a FaultTransport, or a Client with Fault set, deliberately does
+// to the requests it sends, to check that a server's verification policies actually fire.
+// Every field is optional; the zero value sends requests unchanged.
+type Fault struct {
+	CorruptTrailer string // trailer field whose value is altered, keeping its format, e.g. "X-Body-SHA256"
+	OmitTrailer    string // trailer field announced in the headers but left out of the trailer section
+
+	// TruncateAfter, when above 0, sends only this many body bytes, then ends the body
+	// normally with the trailers still describing all of it, as if an intermediary had
+	// dropped the rest. The server sees a length or digest mismatch.
+	TruncateAfter int64
+
+	// AbortAfter, when above 0, cuts the request off after this many body bytes, without
+	// the last chunk or any trailer. The server sees a truncated body; the request fails
+	// with ErrFaultInjected.
+	AbortAfter int64
+}
+
+// ErrFaultInjected is the error of a request cut off by Fault.AbortAfter
+var ErrFaultInjected = errors.New("request aborted by fault injection")
+
+// FaultTransport is an http.RoundTripper that applies a Fault to every request with a body,
+// after whatever computed its trailers (a Client, or a Transport used as the caller):
+//
+//	client := &http.Client{Transport: &trailerhttp.Transport{
+//		Algorithms: []trailerhttp.TrailerAlgo{trailerhttp.AlgoSHA256},
+//		Base:       &trailerhttp.FaultTransport{Fault: trailerhttp.Fault{CorruptTrailer: "X-Body-SHA256"}},
+//	}}
+//
+// It is meant for tests and demonstrations, never for production traffic.
+type FaultTransport struct {
+	Base  http.RoundTripper // nil = http.DefaultTransport
+	Fault Fault
+}
+
+// RoundTrip sends a damaged copy of req
+func (t *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the caller's request: the copy gets the trailer values
+	// the caller sets at EOF, damaged
+	out := req.Clone(req.Context())
+	out.Body = &faultBody{body: req.Body, fault: t.Fault, from: req, to: out}
+	if t.Fault.TruncateAfter > 0 || t.Fault.AbortAfter > 0 {
+		out.ContentLength = -1 // stream the shortened body chunked
+	}
+	return base.RoundTrip(out)
+} // RoundTrip() func
+
+// faultBody damages the body of to, reading it from the body of from
+type faultBody struct {
+	body     io.ReadCloser
+	fault    Fault
+	from, to *http.Request
+	n        int64 // body bytes read from body
+}
+
+func (fb *faultBody) Read(p []byte) (int, error) {
+	for {
+		n, err := fb.body.Read(p)
+		start := fb.n
+		fb.n += int64(n)
+		if limit := fb.fault.AbortAfter; limit > 0 && fb.n > limit {
+			return int(max(0, limit-start)), ErrFaultInjected
+		}
+		if limit := fb.fault.TruncateAfter; limit > 0 && fb.n > limit {
+			// Keep reading to EOF, so the caller still computes the trailers over the whole body
+			n = int(max(0, limit-start))
+		}
+		if err == io.EOF {
+			fb.damageTrailers()
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (fb *faultBody) Close() error { return fb.body.Close() }
+
+// damageTrailers copies the trailer values of from, now final, into to, applying the fault
+func (fb *faultBody) damageTrailers() {
+	for name, values := range fb.from.Trailer {
+		fb.to.Trailer[name] = values
+	}
+	if name, ok := fieldKey(fb.to.Trailer, fb.fault.CorruptTrailer); ok {
+		values := fb.to.Trailer[name]
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		fb.to.Trailer[name] = append([]string{corruptValue(values[0])}, values[1:]...)
+	}
+	if name, ok := fieldKey(fb.to.Trailer, fb.fault.OmitTrailer); ok {
+		delete(fb.to.Trailer, name)
+	}
+} // damageTrailers() func
+
+// fieldKey returns the key of h holding the field name, whatever its case
+func fieldKey(h http.Header, name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	for key := range h {
+		if http.CanonicalHeaderKey(key) == http.CanonicalHeaderKey(name) {
+			return key, true
+		}
+	}
+	return "", false
+} // fieldKey() func
+
+// corruptValue changes a letter or digit of v to another of the same kind, so hex, base64
+// and decimal values stay well-formed but no longer match. It picks the middle of the longest
+// run of them: in "sha-256=:...=:" that is the base64 sum rather than the label, and never
+// the last base64 character, whose low bits may only be padding.
+func corruptValue(v string) string {
+	isAlnum := func(c byte) bool { return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+	runStart, runLen := 0, 0
+	for i := 0; i < len(v); {
+		j := i
+		for j < len(v) && isAlnum(v[j]) {
+			j++
+		}
+		if j-i > runLen {
+			runStart, runLen = i, j-i
+		}
+		i = j + 1
+	}
+	if runLen == 0 {
+		return v + "0"
+	}
+	b := []byte(v)
+	i := runStart + runLen/2
+	// '0'/'1', 'a'/'b' and 'A'/'B' are valid in every one of those alphabets
+	switch c := b[i]; {
+	case c == '0':
+		b[i] = '1'
+	case c <= '9':
+		b[i] = '0'
+	case c == 'a':
+		b[i] = 'b'
+	case c >= 'a':
+		b[i] = 'a'
+	case c == 'A':
+		b[i] = 'B'
+	default:
+		b[i] = 'A'
+	}
+	return string(b)
+} // corruptValue() func
+
+// faultClient returns hc sending through a FaultTransport applying f
+func faultClient(hc *http.Client, f Fault) *http.Client {
+	faulty := *hc
+	faulty.Transport = &FaultTransport{Base: hc.Transport, Fault: f}
+	return &faulty
+} // faultClient() func
@@ -0,0 +1,62 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientFault(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Policy: PolicyStrict, Logger: discardLogger()}))
+	defer srv.Close()
+	body := bytes.Repeat([]byte("damaged on purpose "), 1000)
+	for _, tc := range []struct {
+		name   string
+		fault  Fault
+		status int
+		check  func(*UploadResult) bool
+	}{
+		{"corrupt trailer", Fault{CorruptTrailer: "X-Body-SHA256"}, http.StatusUnprocessableEntity, func(r *UploadResult) bool {
+			return len(r.Checks) == 2 && r.Checks[0].Matched && !r.Checks[1].Matched
+		}},
+		{"omitted trailer", Fault{OmitTrailer: "X-Body-SHA256"}, http.StatusBadRequest, func(r *UploadResult) bool {
+			return len(r.MissingTrailers) == 1 && r.MissingTrailers[0] == "X-Body-Sha256"
+		}},
+		{"truncated body", Fault{TruncateAfter: 100}, http.StatusUnprocessableEntity, func(r *UploadResult) bool {
+			return r.BodyLength == 100 && r.ReportedLength != nil && *r.ReportedLength == int64(len(body))
+		}},
+	} {
+		c := &Client{Algorithms: []string{"length", "sha256"}, Fault: &tc.fault, Logger: discardLogger()}
+		result, _ := c.Send(t.Context(), srv.URL, body)
+		if result == nil || result.StatusCode != tc.status || result.Matched || !tc.check(result) {
+			t.Errorf("%s: result %+v, want status %d with the damage reported", tc.name, result, tc.status)
+		}
+	}
+
+	c := &Client{Fault: &Fault{AbortAfter: 100}, Logger: discardLogger()}
+	if _, err := c.Send(t.Context(), srv.URL, body); !errors.Is(err, ErrFaultInjected) {
+		t.Errorf("aborted upload: error %v, want ErrFaultInjected", err)
+	}
+}
+
+func TestFaultTransport(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Policy: PolicyStrict, Logger: discardLogger()}))
+	defer srv.Close()
+	hc := &http.Client{Transport: &Transport{
+		Algorithms: []TrailerAlgo{AlgoSHA256},
+		Base:       &FaultTransport{Fault: Fault{CorruptTrailer: "X-Body-SHA256"}},
+	}}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"))
+	req.ContentLength = -1 // streamed, so the Transport adds its trailers
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want the corrupted digest rejected with 422", resp.StatusCode)
+	}
+}
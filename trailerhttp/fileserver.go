@@ -0,0 +1,189 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileServerOptions configures a FileServer
+type FileServerOptions struct {
+	Root fs.FS // the files served, e.g. os.DirFS(dir); required
+
+	// Algorithms are the digest trailers computed over every file while it streams, besides
+	// the length trailer; nil means AlgoContentDigest
+	Algorithms []TrailerAlgo
+	Fallback   TrailerFallback // what clients that cannot receive trailers get instead
+
+	// Compress sends whole files to clients that accept it gzip- or zstd-compressed, as
+	// NewCompressedResponseWriter does, bar media types that are compressed already; the
+	// length trailer still describes the file itself
+	Compress bool
+
+	Logger *slog.Logger // nil means slog.Default()
+}
+
+// FileServer serves the files of a file system like http.FileServer, but streams each one
+// with chunked encoding (under HTTP/1.1) and ends it with a Content-Digest and a length
+// trailer computed as the bytes go out, so Client.Download or NewVerifiedResponse can check a
+// download without the server ever hashing its files ahead of time. Clients that do not
+// accept trailers get the file with a Content-Length instead. It answers GET and HEAD, the
+// latter without trailers, honors If-Modified-Since and serves no directory listings.
+//
+// A request for a single byte range (Range: bytes=100-, If-Range with the Last-Modified date)
+// is answered 206 with trailers covering exactly the bytes of the range, as RFC 9530 has
+// Content-Digest cover the content of a partial response; with Want-Repr-Digest it also gets
+// a Repr-Digest trailer over the whole file, hashing the bytes outside the range as well, for
+// Client.ResumeDownload to verify a file assembled from several ranges. A Range of several
+// ranges is ignored, as RFC 9110 allows, and gets the whole file. Ranges are never compressed.
+type FileServer struct {
+	opts   FileServerOptions
+	algos  []TrailerAlgo
+	logger *slog.Logger
+}
+
+// NewFileServer returns a FileServer for opts. It panics if opts.Root is nil.
+func NewFileServer(opts FileServerOptions) *FileServer {
+	if opts.Root == nil {
+		panic("FileServerOptions.Root: no file system")
+	}
+	algos := opts.Algorithms
+	if algos == nil {
+		algos = []TrailerAlgo{AlgoContentDigest}
+	}
+	return &FileServer{opts: opts, algos: algos, logger: orDefaultLogger(opts.Logger).With("component", "files")}
+} // NewFileServer() func
+
+func (h *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	f, err := h.opts.Root.Open(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		h.logger.Error("Could not open file", "name", name, "err", err)
+		http.Error(w, "could not open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r) // directories are not listed
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if modified := info.ModTime(); !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	size := info.Size()
+	start, length, status := int64(0), size, http.StatusOK
+	w.Header().Set("Accept-Ranges", "bytes")
+	if rng := r.Header.Get("Range"); rng != "" && ifRangeMatches(r, info.ModTime()) {
+		switch first, n, err := parseByteRange(rng, size); {
+		case errors.Is(err, errRangeUnsatisfiable):
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		case err == nil:
+			start, length, status = first, n, http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		}
+	}
+	compress := h.opts.Compress && status == http.StatusOK && compressible(contentType)
+	if r.Method == http.MethodHead {
+		if compress {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		if coding, ok := negotiateCoding(r); compress && ok {
+			w.Header().Set("Content-Encoding", coding.name) // the compressed length is unknown
+		} else {
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	// Want-Repr-Digest asks for a digest of the whole file as well: for the whole file, the
+	// content is the representation; for a range, the bytes around it are hashed too
+	wantRepr := r.Header.Get("Want-Repr-Digest") != ""
+	algos := h.algos
+	if wantRepr && status == http.StatusOK && !slices.ContainsFunc(algos, func(a TrailerAlgo) bool { return a.algorithm == AlgoReprDigest.algorithm }) {
+		algos = append(slices.Clip(algos), AlgoReprDigest)
+	}
+	newWriter := NewTrailerResponseWriterFallback
+	if compress {
+		newWriter = NewCompressedResponseWriter
+	}
+	tw := newWriter(w, r, h.opts.Fallback, algos...)
+	var repr bodyDigest
+	if wantRepr && status == http.StatusPartialContent && tw.set != nil {
+		repr = newDigestFieldDigest(nil)
+		w.Header().Add("Trailer", "Repr-Digest")
+	}
+	if tw.set == nil && tw.Encoding() == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10)) // no trailers follow the body
+	}
+	tw.WriteHeader(status)
+	if err := copyRange(tw, f, start, length, repr); err != nil {
+		h.logger.Warn("Error streaming file", "name", name, "range_start", start, "range_length", length, "err", err)
+		return
+	}
+	tw.Finish()
+	if repr != nil {
+		w.Header().Set("Repr-Digest", repr.Value())
+	}
+} // ServeHTTP() func
+
+// copyRange copies length bytes of f from start to dst, seeking to start when f can. With
+// repr it reads the whole file instead, hashing all of it into repr.
+func copyRange(dst io.Writer, f fs.File, start, length int64, repr bodyDigest) error {
+	if repr == nil {
+		if seeker, ok := f.(io.Seeker); ok {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		} else if _, err := io.CopyN(io.Discard, f, start); err != nil {
+			return err
+		}
+		_, err := io.CopyN(dst, f, length)
+		return err
+	}
+	if _, err := io.CopyN(repr, f, start); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.MultiWriter(dst, repr), f, length); err != nil {
+		return err
+	}
+	_, err := io.Copy(repr, f)
+	return err
+} // copyRange() func
@@ -0,0 +1,274 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// maxChunkLineBytes is the longest chunk size line, extensions included, net/http accepts
+const maxChunkLineBytes = 4096
+
+// framingNearBytes is how much of the offending line a FramingError quotes
+const framingNearBytes = 64
+
+// FramingError is a place where HTTP/1.1 bytes break the message framing rules of RFC 9112:
+// in the start line, a header or trailer field, a chunk size line or the end of a chunk. A
+// fatal one loses track of where messages begin and end, so nothing after it is checked.
+type FramingError struct {
+	Offset  int    `json:"offset"` // into the bytes checked
+	Line    int    `json:"line"`   // line number of Offset, from 1
+	Message string `json:"message"`
+	Near    string `json:"near"` // the line Offset is on, cut at 64 bytes
+	Fatal   bool   `json:"fatal,omitempty"`
+}
+
+func (e *FramingError) Error() string {
+	return fmt.Sprintf("offset %d, line %d: %s, near %q", e.Offset, e.Line, e.Message, e.Near)
+}
+
+// CheckHTTP1Framing reports, in order, every place where raw, the bytes one side of an
+// HTTP/1.1 connection sent, breaks the framing rules: bare LFs and CRs, obsolete line
+// folding, whitespace before a field's colon, a field name that is not a token, a message
+// with both Transfer-Encoding and Content-Length, a chunk size line that is not hexadecimal
+// or too long for net/http, chunk data longer or shorter than its size line says, a trailer
+// section that is not terminated, forbidden or unannounced trailer fields, and a connection
+// that ends inside a message. No error means raw is well framed. Check what RawRequest sends,
+// what SendRaw receives, or either side of a CapturedConn.
+func CheckHTTP1Framing(raw []byte) []FramingError {
+	fs := &framingScanner{raw: raw}
+	for fs.pos < len(raw) && fs.message() {
+	}
+	return fs.errs
+} // CheckHTTP1Framing() func
+
+// framingScanner walks the bytes CheckHTTP1Framing checks, collecting what is wrong with them
+type framingScanner struct {
+	raw  []byte
+	pos  int
+	errs []FramingError
+}
+
+// report records what is wrong at offset at
+func (fs *framingScanner) report(at int, fatal bool, format string, args ...any) {
+	start := bytes.LastIndexByte(fs.raw[:at], '\n') + 1
+	end := len(fs.raw)
+	if i := bytes.IndexByte(fs.raw[start:], '\n'); i >= 0 {
+		end = start + i
+	}
+	near := bytes.TrimSuffix(fs.raw[start:end], []byte("\r"))
+	if len(near) > framingNearBytes {
+		near = near[:framingNearBytes]
+	}
+	fs.errs = append(fs.errs, FramingError{
+		Offset:  at,
+		Line:    bytes.Count(fs.raw[:at], []byte("\n")) + 1,
+		Message: fmt.Sprintf(format, args...),
+		Near:    string(near),
+		Fatal:   fatal,
+	})
+} // report() func
+
+// line reads the line at pos without its line ending, and where it starts; ok is false when
+// the bytes end before a LF does
+func (fs *framingScanner) line() (line string, start int, ok bool) {
+	start = fs.pos
+	i := bytes.IndexByte(fs.raw[start:], '\n')
+	if i < 0 {
+		fs.pos = len(fs.raw)
+		return "", start, false
+	}
+	content := fs.raw[start : start+i]
+	fs.pos = start + i + 1
+	if trimmed, crlf := bytes.CutSuffix(content, []byte("\r")); crlf {
+		content = trimmed
+	} else {
+		fs.report(start+i, false, "line ends in a bare LF instead of CRLF")
+	}
+	if j := bytes.IndexByte(content, '\r'); j >= 0 {
+		fs.report(start+j, false, "bare CR inside a line")
+	}
+	return string(content), start, true
+} // line() func
+
+// message checks one message at pos, and reports whether the next one can be found
+func (fs *framingScanner) message() bool {
+	startLine, start, ok := fs.line()
+	if !ok {
+		fs.report(start, true, "connection ends inside a start line")
+		return false
+	}
+	if startLine == "" {
+		return true // an empty line between messages, which recipients ignore
+	}
+	parts := strings.Split(startLine, " ")
+	isResponse := strings.HasPrefix(startLine, "HTTP/")
+	status := 0
+	switch {
+	case isResponse:
+		if len(parts) < 2 || !isHTTP1Version(parts[0]) || len(parts[1]) != 3 {
+			fs.report(start, true, "not an HTTP/1.x status line")
+			return false
+		}
+		status, _ = strconv.Atoi(parts[1])
+	case len(parts) != 3 || !httpguts.ValidHeaderFieldName(parts[0]) || parts[1] == "" || !isHTTP1Version(parts[2]):
+		fs.report(start, true, "not an HTTP/1.x request line")
+		return false
+	}
+	header, ok := fs.fields("header", nil)
+	if !ok {
+		return false
+	}
+	headerEnd := fs.pos
+
+	codings := strings.Split(strings.ToLower(strings.Join(header.Values("Transfer-Encoding"), ",")), ",")
+	lengths := header.Values("Content-Length")
+	chunked := strings.TrimSpace(codings[len(codings)-1]) == "chunked"
+	if len(header.Values("Transfer-Encoding")) > 0 && len(lengths) > 0 {
+		fs.report(headerEnd-1, false, "both Transfer-Encoding and Content-Length, whose disagreement request smuggling exploits")
+	}
+	switch {
+	case status == http.StatusSwitchingProtocols:
+		fs.pos = len(fs.raw) // the connection speaks another protocol from here
+		return false
+	case status/100 == 1:
+		return true // an interim response; the final one follows
+	case status == http.StatusNoContent || status == http.StatusNotModified:
+		return true
+	case len(header.Values("Transfer-Encoding")) > 0 && !chunked:
+		if !isResponse {
+			fs.report(headerEnd-1, true, "chunked is not the final transfer coding of a request")
+			return false
+		}
+		fs.pos = len(fs.raw) // the body runs until the connection closes
+		return false
+	case chunked:
+		return fs.chunks(header.Values("Trailer"))
+	case len(lengths) > 0:
+		n, err := strconv.ParseInt(lengths[0], 10, 64)
+		if err != nil || n < 0 || slices.ContainsFunc(lengths, func(l string) bool { return l != lengths[0] }) {
+			fs.report(headerEnd-1, true, "invalid Content-Length %q", strings.Join(lengths, ", "))
+			return false
+		}
+		if int64(len(fs.raw)-fs.pos) < n {
+			fs.report(len(fs.raw), true, "connection ends after %d of the %d bytes of Content-Length", len(fs.raw)-fs.pos, n)
+			return false
+		}
+		fs.pos += int(n)
+		return true
+	case isResponse:
+		fs.pos = len(fs.raw)
+		return false
+	}
+	return true // a request without a body
+} // message() func
+
+// fields checks a header or trailer section up to the empty line ending it, and returns its
+// fields; in a trailer section, announced lists what the Trailer header announced
+func (fs *framingScanner) fields(section string, announced []string) (http.Header, bool) {
+	fields := http.Header{}
+	var last string
+	for {
+		line, start, ok := fs.line()
+		if !ok {
+			fs.report(start, true, "connection ends inside the %s section, before the empty line ending it", section)
+			return nil, false
+		}
+		if line == "" {
+			return fields, true
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			fs.report(start, false, "obsolete line folding continues a %s field", section)
+			if values := fields[last]; len(values) > 0 {
+				values[len(values)-1] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		switch {
+		case !ok:
+			fs.report(start, false, "%s line without a colon", section)
+			continue
+		case strings.TrimRight(name, " \t") != name:
+			fs.report(start+len(strings.TrimRight(name, " \t")), false, "whitespace between the field name and the colon")
+			name = strings.TrimRight(name, " \t")
+		}
+		if !httpguts.ValidHeaderFieldName(name) {
+			fs.report(start, false, "field name %q is not a token", name)
+		}
+		if i := strings.IndexFunc(value, func(c rune) bool { return c < ' ' && c != '\t' || c == 0x7f }); i >= 0 {
+			fs.report(start+strings.IndexByte(line, ':')+1+i, false, "control character in the value of %s", name)
+		}
+		last = http.CanonicalHeaderKey(name)
+		fields.Add(last, strings.Trim(value, " \t"))
+		if section != "trailer" {
+			continue
+		}
+		switch {
+		case isForbiddenTrailer(name):
+			fs.report(start, false, "%s is not allowed in a trailer section", last)
+		case !slices.ContainsFunc(announced, func(list string) bool {
+			return slices.ContainsFunc(strings.Split(list, ","), func(a string) bool { return strings.EqualFold(strings.TrimSpace(a), name) })
+		}):
+			fs.report(start, false, "trailer field %s was not announced in the Trailer header", last)
+		}
+	}
+} // fields() func
+
+// chunks checks a chunked body, its trailer section included
+func (fs *framingScanner) chunks(announced []string) bool {
+	for {
+		line, start, ok := fs.line()
+		if !ok {
+			fs.report(start, true, "connection ends inside a chunked body, before its last chunk")
+			return false
+		}
+		if len(line) > maxChunkLineBytes {
+			fs.report(start, false, "chunk size line of %d bytes, longer than the %d net/http accepts", len(line), maxChunkLineBytes)
+		}
+		sizeField, _, hasExt := strings.Cut(line, ";")
+		sizeHex := strings.TrimRight(sizeField, " \t")
+		if sizeHex != sizeField && !hasExt {
+			fs.report(start+len(sizeHex), false, "whitespace after the chunk size")
+		}
+		size, err := strconv.ParseUint(sizeHex, 16, 63)
+		if err != nil || sizeHex == "" || strings.ContainsAny(sizeHex[:1], "+- \t") {
+			fs.report(start, true, "chunk size %q is not hexadecimal", sizeHex)
+			return false
+		}
+		if size == 0 {
+			break
+		}
+		dataStart := fs.pos
+		if uint64(len(fs.raw)-dataStart) < size {
+			fs.report(len(fs.raw), true, "connection ends after %d of the %d bytes of a chunk", len(fs.raw)-dataStart, size)
+			return false
+		}
+		fs.pos += int(size)
+		switch rest := fs.raw[fs.pos:]; {
+		case bytes.HasPrefix(rest, []byte("\r\n")):
+			fs.pos += 2
+		case bytes.HasPrefix(rest, []byte("\n")):
+			fs.report(fs.pos, false, "chunk data ends in a bare LF instead of CRLF")
+			fs.pos++
+		case len(rest) == 0:
+			fs.report(fs.pos, true, "connection ends after chunk data, before its CRLF")
+			return false
+		default:
+			fs.report(fs.pos, true, "chunk data is not followed by CRLF: the chunk is longer than the %d bytes its size line says", size)
+			return false
+		}
+	}
+	_, ok := fs.fields("trailer", announced)
+	return ok
+} // chunks() func
+
+// isHTTP1Version reports whether version is HTTP/1.0 or HTTP/1.1
+func isHTTP1Version(version string) bool {
+	return version == "HTTP/1.1" || version == "HTTP/1.0"
+} // isHTTP1Version() func
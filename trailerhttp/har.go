@@ -0,0 +1,359 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR is an HTTP Archive (HAR 1.2), extended with the trailer fields of every request and
+// response in "_trailers", as recorded by HARRecorder. Plain HAR readers ignore the extension.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the log object of a HAR
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator names the application that wrote a HAR
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is one recorded exchange
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds, from sending the request to the end of the response body
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Error           string      `json:"_error,omitempty"` // why the exchange failed, if it did
+}
+
+// HARRequest is a recorded request; its body is in PostData
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"` // always -1: the recorder does not see the encoded headers
+	BodySize    int64          `json:"bodySize"`
+	Trailers    []HARNameValue `json:"_trailers,omitempty"`
+}
+
+// HARResponse is a recorded response; its body is in Content
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Trailers    []HARNameValue `json:"_trailers,omitempty"`
+}
+
+// HARPostData is a recorded request body; binary bodies are base64-encoded, with Encoding "base64"
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+// HARContent is a recorded response body; binary bodies are base64-encoded, with Encoding "base64"
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings splits HAREntry.Time, in milliseconds
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARNameValue is a header, trailer, cookie or query parameter
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARRecorder is an http.RoundTripper recording every exchange it sends, trailers included,
+// for WriteHAR to archive. The request trailers are recorded once the transport has read the
+// whole request body, the response trailers once the caller has read the whole response body;
+// a body closed early is recorded as far as it was read. Bodies are kept in memory. The values
+// of credentials, such as Authorization and X-Body-Token, and of keyed trailers, such as
+// X-Body-HMAC, are recorded as [redacted] unless KeepSecrets is set.
+type HARRecorder struct {
+	Base http.RoundTripper // nil = http.DefaultTransport
+
+	// KeepSecrets records credentials and keyed trailers as they were sent, so that replaying
+	// the HAR authenticates as the original did; such a file must be kept like the secrets
+	KeepSecrets bool
+
+	mu      sync.Mutex
+	records []*harRecord
+}
+
+// harRecord is an exchange being recorded
+type harRecord struct {
+	entry             HAREntry
+	reqBody, respBody bytes.Buffer
+	reqType, respType string
+	sent, headersAt   time.Time
+	done              bool
+}
+
+// RoundTrip sends req through Base, recording the exchange
+func (hr *HARRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := hr.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	rec := &harRecord{sent: time.Now(), reqType: req.Header.Get("Content-Type")}
+	rec.entry.StartedDateTime = rec.sent
+	rec.entry.Request = HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     hr.fields(req.Header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			rec.entry.Request.QueryString = append(rec.entry.Request.QueryString, HARNameValue{name, value})
+		}
+	}
+	hr.mu.Lock()
+	hr.records = append(hr.records, rec)
+	hr.mu.Unlock()
+
+	out := req
+	if req.Body != nil && req.Body != http.NoBody {
+		// A RoundTripper must not modify the caller's request: the copy gets the trailer
+		// values the caller sets at EOF
+		out = req.Clone(req.Context())
+		out.Body = &harBody{body: req.Body, mu: &hr.mu, buf: &rec.reqBody, atEOF: func() {
+			for name, values := range req.Trailer {
+				out.Trailer[name] = values
+			}
+			rec.entry.Request.Trailers = hr.fields(req.Trailer)
+		}}
+	}
+	resp, err := base.RoundTrip(out)
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	rec.headersAt = time.Now()
+	if err != nil {
+		rec.entry.Error = err.Error()
+		rec.finish()
+		return nil, err
+	}
+	rec.respType = resp.Header.Get("Content-Type")
+	rec.entry.Request.HTTPVersion = resp.Proto // the version actually negotiated
+	rec.entry.Response = HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     hr.fields(resp.Header),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	resp.Body = &harBody{body: resp.Body, mu: &hr.mu, buf: &rec.respBody, atEOF: func() {
+		rec.entry.Response.Trailers = hr.fields(resp.Trailer)
+		rec.finish()
+	}, atClose: rec.finish}
+	return resp, nil
+} // RoundTrip() func
+
+// finish completes the timings of the exchange; the caller holds the recorder's lock
+func (rec *harRecord) finish() {
+	if rec.done {
+		return
+	}
+	rec.done = true
+	now := time.Now()
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	rec.entry.Time = ms(now.Sub(rec.sent))
+	if !rec.headersAt.IsZero() {
+		rec.entry.Timings = HARTimings{Wait: ms(rec.headersAt.Sub(rec.sent)), Receive: ms(now.Sub(rec.headersAt))}
+	}
+} // finish() func
+
+// HAR returns the exchanges recorded so far
+func (hr *HARRecorder) HAR() *HAR {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	har := &HAR{Log: HARLog{Version: "1.2", Creator: HARCreator{Name: "trailer_header/trailerhttp", Version: "1"}, Entries: []HAREntry{}}}
+	for _, rec := range hr.records {
+		entry := rec.entry
+		if rec.reqBody.Len() > 0 || entry.Request.Trailers != nil {
+			text, encoding := harText(rec.reqBody.Bytes())
+			entry.Request.PostData = &HARPostData{MimeType: rec.reqType, Text: text, Encoding: encoding}
+		}
+		entry.Request.BodySize = int64(rec.reqBody.Len())
+		text, encoding := harText(rec.respBody.Bytes())
+		entry.Response.Content = HARContent{Size: int64(rec.respBody.Len()), MimeType: rec.respType, Text: text, Encoding: encoding}
+		entry.Response.BodySize = int64(rec.respBody.Len())
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+	return har
+} // HAR() func
+
+// WriteHAR writes the exchanges recorded so far to w as an indented HAR file
+func (hr *HARRecorder) WriteHAR(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(hr.HAR())
+} // WriteHAR() func
+
+// harBody copies a body into buf as it is read, calling atEOF once the body is exhausted
+// and atClose when it is closed, both under mu
+type harBody struct {
+	body           io.ReadCloser
+	mu             *sync.Mutex
+	buf            *bytes.Buffer
+	atEOF, atClose func()
+	eof            bool
+}
+
+func (hb *harBody) Read(p []byte) (int, error) {
+	n, err := hb.body.Read(p)
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.buf.Write(p[:n])
+	if err == io.EOF && !hb.eof {
+		hb.eof = true
+		hb.atEOF()
+	}
+	return n, err
+}
+
+func (hb *harBody) Close() error {
+	err := hb.body.Close()
+	if hb.atClose != nil {
+		hb.mu.Lock()
+		hb.atClose()
+		hb.mu.Unlock()
+	}
+	return err
+}
+
+// fields flattens h into name/value pairs, sorted by name, secrets redacted unless KeepSecrets;
+// nil for an empty h
+func (hr *HARRecorder) fields(h http.Header) []HARNameValue {
+	var fields []HARNameValue
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		redact := !hr.KeepSecrets && redactedField(name)
+		for _, value := range h[name] {
+			if redact {
+				value = "[redacted]"
+			}
+			fields = append(fields, HARNameValue{name, value})
+		}
+	}
+	return fields
+} // fields() func
+
+// harText returns body as HAR text: as is when it is UTF-8, base64-encoded otherwise
+func harText(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+} // harText() func
+
+// ReadHAR decodes a HAR file, such as one written by HARRecorder.WriteHAR
+func ReadHAR(r io.Reader) (*HAR, error) {
+	var har HAR
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, err
+	}
+	return &har, nil
+} // ReadHAR() func
+
+// harSkippedHeaders are the recorded fields NewRequest leaves to the transport
+var harSkippedHeaders = []string{"Content-Length", "Transfer-Encoding", "Trailer", "Connection", "Host"}
+
+// NewRequest rebuilds the recorded request for replay: same method, URL, headers and body,
+// with the recorded trailers sent after the body, as originally. The body is streamed (chunked
+// over HTTP/1.1) when there are trailers.
+func (e *HAREntry) NewRequest(ctx context.Context) (*http.Request, error) {
+	var body []byte
+	if pd := e.Request.PostData; pd != nil {
+		body = []byte(pd.Text)
+		if pd.Encoding == "base64" {
+			var err error
+			if body, err = base64.StdEncoding.DecodeString(pd.Text); err != nil {
+				return nil, err
+			}
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, e.Request.Method, e.Request.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range e.Request.Headers {
+		if !slices.Contains(harSkippedHeaders, http.CanonicalHeaderKey(h.Name)) {
+			req.Header.Add(h.Name, h.Value)
+		}
+	}
+	if len(e.Request.Trailers) > 0 {
+		recorded := http.Header{}
+		for _, t := range e.Request.Trailers {
+			recorded.Add(t.Name, t.Value)
+		}
+		req.Trailer = http.Header{}
+		for name := range recorded {
+			req.Trailer[name] = nil
+		}
+		req.Body = &replayBody{r: bytes.NewReader(body), req: req, trailer: recorded}
+		req.ContentLength = -1
+		req.GetBody = nil
+	}
+	return req, nil
+} // NewRequest() func
+
+// replayBody sets the recorded trailer values at EOF, before the transport reads them
+type replayBody struct {
+	r       io.Reader
+	req     *http.Request
+	trailer http.Header
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		maps.Copy(b.req.Trailer, b.trailer)
+	}
+	return n, err
+}
+
+func (b *replayBody) Close() error { return nil }
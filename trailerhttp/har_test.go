@@ -0,0 +1,62 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHARRecorder(t *testing.T) {
+	key := []byte("key")
+	srv := httptest.NewServer(NewHandler(ServerOptions{HMACKey: key, Logger: discardLogger()}))
+	defer srv.Close()
+	body := []byte("recorded")
+	hmac := ComputeTrailers(body, AlgoHMACSHA256(key)).Get("X-Body-HMAC")
+	for _, keep := range []bool{false, true} {
+		rec := &HARRecorder{KeepSecrets: keep}
+		hc := &http.Client{Transport: &Transport{Algorithms: []TrailerAlgo{AlgoLength, AlgoHMACSHA256(key)}, Base: rec}}
+		req, _ := http.NewRequestWithContext(t.Context(), http.MethodPost, srv.URL, strings.NewReader(string(body)))
+		req.ContentLength = -1 // streamed, so the Transport adds its trailers
+		req.Header.Set("Authorization", "Bearer secret-token")
+		req.Header.Set("TE", "trailers") // for the Handler to echo the trailers it received
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body) // the response trailers are recorded at EOF
+		resp.Body.Close()
+
+		var out bytes.Buffer
+		if err := rec.WriteHAR(&out); err != nil {
+			t.Fatal(err)
+		}
+		har, err := ReadHAR(&out)
+		if err != nil || len(har.Log.Entries) != 1 {
+			t.Fatalf("KeepSecrets %v: %v, %d entries", keep, err, len(har.Log.Entries))
+		}
+		entry := har.Log.Entries[0]
+		secret := func(s string) string {
+			if keep {
+				return s
+			}
+			return "[redacted]"
+		}
+		wantTrailers := []HARNameValue{{"X-Body-Byte-Length", "8"}, {"X-Body-Hmac", secret(hmac)}}
+		if !slices.Equal(entry.Request.Trailers, wantTrailers) {
+			t.Errorf("KeepSecrets %v: request trailers %v, want %v", keep, entry.Request.Trailers, wantTrailers)
+		}
+		if !slices.Contains(entry.Request.Headers, HARNameValue{"Authorization", secret("Bearer secret-token")}) {
+			t.Errorf("KeepSecrets %v: request headers %v, want Authorization %s", keep, entry.Request.Headers, secret("Bearer secret-token"))
+		}
+		if !slices.Contains(entry.Response.Trailers, HARNameValue{"X-Received-X-Body-Hmac", secret(hmac)}) {
+			t.Errorf("KeepSecrets %v: response trailers %v, want the echoed HMAC %s", keep, entry.Response.Trailers, secret(hmac))
+		}
+		if entry.Request.PostData == nil || entry.Request.PostData.Text != string(body) {
+			t.Errorf("KeepSecrets %v: post data %+v, want the body", keep, entry.Request.PostData)
+		}
+	}
+}
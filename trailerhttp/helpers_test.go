@@ -0,0 +1,11 @@
+package trailerhttp
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger returns a logger that drops everything, to keep test output readable
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+} // discardLogger() func
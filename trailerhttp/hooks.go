@@ -0,0 +1,42 @@
+package trailerhttp
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// receivedTrailers calls hook with every field line of trailer, fields in name order
+func receivedTrailers(trailer http.Header, hook func(field, value string)) {
+	for _, field := range slices.Sorted(maps.Keys(trailer)) {
+		for _, value := range trailer[field] {
+			hook(field, value)
+		}
+	}
+} // receivedTrailers() func
+
+// verificationError collects the failed checks and missing trailers of s, or returns nil when
+// there are none. A client gets the server's errors as text, so there a failed check makes an
+// error of its message, which errors.Is does not match with the sentinels.
+func (s *UploadResult) verificationError() error {
+	e := &VerificationError{Missing: s.MissingTrailers}
+	if len(s.MissingTrailers) > 0 {
+		e.errs = append(e.errs, missingTrailerError(s.MissingTrailers))
+	}
+	for _, check := range s.Checks {
+		e.Results = append(e.Results, check.VerificationResult)
+		switch {
+		case check.Err != nil:
+			e.errs = append(e.errs, check.Err)
+		case !check.Matched && check.Error != "":
+			e.errs = append(e.errs, errors.New(check.Error))
+		case !check.Matched:
+			e.errs = append(e.errs, errors.New(check.TrailerName+" does not match"))
+		}
+	}
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e
+} // verificationError() func
@@ -0,0 +1,54 @@
+//go:build http3
+
+package trailerhttp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// NewHTTP3Server returns an HTTP/3 (QUIC) server for the handlers of RegisterHandlers,
+// configured from opts like NewServer. tlsConfig must hold the server certificate: HTTP/3
+// always runs over TLS. Start it with ListenAndServe, or Serve on a UDP net.PacketConn.
+// HTTP/3 carries trailers in a HEADERS frame after the last DATA frame of the request stream,
+// as HTTP/2 does, so the Handler verifies them unchanged.
+// It is only built with the http3 build tag, which pulls in quic-go.
+func NewHTTP3Server(opts ServerOptions, tlsConfig *tls.Config) *http3.Server {
+	if opts.Addr == "" {
+		opts.Addr = DefaultAddr
+	}
+	switch {
+	case opts.ReadTimeout == 0:
+		opts.ReadTimeout = DefaultReadTimeout
+	case opts.ReadTimeout < 0:
+		opts.ReadTimeout = 0
+	}
+	if opts.ClientCAs != nil {
+		tlsConfig = requireClientCerts(tlsConfig, opts.ClientCAs)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, opts)
+	var handler http.Handler = mux
+	if timeout := opts.ReadTimeout; timeout > 0 {
+		// http3.Server has no ReadTimeout; the stream's read deadline does the same job
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+			mux.ServeHTTP(w, r)
+		})
+	}
+	return &http3.Server{
+		Addr:      opts.Addr,
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+} // NewHTTP3Server() func
+
+// NewHTTP3Client returns an HTTP client that sends every request over HTTP/3, for use as
+// Client.HTTPClient against a NewHTTP3Server. tlsConfig may be nil to trust the system roots.
+// It is only built with the http3 build tag.
+func NewHTTP3Client(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{Transport: &http3.Transport{TLSClientConfig: tlsConfig}}
+} // NewHTTP3Client() func
@@ -0,0 +1,123 @@
+package trailerhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TransportOptions configures the HTTP transport of NewHTTPClient and Client.TransportOptions,
+// so the trailers go out over the connection settings of a real deployment: timeouts, TLS, a
+// proxy and the sizes of the connection pool. Zero fields keep the value of the base transport.
+type TransportOptions struct {
+	// Base is cloned as the starting point, e.g. a transport an application has already tuned;
+	// nil means http.DefaultTransport. Setting DialTimeout or KeepAlive replaces its DialContext.
+	Base *http.Transport
+
+	DialTimeout time.Duration // bound on establishing a connection; http.DefaultTransport allows 30s
+	KeepAlive   time.Duration // interval of TCP keep-alive probes; negative disables them
+
+	TLSConfig           *tls.Config // client TLS settings, e.g. RootCAs, a client certificate or MinVersion
+	TLSHandshakeTimeout time.Duration
+
+	// Proxy chooses the proxy of each request, e.g. http.ProxyURL(u); http.DefaultTransport
+	// uses http.ProxyFromEnvironment. NoProxy connects directly, whatever the environment says.
+	Proxy   func(*http.Request) (*url.URL, error)
+	NoProxy bool
+
+	MaxIdleConns        int           // idle connections kept across all hosts
+	MaxIdleConnsPerHost int           // idle connections kept per host; net/http keeps 2 by default
+	MaxConnsPerHost     int           // connections per host in any state, dialing and active included
+	IdleConnTimeout     time.Duration // how long an idle connection is kept
+
+	ResponseHeaderTimeout time.Duration // wait for the response headers once the request, trailers included, is written
+	ExpectContinueTimeout time.Duration // wait for "100 Continue"; see Client.ExpectContinue
+
+	H2C bool // speak HTTP/2 over cleartext TCP with prior knowledge, as NewH2CClient does
+}
+
+// transportClients caches the HTTP clients of Client.TransportOptions, one per options value
+// and socket, so uploads reuse the pool of connections they configure
+var transportClients sync.Map // transportKey -> *http.Client
+
+type transportKey struct {
+	opts          *TransportOptions
+	network, addr string
+}
+
+// NewHTTPClient returns an HTTP client whose transport is configured by opts, for
+// Client.HTTPClient or any other use; Client.TransportOptions builds one the same way
+func NewHTTPClient(opts TransportOptions) *http.Client {
+	return &http.Client{Transport: opts.transport("", "")}
+} // NewHTTPClient() func
+
+// transport builds the transport opts describe, dialing addr over network for every request
+// when network is set, as NewSocketClient does
+func (opts *TransportOptions) transport(network, addr string) *http.Transport {
+	base := opts.Base
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+	if opts.DialTimeout != 0 || opts.KeepAlive != 0 || network != "" {
+		dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+		if dialer.Timeout == 0 {
+			dialer.Timeout = 30 * time.Second
+		}
+		t.DialContext = func(ctx context.Context, dialNetwork, dialAddr string) (net.Conn, error) {
+			if network != "" {
+				dialNetwork, dialAddr = network, addr
+			}
+			return dialer.DialContext(ctx, dialNetwork, dialAddr)
+		}
+	}
+	if opts.TLSConfig != nil {
+		t.TLSClientConfig = opts.TLSConfig.Clone()
+	}
+	if opts.TLSHandshakeTimeout != 0 {
+		t.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	switch {
+	case opts.NoProxy:
+		t.Proxy = nil
+	case opts.Proxy != nil:
+		t.Proxy = opts.Proxy
+	}
+	if opts.MaxIdleConns != 0 {
+		t.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost != 0 {
+		t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.MaxConnsPerHost != 0 {
+		t.MaxConnsPerHost = opts.MaxConnsPerHost
+	}
+	if opts.IdleConnTimeout != 0 {
+		t.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.ResponseHeaderTimeout != 0 {
+		t.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	}
+	if opts.ExpectContinueTimeout != 0 {
+		t.ExpectContinueTimeout = opts.ExpectContinueTimeout
+	}
+	if opts.H2C {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return t
+} // transport() func
+
+// transportClient returns the cached HTTP client of c.TransportOptions
+func (c *Client) transportClient() *http.Client {
+	key := transportKey{opts: c.TransportOptions, network: c.Network, addr: c.Addr}
+	if hc, ok := transportClients.Load(key); ok {
+		return hc.(*http.Client)
+	}
+	hc, _ := transportClients.LoadOrStore(key, &http.Client{Transport: c.TransportOptions.transport(c.Network, c.Addr)})
+	return hc.(*http.Client)
+} // transportClient() func
@@ -0,0 +1,283 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotentReplayHeader is set to "true" on a response WithIdempotency replays from its
+// cache instead of running the handler again
+const IdempotentReplayHeader = "X-Idempotent-Replay"
+
+// maxCachedResponseBytes is the largest response body WithIdempotency keeps for replay; the
+// responses of larger ones are not remembered
+const maxCachedResponseBytes = 1 << 20
+
+// CachedResponse is a response kept by an IdempotencyCache, replayed as it was written
+type CachedResponse struct {
+	Status  int
+	Header  http.Header // as the header was written, with the Trailer announcement
+	Body    []byte
+	Trailer http.Header // the trailer values set after the body
+}
+
+// IdempotencyCache keeps the responses of uploads by key, for WithIdempotency. Implementations
+// must be safe for concurrent use; one shared by several servers makes a duplicate sent to
+// another server a replay too, though two arriving at once on different servers may both run.
+type IdempotencyCache interface {
+	// Get returns the response stored under key, unless it has expired
+	Get(key string) (*CachedResponse, bool)
+	// Set stores resp under key for ttl
+	Set(key string, resp *CachedResponse, ttl time.Duration)
+}
+
+// MemoryIdempotencyCache is an IdempotencyCache in memory, safe for concurrent use; its zero
+// value is empty. Expired responses are dropped as they are looked up, and at most once a
+// minute all of them as new ones are stored.
+type MemoryIdempotencyCache struct {
+	mu        sync.Mutex
+	entries   map[string]memoryCacheEntry
+	lastSweep time.Time
+}
+
+// memoryCacheEntry is a response of a MemoryIdempotencyCache and when it expires
+type memoryCacheEntry struct {
+	resp    *CachedResponse
+	expires time.Time
+}
+
+func (c *MemoryIdempotencyCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.resp, ok
+} // Get() func
+
+func (c *MemoryIdempotencyCache) Set(key string, resp *CachedResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]memoryCacheEntry)
+	}
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = memoryCacheEntry{resp: resp, expires: now.Add(ttl)}
+} // Set() func
+
+// idempotency is the state of WithIdempotency: the cache, and the uploads being handled, so a
+// duplicate arriving meanwhile waits for the first one's response
+type idempotency struct {
+	cache    IdempotencyCache
+	ttl      time.Duration
+	identity func(*http.Request) string // the caller a response is kept for
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // closed once the upload under the key has been handled
+}
+
+// WithIdempotency gives uploads at-most-once semantics without idempotency keys: the response
+// to a verified upload is kept in cache for ttl under its method, its request target, the
+// SHA-256 of its body, as a sha256, Content-Digest or amz-sha256 trailer verified it, and its
+// caller, by default its Authorization and Cookie headers and its client certificate (see
+// WithIdempotencyKey), and a duplicate submission by the same caller within ttl gets that
+// response back, with X-Idempotent-Replay: true, without running the wrapped handler; a
+// Set-Cookie field is never kept. A duplicate arriving while the first is still handled
+// waits for its response. It turns on RejectUnverified, without a limit unless that sets one,
+// since the digest is only known once the whole body and its trailers have been read.
+// Verified uploads without such a trailer are handled every time, and responses of 500 and
+// above or of more than 1 MiB are not kept, so a failed upload can be retried.
+func WithIdempotency(cache IdempotencyCache, ttl time.Duration) Option {
+	return func(cfg *integrityConfig) {
+		cfg.reject = true
+		cfg.idempotency = &idempotency{cache: cache, ttl: ttl, identity: callerIdentity, inflight: make(map[string]chan struct{})}
+	}
+} // WithIdempotency() func
+
+// WithIdempotencyKey makes WithIdempotency tell callers apart by key instead, e.g. by the
+// account a session belongs to; uploads of the same body by two callers never share a response.
+func WithIdempotencyKey(key func(r *http.Request) string) Option {
+	return func(cfg *integrityConfig) { cfg.idempotencyKey = key }
+} // WithIdempotencyKey() func
+
+// callerIdentity is the caller of r as WithIdempotency tells them apart by default: its
+// credentials, and its verified client certificate under mutual TLS
+func callerIdentity(r *http.Request) string {
+	identity := strings.Join(r.Header.Values("Authorization"), "\n") + "\n" + strings.Join(r.Header.Values("Cookie"), "\n")
+	if cert := ClientCertificate(r); cert != nil {
+		identity += "\n" + string(cert.Raw)
+	}
+	return identity
+} // callerIdentity() func
+
+// serve runs next on the verified upload r, or replays the response to an earlier upload of
+// the same body; encoded tells the body arrived with a content coding
+func (id *idempotency) serve(w http.ResponseWriter, r *http.Request, next http.Handler, vb *VerifiedBody, encoded bool) {
+	checks := make([]CheckSummary, len(vb.Results()))
+	for i, result := range vb.Results() {
+		checks[i] = CheckSummary{VerificationResult: result}
+	}
+	etag := uploadETag(checks, encoded)
+	if etag == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+	caller := sha256.Sum256([]byte(id.identity(r))) // credentials are not kept in the cache as they are
+	key := r.Method + " " + r.URL.RequestURI() + " " + etag + " " + hex.EncodeToString(caller[:])
+	for {
+		if resp, ok := id.cache.Get(key); ok {
+			resp.replay(w)
+			return
+		}
+		done, first := id.claim(key)
+		if first {
+			break
+		}
+		select {
+		case <-done: // the response is in the cache now, unless it was not kept
+		case <-r.Context().Done():
+			return
+		}
+	}
+	defer id.release(key)
+	rec := &responseRecorder{w: w}
+	next.ServeHTTP(rec, r)
+	if resp := rec.result(); resp != nil {
+		id.cache.Set(key, resp, id.ttl)
+	}
+} // serve() func
+
+// claim marks the upload under key as being handled, and reports whether it was not already;
+// if it was, done is closed when that one is
+func (id *idempotency) claim(key string) (done chan struct{}, first bool) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if done, ok := id.inflight[key]; ok {
+		return done, false
+	}
+	id.inflight[key] = make(chan struct{})
+	return nil, true
+} // claim() func
+
+// release wakes the duplicates waiting for the upload under key
+func (id *idempotency) release(key string) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	close(id.inflight[key])
+	delete(id.inflight, key)
+} // release() func
+
+// replay writes resp again, marked as a replay
+func (resp *CachedResponse) replay(w http.ResponseWriter) {
+	w.Header().Set(IdempotentReplayHeader, "true")
+	resp.write(w)
+} // replay() func
+
+// write writes resp to w: its header, its body, and then its trailer values
+func (resp *CachedResponse) write(w http.ResponseWriter) {
+	header := w.Header()
+	for name, values := range resp.Header {
+		if name != "Set-Cookie" {
+			header[name] = slices.Clone(values)
+		}
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+	for name, values := range resp.Trailer {
+		if name != "Set-Cookie" {
+			header[name] = slices.Clone(values)
+		}
+	}
+} // write() func
+
+// responseRecorder passes a response through to w, keeping a copy of it for the cache
+type responseRecorder struct {
+	w        http.ResponseWriter
+	status   int
+	header   http.Header // as it was when the status was written
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.w.Header()
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 && status >= http.StatusOK {
+		rec.status, rec.header = status, rec.w.Header().Clone()
+		rec.header.Del("Set-Cookie") // the session of one caller is never handed to another
+	}
+	rec.w.WriteHeader(status)
+} // WriteHeader() func
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.w.Write(p)
+	if rec.tooLarge = rec.tooLarge || rec.body.Len()+n > maxCachedResponseBytes; !rec.tooLarge {
+		rec.body.Write(p[:n])
+	}
+	return n, err
+} // Write() func
+
+// Flush sends any buffered body bytes to the client, if the underlying writer supports it
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.w.(http.Flusher); ok {
+		f.Flush()
+	}
+} // Flush() func
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.w
+}
+
+// result returns the response recorded, with the trailer values the handler set after the
+// body, or nil for one not to keep
+func (rec *responseRecorder) result() *CachedResponse {
+	if rec.status == 0 {
+		rec.status, rec.header = http.StatusOK, rec.w.Header().Clone() // the handler wrote nothing
+		rec.header.Del("Set-Cookie")
+	}
+	if rec.status >= http.StatusInternalServerError || rec.tooLarge {
+		return nil
+	}
+	return &CachedResponse{Status: rec.status, Header: rec.header, Body: bytes.Clone(rec.body.Bytes()), Trailer: recordedTrailers(rec.header, rec.w.Header())}
+} // result() func
+
+// recordedTrailers returns the trailer values of a response whose header was sent as it is in
+// sent and became final after the body: the fields sent announced, and the TrailerPrefix ones
+func recordedTrailers(sent, final http.Header) http.Header {
+	trailer := http.Header{}
+	for _, field := range sent.Values("Trailer") {
+		for _, name := range strings.Split(field, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && name != "Set-Cookie" && final[name] != nil {
+				trailer[name] = slices.Clone(final[name])
+			}
+		}
+	}
+	for name, values := range final {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			trailer[name] = slices.Clone(values)
+		}
+	}
+	return trailer
+} // recordedTrailers() func
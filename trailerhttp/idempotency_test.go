@@ -0,0 +1,86 @@
+package trailerhttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIntegrityWithIdempotency(t *testing.T) {
+	var runs atomic.Int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		n := runs.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "upload %d", n)
+	})
+	srv := httptest.NewServer(Integrity(next, WithIdempotency(&MemoryIdempotencyCache{}, time.Minute)))
+	defer srv.Close()
+
+	body := []byte("submitted twice")
+	sum := ComputeTrailers(body, AlgoSHA256).Get("X-Body-Sha256")
+	send := func(digest string) *RawResponse {
+		t.Helper()
+		req := &RawRequest{Target: "/orders", Body: body, Trailer: []RawField{{"X-Body-Sha256", digest}}}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	first := send(sum)
+	if first.Response.StatusCode != http.StatusCreated || string(first.Body) != "upload 1" || first.Response.Header.Get(IdempotentReplayHeader) != "" {
+		t.Fatalf("first upload: status %d, replay %q; want 201 from the handler", first.Response.StatusCode, first.Response.Header.Get(IdempotentReplayHeader))
+	}
+
+	dup := send(sum)
+	if dup.Response.StatusCode != http.StatusCreated || string(dup.Body) != "upload 1" || dup.Response.Header.Get(IdempotentReplayHeader) != "true" || runs.Load() != 1 {
+		t.Errorf("verified duplicate: status %d, body %q, replay %q, handler ran %d times; want the first response replayed", dup.Response.StatusCode, dup.Body, dup.Response.Header.Get(IdempotentReplayHeader), runs.Load())
+	}
+
+	forged := send(strings.Repeat("0", len(sum))).Response
+	if forged.StatusCode != http.StatusBadRequest || forged.Header.Get(IdempotentReplayHeader) != "" || runs.Load() != 1 {
+		t.Errorf("unverified duplicate: status %d, replay %q, handler ran %d times; want 400 without a replay", forged.StatusCode, forged.Header.Get(IdempotentReplayHeader), runs.Load())
+	}
+}
+
+func TestIdempotencyPerCaller(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: r.Header.Get("Authorization")})
+		fmt.Fprintf(w, "receipt for %s", r.Header.Get("Authorization"))
+	})
+	srv := httptest.NewServer(Integrity(next, WithIdempotency(&MemoryIdempotencyCache{}, time.Minute)))
+	defer srv.Close()
+
+	body := []byte("the same bytes")
+	sum := ComputeTrailers(body, AlgoSHA256).Get("X-Body-Sha256")
+	send := func(auth string) *RawResponse {
+		t.Helper()
+		req := &RawRequest{Header: []RawField{{"Authorization", auth}}, Body: body, Trailer: []RawField{{"X-Body-Sha256", sum}}}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	send("Bearer alice")
+	bob := send("Bearer bob")
+	if string(bob.Body) != "receipt for Bearer bob" || bob.Response.Header.Get(IdempotentReplayHeader) != "" {
+		t.Errorf("another caller got %q, replay %q; want its own response", bob.Body, bob.Response.Header.Get(IdempotentReplayHeader))
+	}
+	replay := send("Bearer alice")
+	if string(replay.Body) != "receipt for Bearer alice" || replay.Response.Header.Get(IdempotentReplayHeader) != "true" {
+		t.Errorf("same caller got %q, replay %q; want the first response replayed", replay.Body, replay.Response.Header.Get(IdempotentReplayHeader))
+	}
+	if cookies := replay.Response.Header.Values("Set-Cookie"); len(cookies) != 0 {
+		t.Errorf("replay set cookies %v, want none", cookies)
+	}
+}
@@ -0,0 +1,107 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrUnverified reports a body that arrived whole but that no trailer check covered, so
+// nothing decoded from it is delivered
+var ErrUnverified = errors.New("no trailer verified the body")
+
+// DecodeJSON decodes the JSON body of r into Ts as it streams in, and returns them only once
+// the body has reached its end and its trailers verified: all of them, or none with the error.
+// The body is a single JSON array, whose elements are decoded one by one, or a sequence of
+// values such as NDJSON, whose X-Record-Count is checked too when announced; e.g.
+//
+//	events, err := trailerhttp.DecodeJSON[Event](r, key)
+//	if err != nil { ... } // a *VerificationError, ErrUnverified, or malformed JSON
+//	store(events)         // every event arrived as sent
+//
+// Under the Integrity middleware it uses the VerifiedBody the middleware made, WithHMACKey's key
+// and not key; otherwise it wraps r.Body, checking the trailers with key. A body that fails to
+// decode is still read to its end, so one corrupted in transit reports the failed check rather
+// than the syntax error the corruption caused. A body that announced no integrity trailer
+// fails with ErrUnverified.
+func DecodeJSON[T any](r *http.Request, key []byte) ([]T, error) {
+	body := io.Reader(r.Body)
+	vb, ok := VerificationFromContext(r.Context())
+	if !ok {
+		vb = NewVerifiedBody(r, key)
+		body = vb
+	}
+	values, err := decodeJSONValues[T](body)
+	if _, drainErr := io.Copy(io.Discard, body); drainErr != nil {
+		return nil, drainErr // the checks failed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	if !vb.Verified() {
+		return nil, ErrUnverified
+	}
+	return values, nil
+} // DecodeJSON() func
+
+// decodeJSONValues decodes body, a JSON array or a sequence of values, into the Ts staged for
+// DecodeJSON; body may not have reached its end yet when it returns
+func decodeJSONValues[T any](body io.Reader) ([]T, error) {
+	br := bufio.NewReader(body)
+	first, err := firstNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(br)
+	var values []T
+	if first != '[' {
+		for {
+			var v T
+			if err := dec.Decode(&v); err == io.EOF {
+				return values, nil
+			} else if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+	dec.Token() // the '[' peeked at
+	for dec.More() {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if _, err := dec.Token(); err != nil { // the closing ']'
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("data after the JSON array")
+		}
+		return nil, err
+	}
+	return values, nil
+} // decodeJSONValues() func
+
+// firstNonSpace returns the first byte of br that is not JSON whitespace, leaving it unread
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b, br.UnreadByte()
+		}
+	}
+} // firstNonSpace() func
@@ -0,0 +1,54 @@
+package trailerhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type event struct{ ID int }
+	type decoded struct {
+		events []event
+		err    error
+	}
+	got := make(chan decoded, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events, err := DecodeJSON[event](r, nil)
+		got <- decoded{events, err}
+	}))
+	defer srv.Close()
+
+	array := []byte(`[{"ID":1},{"ID":2},{"ID":3}]`)
+	ndjson := []byte("{\"ID\":1}\n{\"ID\":2}\n{\"ID\":3}\n")
+	sha256Of := func(body []byte) string { return ComputeTrailers(body, AlgoSHA256).Get("X-Body-Sha256") }
+	for _, tc := range []struct {
+		name    string
+		body    []byte
+		trailer []RawField
+		err     error // nil when all three events are delivered
+	}{
+		{"JSON array", array, []RawField{{"X-Body-Sha256", sha256Of(array)}}, nil},
+		{"JSON array, corrupted", array, []RawField{{"X-Body-Sha256", sha256Of(ndjson)}}, ErrHashMismatch},
+		{"NDJSON", ndjson, []RawField{{"X-Body-Sha256", sha256Of(ndjson)}, {RecordCountTrailer, "3"}}, nil},
+		{"NDJSON, corrupted", ndjson, []RawField{{"X-Body-Sha256", sha256Of(array)}}, ErrHashMismatch},
+		{"NDJSON, a record short", ndjson, []RawField{{"X-Body-Sha256", sha256Of(ndjson)}, {RecordCountTrailer, "4"}}, ErrRecordCountMismatch},
+		{"no trailers", ndjson, nil, ErrUnverified},
+	} {
+		req := &RawRequest{Header: []RawField{{"Content-Type", "application/json"}}, Body: tc.body, Trailer: tc.trailer}
+		if tc.body[0] != '[' {
+			req.Header[0].Value = string(RecordsNDJSON)
+		}
+		if _, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		d := <-got
+		switch {
+		case tc.err == nil && (d.err != nil || len(d.events) != 3 || d.events[2].ID != 3):
+			t.Errorf("%s: got %v, %v; want the three events", tc.name, d.events, d.err)
+		case tc.err != nil && (!errors.Is(d.err, tc.err) || d.events != nil):
+			t.Errorf("%s: got %v, %v; want no events and %v", tc.name, d.events, d.err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package trailerhttp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+)
+
+// BodyLimitError reports a request body over the limit of LimitBody. It wraps an
+// *http.MaxBytesError, so code written for http.MaxBytesReader keeps working.
+type BodyLimitError struct {
+	Limit    int64       // the limit the body crossed
+	Size     int64       // body bytes seen, limit and drained bytes included; the whole body when Complete
+	Complete bool        // the body was drained to its end, so Trailer holds its trailer section
+	Trailer  http.Header // the trailers that arrived after the body, when Complete
+}
+
+func (e *BodyLimitError) Error() string {
+	if e.Complete {
+		return fmt.Sprintf("request body of %d bytes exceeds the %d byte limit", e.Size, e.Limit)
+	}
+	return fmt.Sprintf("request body exceeds the %d byte limit", e.Limit)
+}
+
+func (e *BodyLimitError) Unwrap() error {
+	return &http.MaxBytesError{Limit: e.Limit}
+}
+
+// LimitBody is http.MaxBytesReader for r.Body, except that a body crossing limit is not
+// abandoned there: up to drain more bytes are read and discarded to reach its end, so net/http
+// parses the trailer section into r.Trailer and the *BodyLimitError can report the real size and
+// the trailers, e.g. the length an X-Body-Byte-Length trailer claimed. With http.MaxBytesReader
+// the read stops at the limit and r.Trailer is never populated. A body longer still than limit
+// plus drain is cut off as http.MaxBytesReader cuts it, closing the connection afterwards.
+func LimitBody(w http.ResponseWriter, r *http.Request, limit, drain int64) io.ReadCloser {
+	return newLimitedBody(w, r.Body, &r.Trailer, limit, drain)
+} // LimitBody() func
+
+// limitedBody enforces the soft limit of LimitBody over a hard http.MaxBytesReader at limit+drain
+type limitedBody struct {
+	body    io.ReadCloser
+	trailer *http.Header
+	limit   int64
+	n       int64
+	err     error // the BodyLimitError, once the limit is crossed
+}
+
+func newLimitedBody(w http.ResponseWriter, body io.ReadCloser, trailer *http.Header, limit, drain int64) *limitedBody {
+	hard := limit + max(drain, 0)
+	if hard < limit {
+		hard = math.MaxInt64 // saturate rather than wrap around to a negative limit
+	}
+	return &limitedBody{body: http.MaxBytesReader(w, body, hard), trailer: trailer, limit: limit}
+} // newLimitedBody() func
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.err != nil {
+		return 0, lb.err
+	}
+	if left := lb.limit - lb.n; int64(len(p))-1 > left { // left+1 would overflow at math.MaxInt64
+		p = p[:left+1] // one byte past the limit tells it was crossed
+	}
+	n, err := lb.body.Read(p)
+	lb.n += int64(n)
+	if lb.n <= lb.limit {
+		return n, err
+	}
+	keep := n - int(lb.n-lb.limit) // the bytes of this read up to the limit, before drain counts more
+	lb.err = lb.drain(err)
+	return keep, lb.err
+} // Read() func
+
+// drain discards the rest of a body that crossed the limit, given the error of the read that did
+func (lb *limitedBody) drain(err error) error {
+	if err == nil {
+		var m int64
+		m, err = io.Copy(io.Discard, lb.body)
+		lb.n += m
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	limitErr := &BodyLimitError{Limit: lb.limit, Size: lb.n, Complete: err == io.EOF}
+	if limitErr.Complete {
+		limitErr.Trailer = *lb.trailer
+	}
+	return limitErr
+} // drain() func
+
+func (lb *limitedBody) Close() error {
+	return lb.body.Close()
+}
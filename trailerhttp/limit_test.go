@@ -0,0 +1,145 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitBodyOverLimit(t *testing.T) {
+	for _, size := range []int{11, 20, 5000} {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(bytes.Repeat([]byte("x"), size)))
+		r.Trailer = http.Header{"X-Body-Byte-Length": {"20"}}
+		got, err := io.ReadAll(LimitBody(httptest.NewRecorder(), r, 10, 1<<20))
+		if len(got) != 10 {
+			t.Errorf("%d-byte body: read %d bytes, want the 10 up to the limit", size, len(got))
+		}
+		var limitErr *BodyLimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("%d-byte body: error %v, want a *BodyLimitError", size, err)
+		}
+		if !limitErr.Complete || limitErr.Size != int64(size) || limitErr.Trailer.Get("X-Body-Byte-Length") != "20" {
+			t.Errorf("%d-byte body: %+v, want it complete with the size and trailer of the body", size, limitErr)
+		}
+		var maxErr *http.MaxBytesError
+		if !errors.As(err, &maxErr) || maxErr.Limit != 10 {
+			t.Errorf("%d-byte body: error %v does not wrap the http.MaxBytesError", size, err)
+		}
+	}
+}
+
+func TestLimitBodyBufio(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("line\n", 1000)))
+	br := bufio.NewReaderSize(LimitBody(httptest.NewRecorder(), r, 12, 1<<20), 16)
+	var got []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		got = append(got, line...)
+		if err != nil {
+			var limitErr *BodyLimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("error %v, want a *BodyLimitError", err)
+			}
+			break
+		}
+	}
+	if string(got) != "line\nline\nli" {
+		t.Errorf("read %q, want the 12 bytes up to the limit", got)
+	}
+}
+
+func TestLimitBodyMaxLimit(t *testing.T) {
+	for _, drain := range []int64{0, 1, 1 << 20, math.MaxInt64} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789"))
+		got, err := io.ReadAll(LimitBody(httptest.NewRecorder(), r, math.MaxInt64, drain))
+		if err != nil || string(got) != "0123456789" {
+			t.Errorf("drain %d: got %q, %v; want the whole body under a limit of math.MaxInt64", drain, got, err)
+		}
+	}
+}
+
+func TestLimitBodyUnderLimit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789"))
+	got, err := io.ReadAll(LimitBody(httptest.NewRecorder(), r, 10, 0))
+	if err != nil || string(got) != "0123456789" {
+		t.Errorf("got %q, %v; want the whole body at the limit", got, err)
+	}
+}
+
+func TestLimitBodyCutOff(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, 100)))
+	got, err := io.ReadAll(LimitBody(httptest.NewRecorder(), r, 10, 20))
+	var limitErr *BodyLimitError
+	if !errors.As(err, &limitErr) || limitErr.Complete || len(got) != 10 {
+		t.Errorf("read %d bytes, error %v; want 10 and an incomplete *BodyLimitError past limit plus drain", len(got), err)
+	}
+}
+
+func TestHandlerDrainsOversizedBody(t *testing.T) {
+	h := NewHandler(ServerOptions{MaxBodyBytes: 1000, DrainOversizedBytes: 1 << 20, Logger: discardLogger()})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for _, gzipped := range []bool{false, true} {
+		c := &Client{Gzip: gzipped, Logger: discardLogger()}
+		result, _ := c.SendStream(t.Context(), srv.URL, bytes.NewReader(bytes.Repeat([]byte("0123456789"), 5000)))
+		if result == nil || result.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("gzip %v: result %+v, want 413", gzipped, result)
+		}
+		if !gzipped && (result.BodyLength != 50000 || result.ReportedLength == nil || *result.ReportedLength != 50000) {
+			t.Errorf("body length %d, reported %v; want the drained size and the length trailer", result.BodyLength, result.ReportedLength)
+		}
+	}
+}
+
+func TestHandlerMaxBodyBytes(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{MaxBodyBytes: 1000, Logger: discardLogger()}))
+	defer srv.Close()
+	c := &Client{Logger: discardLogger()}
+	result, _ := c.Send(t.Context(), srv.URL, make([]byte, 1001))
+	if result == nil || result.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("result %+v, want 413 for a body one byte over the limit", result)
+	}
+	if result, err := c.Send(t.Context(), srv.URL, make([]byte, 1000)); err != nil || !result.Matched {
+		t.Errorf("result %+v, %v; want a body at the limit verified", result, err)
+	}
+}
+
+func TestHandlerTrailerLimits(t *testing.T) {
+	trailer := http.Header{"X-Body-Byte-Length": {"5"}, "X-Note": {strings.Repeat("n", 40)}}
+	for _, tc := range []struct {
+		name string
+		opts ServerOptions
+		want int
+	}{
+		{"within every limit", ServerOptions{MaxTrailerFields: 2, MaxTrailerBytes: 100, MaxTrailerValueBytes: 40}, http.StatusOK},
+		{"too many fields announced", ServerOptions{MaxTrailerFields: 1}, http.StatusRequestHeaderFieldsTooLarge},
+		{"too many bytes", ServerOptions{MaxTrailerBytes: 60}, http.StatusRequestHeaderFieldsTooLarge},
+		{"a value too long", ServerOptions{MaxTrailerValueBytes: 39}, http.StatusRequestHeaderFieldsTooLarge},
+	} {
+		tc.opts.Logger = discardLogger()
+		w := httptest.NewRecorder()
+		NewHandler(tc.opts).ServeHTTP(w, chunkedRequest("hello", trailer.Clone()))
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	// Fields delivered without being announced count as well, once the body has been read
+	srv := httptest.NewServer(NewHandler(ServerOptions{MaxTrailerFields: 1, Logger: discardLogger()}))
+	defer srv.Close()
+	req := &RawRequest{Header: []RawField{{"Trailer", "X-Body-Byte-Length"}}, Body: []byte("hello"),
+		Trailer: []RawField{{"X-Body-Byte-Length", "5"}, {"X-Note", "unannounced"}}}
+	resp, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("unannounced field past MaxTrailerFields: status %d, want 431", resp.Response.StatusCode)
+	}
+}
@@ -0,0 +1,182 @@
+package trailerhttp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SizeDistribution draws the body size of each upload of a LoadTest
+type SizeDistribution func(r *rand.Rand) int64
+
+// FixedSize makes every body n bytes
+func FixedSize(n int64) SizeDistribution {
+	return func(*rand.Rand) int64 { return n }
+} // FixedSize() func
+
+// UniformSize draws body sizes uniformly between lo and hi bytes, both included
+func UniformSize(lo, hi int64) SizeDistribution {
+	return func(r *rand.Rand) int64 { return lo + r.Int64N(hi-lo+1) }
+} // UniformSize() func
+
+// ExponentialSize draws body sizes from an exponential distribution of that mean, capped at 64
+// times it: many small bodies and a few large ones, as file uploads typically are
+func ExponentialSize(mean int64) SizeDistribution {
+	return func(r *rand.Rand) int64 { return int64(min(r.ExpFloat64(), 64) * float64(mean)) }
+} // ExponentialSize() func
+
+// LoadOptions configures Client.LoadTest
+type LoadOptions struct {
+	Requests    int              // uploads to send in total; 0 means 100
+	Concurrency int              // uploads in flight at once; 0 means 10
+	Rate        float64          // uploads started per second at most; 0 means as fast as they complete
+	Size        SizeDistribution // body size of each upload; nil means FixedSize(64 KiB)
+	Seed        uint64           // seeds the sizes and the contents of the bodies, to repeat a run
+}
+
+// LatencyPercentiles summarizes the latencies of the uploads of a LoadTest that got a response
+type LatencyPercentiles struct {
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// LoadReport is the outcome of a LoadTest. Every upload sent counts in exactly one of Verified,
+// VerificationFailures, Rejected and Errors.
+type LoadReport struct {
+	Requests             int                `json:"requests"`              // uploads sent
+	Verified             int                `json:"verified"`              // the server verified the trailers
+	VerificationFailures int                `json:"verification_failures"` // a check failed or a trailer went missing, either way
+	Rejected             int                `json:"rejected"`              // answered with another failure, e.g. a policy or a status
+	Errors               int                `json:"errors"`                // no result at all, e.g. the connection failed
+	FirstError           string             `json:"first_error,omitempty"` // the error of the first upload that got none
+	Outcomes             map[string]int     `json:"outcomes"`              // uploads per UploadResult.Outcome
+	Bytes                int64              `json:"bytes"`                 // body bytes sent
+	Elapsed              time.Duration      `json:"elapsed_ns"`
+	Rate                 float64            `json:"rate"`       // uploads completed per second
+	Throughput           float64            `json:"throughput"` // body bytes sent per second
+	Latency              LatencyPercentiles `json:"latency"`
+}
+
+// LoadTest sends opts.Requests streamed uploads with the client's trailers to url, opts.Concurrency
+// at a time and at most opts.Rate per second, and reports how they fared. The bodies are generated
+// while they are sent. It stops early, with the uploads completed so far, when ctx is done.
+func (c *Client) LoadTest(ctx context.Context, url string, opts LoadOptions) *LoadReport {
+	if opts.Requests == 0 {
+		opts.Requests = 100
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = 10
+	}
+	if opts.Size == nil {
+		opts.Size = FixedSize(64 << 10)
+	}
+	jobs := make(chan uint64)
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if opts.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := range uint64(opts.Requests) {
+			if tick != nil && i > 0 {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	report := &LoadReport{Outcomes: make(map[string]int)}
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range opts.Concurrency {
+		wg.Go(func() {
+			for i := range jobs {
+				sizes := rand.New(rand.NewPCG(opts.Seed, i))
+				size := max(opts.Size(sizes), 0)
+				var seed [32]byte
+				binary.LittleEndian.PutUint64(seed[:], opts.Seed)
+				binary.LittleEndian.PutUint64(seed[8:], i)
+				began := time.Now()
+				result, err := c.SendStream(ctx, url, io.LimitReader(rand.NewChaCha8(seed), size))
+				latency := time.Since(began)
+
+				mu.Lock()
+				report.count(result, err, size)
+				if result != nil {
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	report.Rate = float64(report.Requests) / report.Elapsed.Seconds()
+	report.Throughput = float64(report.Bytes) / report.Elapsed.Seconds()
+	report.Latency = latencyPercentiles(latencies)
+	return report
+} // LoadTest() func
+
+// count adds the outcome of one upload of size bytes to r
+func (r *LoadReport) count(result *UploadResult, err error, size int64) {
+	r.Requests++
+	switch {
+	case result == nil:
+		r.Errors++
+		if r.FirstError == "" {
+			r.FirstError = err.Error()
+		}
+		return
+	case integrityFailed(result, err):
+		r.VerificationFailures++
+	case result.Matched && err == nil:
+		r.Verified++
+	default:
+		r.Rejected++
+	}
+	r.Bytes += size
+	r.Outcomes[result.Outcome]++
+} // count() func
+
+// latencyPercentiles summarizes latencies, which it sorts
+func latencyPercentiles(latencies []time.Duration) LatencyPercentiles {
+	if len(latencies) == 0 {
+		return LatencyPercentiles{}
+	}
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	// The nearest-rank percentile: the smallest latency at least p of them do not exceed
+	rank := func(p float64) time.Duration {
+		return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+	}
+	return LatencyPercentiles{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  rank(0.50),
+		P90:  rank(0.90),
+		P99:  rank(0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+} // latencyPercentiles() func
@@ -0,0 +1,187 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMerkleSegmentSize is the segment size of the "merkle-sha256" algorithm
+const DefaultMerkleSegmentSize = 4 << 20
+
+// merkleSegmentPrefix is how many bytes of every segment hash the trailer carries: enough
+// to tell which segments differ, while the root covers the body at full strength
+const merkleSegmentPrefix = 8
+
+// SegmentMismatchError locates the damage in a body whose Merkle trailer did not match:
+// segment i covers body bytes [i*SegmentSize, (i+1)*SegmentSize). It wraps ErrHashMismatch.
+type SegmentMismatchError struct {
+	SegmentSize int64
+	Segments    []int // indexes of the segments that differ, ascending
+	Total       int   // number of segments the receiver read
+}
+
+func (e *SegmentMismatchError) Error() string {
+	indexes := make([]string, len(e.Segments))
+	for i, segment := range e.Segments {
+		indexes[i] = strconv.Itoa(segment)
+	}
+	return fmt.Sprintf("segments %s of %d differ (%d bytes each)", strings.Join(indexes, ", "), e.Total, e.SegmentSize)
+}
+
+func (e *SegmentMismatchError) Unwrap() error { return ErrHashMismatch }
+
+// merkleDigest hashes the body in fixed-size segments and builds a binary Merkle tree over
+// them. Its trailer value is a dictionary of the segment size, the root and the first bytes
+// of every segment hash, such as
+//
+//	size=4194304, root=:base64:, segments=:base64:
+//
+// A leaf is SHA-256(0x00 || segment), a node SHA-256(0x01 || left || right); a node left
+// without a sibling moves up a level unchanged. An empty body is one empty segment.
+// The trailer grows by about 11 bytes per segment.
+type merkleDigest struct {
+	segmentSize int64
+	leaves      [][]byte
+	segment     hash.Hash // the segment being read
+	segmentN    int64     // bytes of it read so far
+	mismatch    *SegmentMismatchError
+}
+
+func newMerkleDigest(segmentSize int64) *merkleDigest {
+	d := &merkleDigest{segmentSize: segmentSize, segment: sha256.New()}
+	d.segment.Write([]byte{0})
+	return d
+} // newMerkleDigest() func
+
+func (d *merkleDigest) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p[:min(int64(len(p)), d.segmentSize-d.segmentN)]
+		d.segment.Write(chunk)
+		d.segmentN += int64(len(chunk))
+		p = p[len(chunk):]
+		if d.segmentN == d.segmentSize {
+			d.leaves = append(d.leaves, d.segment.Sum(nil))
+			d.segment.Reset()
+			d.segment.Write([]byte{0})
+			d.segmentN = 0
+		}
+	}
+	return n, nil
+}
+
+// allLeaves returns the hashes of the segments read so far, the partial last one included
+func (d *merkleDigest) allLeaves() [][]byte {
+	if d.segmentN > 0 || len(d.leaves) == 0 {
+		return append(d.leaves[:len(d.leaves):len(d.leaves)], d.segment.Sum(nil))
+	}
+	return d.leaves
+} // allLeaves() func
+
+// merkleRoot reduces leaves level by level to the root of their tree
+func merkleRoot(leaves [][]byte) []byte {
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return level[0]
+} // merkleRoot() func
+
+func (d *merkleDigest) Value() string {
+	leaves := d.allLeaves()
+	prefixes := make([]byte, 0, len(leaves)*merkleSegmentPrefix)
+	for _, leaf := range leaves {
+		prefixes = append(prefixes, leaf[:merkleSegmentPrefix]...)
+	}
+	return fmt.Sprintf("size=%d, root=:%s:, segments=:%s:", d.segmentSize,
+		base64.StdEncoding.EncodeToString(merkleRoot(leaves)), base64.StdEncoding.EncodeToString(prefixes))
+}
+
+// Matches compares the roots; when they differ it compares the segment hashes, if the value
+// carries them, to record which segments differ
+func (d *merkleDigest) Matches(reported string) (bool, error) {
+	size, root, segments, err := parseMerkleValue(reported)
+	if err != nil {
+		return false, err
+	}
+	if size != d.segmentSize {
+		return false, fmt.Errorf("segment size %d, this algorithm uses %d", size, d.segmentSize)
+	}
+	leaves := d.allLeaves()
+	if bytes.Equal(root, merkleRoot(leaves)) {
+		return true, nil
+	}
+	d.mismatch = &SegmentMismatchError{SegmentSize: d.segmentSize, Total: len(leaves)}
+	if segments == nil {
+		return false, nil // the sender only sent the root: nothing to locate the damage with
+	}
+	reportedCount := len(segments) / merkleSegmentPrefix
+	for i := range max(len(leaves), reportedCount) {
+		if i >= len(leaves) || i >= reportedCount || !bytes.Equal(leaves[i][:merkleSegmentPrefix], segments[i*merkleSegmentPrefix:(i+1)*merkleSegmentPrefix]) {
+			d.mismatch.Segments = append(d.mismatch.Segments, i)
+		}
+	}
+	return false, nil
+}
+
+// parseMerkleValue parses a merkleDigest trailer value; segments is nil when absent
+func parseMerkleValue(value string) (size int64, root, segments []byte, err error) {
+	dict, err := ParseDictionary(value)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	size, _ = dict.Integer("size")
+	root, _ = dict.Bytes("root")
+	if item, ok := dict.Get("segments"); ok {
+		if segments, ok = item.Value.([]byte); !ok {
+			return 0, nil, nil, errors.New("segments is not a byte sequence")
+		}
+	}
+	switch {
+	case size <= 0 || root == nil:
+		return 0, nil, nil, errors.New("a positive size and a root are required")
+	case len(root) != sha256.Size:
+		return 0, nil, nil, fmt.Errorf("root is %d bytes, want %d", len(root), sha256.Size)
+	case len(segments)%merkleSegmentPrefix != 0:
+		return 0, nil, nil, fmt.Errorf("segments is %d bytes, not a multiple of %d", len(segments), merkleSegmentPrefix)
+	}
+	return size, root, segments, nil
+} // parseMerkleValue() func
+
+// RegisterMerkleVerifier adds a Merkle tree check with segments of segmentSize bytes, carried
+// in trailerName, alongside the built-in "merkle-sha256" (DefaultMerkleSegmentSize segments,
+// trailer X-Body-Merkle-SHA256). Smaller segments locate damage more precisely, at the cost
+// of a longer trailer. A failed check wraps a *SegmentMismatchError naming the bad segments.
+func RegisterMerkleVerifier(algorithm, trailerName string, segmentSize int64) error {
+	if algorithm == "" || trailerName == "" || segmentSize <= 0 {
+		return errors.New("merkle verifier needs an algorithm name, a trailer field and a positive segment size")
+	}
+	if err := validateTrailerNames([]string{trailerName}); err != nil {
+		return err
+	}
+	return trailerVerifiers.register(trailerVerifier{
+		Algorithm:   algorithm,
+		TrailerName: http.CanonicalHeaderKey(trailerName),
+		Dictionary:  true,
+		NewDigest:   func([]byte) bodyDigest { return newMerkleDigest(segmentSize) },
+	})
+} // RegisterMerkleVerifier() func
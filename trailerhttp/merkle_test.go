@@ -0,0 +1,54 @@
+package trailerhttp
+
+import (
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	body := []byte("0123456789abcdefghij")
+	for _, tc := range []struct {
+		name string
+		n    int // body bytes, in 4-byte segments
+		root string
+	}{
+		{"empty body, one empty segment", 0, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d"},
+		{"one segment", 4, "96acfbd1e26d0d8573f7218c83e4dee5b7b05e2a13c6a4d2761eecc02e590abe"},
+		{"two segments", 8, "e63474db741eeccad4b2540dd6d5b6bc1ff7a63ea35afe300bfeca4892f07c54"},
+		{"three segments", 12, "bbec87cdbcfd2f13aeb5d22574e5c9d10862a168db325449573a14da7bb032ea"},
+		{"five segments", 20, "4b27e61cf4c01a2daa38fabdac47c205acc7666ed1f01df162f6ff4c6ba93d89"},
+		{"partial last segment", 10, "bc1044a40ff355812e6d1c6c23ac4b1189840cee880dcb44d5334e72762369bf"},
+	} {
+		d := newMerkleDigest(4)
+		d.Write(body[:tc.n])
+		if got := hex.EncodeToString(merkleRoot(d.allLeaves())); got != tc.root {
+			t.Errorf("%s: root %s, want %s", tc.name, got, tc.root)
+		}
+		if ok, err := d.Matches(d.Value()); !ok || err != nil {
+			t.Errorf("%s: its own value does not match: %v", tc.name, err)
+		}
+	}
+}
+
+func TestMerkleLocatesFlippedSegment(t *testing.T) {
+	body := []byte("0123456789abcdefghij")
+	sent := newMerkleDigest(4)
+	sent.Write(body)
+
+	damaged := slices.Clone(body)
+	damaged[9] ^= 0x01 // in segment 2, bytes [8, 12)
+	d := newMerkleDigest(4)
+	d.Write(damaged)
+	ok, err := d.Matches(sent.Value())
+	if ok || err != nil {
+		t.Fatalf("matched %v, err %v; want a mismatch", ok, err)
+	}
+	if d.mismatch == nil || !slices.Equal(d.mismatch.Segments, []int{2}) || d.mismatch.Total != 5 {
+		t.Errorf("mismatch %+v, want only segment 2 of 5", d.mismatch)
+	}
+	if !errors.Is(d.mismatch, ErrHashMismatch) {
+		t.Error("SegmentMismatchError does not wrap ErrHashMismatch")
+	}
+}
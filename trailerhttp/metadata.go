@@ -0,0 +1,274 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// MetadataTrailer carries JSON metadata known only once the body has been sent, such as a
+// record count or a summary computed while streaming; see Client.Metadata and SetMetadata
+const MetadataTrailer = "X-Body-Metadata"
+
+// DefaultMaxMetadataBytes bounds the MetadataTrailer value a Handler accepts; see ServerOptions.MaxMetadataBytes
+const DefaultMaxMetadataBytes = 8 << 10
+
+// ErrInvalidMetadata reports a MetadataTrailer value that is not JSON, or that does not
+// satisfy ServerOptions.MetadataSchema
+var ErrInvalidMetadata = errors.New("invalid metadata trailer")
+
+// errMetadataSchema marks metadata that is valid JSON but violates the schema
+var errMetadataSchema = fmt.Errorf("%w: schema violation", ErrInvalidMetadata)
+
+// MarshalMetadata encodes v as compact JSON fit for a trailer value
+func MarshalMetadata(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	value := string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return "", fmt.Errorf("%w: not a valid field value", ErrInvalidMetadata) // a json.RawMessage with newlines
+	}
+	return value, nil
+} // MarshalMetadata() func
+
+// SetMetadata sets the MetadataTrailer of trailer, request or response trailers alike, to v
+// encoded as JSON. The trailer must have been announced before the body was sent.
+func SetMetadata(trailer http.Header, v any) error {
+	value, err := MarshalMetadata(v)
+	if err != nil {
+		return err
+	}
+	trailer.Set(MetadataTrailer, value)
+	return nil
+} // SetMetadata() func
+
+// DecodeMetadata decodes the MetadataTrailer of trailer, such as r.Trailer once the request
+// body has been read or resp.Trailer once the response body has, into a T
+func DecodeMetadata[T any](trailer http.Header) (T, error) {
+	var v T
+	value, err := Trailers(trailer).Get(MetadataTrailer)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return v, fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	return v, nil
+} // DecodeMetadata() func
+
+// checkMetadata validates a delivered MetadataTrailer value against the handler's limits and schema
+func (h *Handler) checkMetadata(value string) (json.RawMessage, error) {
+	limit := h.opts.MaxMetadataBytes
+	if limit == 0 {
+		limit = DefaultMaxMetadataBytes
+	}
+	if len(value) > limit {
+		return nil, fmt.Errorf("%w: %d bytes, more than the %d allowed", ErrInvalidMetadata, len(value), limit)
+	}
+	var doc any
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	if h.schema != nil {
+		if err := h.schema.validate(doc, "$"); err != nil {
+			return nil, fmt.Errorf("%w: %w", errMetadataSchema, err)
+		}
+	}
+	return json.RawMessage(value), nil
+} // checkMetadata() func
+
+// jsonSchema is the subset of JSON Schema that ServerOptions.MetadataSchema supports: type,
+// enum, const, the object keywords properties, required and additionalProperties, the array
+// keywords items, minItems and maxItems, the string keywords minLength, maxLength and pattern,
+// and the number keywords minimum, maximum, exclusiveMinimum and exclusiveMaximum. Other
+// keywords are ignored, as annotations are.
+type jsonSchema struct {
+	Type                 any                    `json:"type"` // a type name or a list of them
+	Enum                 []any                  `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+
+	never   bool           // the schema false, which nothing satisfies
+	types   []string       // Type, resolved
+	pattern *regexp.Regexp // Pattern, compiled
+	konst   any            // Const, decoded
+}
+
+// UnmarshalJSON accepts the boolean schemas true and false as well as objects
+func (s *jsonSchema) UnmarshalJSON(data []byte) error {
+	var boolean bool
+	if err := json.Unmarshal(data, &boolean); err == nil {
+		s.never = !boolean
+		return nil
+	}
+	type plain jsonSchema
+	return json.Unmarshal(data, (*plain)(s))
+} // UnmarshalJSON() func
+
+// schemaTypes are the type names of JSON Schema
+var schemaTypes = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// compileSchema parses a JSON Schema document and resolves its types and patterns
+func compileSchema(doc []byte) (*jsonSchema, error) {
+	var s jsonSchema
+	if err := json.Unmarshal(doc, &s); err != nil {
+		return nil, err
+	}
+	return &s, s.compile()
+} // compileSchema() func
+
+func (s *jsonSchema) compile() error {
+	switch t := s.Type.(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				s.types = append(s.types, name)
+			}
+		}
+	}
+	for _, name := range s.types {
+		if !slices.Contains(schemaTypes, name) {
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+	}
+	if s.Const != nil {
+		if err := json.Unmarshal(s.Const, &s.konst); err != nil {
+			return fmt.Errorf("const: %w", err)
+		}
+	}
+	for name, sub := range s.Properties {
+		if sub == nil {
+			return fmt.Errorf("properties.%s: not a schema", name)
+		}
+		if err := sub.compile(); err != nil {
+			return fmt.Errorf("properties.%s: %w", name, err)
+		}
+	}
+	for _, sub := range []*jsonSchema{s.AdditionalProperties, s.Items} {
+		if sub != nil {
+			if err := sub.compile(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+} // compile() func
+
+// schemaType returns the JSON Schema type name of a value decoded by encoding/json
+func schemaType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return "string"
+	}
+} // schemaType() func
+
+// validate checks v, found at path, against s and returns the first violation
+func (s *jsonSchema) validate(v any, path string) error {
+	if s.never {
+		return fmt.Errorf("%s is not allowed", path)
+	}
+	kind := schemaType(v)
+	if s.types != nil && !slices.Contains(s.types, kind) && !(kind == "integer" && slices.Contains(s.types, "number")) {
+		return fmt.Errorf("%s is %s, want %s", path, kind, s.Type)
+	}
+	if s.Enum != nil && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%s is not one of the allowed values", path)
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.konst, v) {
+		return fmt.Errorf("%s does not equal %s", path, s.Const)
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s lacks the required property %q", path, name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			value, sub := v[name], s.Properties[name]
+			if sub == nil {
+				sub = s.AdditionalProperties
+			}
+			if sub != nil {
+				if err := sub.validate(value, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems || s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s has %d items, outside the allowed range", path, len(v))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength || s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s is %d characters long, outside the allowed range", path, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s does not match %q", path, s.Pattern)
+		}
+	case float64:
+		switch {
+		case s.Minimum != nil && v < *s.Minimum, s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum,
+			s.Maximum != nil && v > *s.Maximum, s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum:
+			return fmt.Errorf("%s is %v, outside the allowed range", path, v)
+		}
+	}
+	return nil
+} // validate() func
@@ -0,0 +1,125 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetadataSchema(t *testing.T) {
+	for _, tc := range []struct {
+		schema, pass, fail string
+	}{
+		{`true`, `{"any":"thing"}`, ``},
+		{`false`, ``, `{}`},
+		{`{"type":"object"}`, `{}`, `[]`},
+		{`{"type":["string","null"]}`, `null`, `1`},
+		{`{"type":"number"}`, `3`, `"3"`},
+		{`{"type":"integer"}`, `3`, `3.5`},
+		{`{"enum":["a",2,null]}`, `2`, `"b"`},
+		{`{"const":{"v":1}}`, `{"v":1}`, `{"v":2}`},
+		{`{"properties":{"n":{"type":"integer"}}}`, `{"n":1,"other":"x"}`, `{"n":"1"}`},
+		{`{"required":["n"]}`, `{"n":null}`, `{"m":1}`},
+		{`{"properties":{"n":true},"additionalProperties":false}`, `{"n":1}`, `{"n":1,"m":2}`},
+		{`{"additionalProperties":{"type":"string"}}`, `{"a":"x"}`, `{"a":1}`},
+		{`{"items":{"type":"string"}}`, `["a","b"]`, `["a",1]`},
+		{`{"minItems":2}`, `[1,2]`, `[1]`},
+		{`{"maxItems":1}`, `[1]`, `[1,2]`},
+		{`{"minLength":2}`, `"éé"`, `"é"`},
+		{`{"maxLength":2}`, `"éé"`, `"ééé"`},
+		{`{"pattern":"^[0-9]+$"}`, `"123"`, `"12a"`},
+		{`{"minimum":1}`, `1`, `0.5`},
+		{`{"maximum":1}`, `1`, `1.5`},
+		{`{"exclusiveMinimum":1}`, `1.5`, `1`},
+		{`{"exclusiveMaximum":1}`, `0.5`, `1`},
+		{`{"minLength":5,"minimum":10}`, `[]`, ``}, // keywords of other types do not apply
+		{`{"title":"annotated","x-unknown":1}`, `{}`, ``},
+	} {
+		s, err := compileSchema([]byte(tc.schema))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.schema, err)
+		}
+		for doc, ok := range map[string]bool{tc.pass: true, tc.fail: false} {
+			if doc == "" {
+				continue
+			}
+			var v any
+			json.Unmarshal([]byte(doc), &v)
+			if err := s.validate(v, "$"); (err == nil) != ok {
+				t.Errorf("schema %s, value %s: error %v, want it valid %v", tc.schema, doc, err, ok)
+			}
+		}
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	for _, schema := range []string{`{"type":"float"}`, `{"pattern":"("}`, `{"properties":{"n":{"type":"list"}}}`, `{"items":{"type":"tuple"}}`, `{"properties":{"n":null}}`, `{"const":1,"type":"bogus"}`, `[]`} {
+		if _, err := compileSchema([]byte(schema)); err == nil {
+			t.Errorf("schema %s compiled, want an error", schema)
+		}
+	}
+}
+
+func TestSchemaViolationPath(t *testing.T) {
+	s, _ := compileSchema([]byte(`{"properties":{"rows":{"items":{"minimum":0}}}}`))
+	var v any
+	json.Unmarshal([]byte(`{"rows":[1,-1]}`), &v)
+	if err := s.validate(v, "$"); err == nil || !strings.HasPrefix(err.Error(), "$.rows[1] ") {
+		t.Errorf("error %v, want it to name $.rows[1]", err)
+	}
+}
+
+func TestHandlerMetadata(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object","required":["records"],"properties":{"records":{"type":"integer","minimum":0}}}`)
+	h := NewHandler(ServerOptions{MaxMetadataBytes: 64, MetadataSchema: schema, Logger: discardLogger()})
+	for _, tc := range []struct {
+		metadata string
+		status   int
+	}{
+		{`{"records":3,"source":"batch"}`, http.StatusOK},
+		{`{"records":-1}`, http.StatusUnprocessableEntity},
+		{`{"rows":3}`, http.StatusUnprocessableEntity},
+		{`{"records":`, http.StatusBadRequest},
+		{`{"records":3,"padding":"` + strings.Repeat("x", 64) + `"}`, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, chunkedRequest("body", http.Header{"X-Body-Byte-Length": {"4"}, MetadataTrailer: {tc.metadata}}))
+		var result UploadResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if w.Code != tc.status {
+			t.Errorf("metadata %s: status %d (%s), want %d", tc.metadata, w.Code, result.Error, tc.status)
+		}
+		if tc.status == http.StatusOK && string(result.Metadata) != tc.metadata {
+			t.Errorf("metadata %s: result carries %s, want it returned as sent", tc.metadata, result.Metadata)
+		}
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	type summary struct {
+		Records int    `json:"records"`
+		Note    string `json:"note"`
+	}
+	trailer := http.Header{}
+	if err := SetMetadata(trailer, summary{Records: 2, Note: "<a&b>"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := trailer.Get(MetadataTrailer); got != `{"records":2,"note":"<a&b>"}` {
+		t.Errorf("trailer value %s, want compact JSON without HTML escaping", got)
+	}
+	if got, err := DecodeMetadata[summary](trailer); err != nil || got != (summary{Records: 2, Note: "<a&b>"}) {
+		t.Errorf("decoded %+v, %v; want the metadata set", got, err)
+	}
+	if _, err := DecodeMetadata[summary](http.Header{MetadataTrailer: {`{"records":"two"}`}}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("error %v for a mistyped value, want ErrInvalidMetadata", err)
+	}
+	if _, err := DecodeMetadata[summary](http.Header{}); err == nil {
+		t.Error("decoded metadata from trailers without it, want an error")
+	}
+	if _, err := MarshalMetadata("rub\x7fout"); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("error %v for a value encoding a DEL, which JSON leaves unescaped, want ErrInvalidMetadata", err)
+	}
+}
@@ -0,0 +1,78 @@
+package trailerhttp
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// trailerMetrics counts verification outcomes; expvar publishes it at /debug/vars
+// so a rising integrity-failure rate can be alerted on. expvar.Map updates are atomic.
+var trailerMetrics = expvar.NewMap("trailer_verification")
+
+// trailerOutcomes counts requests per outcome class, see classifyOutcome
+var trailerOutcomes = expvar.NewMap("trailer_outcomes")
+
+// trailerChecks counts checks per algorithm, as "<algorithm>.passed" and "<algorithm>.failed",
+// to tell which trailer keeps failing
+var trailerChecks = expvar.NewMap("trailer_checks")
+
+// Set by the prometheus build tag: prometheusHandler serves the Prometheus metrics, and
+// observeResult records every handled request into them
+var (
+	prometheusHandler http.Handler
+	observeResult     func(*UploadResult)
+)
+
+// Outcome classes of a handled request. A request without trailers is normal;
+// one that announced trailers and never delivered them is a client or proxy bug.
+const (
+	outcomeNoTrailer        = "no-trailer"
+	outcomeVerified         = "trailer-verified-ok"
+	outcomeFailed           = "trailer-failed"
+	outcomeAnnouncedMissing = "trailer-announced-missing"
+)
+
+// classifyOutcome sorts a handled request into one of the outcome classes.
+// Trailers stripped on the way (an inconclusive result) count as announced-missing;
+// a request whose trailers could not be checked counts as failed only if it was rejected.
+func classifyOutcome(result *UploadResult) string {
+	switch {
+	case len(result.MissingTrailers) > 0 || result.Inconclusive:
+		return outcomeAnnouncedMissing
+	case len(result.Checks) == 0 && (len(result.AnnouncedTrailers) == 0 || result.Error == ""):
+		return outcomeNoTrailer
+	case result.Matched:
+		return outcomeVerified
+	default:
+		return outcomeFailed
+	}
+} // classifyOutcome() func
+
+// recordMetrics classifies a handled request into the counters
+func recordMetrics(result *UploadResult) {
+	trailerMetrics.Add("requests", 1)
+	if len(result.DeliveredTrailers) > 0 {
+		trailerMetrics.Add("with_trailers", 1)
+	}
+	if len(result.MissingTrailers) > 0 {
+		trailerMetrics.Add("announced_missing", 1)
+	}
+	if len(result.Checks) > 0 {
+		if result.Matched {
+			trailerMetrics.Add("passed", 1)
+		} else {
+			trailerMetrics.Add("failed", 1)
+		}
+	}
+	for _, check := range result.Checks {
+		if check.Matched {
+			trailerChecks.Add(check.Algorithm+".passed", 1)
+		} else {
+			trailerChecks.Add(check.Algorithm+".failed", 1)
+		}
+	}
+	trailerOutcomes.Add(classifyOutcome(result), 1)
+	if observeResult != nil {
+		observeResult(result)
+	}
+} // recordMetrics() func
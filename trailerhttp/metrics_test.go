@@ -0,0 +1,66 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// counter returns the value of key in an expvar map, 0 when it is not set
+func counter(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+} // counter() func
+
+func TestMetricsCounters(t *testing.T) {
+	keys := []string{"requests", "with_trailers", "passed", "failed", "announced_missing"}
+	before := map[string]int64{}
+	for _, key := range keys {
+		before[key] = counter(trailerMetrics, key)
+	}
+	h := NewHandler(ServerOptions{Logger: discardLogger()})
+	for _, trailer := range []http.Header{
+		{"X-Body-Byte-Length": {"5"}},                       // passes
+		{"X-Body-Byte-Length": {"5"}},                       // passes
+		{"X-Body-Byte-Length": {"6"}},                       // fails
+		{"X-Body-Byte-Length": {"5"}, "X-Body-Sha256": nil}, // one never arrives
+		nil, // no trailers
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), chunkedRequest("hello", trailer))
+	}
+	want := map[string]int64{"requests": 5, "with_trailers": 4, "passed": 2, "failed": 2, "announced_missing": 1}
+	for _, key := range keys {
+		if delta := counter(trailerMetrics, key) - before[key]; delta != want[key] {
+			t.Errorf("%s went up by %d, want %d", key, delta, want[key])
+		}
+	}
+}
+
+func TestClassifyOutcome(t *testing.T) {
+	h := NewHandler(ServerOptions{Logger: discardLogger()})
+	for _, tc := range []struct {
+		trailer http.Header
+		want    string
+	}{
+		{nil, outcomeNoTrailer},
+		{http.Header{"X-Body-Byte-Length": {"5"}}, outcomeVerified},
+		{http.Header{"X-Body-Byte-Length": {"6"}}, outcomeFailed},
+		{http.Header{"X-Body-Byte-Length": nil}, outcomeAnnouncedMissing},
+	} {
+		before := counter(trailerOutcomes, tc.want)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, chunkedRequest("hello", tc.trailer))
+		var result UploadResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if result.Outcome != tc.want || classifyOutcome(&result) != tc.want {
+			t.Errorf("trailer %v: outcome %q, want %q", tc.trailer, result.Outcome, tc.want)
+		}
+		if delta := counter(trailerOutcomes, tc.want) - before; delta != 1 {
+			t.Errorf("trailer %v: %s counted %d times, want once", tc.trailer, tc.want, delta)
+		}
+	}
+}
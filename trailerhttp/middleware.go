@@ -0,0 +1,233 @@
+package trailerhttp
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option configures the Integrity middleware
+type Option func(*integrityConfig)
+
+// integrityConfig collects the Options of one Integrity middleware
+type integrityConfig struct {
+	hmacKey        []byte
+	reject         bool
+	maxBufferBytes int64
+	gunzip         bool
+	admit          func(r *http.Request) (status int, reason string)
+	tokenKeys      map[string]crypto.PublicKey
+	spoolMemBytes  int64 // under SpillToDisk, the most of a buffered body kept in memory
+	spoolDir       string
+	trailerTimeout time.Duration
+	idempotency    *idempotency // replays the responses of duplicate uploads, under WithIdempotency
+	idempotencyKey func(r *http.Request) string
+	nonces         *nonceConfig
+	trailerNames   map[string]string
+}
+
+// WithHMACKey sets the shared secret for keyed trailers such as X-Body-HMAC
+func WithHMACKey(key []byte) Option {
+	return func(cfg *integrityConfig) { cfg.hmacKey = key }
+} // WithHMACKey() func
+
+// RejectUnverified makes the middleware read and check the whole body before calling the
+// wrapped handler, answering 400 Bad Request itself when a check fails, or when none ran, as
+// for a body without integrity trailers (ErrUnverified). The body is buffered in memory to do
+// so, or partly on disk with SpillToDisk, and the handler gets it as a *SpooledBody, an
+// io.ReadSeeker, with r.ContentLength set; larger bodies than maxBytes get 413 Payload Too
+// Large (0 means no limit) without being read to their end, so their trailers never arrive;
+// see LimitBody for that.
+func RejectUnverified(maxBytes int64) Option {
+	return func(cfg *integrityConfig) { cfg.reject, cfg.maxBufferBytes = true, maxBytes }
+} // RejectUnverified() func
+
+// SpillToDisk makes RejectUnverified keep at most memBytes of a body in memory (0 means
+// DefaultSpoolMemoryBytes) and spool the rest to a temporary file in dir ("" means os.TempDir()),
+// removed once the wrapped handler returns, so large uploads can be verified before the handler
+// runs without holding them in memory; see SpoolBody.
+func SpillToDisk(memBytes int64, dir string) Option {
+	return func(cfg *integrityConfig) {
+		cfg.spoolMemBytes, cfg.spoolDir = max(memBytes, 0), dir
+		if cfg.spoolMemBytes == 0 {
+			cfg.spoolMemBytes = DefaultSpoolMemoryBytes
+		}
+	}
+} // SpillToDisk() func
+
+// WithGzip makes the middleware decompress gzip-encoded request bodies for the wrapped handler,
+// checking the trailers against the uncompressed bytes as NewGzipVerifiedBody does. The handler
+// sees the decompressed body, without the Content-Encoding header.
+func WithGzip() Option {
+	return func(cfg *integrityConfig) { cfg.gunzip = true }
+} // WithGzip() func
+
+// WithAdmit runs admit, like ServerOptions.Admit, on every request before any body byte is read;
+// a non-zero status rejects it with that status and reason without calling the wrapped handler,
+// so a client that sent "Expect: 100-continue" never transmits the body. See RequireTrailers.
+func WithAdmit(admit func(r *http.Request) (status int, reason string)) Option {
+	return func(cfg *integrityConfig) { cfg.admit = admit }
+} // WithAdmit() func
+
+// WithTokenKeys requires every upload to carry an X-Body-Token trailer that verifies with these
+// public keys, as ServerOptions.TokenKeys does: a request that does not announce it gets 401
+// Unauthorized before its body is read; otherwise the token is one more check of the VerifiedBody,
+// which fails unless the Content-Digest trailer it binds was announced too and matched the body,
+// so the wrapped handler sees a bad token as the error of its final Read and must not commit
+// anything before that. With RejectUnverified the middleware answers 401 itself.
+func WithTokenKeys(keys map[string]crypto.PublicKey) Option {
+	return func(cfg *integrityConfig) { cfg.tokenKeys = keys }
+} // WithTokenKeys() func
+
+// WithNonces refuses replays as ServerOptions.Nonces does: a request that does not announce
+// an X-Body-Nonce trailer gets 401 Unauthorized before its body is read; otherwise the nonce,
+// checked with WithHMACKey's key against the Content-Digest trailer, which must be announced
+// too and match the body, and remembered in store for ttl (0 means DefaultNonceTTL) once the
+// rest of the body verified, is one more check of the VerifiedBody, so the wrapped handler
+// sees a replay as the error of its final Read, ErrReplayedNonce. With RejectUnverified the
+// middleware answers 409 Conflict to a replay itself, and 401 to a nonce that does not verify.
+func WithNonces(store NonceStore, ttl time.Duration) Option {
+	return func(cfg *integrityConfig) { cfg.nonces = &nonceConfig{store: store, ttl: ttl} }
+} // WithNonces() func
+
+// WithTrailerNames renames the trailer fields the middleware checks, keyed by algorithm name,
+// as ServerOptions.TrailerNames does, e.g. {"length": "X-Content-Length"}
+func WithTrailerNames(names map[string]string) Option {
+	return func(cfg *integrityConfig) { cfg.trailerNames = names }
+} // WithTrailerNames() func
+
+// WithTrailerTimeout fails the body read of a request whose trailer section does not arrive
+// within timeout of the last body bytes, as ServerOptions.TrailerTimeout does: the wrapped
+// handler sees an ErrTrailerTimeout error, and RejectUnverified answers 408 Request Timeout.
+// Bodies that cannot carry trailers are not watched.
+func WithTrailerTimeout(timeout time.Duration) Option {
+	return func(cfg *integrityConfig) { cfg.trailerTimeout = timeout }
+} // WithTrailerTimeout() func
+
+// verifiedBodyKey is the context key for the *VerifiedBody of a request
+type verifiedBodyKey struct{}
+
+// VerificationFromContext returns the trailer verification of a request wrapped by Integrity.
+// Its Results and Verified are only final once the body has been read to EOF.
+func VerificationFromContext(ctx context.Context) (*VerifiedBody, bool) {
+	vb, ok := ctx.Value(verifiedBodyKey{}).(*VerifiedBody)
+	return vb, ok
+} // VerificationFromContext() func
+
+// Integrity verifies the announced integrity trailers of every request before or while next
+// reads it. By default r.Body becomes a VerifiedBody, so next sees a verification failure as
+// the error of its final Read; with RejectUnverified the check completes before next runs.
+// Either way the verification is available through VerificationFromContext, along with the
+// uploader's verified client certificate under mutual TLS (VerifiedBody.ClientCertificate).
+func Integrity(next http.Handler, opts ...Option) http.Handler {
+	var cfg integrityConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.idempotency != nil && cfg.idempotencyKey != nil {
+		cfg.idempotency.identity = cfg.idempotencyKey
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := validateTrailerNames(AnnouncedTrailers(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cfg.admit != nil {
+			if status, reason := cfg.admit(r); status != 0 {
+				http.Error(w, reason, status)
+				return
+			}
+		}
+		if _, announced := lookupField(r.Trailer, BodyTokenTrailer); cfg.tokenKeys != nil && !announced {
+			http.Error(w, "request does not announce a body token", http.StatusUnauthorized)
+			return
+		}
+		if _, announced := lookupField(r.Trailer, NonceTrailer); cfg.nonces != nil && !announced {
+			http.Error(w, "request does not announce a nonce", http.StatusUnauthorized)
+			return
+		}
+		if cfg.trailerTimeout > 0 && canCarryTrailers(r) {
+			watchdog := newRequestWatchdog(w, r.Body, cfg.trailerTimeout)
+			defer watchdog.stop()
+			r.Body = watchdog
+		}
+		gzipped := cfg.gunzip && strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip")
+		vb := verifyRequest(r, cfg.hmacKey, cfg.trailerNames)
+		if gzipped {
+			if err := vb.decodeGzip(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		vb.tokenKeys = cfg.tokenKeys
+		if cfg.nonces != nil {
+			vb.nonces = &nonceConfig{key: cfg.hmacKey, store: cfg.nonces.store, ttl: cfg.nonces.ttl}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), verifiedBodyKey{}, vb))
+		r.Body = vb
+		if gzipped {
+			r.Header = r.Header.Clone()
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		}
+		if !cfg.reject {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := io.Reader(vb)
+		if cfg.maxBufferBytes > 0 {
+			body = http.MaxBytesReader(w, vb, cfg.maxBufferBytes)
+		}
+		memBytes := cfg.spoolMemBytes
+		if memBytes == 0 {
+			memBytes = math.MaxInt64 // all in memory without SpillToDisk
+		}
+		spooled, err := SpoolBody(body, memBytes, cfg.spoolDir)
+		if err == nil && !vb.Verified() { // a body without trailers, or with none the checks cover
+			spooled.Close()
+			err = ErrUnverified
+		}
+		if err != nil {
+			rejectBuffered(w, err)
+			return
+		}
+		defer spooled.Close()
+		r.Body, r.ContentLength = spooled, spooled.Size()
+		if cfg.idempotency != nil {
+			cfg.idempotency.serve(w, r, next, vb, gzipped)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+} // Integrity() func
+
+// rejectBuffered answers a request whose body RejectUnverified failed to buffer with err
+func rejectBuffered(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	var failed *VerificationError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.As(err, &failed) && errors.Is(err, ErrReplayedNonce):
+		http.Error(w, failed.Error(), http.StatusConflict)
+	case errors.As(err, &failed) && (errors.Is(err, ErrBadToken) || errors.Is(err, ErrBadNonce)):
+		http.Error(w, failed.Error(), http.StatusUnauthorized)
+	case errors.As(err, &failed):
+		http.Error(w, failed.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrUnverified):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTrailerTimeout):
+		http.Error(w, "timed out waiting for the trailer section", http.StatusRequestTimeout)
+	case errors.Is(err, errSpoolFailed):
+		http.Error(w, "could not buffer request body", http.StatusInternalServerError)
+	default:
+		http.Error(w, "error reading request body", http.StatusBadRequest)
+	}
+} // rejectBuffered() func
@@ -0,0 +1,47 @@
+package trailerhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIntegrityRejectUnverified(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	})
+	srv := httptest.NewServer(Integrity(next, RejectUnverified(1<<20)))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "text/plain", strings.NewReader("no trailers at all"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || got != "" {
+		t.Errorf("without trailers: status %d, handler read %q; want 400 before the handler runs", resp.StatusCode, got)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		length string
+		status int
+	}{
+		{"verified", "5", http.StatusOK},
+		{"length mismatch", "6", http.StatusBadRequest},
+	} {
+		got = ""
+		req := &RawRequest{Body: []byte("hello"), Trailer: []RawField{{"X-Body-Byte-Length", tc.length}}}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if raw.Response.StatusCode != tc.status || (got == "hello") != (tc.status == http.StatusOK) {
+			t.Errorf("%s: status %d, handler read %q; want %d", tc.name, raw.Response.StatusCode, got, tc.status)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package trailerhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// requireClientCerts returns cfg (a new configuration if nil) set up for mutual TLS:
+// every client must present a certificate chaining to one of clientCAs
+func requireClientCerts(cfg *tls.Config, clientCAs *x509.CertPool) *tls.Config {
+	if cfg == nil {
+		cfg = new(tls.Config)
+	} else {
+		cfg = cfg.Clone()
+	}
+	cfg.ClientCAs = clientCAs
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg
+} // requireClientCerts() func
+
+// ClientCertificate returns the verified TLS client certificate of r, the client's identity
+// under mutual TLS (see ServerOptions.ClientCAs), or nil when the client presented none or
+// it was not verified
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+} // ClientCertificate() func
+
+// LoadCertPool reads a PEM bundle of CA certificates, e.g. for ServerOptions.ClientCAs
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", file)
+	}
+	return pool, nil
+} // LoadCertPool() func
+
+// NewMTLSClient returns an HTTP client that presents clientCert to the servers it connects to,
+// for use as Client.HTTPClient (or as the Base of a Transport) against a Server requiring
+// client certificates. serverRoots verifies the servers; nil means the system roots.
+// HTTP/2 is negotiated via ALPN when the server offers it; the trailers travel the same way.
+func NewMTLSClient(clientCert tls.Certificate, serverRoots *x509.CertPool) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:       &tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: serverRoots},
+		ForceAttemptHTTP2:     true,
+		ExpectContinueTimeout: time.Second,
+	}}
+} // NewMTLSClient() func
@@ -0,0 +1,420 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// errMalformedMultipart marks a multipart body whose framing does not parse
+var errMalformedMultipart = errors.New("malformed multipart body")
+
+// Per-part trailers of a multipart upload: the prefix followed by the form name of the part,
+// e.g. X-Part-Digest-avatar with the SHA-256 of its content and X-Part-Length-avatar with its
+// length. A name several parts share gets one comma-separated value per part, in their order.
+const (
+	PartDigestTrailerPrefix = "X-Part-Digest-"
+	PartLengthTrailerPrefix = "X-Part-Length-"
+)
+
+// PartSummary describes one part of a multipart upload
+type PartSummary struct {
+	Name     string `json:"name,omitempty"`
+	FileName string `json:"filename,omitempty"`
+	Length   int64  `json:"length"`           // bytes of part content, excluding its headers and boundary
+	SHA256   string `json:"sha256,omitempty"` // hex SHA-256 of the content, when the upload announced part digests
+}
+
+// multipartBoundary returns the boundary of a multipart request body, or "" if the body is not multipart
+func multipartBoundary(r *http.Request) string {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return ""
+	}
+	return params["boundary"]
+} // multipartBoundary() func
+
+// streamMultipart reads a multipart body part by part and returns the raw body length and the parts.
+// dst and the length see the raw body, boundaries and part headers included, since that is what
+// the client's length and digest trailers describe; the epilogue after the closing boundary is
+// read as well, so the trailers that follow it arrive.
+// With hashParts each part's content is hashed too, for its X-Part-Digest- trailer.
+func streamMultipart(dst io.Writer, body io.Reader, boundary string, hashParts bool) (int64, []PartSummary, error) {
+	var raw lengthDigest
+	src := &readErrRecorder{r: body}
+	tee := io.TeeReader(src, io.MultiWriter(dst, &raw))
+	mr := multipart.NewReader(tee, boundary)
+
+	// Tell transport errors (size limit, timeout, ...) apart from bad multipart framing
+	fail := func(err error) (int64, []PartSummary, error) {
+		if src.err != nil {
+			return raw.n, nil, src.err
+		}
+		return raw.n, nil, fmt.Errorf("%w: %w", errMalformedMultipart, err)
+	}
+
+	var parts []PartSummary
+	for {
+		part, err := mr.NextRawPart() // raw: a part's Content-Transfer-Encoding must not change its length
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		vp := newVerifiedPart(part, hashParts)
+		if _, err := streamBody(io.Discard, vp); err != nil {
+			return fail(err)
+		}
+		parts = append(parts, vp.summary())
+	}
+	if _, err := streamBody(io.Discard, tee); err != nil {
+		return fail(err)
+	}
+	return raw.n, parts, nil
+} // streamMultipart() func
+
+// announcesPartDigests reports whether trailer declares an X-Part-Digest- field
+func announcesPartDigests(trailer http.Header) bool {
+	for name := range trailer {
+		if _, ok := cutPrefixFold(name, PartDigestTrailerPrefix); ok {
+			return true
+		}
+	}
+	return false
+} // announcesPartDigests() func
+
+// cutPrefixFold is strings.CutPrefix ignoring case, as field names do
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+} // cutPrefixFold() func
+
+// partTrailers are the per-part checks: the algorithm reported, the field prefix, the sentinel
+// of a mismatch, and the value of a part and whether one reported for it matches
+var partTrailers = []struct {
+	algorithm string
+	prefix    string
+	mismatch  error
+	value     func(p PartSummary) string
+	matches   func(reported string, p PartSummary) (bool, error)
+}{{
+	algorithm: "part-sha256",
+	prefix:    PartDigestTrailerPrefix,
+	mismatch:  ErrHashMismatch,
+	value:     func(p PartSummary) string { return p.SHA256 },
+	matches: func(reported string, p PartSummary) (bool, error) {
+		sum, err := decodeDigest(reported, sha256.Size)
+		if err != nil {
+			return false, err
+		}
+		computed, _ := hex.DecodeString(p.SHA256)
+		return bytes.Equal(sum, computed), nil
+	},
+}, {
+	algorithm: "part-length",
+	prefix:    PartLengthTrailerPrefix,
+	mismatch:  ErrLengthMismatch,
+	value:     func(p PartSummary) string { return strconv.FormatInt(p.Length, 10) },
+	matches: func(reported string, p PartSummary) (bool, error) {
+		return matchCount(reported, p.Length)
+	},
+}}
+
+// verifyParts checks parts against the X-Part-Digest- and X-Part-Length- fields of trailer,
+// one result per field. A field matches when it has a value per part of its form name, the
+// name compared ignoring case as field names are, and each value matches its part; a field
+// naming no part fails, as that part went missing.
+func verifyParts(parts []PartSummary, trailer http.Header) []VerificationResult {
+	var results []VerificationResult
+	for _, name := range slices.Sorted(maps.Keys(trailer)) {
+		for _, check := range partTrailers {
+			formName, ok := cutPrefixFold(name, check.prefix)
+			if !ok || formName == "" {
+				continue
+			}
+			var group []PartSummary
+			for _, p := range parts {
+				if strings.EqualFold(p.Name, formName) {
+					group = append(group, p)
+				}
+			}
+			reported := CombineFieldValues(trailer[name])
+			values := strings.Split(reported, ",")
+			computed := make([]string, len(group))
+			for i, p := range group {
+				computed[i] = check.value(p)
+			}
+			result := VerificationResult{Algorithm: check.algorithm, TrailerName: name, Computed: strings.Join(computed, ", "), Reported: reported}
+			result.Matched = len(values) == len(group)
+			for i := 0; result.Matched && i < len(group); i++ {
+				matched, err := check.matches(strings.TrimSpace(values[i]), group[i])
+				if err != nil {
+					result.Matched, result.Err = false, fmt.Errorf("%w in %s: %w", ErrMalformedTrailer, name, err)
+				}
+				result.Matched = result.Matched && matched
+			}
+			if !result.Matched && result.Err == nil {
+				result.Err = &TrailerError{Field: name, Expected: reported, Actual: result.Computed, Err: check.mismatch}
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+} // verifyParts() func
+
+// MultipartReader reads the parts of a multipart upload as they stream in, as
+// multipart.Reader does, while checking the integrity trailers of the whole body, as a
+// VerifiedBody does, and those of each part, X-Part-Digest-<name> and X-Part-Length-<name>,
+// which Client.SendMultipart sends. Each part is hashed as the handler reads it, or as
+// NextPart skips the rest of it, so nothing is buffered; the trailers only arrive after the
+// last part, so the handler must not commit what it read until NextPart has returned io.EOF,
+// which it returns only once every check passed, a *VerificationError otherwise.
+type MultipartReader struct {
+	vb      *VerifiedBody
+	mr      *multipart.Reader
+	trailer *http.Header
+	hash    bool // the upload announced part digests
+	part    *VerifiedPart
+	parts   []PartSummary
+	results []VerificationResult
+	done    bool
+	err     error // the verification error NextPart returns when the parts have run out
+}
+
+// NewMultipartReader returns a MultipartReader of r, or http.ErrNotMultipart if r is not a
+// multipart upload. key is the shared secret for keyed verifiers such as hmac-sha256.
+// Call it before reading any of the body.
+func NewMultipartReader(r *http.Request, key []byte) (*MultipartReader, error) {
+	boundary := multipartBoundary(r)
+	if boundary == "" {
+		return nil, http.ErrNotMultipart
+	}
+	vb := NewVerifiedBody(r, key)
+	return &MultipartReader{
+		vb:      vb,
+		mr:      multipart.NewReader(verifiedEOF{vb}, boundary),
+		trailer: &r.Trailer,
+		hash:    announcesPartDigests(r.Trailer),
+	}, nil
+} // NewMultipartReader() func
+
+// verifiedEOF hands the parser of a MultipartReader the body with a failed verification as
+// a plain EOF; NextPart reports it once the parts have run out
+type verifiedEOF struct {
+	vb *VerifiedBody
+}
+
+func (r verifiedEOF) Read(p []byte) (int, error) {
+	n, err := r.vb.Read(p)
+	if r.vb.done {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// NextPart returns the next part, after skipping what the handler did not read of the last
+// one. After the last part it reads the trailers and returns io.EOF if every check passed,
+// or a *VerificationError with the checks of the body and its parts.
+func (mr *MultipartReader) NextPart() (*VerifiedPart, error) {
+	if mr.part != nil {
+		if _, err := io.Copy(io.Discard, mr.part); err != nil {
+			return nil, err
+		}
+		mr.parts = append(mr.parts, mr.part.summary())
+		mr.part = nil
+	}
+	if mr.done {
+		return nil, mr.eof()
+	}
+	part, err := mr.mr.NextRawPart() // raw: a part's Content-Transfer-Encoding must not change its length
+	if err == io.EOF {
+		mr.finish()
+		return nil, mr.eof()
+	}
+	if err != nil {
+		return nil, err
+	}
+	mr.part = newVerifiedPart(part, mr.hash)
+	return mr.part, nil
+} // NextPart() func
+
+// finish reads the rest of the body, the epilogue and the trailers, and runs the checks
+func (mr *MultipartReader) finish() {
+	mr.done = true
+	_, err := io.Copy(io.Discard, mr.vb) // the verification error of the body, if any
+	var failed *VerificationError
+	if err != nil && !errors.As(err, &failed) {
+		mr.err = err
+		return
+	}
+	mr.results = append(slices.Clone(mr.vb.Results()), verifyParts(mr.parts, *mr.trailer)...)
+	verr := &VerificationError{Results: mr.results}
+	if failed != nil {
+		verr.Missing, verr.errs = failed.Missing, slices.Clone(failed.errs)
+	}
+	for _, result := range mr.results[len(mr.vb.Results()):] {
+		if result.Err != nil {
+			verr.errs = append(verr.errs, result.Err)
+		}
+	}
+	if len(verr.errs) > 0 {
+		mr.err = verr
+	}
+} // finish() func
+
+// eof returns the verification error, or io.EOF if every check passed
+func (mr *MultipartReader) eof() error {
+	if mr.err != nil {
+		return mr.err
+	}
+	return io.EOF
+} // eof() func
+
+// Parts returns the parts read so far
+func (mr *MultipartReader) Parts() []PartSummary {
+	return mr.parts
+} // Parts() func
+
+// Results returns the checks of the body and of its parts; it is empty until NextPart returned
+// after the last part
+func (mr *MultipartReader) Results() []VerificationResult {
+	return mr.results
+} // Results() func
+
+// VerifiedPart is a part of a MultipartReader, hashed as it is read
+type VerifiedPart struct {
+	*multipart.Part
+	sha hash.Hash // nil unless the upload announced part digests
+	n   int64
+}
+
+// newVerifiedPart wraps part, hashing it with hashPart
+func newVerifiedPart(part *multipart.Part, hashPart bool) *VerifiedPart {
+	vp := &VerifiedPart{Part: part}
+	if hashPart {
+		vp.sha = sha256.New()
+	}
+	return vp
+} // newVerifiedPart() func
+
+func (p *VerifiedPart) Read(b []byte) (int, error) {
+	n, err := p.Part.Read(b)
+	p.n += int64(n)
+	if p.sha != nil {
+		p.sha.Write(b[:n])
+	}
+	return n, err
+}
+
+// summary describes the part, once it has been read
+func (p *VerifiedPart) summary() PartSummary {
+	s := PartSummary{Name: p.FormName(), FileName: p.FileName(), Length: p.n}
+	if p.sha != nil {
+		s.SHA256 = hex.EncodeToString(p.sha.Sum(nil))
+	}
+	return s
+} // summary() func
+
+// FormPart is a part of a multipart/form-data upload; see Client.SendMultipart
+type FormPart struct {
+	Name        string // form field name, which also names the part's trailers, so it must be a valid field name
+	FileName    string // "" for a plain form field
+	ContentType string // "" means application/octet-stream for a file and none for a plain field
+	Body        io.Reader
+}
+
+// SendMultipart streams parts to url as a multipart/form-data body, as SendStream does, with the
+// trailers of Client.Algorithms over the whole body and, for each part, its SHA-256 and length
+// in X-Part-Digest-<name> and X-Part-Length-<name> trailers, all computed as the parts stream
+// out; parts sharing a name share the fields, a value per part. The Handler, and a
+// MultipartReader on any server, check every part without buffering the form, so a failed
+// check names the part that was damaged.
+func (c *Client) SendMultipart(ctx context.Context, url string, parts ...FormPart) (*UploadResult, error) {
+	for _, part := range parts {
+		if part.Name == "" || !httpguts.ValidHeaderFieldName(PartDigestTrailerPrefix+part.Name) {
+			return nil, fmt.Errorf("multipart part name %q cannot name a trailer field", part.Name)
+		}
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	summaries := make([]PartSummary, len(parts))
+	body := &pipedBody{pr: pr, copy: func() {
+		pw.CloseWithError(writeFormParts(mw, parts, summaries))
+	}}
+	defer pr.Close() // stops the writer if the upload ended before the body did
+
+	upload := *c
+	upload.contentType = mw.FormDataContentType()
+	upload.customTrailers = slices.Clip(upload.customTrailers)
+	var names []string
+	for _, part := range parts {
+		if slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, part.Name) }) {
+			continue
+		}
+		names = append(names, part.Name)
+		for _, check := range partTrailers {
+			upload.customTrailers = append(upload.customTrailers, customTrailer{
+				name: check.prefix + part.Name,
+				value: func() string { // called once the body has been sent, the summaries complete
+					var values []string
+					for _, s := range summaries {
+						if strings.EqualFold(s.Name, part.Name) {
+							values = append(values, check.value(s))
+						}
+					}
+					return strings.Join(values, ", ")
+				},
+			})
+		}
+	}
+	upload.debug(upload.logger(), "Sending multipart upload", "url", url, "parts", names)
+	return upload.SendStream(ctx, url, body)
+} // SendMultipart() func
+
+// writeFormParts writes parts into mw, describing each in summaries as it goes, and closes mw
+func writeFormParts(mw *multipart.Writer, parts []FormPart, summaries []PartSummary) error {
+	for i, part := range parts {
+		header := textproto.MIMEHeader{}
+		disposition := mime.FormatMediaType("form-data", map[string]string{"name": part.Name})
+		contentType := part.ContentType
+		if part.FileName != "" {
+			disposition = mime.FormatMediaType("form-data", map[string]string{"name": part.Name, "filename": part.FileName})
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+		header.Set("Content-Disposition", disposition)
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		sha := sha256.New()
+		n, err := io.Copy(io.MultiWriter(w, sha), part.Body)
+		if err != nil {
+			return fmt.Errorf("%w: part %q: %w", ErrBodyStream, part.Name, err)
+		}
+		summaries[i] = PartSummary{Name: part.Name, FileName: part.FileName, Length: n, SHA256: hex.EncodeToString(sha.Sum(nil))}
+	}
+	return mw.Close()
+} // writeFormParts() func
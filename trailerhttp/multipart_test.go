@@ -0,0 +1,110 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandlerMultipartLength(t *testing.T) {
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("comment", "two parts")
+	fw, _ := mw.CreateFormFile("file", "data.bin")
+	fw.Write(bytes.Repeat([]byte{0xab}, 1000))
+	mw.Close()
+
+	r := chunkedRequest(form.String(), http.Header{"X-Body-Byte-Length": {strconv.Itoa(form.Len())}})
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	NewHandler(ServerOptions{Logger: discardLogger()}).ServeHTTP(w, r)
+	var result UploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matched || result.BodyLength != int64(form.Len()) {
+		t.Errorf("status %d, matched %v, body length %d; want the %d raw bytes verified", w.Code, result.Matched, result.BodyLength, form.Len())
+	}
+	want := []PartSummary{{Name: "comment", Length: 9}, {Name: "file", FileName: "data.bin", Length: 1000}}
+	if len(result.Parts) != len(want) || result.Parts[0] != want[0] || result.Parts[1] != want[1] {
+		t.Errorf("parts %+v, want %+v", result.Parts, want)
+	}
+}
+
+func TestMultipartReaderPartTrailers(t *testing.T) {
+	type received struct {
+		parts   map[string]string
+		results []VerificationResult
+		err     error
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := NewMultipartReader(r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rcv := received{parts: map[string]string{}}
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				rcv.err = err
+				break
+			}
+			b, _ := io.ReadAll(part)
+			rcv.parts[part.FormName()] = string(b)
+		}
+		rcv.results = mr.Results()
+		if rcv.err != io.EOF {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		got <- rcv
+	}))
+	defer srv.Close()
+
+	file := bytes.Repeat([]byte("part content "), 1000)
+	for _, tc := range []struct {
+		name    string
+		fault   *Fault
+		corrupt string // the trailer of the part that fails its check, "" for none
+	}{
+		{"intact", nil, ""},
+		{"corrupted part", &Fault{CorruptTrailer: "X-Part-Digest-file"}, "X-Part-Digest-File"},
+	} {
+		c := &Client{Algorithms: []string{"sha256"}, Fault: tc.fault, Logger: discardLogger()}
+		result, _ := c.SendMultipart(t.Context(), srv.URL,
+			FormPart{Name: "comment", Body: strings.NewReader("two parts")},
+			FormPart{Name: "file", FileName: "data.bin", Body: bytes.NewReader(file)})
+		rcv := <-got
+		if rcv.parts["comment"] != "two parts" || rcv.parts["file"] != string(file) {
+			t.Errorf("%s: the parts did not arrive whole", tc.name)
+		}
+		var checked, failed []string
+		for _, r := range rcv.results {
+			checked = append(checked, r.TrailerName)
+			if !r.Matched {
+				failed = append(failed, r.TrailerName)
+			}
+		}
+		for _, name := range []string{"X-Body-SHA256", "X-Part-Digest-Comment", "X-Part-Length-Comment", "X-Part-Digest-File", "X-Part-Length-File"} {
+			if !slices.Contains(checked, name) {
+				t.Errorf("%s: checks %v, want %s among them", tc.name, checked, name)
+			}
+		}
+		var verr *VerificationError
+		switch {
+		case tc.corrupt == "" && (rcv.err != io.EOF || len(failed) > 0 || result == nil || result.StatusCode != http.StatusOK):
+			t.Errorf("%s: NextPart ended with %v, failed checks %v; want io.EOF and every check passed", tc.name, rcv.err, failed)
+		case tc.corrupt != "" && (!errors.As(rcv.err, &verr) || !slices.Equal(failed, []string{tc.corrupt}) || result == nil || result.StatusCode != http.StatusUnprocessableEntity):
+			t.Errorf("%s: NextPart ended with %v, failed checks %v; want a *VerificationError naming only %s", tc.name, rcv.err, failed, tc.corrupt)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package trailerhttp
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CombineFieldValues joins the field lines of one field into a single value, as RFC 9110,
+// Section 5.3 allows for list-based fields: each line trimmed, empty ones dropped, the rest
+// separated by ", "
+func CombineFieldValues(values []string) string {
+	var lines []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			lines = append(lines, value)
+		}
+	}
+	return strings.Join(lines, ", ")
+} // CombineFieldValues() func
+
+// MergeDictionaries merges RFC 8941 dictionary field values, such as the repeated field lines of
+// a Content-Digest trailer, into one canonically serialized value. As when a split field is
+// parsed, a repeated key keeps its first position and its last value.
+func MergeDictionaries(values ...string) (string, error) {
+	dict, err := ParseDictionary(CombineFieldValues(values))
+	if err != nil {
+		return "", err
+	}
+	return EncodeDictionary(dict)
+} // MergeDictionaries() func
+
+// CanonicalizeTrailers returns a copy of trailer with canonical field names, fields whose names
+// differ only in case joined in one, and the field lines of every dictionary-valued trailer a
+// registered verifier checks (Content-Digest, Repr-Digest, X-Body-Merkle-SHA256, ...) merged into
+// one value by MergeDictionaries. Other fields keep their lines, trimmed, empty ones dropped.
+func CanonicalizeTrailers(trailer http.Header) (http.Header, error) {
+	out := http.Header{}
+	for _, name := range slices.Sorted(maps.Keys(trailer)) {
+		canonical := http.CanonicalHeaderKey(name)
+		for _, value := range trailer[name] {
+			if value = strings.TrimSpace(value); value != "" {
+				out[canonical] = append(out[canonical], value)
+			}
+		}
+	}
+	for _, v := range trailerVerifiers.all() {
+		name := http.CanonicalHeaderKey(v.TrailerName)
+		if values := out[name]; v.Dictionary && len(values) > 0 {
+			merged, err := MergeDictionaries(values...)
+			if err != nil {
+				return nil, malformedTrailerError(name, CombineFieldValues(values), err)
+			}
+			out[name] = []string{merged}
+		}
+	}
+	return out, nil
+} // CanonicalizeTrailers() func
+
+// checkDuplicateMembers rejects a dictionary repeating a key with a different value, as a
+// digest field naming two different sums for one algorithm, which cannot be trusted either way
+func checkDuplicateMembers(field string) error {
+	members, err := parseField(field, (*sfParser).parseDictionaryMembers)
+	if err != nil {
+		return err
+	}
+	for i, member := range members {
+		if j := dictIndex(members[:i], member.Key); j >= 0 && !sameItem(members[j].Item, member.Item) {
+			return fmt.Errorf("conflicting values for %q", member.Key)
+		}
+	}
+	return nil
+} // checkDuplicateMembers() func
+
+// sameItem reports whether two dictionary member values serialize the same
+func sameItem(a, b Item) bool {
+	var encodedA, encodedB strings.Builder
+	return writeMember(&encodedA, a) == nil && writeMember(&encodedB, b) == nil && encodedA.String() == encodedB.String()
+} // sameItem() func
@@ -0,0 +1,279 @@
+package trailerhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// AcceptTrailersHeader is the response header in which a Handler answering OPTIONS lists the
+// integrity trailers it verifies, as an RFC 8941 list of algorithm names, e.g.
+//
+//	Accept-Trailers: length, crc32c, sha256;field="X-Checksum", content-digest
+//
+// where a field parameter names the trailer field of a verifier renamed with
+// ServerOptions.TrailerNames
+const AcceptTrailersHeader = "Accept-Trailers"
+
+// ErrTrailersUnsupported reports an upload that needs trailers to a server that advertised
+// none: its signature, body token or metadata cannot move to the request headers
+var ErrTrailersUnsupported = errors.New("server does not accept trailers")
+
+// Capabilities is what a server answered to an OPTIONS request about its trailer support
+type Capabilities struct {
+	AcceptsTrailers bool     // the server sent Accept-Trailers, so it verifies integrity trailers
+	Algorithms      []string // the algorithms it verifies in the trailer fields this client sends, in its order
+}
+
+// serveOptions answers an OPTIONS request with the Accept-Trailers header, without reading a body
+func (h *Handler) serveOptions(w http.ResponseWriter) {
+	var list []Item
+	for _, v := range h.activeVerifiers() {
+		if v.Keyed && len(h.opts.HMACKey) == 0 {
+			continue // it would fail every upload
+		}
+		item := Item{Value: Token(v.Algorithm)}
+		if def, err := lookupVerifier(v.Algorithm); err == nil && !strings.EqualFold(def.TrailerName, v.TrailerName) {
+			item.Params = Params{{Key: "field", Value: v.TrailerName}}
+		}
+		if _, err := EncodeItem(item); err == nil {
+			list = append(list, item) // a registered name need not be a valid token
+		}
+	}
+	accept, _ := EncodeList(list)
+	w.Header().Set(AcceptTrailersHeader, accept)
+	w.WriteHeader(http.StatusNoContent)
+} // serveOptions() func
+
+// integrityHeaders returns the integrity fields of verifiers that r carries as headers and does
+// not announce as trailers: the checks of a client that cannot send trailers, as Client.Negotiate
+// falls back to. The Handler verifies them like trailers once the body has been read.
+func integrityHeaders(r *http.Request, verifiers []trailerVerifier) http.Header {
+	var fields http.Header
+	for _, v := range verifiers {
+		values, inHeader := lookupField(r.Header, v.TrailerName)
+		if _, announced := lookupField(r.Trailer, v.TrailerName); inHeader && !announced {
+			if fields == nil {
+				fields = http.Header{}
+			}
+			fields[http.CanonicalHeaderKey(v.TrailerName)] = values
+		}
+	}
+	return fields
+} // integrityHeaders() func
+
+// Capabilities asks the server at url with an OPTIONS request which integrity trailers it
+// verifies. A server that sends no Accept-Trailers header, or does not answer OPTIONS with a
+// 2xx status, is reported as not accepting trailers; only a failed request is an error.
+func (c *Client) Capabilities(ctx context.Context, url string) (*Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(requestIDHeader, newRequestID())
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainBody(resp)
+	caps := &Capabilities{}
+	accept, ok := resp.Header[AcceptTrailersHeader]
+	if resp.StatusCode/100 != 2 || !ok {
+		c.debug(c.requestLogger(req), "Server advertises no trailers", "status", resp.Status)
+		return caps, nil
+	}
+	list, err := ParseList(CombineFieldValues(accept))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", AcceptTrailersHeader, err)
+	}
+	caps.AcceptsTrailers = true
+	for _, item := range list {
+		algorithm, ok := item.Value.(Token)
+		if !ok {
+			continue
+		}
+		v, err := lookupVerifier(string(algorithm))
+		if err != nil {
+			continue // unknown to this client
+		}
+		v = v.renamed(c.TrailerNames)
+		if field, ok := item.Params.Get("field"); ok && !strings.EqualFold(fmt.Sprint(field), v.TrailerName) {
+			continue // the server reads it from a field this client does not send
+		}
+		caps.Algorithms = append(caps.Algorithms, v.Algorithm)
+	}
+	c.debug(c.requestLogger(req), "Server advertises trailers", "algorithms", caps.Algorithms)
+	return caps, nil
+} // Capabilities() func
+
+// negotiated returns a copy of the client adapted to the capabilities of the server at url,
+// for Client.Negotiate
+func (c *Client) negotiated(ctx context.Context, url string) (*Client, error) {
+	caps, err := c.Capabilities(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("negotiating trailers: %w", err)
+	}
+	upload := *c
+	upload.Negotiate = false
+	if !caps.AcceptsTrailers {
+		if c.Signer != nil || c.BodyToken != nil || c.Metadata != nil {
+			return nil, fmt.Errorf("%w: signature, body token and metadata travel in trailers only", ErrTrailersUnsupported)
+		}
+		upload.headerDigests = true
+		upload.logger().Info("Server accepts no trailers; sending the integrity fields as headers", "url", url)
+		return &upload, nil
+	}
+	algorithms := upload.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"length"}
+	}
+	algorithms = slices.DeleteFunc(slices.Clone(algorithms), func(algorithm string) bool {
+		return !slices.Contains(caps.Algorithms, strings.TrimSpace(algorithm))
+	})
+	if len(algorithms) == 0 {
+		// None of those it verifies; send every one it does
+		for _, algorithm := range caps.Algorithms {
+			if v, _ := lookupVerifier(algorithm); !v.Keyed || len(c.HMACKey) > 0 {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	upload.Algorithms = algorithms
+	upload.debug(upload.logger(), "Negotiated trailers", "url", url, "algorithms", algorithms)
+	return &upload, nil
+} // negotiated() func
+
+// sendBuffered sends src the way a server without trailer support can check it: buffered
+// (see SpoolBody) to learn its length, with the integrity fields of c.Algorithms in the request
+// headers instead of trailers, along with the fields of WithTrailer. The body goes uncompressed,
+// whatever c.Gzip says.
+func (c *Client) sendBuffered(ctx context.Context, method, url string, header http.Header, src io.Reader) (*http.Response, error) {
+	verifiers, digests, writers, err := c.headerDigestWriters()
+	if err != nil {
+		return nil, err
+	}
+	if c.ProgressFunc != nil {
+		writers = append(writers, &progressWriter{report: c.ProgressFunc})
+	}
+	spooled, err := SpoolBody(io.TeeReader(contextReader{ctx: ctx, r: src}, io.MultiWriter(writers...)), 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBodyStream, err)
+	}
+	if c.OnBodyComplete != nil {
+		c.OnBodyComplete(spooled.Size(), firstSum(digests))
+	}
+	req, err := c.headerDigestRequest(ctx, method, url, header, spooled, spooled.Size(), verifiers, digests)
+	if err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if req.Body == http.NoBody {
+		spooled.Close()
+	}
+	c.debug(c.requestLogger(req), "Sending buffered request with integrity headers", "bytes", spooled.Size(), "on_disk", spooled.OnDisk())
+	return c.httpClient().Do(req)
+} // sendBuffered() func
+
+// sendPrePassed sends src as sendBuffered does, but without buffering it: a first pass reads it
+// to its end to compute the integrity fields, and the request then sends it again from where
+// it started, with its length
+func (c *Client) sendPrePassed(ctx context.Context, method, url string, header http.Header, src io.ReadSeeker) (*http.Response, error) {
+	verifiers, digests, writers, err := c.headerDigestWriters()
+	if err != nil {
+		return nil, err
+	}
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBodyStream, err)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), contextReader{ctx: ctx, r: src})
+	if err == nil {
+		_, err = src.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBodyStream, err)
+	}
+	if c.OnBodyComplete != nil {
+		c.OnBodyComplete(n, firstSum(digests))
+	}
+	body := io.LimitReader(src, n) // what was hashed, should src have grown since
+	if c.ProgressFunc != nil {
+		body = io.TeeReader(body, &progressWriter{report: c.ProgressFunc})
+	}
+	req, err := c.headerDigestRequest(ctx, method, url, header, io.NopCloser(body), n, verifiers, digests)
+	if err != nil {
+		return nil, err
+	}
+	c.debug(c.requestLogger(req), "Sending pre-passed request with integrity headers", "bytes", n)
+	return c.httpClient().Do(req)
+} // sendPrePassed() func
+
+// headerDigestWriters returns the verifiers of the integrity fields a body sent with them as
+// headers carries, their digests, and the writers feeding those
+func (c *Client) headerDigestWriters() ([]trailerVerifier, []bodyDigest, []io.Writer, error) {
+	verifiers, err := c.verifiers()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	digests := make([]bodyDigest, len(verifiers))
+	writers := make([]io.Writer, len(verifiers))
+	for i, v := range verifiers {
+		digests[i] = withEncoding(v.NewDigest(c.HMACKey), c.DigestEncoding)
+		writers[i] = digests[i]
+	}
+	return verifiers, digests, writers, nil
+} // headerDigestWriters() func
+
+// headerDigestRequest returns the request sending body, of size bytes, with the extra header
+// fields and the integrity fields of verifiers, whose digests have seen all of it, as headers
+func (c *Client) headerDigestRequest(ctx context.Context, method, url string, header http.Header, body io.ReadCloser, size int64, verifiers []trailerVerifier, digests []bodyDigest) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.records != "" {
+		req.Header.Set("Content-Type", string(c.records))
+	}
+	if c.contentType != "" {
+		req.Header.Set("Content-Type", c.contentType)
+	}
+	if c.ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", c.ifNoneMatch)
+	}
+	req.Header.Set("TE", "trailers")
+	req.Header.Set(requestIDHeader, newRequestID())
+	if injectTrace != nil {
+		injectTrace(ctx, req.Header)
+	}
+	if c.ExpectContinue {
+		req.Header.Set("Expect", "100-continue")
+	}
+	fields := http.Header{}
+	for i, v := range verifiers {
+		fields.Set(v.TrailerName, digests[i].Value())
+	}
+	for _, custom := range c.customTrailers {
+		fields.Set(custom.name, custom.value())
+	}
+	for name, values := range c.TrailerOverride {
+		fields[http.CanonicalHeaderKey(name)] = values
+	}
+	for name, values := range fields {
+		req.Header[name] = values
+	}
+	if c.OnTrailersSent != nil {
+		c.OnTrailersSent(size, fields.Clone())
+	}
+	return req, nil
+} // headerDigestRequest() func
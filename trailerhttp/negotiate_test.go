@@ -0,0 +1,111 @@
+package trailerhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	url := startServer(t, ServerOptions{
+		Algorithms:   []string{"length", "sha256", "crc32c", "hmac-sha256"},
+		TrailerNames: map[string]string{"crc32c": "X-Checksum"},
+	})
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodOptions, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(AcceptTrailersHeader); resp.StatusCode != http.StatusNoContent || got != `length, sha256, crc32c;field="X-Checksum"` {
+		t.Errorf("status %d, %s %q; want the keyless algorithms, crc32c with its field", resp.StatusCode, AcceptTrailersHeader, got)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		trailerNames map[string]string
+		want         []string
+	}{
+		{"default fields", nil, []string{"length", "sha256"}},
+		{"crc32c renamed alike", map[string]string{"crc32c": "x-checksum"}, []string{"length", "sha256", "crc32c"}},
+	} {
+		caps, err := (&Client{TrailerNames: tc.trailerNames, Logger: discardLogger()}).Capabilities(t.Context(), url)
+		if err != nil || !caps.AcceptsTrailers || !slices.Equal(caps.Algorithms, tc.want) {
+			t.Errorf("%s: %+v, %v; want trailers accepted for %v", tc.name, caps, err, tc.want)
+		}
+	}
+}
+
+// noOptions serves h, except for OPTIONS, which it answers 405 as a server unaware of trailers
+// would, and records the last request it passed on
+func noOptions(h http.Handler, last *http.Request) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		*last = *r
+		h.ServeHTTP(w, r)
+	})
+} // noOptions() func
+
+func TestCapabilitiesNoTrailers(t *testing.T) {
+	var last http.Request
+	srv := httptest.NewServer(noOptions(NewHandler(ServerOptions{Logger: discardLogger()}), &last))
+	defer srv.Close()
+	caps, err := (&Client{Logger: discardLogger()}).Capabilities(t.Context(), srv.URL)
+	if err != nil || caps.AcceptsTrailers || caps.Algorithms != nil {
+		t.Errorf("%+v, %v; want no trailers accepted and no error", caps, err)
+	}
+}
+
+func TestNegotiatePicksShared(t *testing.T) {
+	url := startServer(t, ServerOptions{Algorithms: []string{"length", "crc32c"}})
+	for _, tc := range []struct {
+		name       string
+		algorithms []string
+		want       []string
+	}{
+		{"some shared", []string{"sha256", "crc32c"}, []string{"crc32c"}},
+		{"none shared", []string{"sha256"}, []string{"length", "crc32c"}},
+		{"default", nil, []string{"length"}},
+	} {
+		c := &Client{Algorithms: tc.algorithms, Negotiate: true, Logger: discardLogger()}
+		upload, err := c.negotiated(t.Context(), url)
+		if err != nil || !slices.Equal(upload.Algorithms, tc.want) || upload.Negotiate || upload.headerDigests {
+			t.Errorf("%s: %v, %v; want trailers %v", tc.name, upload.Algorithms, err, tc.want)
+		}
+		result, err := c.Send(t.Context(), url, []byte("negotiated"))
+		if err != nil || !result.Matched {
+			t.Errorf("%s: result %+v, %v; want the negotiated upload verified", tc.name, result, err)
+		}
+	}
+}
+
+func TestNegotiateFallsBackToHeaders(t *testing.T) {
+	var last http.Request
+	srv := httptest.NewServer(noOptions(NewHandler(ServerOptions{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}), &last))
+	defer srv.Close()
+	c := &Client{Algorithms: []string{"length", "sha256"}, Negotiate: true, Logger: discardLogger()}
+	body := []byte("checked from its headers")
+	result, err := c.Send(t.Context(), srv.URL, body)
+	if err != nil || !result.Matched || result.StatusCode != http.StatusOK {
+		t.Fatalf("result %+v, %v; want the upload verified from its headers", result, err)
+	}
+	want := ComputeTrailers(body, AlgoSHA256)
+	if last.ContentLength != int64(len(body)) || last.Trailer != nil || last.Header.Get("X-Body-SHA256") != want.Get("X-Body-SHA256") {
+		t.Errorf("request of length %d, trailer %v, header %v; want a Content-Length and the digests as headers", last.ContentLength, last.Trailer, last.Header)
+	}
+
+	for name, c := range map[string]*Client{
+		"signer":     {Negotiate: true, Signer: &Signer{}, Logger: discardLogger()},
+		"body token": {Negotiate: true, BodyToken: &TokenSigner{}, Logger: discardLogger()},
+		"metadata":   {Negotiate: true, Metadata: func() (any, error) { return map[string]int{"records": 1}, nil }, Logger: discardLogger()},
+	} {
+		if _, err := c.Send(t.Context(), srv.URL, body); !errors.Is(err, ErrTrailersUnsupported) {
+			t.Errorf("%s: error %v, want ErrTrailersUnsupported", name, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package trailerhttp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NonceTrailer carries a random nonce drawn for each upload, HMAC-SHA256'd with the shared key
+// over the nonce and the Content-Digest trailer, so a server that remembers the nonces it saw
+// can refuse an upload resubmitted by whoever observed it; see Client.Nonce. Its value is an
+// RFC 8941 dictionary, n the nonce and sig the MAC:
+//
+//	X-Body-Nonce: n=:q8Mx...Yw==:, sig=:Zm9v...Ig==:
+const NonceTrailer = "X-Body-Nonce"
+
+// DefaultNonceTTL is how long a nonce is remembered when ServerOptions.NonceTTL is 0
+const DefaultNonceTTL = 10 * time.Minute
+
+// nonceBytes is the length of the nonces Client.Nonce draws
+const nonceBytes = 16
+
+var (
+	// ErrBadNonce reports a body nonce whose MAC does not verify
+	ErrBadNonce = errors.New("body nonce does not verify")
+	// ErrReplayedNonce reports a body nonce seen before, an upload sent again
+	ErrReplayedNonce = errors.New("body nonce was already used")
+)
+
+// NonceStore remembers the nonces of the uploads a server accepted, for ServerOptions.Nonces
+// and WithNonces. Implementations must be safe for concurrent use; one shared by several
+// servers catches a replay sent to another server too.
+type NonceStore interface {
+	// Remember records nonce for ttl, and reports whether it was not already recorded
+	Remember(nonce string, ttl time.Duration) bool
+}
+
+// NonceForgetter is implemented by a NonceStore that can drop a nonce it recorded, so an
+// upload that verified but could not be stored (see ServerOptions.BodySink) can be sent again
+type NonceForgetter interface {
+	Forget(nonce string)
+}
+
+// MemoryNonceStore is a NonceStore in memory, safe for concurrent use; its zero value is
+// empty. Expired nonces are dropped at most once a minute as new ones are recorded.
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+func (s *MemoryNonceStore) Remember(nonce string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.expires == nil {
+		s.expires = make(map[string]time.Time)
+	}
+	if now.Sub(s.lastSweep) > time.Minute {
+		for n, expires := range s.expires {
+			if now.After(expires) {
+				delete(s.expires, n)
+			}
+		}
+		s.lastSweep = now
+	}
+	if expires, ok := s.expires[nonce]; ok && !now.After(expires) {
+		return false
+	}
+	s.expires[nonce] = now.Add(ttl)
+	return true
+} // Remember() func
+
+func (s *MemoryNonceStore) Forget(nonce string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expires, nonce)
+}
+
+// forgetNonce drops nonce from store, if it can
+func forgetNonce(store NonceStore, nonce string) {
+	if f, ok := store.(NonceForgetter); ok {
+		f.Forget(nonce)
+	}
+} // forgetNonce() func
+
+// nonceConfig is how a VerifiedBody checks the body nonce, under WithNonces
+type nonceConfig struct {
+	key   []byte
+	store NonceStore
+	ttl   time.Duration
+}
+
+// nonceMAC returns the MAC of the nonce n, in base64, binding contentDigest, the value of the
+// Content-Digest trailer
+func nonceMAC(key []byte, n, contentDigest string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(n + "\n" + contentDigest))
+	return mac.Sum(nil)
+} // nonceMAC() func
+
+// nonceValue draws a nonce and returns the NonceTrailer value carrying it
+func nonceValue(key []byte, contentDigest string) string {
+	b := make([]byte, nonceBytes)
+	rand.Read(b)
+	n := base64.StdEncoding.EncodeToString(b)
+	return fmt.Sprintf("n=:%s:, sig=:%s:", n, base64.StdEncoding.EncodeToString(nonceMAC(key, n, contentDigest)))
+} // nonceValue() func
+
+// VerifyNonce checks the X-Body-Nonce trailer of a request whose body has been read: its MAC
+// with key over the nonce and the Content-Digest trailer delivered. It returns the nonce, for
+// the caller to check it was not used before; it does not check the Content-Digest trailer
+// against the body, which the content-digest verifier of Handler and NewVerifiedBody does.
+func VerifyNonce(trailer http.Header, key []byte) (string, error) {
+	values, _ := lookupField(trailer, NonceTrailer)
+	if len(values) == 0 {
+		return "", missingTrailerError([]string{NonceTrailer})
+	}
+	if len(key) == 0 {
+		return "", fmt.Errorf("%s: %w", NonceTrailer, errNoHMACKey)
+	}
+	dict, err := ParseDictionary(CombineFieldValues(values))
+	if err != nil {
+		return "", fmt.Errorf("%w in %s: %w", ErrMalformedTrailer, NonceTrailer, err)
+	}
+	n, okN := dict.Bytes("n")
+	sig, okSig := dict.Bytes("sig")
+	if !okN || !okSig || len(n) == 0 {
+		return "", fmt.Errorf("%w in %s: it needs byte sequences n and sig", ErrMalformedTrailer, NonceTrailer)
+	}
+	nonce := base64.StdEncoding.EncodeToString(n)
+	digests, _ := lookupField(trailer, "Content-Digest")
+	if !hmac.Equal(sig, nonceMAC(key, nonce, CombineFieldValues(digests))) {
+		return "", ErrBadNonce
+	}
+	return nonce, nil
+} // VerifyNonce() func
+
+// checkNonce verifies the body nonce of a request as one more check, and records it in store
+// for ttl (0 means DefaultNonceTTL) if the rest of the body verified, so an upload that failed
+// can be sent again and a forged one cannot spend the nonce of the genuine upload. bound says
+// whether the Content-Digest trailer its MAC covers matched the body; without that the nonce
+// binds nothing, and fails.
+func checkNonce(trailer http.Header, key []byte, store NonceStore, ttl time.Duration, verified, bound bool) VerificationResult {
+	result := VerificationResult{Algorithm: "nonce", TrailerName: NonceTrailer}
+	if values, _ := lookupField(trailer, NonceTrailer); len(values) > 0 {
+		result.Reported = values[0]
+	}
+	nonce, err := VerifyNonce(trailer, key)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if !bound {
+		result.Err = fmt.Errorf("%w: the body does not verify against the Content-Digest it binds", ErrBadNonce)
+		return result
+	}
+	if ttl == 0 {
+		ttl = DefaultNonceTTL
+	}
+	if verified && !store.Remember(nonce, ttl) {
+		result.Err = ErrReplayedNonce
+		return result
+	}
+	result.Matched, result.Computed = true, nonce
+	return result
+} // checkNonce() func
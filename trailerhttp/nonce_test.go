@@ -0,0 +1,117 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// flakySink is a bufferSink whose next fail commits fail, discarding the body
+type flakySink struct {
+	bufferSink
+	fail int
+}
+
+func (s *flakySink) Commit() error {
+	if s.fail > 0 {
+		s.fail--
+		s.bufferSink.Discard()
+		return errors.New("disk full")
+	}
+	return s.bufferSink.Commit()
+}
+
+// nonceRequest returns a request sending body with its Content-Digest and the trailer of nonce
+func nonceRequest(body, nonce string) *http.Request {
+	sum := sha256.Sum256([]byte(body))
+	return chunkedRequest(body, http.Header{
+		"Content-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"},
+		NonceTrailer:     {nonce},
+	})
+}
+
+func TestHandlerNonce(t *testing.T) {
+	key := []byte("nonce key")
+	sum := sha256.Sum256([]byte("hello"))
+	nonce := nonceValue(key, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	sink := &flakySink{fail: 1}
+	h := NewHandler(ServerOptions{
+		Algorithms: []string{"content-digest"},
+		HMACKey:    key,
+		Nonces:     new(MemoryNonceStore),
+		BodySink:   sink,
+		Logger:     discardLogger(),
+	})
+	for _, tc := range []struct {
+		name   string
+		nonce  string
+		status int
+		stored string
+	}{
+		{"forged", "n=:AAAA:, sig=:AAAA:", http.StatusUnauthorized, ""},
+		{"sink commit fails", nonce, http.StatusInternalServerError, ""},
+		{"sent again", nonce, http.StatusOK, "hello"},
+		{"replayed", nonce, http.StatusConflict, "hello"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, nonceRequest("hello", tc.nonce))
+		if w.Code != tc.status || sink.String() != tc.stored {
+			t.Errorf("%s: status %d, sink holds %q; want %d and %q", tc.name, w.Code, sink.String(), tc.status, tc.stored)
+		}
+	}
+}
+
+func TestNonceBindsTheBody(t *testing.T) {
+	key := []byte("nonce key")
+	sum := sha256.Sum256([]byte("hello"))
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	nonce := nonceValue(key, digest)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("NewHandler accepted Nonces without a content-digest verifier")
+			}
+		}()
+		NewHandler(ServerOptions{Algorithms: []string{"length"}, HMACKey: key, Nonces: new(MemoryNonceStore)})
+	}()
+
+	// The same length, the nonce and digest of another body: the length check alone matches
+	h := NewHandler(ServerOptions{Algorithms: []string{"length", "content-digest"}, HMACKey: key, Nonces: new(MemoryNonceStore), Logger: discardLogger()})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, chunkedRequest("world", http.Header{"X-Body-Byte-Length": {"5"}, "Content-Digest": {digest}, NonceTrailer: {nonce}}))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Handler: status %d for another body under the nonce, want 401", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, nonceRequest("hello", nonce))
+	if w.Code != http.StatusOK {
+		t.Errorf("Handler: status %d for the genuine body, want 200: the forged one spent its nonce", w.Code)
+	}
+
+	// Integrity never checks a Content-Digest that was not announced
+	srv := httptest.NewServer(Integrity(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+		WithHMACKey(key), WithNonces(new(MemoryNonceStore), 0), RejectUnverified(1<<20)))
+	defer srv.Close()
+	for _, tc := range []struct {
+		name     string
+		announce string
+		body     string
+		status   int
+	}{
+		{"Content-Digest not announced", NonceTrailer, "hello", http.StatusUnauthorized},
+		{"another body", NonceTrailer + ", Content-Digest", "world", http.StatusUnauthorized},
+		{"genuine body", NonceTrailer + ", Content-Digest", "hello", http.StatusOK},
+	} {
+		req := &RawRequest{Header: []RawField{{"Trailer", tc.announce}}, Body: []byte(tc.body), Trailer: []RawField{{NonceTrailer, nonce}, {"Content-Digest", digest}}}
+		resp, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("Integrity, %s: %v", tc.name, err)
+		}
+		if resp.Response.StatusCode != tc.status {
+			t.Errorf("Integrity, %s: status %d, want %d", tc.name, resp.Response.StatusCode, tc.status)
+		}
+	}
+}
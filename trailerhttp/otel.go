@@ -0,0 +1,98 @@
+//go:build otel
+
+package trailerhttp
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans of this package
+const tracerName = "trailer_header/trailerhttp"
+
+// With the otel tag, Handler and Client record OpenTelemetry spans through the global
+// TracerProvider and propagate trace context with the global TextMapPropagator, both set
+// up by the application (otel.SetTracerProvider, otel.SetTextMapPropagator).
+func init() {
+	traceRequest = startServerSpan
+	traceUpload = startClientSpan
+	injectTrace = func(ctx context.Context, header http.Header) {
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	}
+}
+
+// startServerSpan starts the span of a request, continuing the trace context it carries
+func startServerSpan(r *http.Request) (TraceContext, func(*UploadResult)) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := otel.Tracer(tracerName).Start(ctx, "trailerhttp.Handler "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("network.protocol.name", "http"),
+			attribute.String("network.protocol.version", r.Proto),
+			attribute.String("url.path", r.URL.Path),
+		))
+	sc := span.SpanContext()
+	return TraceContext{TraceID: sc.TraceID(), SpanID: sc.SpanID(), Flags: byte(sc.TraceFlags())}, func(result *UploadResult) {
+		defer span.End()
+		span.SetAttributes(summaryAttributes(result)...)
+		if result.Error != "" {
+			span.SetStatus(codes.Error, result.Error)
+		}
+	}
+} // startServerSpan() func
+
+// startClientSpan starts the span of an upload by c
+func startClientSpan(ctx context.Context, c *Client, url string) (context.Context, func(*UploadResult, error)) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "trailerhttp.Client upload",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", http.MethodPost),
+			attribute.String("url.full", url),
+			attribute.StringSlice("trailer.algorithms", c.Algorithms),
+			attribute.Bool("trailer.gzip", c.Gzip),
+		))
+	return ctx, func(result *UploadResult, err error) {
+		defer span.End()
+		if result != nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", result.StatusCode))
+			span.SetAttributes(summaryAttributes(result)...)
+			if tc := result.ServerTrace; tc.IsValid() {
+				// The server named its span in a Traceresponse trailer: link it, it may be in a trace of its own
+				span.AddLink(trace.Link{SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID: tc.TraceID, SpanID: tc.SpanID, TraceFlags: trace.TraceFlags(tc.Flags), Remote: true,
+				})})
+			}
+		}
+		switch {
+		case err != nil:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		case result != nil && result.Error != "":
+			span.SetStatus(codes.Error, result.Error)
+		}
+	}
+} // startClientSpan() func
+
+// summaryAttributes describes the verification of a request, as the server summarized it
+func summaryAttributes(result *UploadResult) []attribute.KeyValue {
+	var algorithms []string
+	for _, check := range result.Checks {
+		algorithms = append(algorithms, check.Algorithm)
+	}
+	return []attribute.KeyValue{
+		attribute.String("trailer.request_id", result.RequestID),
+		attribute.StringSlice("trailer.announced", result.AnnouncedTrailers),
+		attribute.StringSlice("trailer.delivered", result.DeliveredTrailers),
+		attribute.StringSlice("trailer.checked_algorithms", algorithms),
+		attribute.Bool("trailer.matched", result.Matched),
+		attribute.String("trailer.outcome", result.Outcome),
+		attribute.Int64("http.request.body.size", result.BodyLength),
+	}
+} // summaryAttributes() func
@@ -0,0 +1,50 @@
+package trailerhttp
+
+import (
+	"fmt"
+)
+
+// VerificationPolicy decides how the Handler answers a request whose trailers did not verify
+type VerificationPolicy int
+
+const (
+	// PolicyWarn logs failed checks and still answers 200 OK, with "matched": false in the result.
+	// Announced trailers that never arrive are rejected with 400 even so: that is a protocol violation.
+	PolicyWarn VerificationPolicy = iota
+	// PolicyStrict rejects every request that did not verify, with ServerOptions.RejectStatus
+	// (422 Unprocessable Content by default): a failed or malformed check, a stripped trailer,
+	// or no integrity trailer at all. Announced trailers that never arrive still get 400.
+	PolicyStrict
+	// PolicyIgnore answers 200 OK whatever the checks found, missing trailers included.
+	// The checks still run and are reported; failures are logged only in verbose mode.
+	PolicyIgnore
+)
+
+// policyNames are the names accepted by UnmarshalText, indexed by policy
+var policyNames = []string{PolicyWarn: "warn", PolicyStrict: "strict", PolicyIgnore: "ignore"}
+
+func (p VerificationPolicy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("VerificationPolicy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// MarshalText returns the policy name, so a policy can be used with flag.TextVar or in JSON
+func (p VerificationPolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(policyNames) {
+		return nil, fmt.Errorf("unknown verification policy %d", int(p))
+	}
+	return []byte(policyNames[p]), nil
+} // MarshalText() func
+
+// UnmarshalText parses a policy name: "warn", "strict" or "ignore"
+func (p *VerificationPolicy) UnmarshalText(text []byte) error {
+	for policy, name := range policyNames {
+		if string(text) == name {
+			*p = VerificationPolicy(policy)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown verification policy %q (want warn, strict or ignore)", text)
+} // UnmarshalText() func
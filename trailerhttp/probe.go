@@ -0,0 +1,179 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Probe trailer fields: probeTokenTrailer travels with the request next to the integrity
+// trailers, probeEchoTrailer comes back as a response trailer from EchoHandler.
+const (
+	probeTokenTrailer = "X-Probe-Token"
+	probeEchoTrailer  = "X-Probe-Echo"
+)
+
+// probeBodySize is the size of the probe body: several chunks, small enough to send anywhere
+const probeBodySize = 64 << 10
+
+// echoMaxBodyBytes bounds the bodies EchoHandler reads, as it may face the internet
+const echoMaxBodyBytes = 16 << 20
+
+// ProbeEcho is what EchoHandler reports about a request as it arrived, after every proxy on the way
+type ProbeEcho struct {
+	Proto             string      `json:"proto"`
+	TransferEncoding  []string    `json:"transfer_encoding,omitempty"`
+	ContentLength     int64       `json:"content_length"`     // -1 when the request was streamed
+	CanCarryTrailers  bool        `json:"can_carry_trailers"` // chunked under HTTP/1.1, or HTTP/2 and later
+	BodyLength        int64       `json:"body_length"`
+	AnnouncedTrailers []string    `json:"announced_trailers"`
+	Trailer           http.Header `json:"trailer"`
+	Mirrored          []string    `json:"mirrored,omitempty"` // response trailers sent back, sorted
+}
+
+// EchoHandler is the cooperating endpoint for Probe, and a test endpoint for any client that
+// sends trailers: it reads the request body and answers with a JSON ProbeEcho of the trailers
+// that arrived. To a client that sent "TE: trailers" it mirrors every received trailer field
+// back as a response trailer of the same name, forbidden fields excepted, and echoes the
+// X-Probe-Token trailer in an X-Probe-Echo one, so the probe also learns whether response
+// trailers survive. NewServer mounts it at ServerOptions.EchoPath.
+func EchoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		echo := ProbeEcho{
+			Proto:             r.Proto,
+			TransferEncoding:  r.TransferEncoding,
+			ContentLength:     r.ContentLength,
+			CanCarryTrailers:  canCarryTrailers(r),
+			AnnouncedTrailers: AnnouncedTrailers(r),
+		}
+		n, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, echoMaxBodyBytes))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reading request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		echo.BodyLength, echo.Trailer = n, r.Trailer
+
+		var mirrored http.Header
+		if acceptsTrailers(r) {
+			mirrored = mirrorTrailers(r.Trailer)
+			if token, _ := lookupField(r.Trailer, probeTokenTrailer); len(token) > 0 {
+				mirrored.Set(probeEchoTrailer, token[0])
+			}
+		}
+		echo.Mirrored = slices.Sorted(maps.Keys(mirrored))
+		for _, name := range echo.Mirrored {
+			w.Header().Add("Trailer", name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(echo); err != nil {
+			return
+		}
+		for name, values := range mirrored {
+			w.Header()[name] = values
+		}
+	})
+} // EchoHandler() func
+
+// mirrorTrailers copies the received trailer fields that may be sent back in a response trailer section
+func mirrorTrailers(received http.Header) http.Header {
+	mirrored := http.Header{}
+	for name, values := range received {
+		if len(values) > 0 && !isForbiddenTrailer(name) {
+			mirrored[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+	return mirrored
+} // mirrorTrailers() func
+
+// ProbeField reports how one request trailer fared on the way to the echo endpoint
+type ProbeField struct {
+	Name     string `json:"name"`
+	Sent     string `json:"sent"`
+	Received string `json:"received,omitempty"`
+	Arrived  bool   `json:"arrived"`  // a value was delivered
+	Intact   bool   `json:"intact"`   // the value delivered is the value sent
+	Mirrored bool   `json:"mirrored"` // the endpoint mirrored it back, and the response trailer arrived with the value sent
+}
+
+// ProbeReport is the outcome of Probe
+type ProbeReport struct {
+	URL                 string       `json:"url"`
+	Proto               string       `json:"proto"`           // protocol of the response, as the client saw it
+	ArrivedProto        string       `json:"arrived_proto"`   // protocol of the request, as the echo endpoint saw it
+	ArrivedFraming      bool         `json:"arrived_framing"` // the request reached the endpoint in a framing that can carry trailers
+	BodyIntact          bool         `json:"body_intact"`     // the endpoint received as many bytes as were sent
+	Fields              []ProbeField `json:"fields"`
+	ResponseTrailerSent bool         `json:"response_trailer_sent"` // the endpoint announced its X-Probe-Echo response trailer
+	ResponseTrailerOK   bool         `json:"response_trailer_ok"`   // and it arrived with the token sent
+}
+
+// Survived reports whether every request trailer arrived intact and the response trailer made it back
+func (pr *ProbeReport) Survived() bool {
+	return pr.ResponseTrailerOK && !slices.ContainsFunc(pr.Fields, func(f ProbeField) bool { return !f.Intact })
+} // Survived() func
+
+// Probe sends a known streamed body with integrity trailers and a random X-Probe-Token trailer
+// to url, which must be served by EchoHandler, possibly behind load balancers, CDNs or proxies.
+// The report tells which trailer fields survived the path in each direction.
+// client may be nil for http.DefaultClient.
+func Probe(ctx context.Context, client *http.Client, url string) (*ProbeReport, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	token := make([]byte, 16)
+	rand.Read(token)
+	body := bytes.Repeat([]byte("trailer probe\n"), probeBodySize/len("trailer probe\n"))
+
+	req, err := NewRequest(ctx, http.MethodPost, url, bytes.NewReader(body), AlgoSHA256, AlgoContentDigest)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("TE", "trailers")
+	// The body is fixed, so the values sent are known before sending; the token has a fixed value too
+	sent := ComputeTrailers(body, AlgoSHA256, AlgoContentDigest)
+	sent.Set(probeTokenTrailer, hex.EncodeToString(token))
+	req.Trailer[probeTokenTrailer] = sent[probeTokenTrailer]
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected response %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	var echo ProbeEcho
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		return nil, fmt.Errorf("decoding probe echo (is %s served by EchoHandler?): %w", url, err)
+	}
+	io.Copy(io.Discard, resp.Body) // resp.Trailer is only populated at EOF
+
+	report := &ProbeReport{
+		URL:            url,
+		Proto:          resp.Proto,
+		ArrivedProto:   echo.Proto,
+		ArrivedFraming: echo.CanCarryTrailers,
+		BodyIntact:     echo.BodyLength == int64(len(body)),
+	}
+	for _, name := range slices.Sorted(maps.Keys(sent)) {
+		field := ProbeField{Name: name, Sent: sent.Get(name)}
+		if values, _ := lookupField(echo.Trailer, name); len(values) > 0 {
+			field.Received, field.Arrived = values[0], true
+			field.Intact = field.Received == field.Sent
+		}
+		field.Mirrored = field.Sent != "" && resp.Trailer.Get(name) == field.Sent
+		report.Fields = append(report.Fields, field)
+	}
+	_, report.ResponseTrailerSent = lookupField(resp.Trailer, probeEchoTrailer)
+	report.ResponseTrailerOK = resp.Trailer.Get(probeEchoTrailer) == sent.Get(probeTokenTrailer)
+	return report, nil
+} // Probe() func
@@ -0,0 +1,133 @@
+package trailerhttp
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Machine-readable codes of the problems a Handler reports with ServerOptions.ProblemDetails,
+// as Problem.Code and ProblemField.Code
+const (
+	ProblemLengthMismatch     = "length-mismatch"       // the body length differs from the length trailer
+	ProblemRecordMismatch     = "record-count-mismatch" // the records of the body differ from X-Record-Count
+	ProblemDigestMismatch     = "digest-mismatch"       // a hash or MAC of the body differs from its trailer
+	ProblemTransportCorrupt   = "transport-corruption"  // a gzip body changed in transit, per its digest of the bytes as sent
+	ProblemEncodingMismatch   = "encoding-mismatch"     // a gzip body arrived intact but decodes to different content
+	ProblemMalformedTrailer   = "malformed-trailer"     // a trailer value could not be parsed
+	ProblemBadSignature       = "bad-signature"         // the message signature does not verify
+	ProblemBadToken           = "bad-token"             // the body token does not verify, has expired or binds another body
+	ProblemBadTimestamp       = "bad-timestamp"         // the body timestamp does not verify or is off the server's clock
+	ProblemBadNonce           = "bad-nonce"             // the body nonce does not verify
+	ProblemReplayedNonce      = "replayed-nonce"        // the body nonce was used before: the upload is a replay
+	ProblemUnverifiable       = "unverifiable-trailer"  // the server cannot check the trailer, e.g. for lack of an HMAC key
+	ProblemMissingTrailer     = "missing-trailer"       // an announced trailer never arrived
+	ProblemNoIntegrityTrailer = "no-integrity-trailer"  // the request carries no trailer the server checks
+	ProblemTrailersStripped   = "trailers-not-carried"  // trailers were announced on a body that cannot carry them
+)
+
+// problemTypeBase prefixes the code of a problem to form its "type" URI
+const problemTypeBase = "urn:trailerhttp:problem:"
+
+// Problem is an RFC 9457 problem details object, the application/problem+json body of an
+// upload rejected for failing verification under ServerOptions.ProblemDetails. Code, RequestID,
+// Outcome and Fields are extension members.
+type Problem struct {
+	Type      string         `json:"type"` // problemTypeBase + Code
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Code      string         `json:"code"`
+	RequestID string         `json:"request_id,omitempty"`
+	Outcome   string         `json:"outcome,omitempty"`
+	Fields    []ProblemField `json:"fields,omitempty"` // the trailer fields that failed
+}
+
+// ProblemField describes one trailer field that failed verification
+type ProblemField struct {
+	Trailer   string `json:"trailer"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Code      string `json:"code"`
+	Expected  string `json:"expected,omitempty"` // the value the trailer carried
+	Actual    string `json:"actual,omitempty"`   // the value computed over the body received ("redacted" for keyed checks)
+	Detail    string `json:"detail,omitempty"`
+}
+
+// problemCode classifies the error of a failed check
+func problemCode(err error) string {
+	switch {
+	case errors.Is(err, ErrTransportCorruption):
+		return ProblemTransportCorrupt
+	case errors.Is(err, ErrEncodingMismatch):
+		return ProblemEncodingMismatch
+	case errors.Is(err, ErrLengthMismatch):
+		return ProblemLengthMismatch
+	case errors.Is(err, ErrRecordCountMismatch):
+		return ProblemRecordMismatch
+	case errors.Is(err, ErrHashMismatch):
+		return ProblemDigestMismatch
+	case errors.Is(err, ErrMalformedTrailer):
+		return ProblemMalformedTrailer
+	case errors.Is(err, ErrBadSignature):
+		return ProblemBadSignature
+	case errors.Is(err, ErrBadToken):
+		return ProblemBadToken
+	case errors.Is(err, ErrBadTimestamp):
+		return ProblemBadTimestamp
+	case errors.Is(err, ErrBadNonce):
+		return ProblemBadNonce
+	case errors.Is(err, ErrReplayedNonce):
+		return ProblemReplayedNonce
+	default:
+		return ProblemUnverifiable
+	}
+} // problemCode() func
+
+// newProblem describes the rejection of the upload summarized by s
+func newProblem(status int, s *UploadResult) *Problem {
+	p := &Problem{Title: http.StatusText(status), Status: status, Detail: s.Error, RequestID: s.RequestID, Outcome: s.Outcome}
+	for _, name := range s.MissingTrailers {
+		p.Fields = append(p.Fields, ProblemField{Trailer: name, Code: ProblemMissingTrailer, Detail: "announced but never sent"})
+	}
+	for _, check := range s.Checks {
+		if !check.Matched {
+			p.Fields = append(p.Fields, ProblemField{
+				Trailer:   check.TrailerName,
+				Algorithm: check.Algorithm,
+				Code:      problemCode(check.Err),
+				Expected:  check.Reported,
+				Actual:    check.Computed,
+				Detail:    check.Error,
+			})
+		}
+	}
+	switch {
+	case s.Inconclusive:
+		p.Code = ProblemTrailersStripped
+	case len(p.Fields) > 0:
+		p.Code = p.Fields[0].Code
+	default:
+		p.Code = ProblemNoIntegrityTrailer
+	}
+	p.Type = problemTypeBase + p.Code
+	return p
+} // newProblem() func
+
+// resultFromProblem fills a client-side UploadResult from a problem the server sent
+func resultFromProblem(result *UploadResult, p *Problem) {
+	result.Problem = p
+	result.RequestID, result.Outcome, result.Error = p.RequestID, p.Outcome, p.Detail
+	if result.Error == "" {
+		result.Error = p.Title
+	}
+	result.Inconclusive = p.Code == ProblemTrailersStripped
+	for _, field := range p.Fields {
+		if field.Code == ProblemMissingTrailer {
+			result.MissingTrailers = append(result.MissingTrailers, field.Trailer)
+			continue
+		}
+		result.Checks = append(result.Checks, CheckSummary{
+			VerificationResult: VerificationResult{Algorithm: field.Algorithm, TrailerName: field.Trailer, Computed: field.Actual, Reported: field.Expected},
+			Error:              field.Detail,
+		})
+	}
+} // resultFromProblem() func
@@ -0,0 +1,64 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerRejectStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		rejectStatus int
+		want         int
+	}{
+		{"default", 0, http.StatusUnprocessableEntity},
+		{"custom", http.StatusBadRequest, http.StatusBadRequest},
+	} {
+		h := NewHandler(ServerOptions{Policy: PolicyStrict, RejectStatus: tc.rejectStatus, Logger: discardLogger()})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, chunkedRequest("hello", http.Header{"X-Body-Byte-Length": {"6"}}))
+		var result UploadResult
+		if w.Code != tc.want || w.Header().Get("Content-Type") != "application/json" || json.Unmarshal(w.Body.Bytes(), &result) != nil || result.Matched {
+			t.Errorf("%s: status %d, Content-Type %q, body %s; want %d with the unmatched UploadResult", tc.name, w.Code, w.Header().Get("Content-Type"), w.Body, tc.want)
+		}
+	}
+}
+
+func TestHandlerProblemDetails(t *testing.T) {
+	h := NewHandler(ServerOptions{Policy: PolicyStrict, RejectStatus: http.StatusConflict, ProblemDetails: true, Logger: discardLogger()})
+	for _, tc := range []struct {
+		name    string
+		trailer http.Header
+		code    string
+		fields  []ProblemField
+	}{
+		{"length mismatch", http.Header{"X-Body-Byte-Length": {"6"}}, ProblemLengthMismatch,
+			[]ProblemField{{Trailer: "X-Body-Byte-Length", Algorithm: "length", Code: ProblemLengthMismatch, Expected: "6", Actual: "5"}}},
+		{"no trailers", nil, ProblemNoIntegrityTrailer, nil},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, chunkedRequest("hello", tc.trailer))
+		var p Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: %v in %s", tc.name, err, w.Body)
+		}
+		if w.Code != http.StatusConflict || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: status %d, Content-Type %q; want 409 application/problem+json", tc.name, w.Code, w.Header().Get("Content-Type"))
+		}
+		if p.Type != problemTypeBase+tc.code || p.Code != tc.code || p.Status != http.StatusConflict || p.Title != "Conflict" || p.Detail == "" || p.RequestID == "" {
+			t.Errorf("%s: problem %+v, want code %s, status 409, a detail and the request ID", tc.name, p, tc.code)
+		}
+		if len(p.Fields) != len(tc.fields) {
+			t.Fatalf("%s: fields %+v, want %+v", tc.name, p.Fields, tc.fields)
+		}
+		for i, want := range tc.fields {
+			got := p.Fields[i]
+			got.Detail = ""
+			if got != want {
+				t.Errorf("%s: field %+v, want %+v", tc.name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//go:build prometheus
+
+package trailerhttp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promMetrics are the Prometheus counterparts of the expvar counters, with labels
+type promMetrics struct {
+	requests     *prometheus.CounterVec
+	withTrailers prometheus.Counter
+	checks       *prometheus.CounterVec
+	bodyBytes    prometheus.Counter
+	trailerWait  prometheus.Histogram
+}
+
+// trailerPromMetrics holds the metrics of every Handler in the process, as the expvar counters do
+var trailerPromMetrics = &promMetrics{
+	requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "trailer_requests_total",
+		Help: "Requests handled, by outcome class (no-trailer, trailer-verified-ok, trailer-failed, trailer-announced-missing).",
+	}, []string{"outcome"}),
+	withTrailers: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "trailer_requests_with_trailers_total",
+		Help: "Requests that delivered at least one trailer field.",
+	}),
+	checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "trailer_checks_total",
+		Help: "Trailer checks, by algorithm, trailer field and result (match, mismatch or error).",
+	}, []string{"algorithm", "trailer", "result"}),
+	bodyBytes: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "trailer_body_bytes_total",
+		Help: "Request body bytes streamed through the handlers.",
+	}),
+	trailerWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "trailer_wait_seconds",
+		Help:    "Time from the last body bytes of a request to the end of its trailer section.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10), // 100µs to ~26s
+	}),
+}
+
+// With the prometheus tag, RegisterHandlers serves the metrics at ServerOptions.PrometheusPath
+func init() {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(trailerPromMetrics)
+	prometheusHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	observeResult = trailerPromMetrics.observe
+}
+
+// PrometheusCollector returns the collector of the trailer verification metrics, for registering
+// with an application's own registry (only with the prometheus build tag)
+func PrometheusCollector() prometheus.Collector {
+	return trailerPromMetrics
+} // PrometheusCollector() func
+
+// PrometheusHandler serves the trailer verification metrics alone, as RegisterHandlers mounts them
+func PrometheusHandler() http.Handler {
+	return prometheusHandler
+} // PrometheusHandler() func
+
+func (m *promMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.withTrailers.Describe(ch)
+	m.checks.Describe(ch)
+	m.bodyBytes.Describe(ch)
+	m.trailerWait.Describe(ch)
+}
+
+func (m *promMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.withTrailers.Collect(ch)
+	m.checks.Collect(ch)
+	m.bodyBytes.Collect(ch)
+	m.trailerWait.Collect(ch)
+}
+
+// observe records a handled request
+func (m *promMetrics) observe(result *UploadResult) {
+	m.requests.WithLabelValues(classifyOutcome(result)).Inc()
+	if len(result.DeliveredTrailers) > 0 {
+		m.withTrailers.Inc()
+	}
+	for _, check := range result.Checks {
+		outcome := "error"
+		switch {
+		case check.Matched:
+			outcome = "match"
+		case errors.Is(check.Err, ErrLengthMismatch), errors.Is(check.Err, ErrHashMismatch):
+			outcome = "mismatch"
+		}
+		m.checks.WithLabelValues(check.Algorithm, check.TrailerName, outcome).Inc()
+	}
+	m.bodyBytes.Add(float64(result.BodyLength))
+	if result.TrailerWait > 0 {
+		m.trailerWait.Observe(result.TrailerWait.Seconds())
+	}
+} // observe() func
@@ -0,0 +1,241 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// ProxyOptions configures a Proxy
+type ProxyOptions struct {
+	Upstream  *url.URL          // where requests are forwarded; its path is prefixed to the request path
+	Transport http.RoundTripper // sends the upstream requests; nil means http.DefaultTransport
+
+	// UpstreamProtocol fixes the HTTP version spoken upstream, whatever version the client
+	// used, to bridge HTTP/1.1 clients to an HTTP/2 upstream and the reverse. It needs a nil
+	// Transport; the zero value negotiates as http.DefaultTransport does.
+	UpstreamProtocol UpstreamProtocol
+
+	// HMACKey is the shared secret for checking an incoming X-Body-HMAC trailer
+	HMACKey []byte
+
+	// Algorithms are trailers the proxy computes over the forwarded body and sends upstream,
+	// replacing received fields of the same name, e.g. AlgoHMACSHA256 with the upstream's key.
+	// Received trailers are forwarded unchanged otherwise.
+	Algorithms []TrailerAlgo
+
+	// StripForbiddenTrailers drops announced fields that RFC 9110 does not allow in a trailer
+	// section instead of rejecting the request with 400 Bad Request; see ServerOptions
+	StripForbiddenTrailers bool
+
+	// VerifyResponses makes the proxy check the integrity trailers of upstream responses as
+	// it streams them back, asking the upstream for trailers with "TE: trailers" whether the
+	// client did or not. A response that fails a check, or whose announced trailers never
+	// arrive, is cut off before its end, so the client sees a broken response and not one
+	// that looks complete. HMACKey checks the keyed trailers; responses announcing none pass
+	// through unchecked.
+	VerifyResponses bool
+
+	Logger *slog.Logger // receives the proxy's output; nil means slog.Default()
+}
+
+// UpstreamProtocol is the HTTP version a Proxy speaks upstream
+type UpstreamProtocol string
+
+const (
+	UpstreamNegotiated UpstreamProtocol = ""      // HTTP/2 if TLS negotiates it, HTTP/1.1 otherwise
+	UpstreamHTTP1      UpstreamProtocol = "http1" // HTTP/1.1, with chunked bodies to carry trailers
+	UpstreamHTTP2      UpstreamProtocol = "http2" // HTTP/2, over TLS or, for an http:// upstream, cleartext with prior knowledge
+)
+
+// transport returns the RoundTripper speaking protocol upstream
+func (protocol UpstreamProtocol) transport() http.RoundTripper {
+	protocols := new(http.Protocols)
+	switch protocol {
+	case UpstreamNegotiated:
+		return nil
+	case UpstreamHTTP1:
+		protocols.SetHTTP1(true)
+	case UpstreamHTTP2:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		panic(fmt.Sprintf("trailerhttp: unknown upstream protocol %q", string(protocol)))
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = protocols
+	return transport
+} // transport() func
+
+// Proxy is a reverse proxy that keeps request trailers. httputil.ReverseProxy clones the
+// request before its trailers have arrived, so the upstream request goes out with the
+// announced trailer fields but no values. Proxy instead streams the body upstream and fills
+// in the trailers once the last body byte has been read. The announced integrity trailers are
+// verified on the way: a body that fails a check is aborted before upstream sees its end, and
+// the client gets 400 Bad Request. Response trailers are announced to the client and passed
+// back once the response body has been copied, and with VerifyResponses checked on the way too.
+//
+// Trailers survive a change of HTTP version at the proxy. An HTTP/2 request may carry both a
+// Content-Length and trailers, which HTTP/1.1 can only send chunked, so a request with
+// trailers always goes upstream without a length; likewise a response with trailers loses its
+// Content-Length so an HTTP/1.1 client gets it chunked. An HTTP/2 peer may send trailers it did
+// not announce in a Trailer header: net/http drops those from requests, and passes them on in
+// responses only when the response is chunked or the client speaks HTTP/2.
+type Proxy struct {
+	opts   ProxyOptions
+	rp     *httputil.ReverseProxy
+	logger *slog.Logger
+}
+
+// NewProxy returns a Proxy forwarding to opts.Upstream. It panics if opts sets both
+// Transport and UpstreamProtocol.
+func NewProxy(opts ProxyOptions) *Proxy {
+	if opts.Transport != nil && opts.UpstreamProtocol != UpstreamNegotiated {
+		panic("trailerhttp: ProxyOptions sets both Transport and UpstreamProtocol")
+	}
+	transport := opts.Transport
+	if transport == nil {
+		transport = opts.UpstreamProtocol.transport()
+	}
+	p := &Proxy{opts: opts, logger: orDefaultLogger(opts.Logger).With("component", "proxy")}
+	p.rp = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		Transport:      transport,
+		ModifyResponse: p.modifyResponse,
+		ErrorLog:       slog.NewLogLogger(p.logger.Handler(), slog.LevelError),
+		ErrorHandler:   p.handleError,
+	}
+	return p
+} // NewProxy() func
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.opts.StripForbiddenTrailers {
+		if stripped := stripForbiddenTrailers(r.Trailer); len(stripped) > 0 {
+			p.logger.Warn("Not forwarding trailers not allowed in a trailer section", "trailers", stripped)
+		}
+	} else if err := validateTrailerNames(AnnouncedTrailers(r)); err != nil {
+		p.logger.Warn("Rejected request announcing an illegal trailer", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		pb := &proxyBody{verified: NewVerifiedBody(r, p.opts.HMACKey), in: &r.Trailer}
+		if len(p.opts.Algorithms) > 0 {
+			pb.computed = newTrailerSet(p.opts.Algorithms)
+		}
+		r.Body = pb
+	}
+	p.rp.ServeHTTP(w, r)
+} // ServeHTTP() func
+
+// rewrite points the outgoing request at the upstream and declares the trailers it will carry
+func (p *Proxy) rewrite(pr *httputil.ProxyRequest) {
+	pr.SetURL(p.opts.Upstream)
+	pr.SetXForwarded()
+	if p.opts.VerifyResponses {
+		pr.Out.Header.Set("TE", "trailers")
+	}
+	pb, ok := pr.Out.Body.(*proxyBody)
+	if !ok {
+		return
+	}
+	if pr.Out.Trailer == nil {
+		pr.Out.Trailer = http.Header{}
+	}
+	if pb.computed != nil {
+		for _, name := range pb.computed.names() {
+			pr.Out.Trailer[http.CanonicalHeaderKey(name)] = nil
+		}
+	}
+	pb.out = pr.Out.Trailer
+	if len(pb.out) > 0 {
+		pr.Out.ContentLength = -1 // sent chunked over HTTP/1.1, the only way it carries trailers
+		forceChunked(pr.Out)
+	}
+} // rewrite() func
+
+// modifyResponse prepares an upstream response for the client: sent chunked if it announces
+// trailers, and read through a VerifiedBody under VerifyResponses
+func (p *Proxy) modifyResponse(res *http.Response) error {
+	chunkTrailerResponse(res)
+	if p.opts.VerifyResponses && len(res.Trailer) > 0 {
+		res.Body = &proxyResponseBody{verified: NewVerifiedResponse(res, p.opts.HMACKey), req: res.Request, logger: p.logger}
+	}
+	return nil
+} // modifyResponse() func
+
+// chunkTrailerResponse drops the Content-Length of an upstream response announcing trailers, as
+// HTTP/2 allows, so that the proxy sends it chunked, with its trailers, to an HTTP/1.1 client
+func chunkTrailerResponse(res *http.Response) {
+	if len(res.Trailer) > 0 {
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+	}
+} // chunkTrailerResponse() func
+
+// handleError answers 400 for a body that failed verification and 502 for upstream failures
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var failed *VerificationError
+	if errors.As(err, &failed) {
+		p.logger.Warn("Aborted upstream request", "request_id", r.Header.Get(requestIDHeader), "method", r.Method, "path", r.URL.Path, "err", failed)
+		http.Error(w, failed.Error(), http.StatusBadRequest)
+		return
+	}
+	p.logger.Error("Upstream request failed", "request_id", r.Header.Get(requestIDHeader), "method", r.Method, "path", r.URL.Path, "err", err)
+	w.WriteHeader(http.StatusBadGateway)
+} // handleError() func
+
+// proxyBody forwards a verified request body and, at its end, copies the received trailers
+// and the recomputed ones into the upstream request before the transport reads them.
+type proxyBody struct {
+	verified *VerifiedBody
+	in       *http.Header // trailers of the incoming request, filled in at EOF
+	out      http.Header  // trailers of the upstream request
+	computed *trailerSet  // nil unless ProxyOptions.Algorithms is set
+}
+
+func (pb *proxyBody) Read(p []byte) (int, error) {
+	n, err := pb.verified.Read(p)
+	if pb.computed != nil {
+		pb.computed.Write(p[:n])
+	}
+	if err == io.EOF {
+		for name := range pb.out {
+			if values, _ := lookupField(*pb.in, name); len(values) > 0 {
+				pb.out[name] = values
+			}
+		}
+		if pb.computed != nil {
+			pb.computed.setValues(pb.out)
+		}
+	}
+	return n, err
+}
+
+func (pb *proxyBody) Close() error {
+	return pb.verified.body.Close()
+}
+
+// proxyResponseBody reads an upstream response through its verification, logging a failure;
+// ReverseProxy then aborts the response to the client, as for any error reading the body
+type proxyResponseBody struct {
+	verified *VerifiedBody
+	req      *http.Request // the upstream request
+	logger   *slog.Logger
+}
+
+func (rb *proxyResponseBody) Read(p []byte) (int, error) {
+	n, err := rb.verified.Read(p)
+	if failed := (*VerificationError)(nil); errors.As(err, &failed) {
+		rb.logger.Warn("Cut off upstream response failing verification", "request_id", rb.req.Header.Get(requestIDHeader), "method", rb.req.Method, "path", rb.req.URL.Path, "err", failed)
+	}
+	return n, err
+}
+
+func (rb *proxyResponseBody) Close() error {
+	return rb.verified.body.Close()
+}
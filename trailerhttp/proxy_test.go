@@ -0,0 +1,218 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// upstreamRequest is what an upstream behind a Proxy received
+type upstreamRequest struct {
+	proto   string
+	chunked bool
+	n       int
+	err     error // reading the body
+	trailer http.Header
+}
+
+// newUpstream starts a server, for a Proxy to forward to, that reads each request body to
+// the end and passes what it received on the returned channel
+func newUpstream(t *testing.T) (*httptest.Server, <-chan upstreamRequest) {
+	got := make(chan upstreamRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		got <- upstreamRequest{proto: r.Proto, chunked: len(r.TransferEncoding) > 0, n: int(n), err: err, trailer: r.Trailer.Clone()}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+} // newUpstream() func
+
+// startProxy starts a Proxy for opts in front of upstream and returns its base URL
+func startProxy(t *testing.T, upstream string, opts ProxyOptions) string {
+	opts.Upstream, _ = url.Parse(upstream)
+	opts.Logger = discardLogger()
+	srv := httptest.NewServer(NewProxy(opts))
+	t.Cleanup(srv.Close)
+	return srv.URL
+} // startProxy() func
+
+// received waits for the request the upstream received
+func received(t *testing.T, got <-chan upstreamRequest) upstreamRequest {
+	t.Helper()
+	select {
+	case req := <-got:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream received no request")
+		return upstreamRequest{}
+	}
+} // received() func
+
+// postWithTrailer posts body to url chunked, with trailer set up front
+func postWithTrailer(t *testing.T, url string, body []byte, trailer http.Header) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodPost, url, io.MultiReader(bytes.NewReader(body)))
+	req.Trailer = trailer
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+} // postWithTrailer() func
+
+func TestProxyForwardsTrailers(t *testing.T) {
+	upstream, got := newUpstream(t)
+	proxy := startProxy(t, upstream.URL, ProxyOptions{})
+	body := bytes.Repeat([]byte("through the proxy "), 5000)
+	req, _ := NewRequest(t.Context(), http.MethodPut, proxy, bytes.NewReader(body), AlgoSHA256)
+	req.Trailer["X-Note"] = []string{"passed on"}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	up := received(t, got)
+	want := ComputeTrailers(body, AlgoSHA256)
+	if resp.StatusCode != http.StatusOK || up.err != nil || up.n != len(body) || !up.chunked {
+		t.Fatalf("status %d; upstream read %d bytes chunked %v, error %v; want the whole body forwarded chunked", resp.StatusCode, up.n, up.chunked, up.err)
+	}
+	for _, name := range []string{"X-Body-Byte-Length", "X-Body-Sha256"} {
+		if up.trailer.Get(name) != want.Get(name) {
+			t.Errorf("upstream %s %q, want %q", name, up.trailer.Get(name), want.Get(name))
+		}
+	}
+	if up.trailer.Get("X-Note") != "passed on" {
+		t.Errorf("upstream trailer %v, want the custom X-Note forwarded", up.trailer)
+	}
+}
+
+func TestProxyRecomputesTrailers(t *testing.T) {
+	clientKey, upstreamKey := []byte("client secret"), []byte("upstream secret")
+	upstream, got := newUpstream(t)
+	proxy := startProxy(t, upstream.URL, ProxyOptions{HMACKey: clientKey, Algorithms: []TrailerAlgo{AlgoHMACSHA256(upstreamKey)}})
+	body := []byte("re-keyed at the proxy")
+	resp := postWithTrailer(t, proxy, body, ComputeTrailers(body, AlgoHMACSHA256(clientKey)))
+	up := received(t, got)
+	if want := ComputeTrailers(body, AlgoHMACSHA256(upstreamKey)).Get("X-Body-HMAC"); resp.StatusCode != http.StatusOK || up.trailer.Get("X-Body-HMAC") != want {
+		t.Errorf("status %d, upstream X-Body-HMAC %q; want the client's HMAC checked and replaced by %q", resp.StatusCode, up.trailer.Get("X-Body-HMAC"), want)
+	}
+	if up.trailer.Get("X-Body-Byte-Length") != "21" {
+		t.Errorf("upstream trailer %v, want the received length trailer kept", up.trailer)
+	}
+}
+
+func TestProxyRejectsFailedCheck(t *testing.T) {
+	upstream, got := newUpstream(t)
+	proxy := startProxy(t, upstream.URL, ProxyOptions{})
+	body := make([]byte, 1<<20)
+	resp := postWithTrailer(t, proxy, body, http.Header{"X-Body-Byte-Length": {"5"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d, want 400 for a body failing its length trailer", resp.StatusCode)
+	}
+	if up := received(t, got); up.err == nil || up.trailer.Get("X-Body-Byte-Length") != "" {
+		t.Errorf("upstream read %d bytes, error %v, trailer %v; want the body cut off before its end", up.n, up.err, up.trailer)
+	}
+}
+
+func TestProxyStripForbiddenTrailers(t *testing.T) {
+	body := []byte("announcing a forbidden trailer")
+	trailer := func() http.Header { return http.Header{"Authorization": {"Bearer secret"}, "X-Note": {"kept"}} }
+
+	upstream, got := newUpstream(t)
+	if resp := postWithTrailer(t, startProxy(t, upstream.URL, ProxyOptions{}), body, trailer()); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d, want 400 without StripForbiddenTrailers", resp.StatusCode)
+	}
+	select {
+	case up := <-got:
+		t.Errorf("upstream received %+v, want the rejected request not forwarded", up)
+	default:
+	}
+
+	resp := postWithTrailer(t, startProxy(t, upstream.URL, ProxyOptions{StripForbiddenTrailers: true}), body, trailer())
+	up := received(t, got)
+	if resp.StatusCode != http.StatusOK || up.trailer.Get("Authorization") != "" || up.trailer.Get("X-Note") != "kept" {
+		t.Errorf("status %d, upstream trailer %v; want Authorization dropped and X-Note forwarded", resp.StatusCode, up.trailer)
+	}
+}
+
+// serveH2C starts a server for h speaking HTTP/1.1 and h2c with prior knowledge
+func serveH2C(t *testing.T, h http.Handler) *httptest.Server {
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+} // serveH2C() func
+
+// bridgedUpstream records the requests it receives and answers "hello" with an X-Upstream
+// trailer, along with a Content-Length over HTTP/2, which HTTP/1.1 cannot send with trailers
+func bridgedUpstream() (http.Handler, <-chan upstreamRequest) {
+	got := make(chan upstreamRequest, 1)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		got <- upstreamRequest{proto: r.Proto, chunked: len(r.TransferEncoding) > 0, n: int(n), err: err, trailer: r.Trailer.Clone()}
+		w.Header().Set("Trailer", "X-Upstream")
+		if r.ProtoMajor == 2 {
+			w.Header().Set("Content-Length", "5")
+		}
+		io.WriteString(w, "hello")
+		w.Header().Set("X-Upstream", "done")
+	}), got
+} // bridgedUpstream() func
+
+// checkBridged checks that the request and response trailers crossed the proxy, between
+// a client speaking clientProto and an upstream speaking upstreamProto
+func checkBridged(t *testing.T, resp *http.Response, up upstreamRequest, clientProto, upstreamProto string) {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello" || resp.Proto != clientProto || resp.Trailer.Get("X-Upstream") != "done" {
+		t.Errorf("client got %q, %v over %s, trailer %v; want the response and its trailer over %s", body, err, resp.Proto, resp.Trailer, clientProto)
+	}
+	if up.proto != upstreamProto || up.err != nil || up.trailer.Get("X-Note") != "bridged" {
+		t.Errorf("upstream got %s, error %v, trailer %v; want the request trailer over %s", up.proto, up.err, up.trailer, upstreamProto)
+	}
+} // checkBridged() func
+
+func TestProxyHTTP1ToHTTP2(t *testing.T) {
+	h, got := bridgedUpstream()
+	upstream := serveH2C(t, h)
+	proxy := startProxy(t, upstream.URL, ProxyOptions{UpstreamProtocol: UpstreamHTTP2})
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodPost, proxy, io.MultiReader(bytes.NewReader([]byte("from HTTP/1.1"))))
+	req.Trailer = http.Header{"X-Note": {"bridged"}}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("Content-Length %d, want the upstream's length dropped so the response goes chunked", resp.ContentLength)
+	}
+	checkBridged(t, resp, received(t, got), "HTTP/1.1", "HTTP/2.0")
+}
+
+func TestProxyHTTP2ToHTTP1(t *testing.T) {
+	h, got := bridgedUpstream()
+	upstream := httptest.NewServer(h)
+	t.Cleanup(upstream.Close)
+	target, _ := url.Parse(upstream.URL)
+	proxy := serveH2C(t, NewProxy(ProxyOptions{Upstream: target, UpstreamProtocol: UpstreamHTTP1, Logger: discardLogger()}))
+	body := []byte("from HTTP/2, with a length")
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodPost, proxy.URL, bytes.NewReader(body))
+	req.Trailer = http.Header{"X-Note": {"bridged"}}
+	resp, err := NewH2CClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := received(t, got)
+	if !up.chunked || up.n != len(body) {
+		t.Errorf("upstream read %d bytes, chunked %v; want the body of known length sent chunked to carry its trailer", up.n, up.chunked)
+	}
+	checkBridged(t, resp, up, "HTTP/2.0", "HTTP/1.1")
+}
@@ -0,0 +1,217 @@
+package trailerhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wantReprDigest is the Want-Repr-Digest header of ResumeDownload (RFC 9530, Section 4): with a
+// range request, it asks a FileServer for a Repr-Digest trailer over the whole file
+const wantReprDigest = "sha-256=5, sha-512=3"
+
+var (
+	// errRangeUnsatisfiable reports a byte range starting past the end of the file
+	errRangeUnsatisfiable = errors.New("range not satisfiable")
+	// errRangeIgnored reports a Range header served as if it were absent: not a single byte
+	// range, which RFC 9110 lets a server ignore
+	errRangeIgnored = errors.New("not a single byte range")
+	// errDownloadComplete reports a ResumeDownload of a file that has all the bytes already
+	errDownloadComplete = errors.New("download already complete")
+)
+
+// parseByteRange resolves a Range header of a single byte range, such as "bytes=0-99",
+// "bytes=100-" or "bytes=-100", against a file of size bytes
+func parseByteRange(header string, size int64) (start, length int64, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errRangeIgnored
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeIgnored
+	}
+	if first == "" { // the last bytes of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		switch {
+		case err != nil || n < 0:
+			return 0, 0, errRangeIgnored
+		case n == 0 || size == 0:
+			return 0, 0, errRangeUnsatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeIgnored
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, errRangeIgnored
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, errRangeUnsatisfiable
+	}
+	return start, end - start + 1, nil
+} // parseByteRange() func
+
+// parseContentRange parses the Content-Range header of a 206 response, "bytes first-last/size",
+// or of a 416 one, "bytes */size"; size is -1 when the server gives it as "*"
+func parseContentRange(header string) (first, last, size int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	rng, total, ok2 := strings.Cut(spec, "/")
+	if !ok || !ok2 {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+		}
+	}
+	if rng == "*" {
+		return -1, -1, size, nil
+	}
+	a, b, ok := strings.Cut(rng, "-")
+	first, err1 := strconv.ParseInt(a, 10, 64)
+	last, err2 := strconv.ParseInt(b, 10, 64)
+	if !ok || err1 != nil || err2 != nil || last < first {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	return first, last, size, nil
+} // parseContentRange() func
+
+// ifRangeMatches reports whether r's Range applies to a file last modified at modified: a
+// request without If-Range, or whose If-Range is that date; an entity tag never matches, as
+// a FileServer sends none
+func ifRangeMatches(r *http.Request, modified time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && !modified.IsZero() && modified.Truncate(time.Second).Equal(date)
+} // ifRangeMatches() func
+
+// DownloadRange fetches length bytes of url from offset, or all bytes from offset when length
+// is negative, into dst with a Range request, and verifies the response trailers against
+// them: the Content-Digest and length trailers of a FileServer's 206 response cover exactly
+// the bytes of the range. A server answering with anything but that range is an error,
+// as with Download, and so are a negative offset and a length of 0, which no Range can ask for.
+func (c *Client) DownloadRange(ctx context.Context, url string, dst io.Writer, offset, length int64) ([]VerificationResult, error) {
+	if offset < 0 || length == 0 {
+		return nil, fmt.Errorf("invalid range of %d bytes at offset %d", length, offset)
+	}
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+	return c.fetch(ctx, url, http.Header{"Range": {rng}}, func(resp *http.Response) (io.Writer, error) {
+		if resp.StatusCode != http.StatusPartialContent {
+			return nil, fmt.Errorf("unexpected status %s for range %s", resp.Status, rng)
+		}
+		first, last, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		if first != offset || (length >= 0 && last != offset+length-1) {
+			return nil, fmt.Errorf("asked for %s, got Content-Range %s", rng, resp.Header.Get("Content-Range"))
+		}
+		return dst, nil
+	})
+} // DownloadRange() func
+
+// ResumeDownload continues an interrupted download of url into the file at path, creating it
+// if need be: it asks for the bytes past the end of the file and appends them, verifying the
+// trailers of the range as DownloadRange does. It also asks for a Repr-Digest trailer over the
+// whole file (Want-Repr-Digest), which a FileServer computes for a range request; when one
+// arrives, the bytes already in the file are hashed along with the new ones and checked too,
+// in a result of its own, so a resumed file is verified end to end.
+//
+// A server ignoring the range sends the whole file, which replaces the partial one. When a
+// check fails, the file is cut back to the bytes before the range, or emptied if Repr-Digest
+// failed, since the damage may be anywhere, so another ResumeDownload can pick up from there.
+// A transfer cut off mid-range keeps the bytes it received for the next one. A file that is
+// already complete gets a 416 answer and no results.
+func (c *Client) ResumeDownload(ctx context.Context, url, path string) ([]VerificationResult, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return c.Download(ctx, url, f)
+	}
+
+	repr, _ := lookupVerifier("repr-digest")
+	var reprDigest bodyDigest // hashes the file from its start, when a Repr-Digest trailer comes
+	var trailer *http.Header
+	extra := http.Header{"Range": {fmt.Sprintf("bytes=%d-", size)}, "Want-Repr-Digest": {wantReprDigest}}
+	results, err := c.fetch(ctx, url, extra, func(resp *http.Response) (io.Writer, error) {
+		switch resp.StatusCode {
+		case http.StatusOK: // the range was ignored: start over
+			size = 0
+			if err := f.Truncate(0); err != nil {
+				return nil, err
+			}
+			_, err := f.Seek(0, io.SeekStart)
+			return f, err
+		case http.StatusRequestedRangeNotSatisfiable:
+			if _, _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == size {
+				return nil, errDownloadComplete
+			}
+			return nil, fmt.Errorf("%s is %d bytes, more than the server has (%s)", path, size, resp.Header.Get("Content-Range"))
+		case http.StatusPartialContent:
+		default:
+			return nil, fmt.Errorf("unexpected status %s resuming at byte %d", resp.Status, size)
+		}
+		if first, _, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || first != size {
+			return nil, errors.Join(err, fmt.Errorf("resumed at byte %d, got Content-Range %s", size, resp.Header.Get("Content-Range")))
+		}
+		if _, announced := lookupField(resp.Trailer, repr.TrailerName); !announced {
+			return f, nil
+		}
+		// The field covers the whole file, not the range: removed from the trailers the
+		// response is verified against, it is checked here over the file and the range
+		delete(resp.Trailer, http.CanonicalHeaderKey(repr.TrailerName))
+		trailer, reprDigest = &resp.Trailer, repr.NewDigest(nil)
+		if _, err := io.Copy(reprDigest, io.NewSectionReader(f, 0, size)); err != nil {
+			return nil, err
+		}
+		return io.MultiWriter(f, reprDigest), nil
+	})
+	if errors.Is(err, errDownloadComplete) {
+		return nil, nil
+	}
+	cut := size // the length of the file to go back to after a failed check
+	if err == nil && reprDigest != nil {
+		reported, ok := repr.reported(*trailer)
+		if !ok {
+			err = &VerificationError{Results: results, Missing: []string{repr.TrailerName}, errs: []error{missingTrailerError([]string{repr.TrailerName})}}
+		} else if result := repr.verify(reprDigest, reported); result.Err != nil {
+			results = append(results, result)
+			err, cut = &VerificationError{Results: results, errs: []error{result.Err}}, 0
+		} else {
+			results = append(results, result)
+		}
+	}
+	var verr *VerificationError
+	if errors.As(err, &verr) {
+		err = errors.Join(err, f.Truncate(cut))
+	}
+	return results, err
+} // ResumeDownload() func
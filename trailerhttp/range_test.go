@@ -0,0 +1,216 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseByteRange(t *testing.T) {
+	for _, tc := range []struct {
+		header        string
+		start, length int64
+		err           error
+	}{
+		{"bytes=0-99", 0, 100, nil},
+		{"bytes=100-", 100, 900, nil},
+		{"bytes=-100", 900, 100, nil},
+		{"bytes=-5000", 0, 1000, nil},
+		{"bytes=990-5000", 990, 10, nil},
+		{" bytes= 5-5 ", 5, 1, nil},
+		{"bytes=1000-", 0, 0, errRangeUnsatisfiable},
+		{"bytes=-0", 0, 0, errRangeUnsatisfiable},
+		{"bytes=0-1,5-9", 0, 0, errRangeIgnored},
+		{"bytes=9-5", 0, 0, errRangeIgnored},
+		{"bytes=-", 0, 0, errRangeIgnored},
+		{"bytes=5", 0, 0, errRangeIgnored},
+		{"bytes=a-9", 0, 0, errRangeIgnored},
+		{"items=0-9", 0, 0, errRangeIgnored},
+	} {
+		start, length, err := parseByteRange(tc.header, 1000)
+		if start != tc.start || length != tc.length || !errors.Is(err, tc.err) || tc.err == nil && err != nil {
+			t.Errorf("%q: %d, %d, %v; want %d, %d, %v", tc.header, start, length, err, tc.start, tc.length, tc.err)
+		}
+	}
+	if _, _, err := parseByteRange("bytes=-10", 0); !errors.Is(err, errRangeUnsatisfiable) {
+		t.Errorf("suffix range of an empty file: %v, want it unsatisfiable", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, tc := range []struct {
+		header            string
+		first, last, size int64
+		ok                bool
+	}{
+		{"bytes 0-99/1000", 0, 99, 1000, true},
+		{"bytes 5-5/*", 5, 5, -1, true},
+		{"bytes */1000", -1, -1, 1000, true},
+		{"bytes 9-5/1000", 0, 0, 0, false},
+		{"bytes 0-99", 0, 0, 0, false},
+		{"bytes 0-x/1000", 0, 0, 0, false},
+		{"bytes 0-99/big", 0, 0, 0, false},
+		{"items 0-99/1000", 0, 0, 0, false},
+	} {
+		first, last, size, err := parseContentRange(tc.header)
+		if (err == nil) != tc.ok || first != tc.first || last != tc.last || size != tc.size {
+			t.Errorf("%q: %d-%d/%d, %v; want %d-%d/%d, ok %v", tc.header, first, last, size, err, tc.first, tc.last, tc.size, tc.ok)
+		}
+	}
+}
+
+// rangeFile is the file the range tests download
+var rangeFile = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// newRangeServer serves rangeFile as /file from a FileServer behind wrap, which may be nil
+func newRangeServer(t *testing.T, wrap func(http.Handler) http.Handler) string {
+	var h http.Handler = NewFileServer(FileServerOptions{
+		Root:   fstest.MapFS{"file": {Data: rangeFile, ModTime: time.Unix(1700000000, 0)}},
+		Logger: discardLogger(),
+	})
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/file"
+} // newRangeServer() func
+
+func TestDownloadRange(t *testing.T) {
+	url := newRangeServer(t, nil)
+	c := &Client{Logger: discardLogger()}
+	for _, tc := range []struct{ offset, length int64 }{{0, 100}, {1000, 1}, {60000, -1}} {
+		var dst bytes.Buffer
+		results, err := c.DownloadRange(t.Context(), url, &dst, tc.offset, tc.length)
+		want := rangeFile[tc.offset:]
+		if tc.length >= 0 {
+			want = want[:tc.length]
+		}
+		if err != nil || !bytes.Equal(dst.Bytes(), want) || len(results) == 0 {
+			t.Errorf("offset %d, length %d: %d bytes, results %+v, %v; want the range verified", tc.offset, tc.length, dst.Len(), results, err)
+		}
+	}
+	for _, tc := range []struct{ offset, length int64 }{{100, 0}, {-1, 10}} {
+		if _, err := c.DownloadRange(t.Context(), url, new(bytes.Buffer), tc.offset, tc.length); err == nil {
+			t.Errorf("offset %d, length %d: no error, want the range rejected before it is sent", tc.offset, tc.length)
+		}
+	}
+	ignoring := newRangeServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del("Range")
+			h.ServeHTTP(w, r)
+		})
+	})
+	if _, err := c.DownloadRange(t.Context(), ignoring, new(bytes.Buffer), 10, 10); err == nil {
+		t.Error("server ignoring the range: no error, want the 200 rejected")
+	}
+}
+
+// cutOffWriter passes on the first limit bytes of a response, then aborts it
+type cutOffWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutOffWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestResumeDownload(t *testing.T) {
+	url := newRangeServer(t, nil)
+	c := &Client{Logger: discardLogger()}
+	for _, tc := range []struct {
+		name    string
+		partial []byte
+		results int // checks that ran; 0 when the file was complete
+	}{
+		{"empty", nil, 1},
+		{"partial", rangeFile[:10000], 2},
+		{"complete", rangeFile, 0},
+	} {
+		path := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(path, tc.partial, 0o644)
+		results, err := c.ResumeDownload(t.Context(), url, path)
+		got, _ := os.ReadFile(path)
+		if err != nil || !bytes.Equal(got, rangeFile) || len(results) < tc.results || tc.results == 0 && results != nil {
+			t.Errorf("%s: %d bytes, results %+v, %v; want the whole file and %d checks", tc.name, len(got), results, err, tc.results)
+		}
+		for _, result := range results {
+			if !result.Matched {
+				t.Errorf("%s: %+v, want every check matched", tc.name, result)
+			}
+		}
+	}
+}
+
+func TestResumeDownloadRangeIgnored(t *testing.T) {
+	url := newRangeServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del("Range")
+			h.ServeHTTP(w, r)
+		})
+	})
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte("not the start of the file"), 0o644)
+	_, err := (&Client{Logger: discardLogger()}).ResumeDownload(t.Context(), url, path)
+	if got, _ := os.ReadFile(path); err != nil || !bytes.Equal(got, rangeFile) {
+		t.Errorf("%d bytes, %v; want the partial file replaced by the whole one", len(got), err)
+	}
+}
+
+func TestResumeDownloadReprDigestMismatch(t *testing.T) {
+	url := newRangeServer(t, nil)
+	path := filepath.Join(t.TempDir(), "file")
+	damaged := bytes.Clone(rangeFile[:20000])
+	damaged[5] ^= 1 // outside the range asked for, so only Repr-Digest can tell
+	os.WriteFile(path, damaged, 0o644)
+	results, err := (&Client{Logger: discardLogger()}).ResumeDownload(t.Context(), url, path)
+	var verr *VerificationError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("results %+v, %v; want a Repr-Digest mismatch", results, err)
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("file is %d bytes, want it emptied, the damage being anywhere", info.Size())
+	}
+}
+
+func TestResumeDownloadCutOff(t *testing.T) {
+	var cut atomic.Bool
+	cut.Store(true)
+	url := newRangeServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cut.Load() {
+				w = &cutOffWriter{ResponseWriter: w, limit: 5000}
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	c := &Client{Logger: discardLogger()}
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, rangeFile[:10000], 0o644)
+	if _, err := c.ResumeDownload(t.Context(), url, path); err == nil {
+		t.Fatal("no error for a transfer cut off mid-range")
+	}
+	info, _ := os.Stat(path)
+	if info.Size() <= 10000 || info.Size() >= int64(len(rangeFile)) {
+		t.Fatalf("file is %d bytes after the cut, want the bytes received kept", info.Size())
+	}
+	cut.Store(false)
+	results, err := c.ResumeDownload(t.Context(), url, path)
+	if got, _ := os.ReadFile(path); err != nil || !bytes.Equal(got, rangeFile) || len(results) < 2 {
+		t.Errorf("%d bytes, results %+v, %v; want the next resume to complete the file, verified end to end", len(got), results, err)
+	}
+}
@@ -0,0 +1,208 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RawField is a header or trailer field of a RawRequest, written exactly as given: names are
+// not canonicalized and values not checked, so either may be malformed on purpose
+type RawField struct {
+	Name, Value string
+}
+
+// RawViolations are deliberate breaches of the HTTP/1.1 framing rules a RawRequest commits,
+// to see how a server reacts to malformed chunked bodies and trailer sections: whether it
+// answers 400, closes the connection, hangs or accepts what it should not. Every field is
+// optional; the zero value frames the request correctly. Forbidden trailer fields such as
+// Content-Length need no violation: put them in RawRequest.Trailer.
+type RawViolations struct {
+	BareLF           bool   // end every line with a LF alone instead of CRLF
+	Unannounced      bool   // leave out the Trailer header announcing the trailer fields
+	ChunkSizeDelta   int    // declare every chunk this many bytes longer (or, negative, shorter) than it is
+	ChunkExtension   string // append this to every chunk size line, e.g. ";" + strings.Repeat("x", 8192)
+	OmitLastChunk    bool   // send the trailer section right after the data, without the zero-size last chunk
+	SpaceBeforeColon bool   // write trailer fields as "Name : value", which RFC 9112 has servers reject
+	FoldTrailers     bool   // put every trailer value on an obsolete folded continuation line
+	Unterminated     bool   // leave out the empty line ending the trailer section, then half-close the connection
+}
+
+// RawRequest is an HTTP/1.1 request with a chunked body and trailers that SendRaw writes
+// byte for byte over a raw TCP connection, bypassing net/http and everything it would
+// correct or refuse to send
+type RawRequest struct {
+	Method string // "POST" if empty
+	Target string // the request target, "/" if empty
+	Host   string // the Host header, unless Header has one; the address dialed if empty
+
+	Header  []RawField // written in order, after Host; Transfer-Encoding: chunked and Trailer are added unless present
+	Body    []byte
+	Trailer []RawField
+
+	ChunkSize  int // bytes of Body per chunk; 0 sends it in one
+	Violations RawViolations
+
+	// WriteSize, when above 0, writes the request in pieces of this many bytes, 1 writing
+	// one byte at a time, with WriteDelay between them, to exercise a server's handling of
+	// fragmented reads
+	WriteSize  int
+	WriteDelay time.Duration
+}
+
+// RawResponse is what the server answered a RawRequest with
+type RawResponse struct {
+	Sent     []byte         // the request as written
+	Received []byte         // everything the server sent before its response ended or the connection closed
+	Response *http.Response // parsed from Received, its body already read into Body; nil when the server sent none
+	Body     []byte         // the response body
+	Framing  []FramingError // where Received breaks the framing rules, from CheckHTTP1Framing
+}
+
+// Bytes returns the request as SendRaw writes it, with host as the Host header when neither
+// Host nor Header gives one
+func (req *RawRequest) Bytes(host string) []byte {
+	v := req.Violations
+	eol := "\r\n"
+	if v.BareLF {
+		eol = "\n"
+	}
+	var b bytes.Buffer
+	method, target := cmp.Or(req.Method, http.MethodPost), cmp.Or(req.Target, "/")
+	fmt.Fprintf(&b, "%s %s HTTP/1.1%s", method, target, eol)
+	if !req.hasHeader("Host") {
+		fmt.Fprintf(&b, "Host: %s%s", cmp.Or(req.Host, host), eol)
+	}
+	for _, f := range req.Header {
+		fmt.Fprintf(&b, "%s: %s%s", f.Name, f.Value, eol)
+	}
+	if !req.hasHeader("Transfer-Encoding") {
+		fmt.Fprintf(&b, "Transfer-Encoding: chunked%s", eol)
+	}
+	if !req.hasHeader("Trailer") && !v.Unannounced && len(req.Trailer) > 0 {
+		names := make([]string, len(req.Trailer))
+		for i, f := range req.Trailer {
+			names[i] = f.Name
+		}
+		fmt.Fprintf(&b, "Trailer: %s%s", strings.Join(names, ", "), eol)
+	}
+	b.WriteString(eol)
+
+	size := req.ChunkSize
+	if size <= 0 {
+		size = max(len(req.Body), 1)
+	}
+	for body := req.Body; len(body) > 0; {
+		chunk := body[:min(size, len(body))]
+		body = body[len(chunk):]
+		fmt.Fprintf(&b, "%x%s%s", len(chunk)+v.ChunkSizeDelta, v.ChunkExtension, eol)
+		b.Write(chunk)
+		b.WriteString(eol)
+	}
+	if !v.OmitLastChunk {
+		fmt.Fprintf(&b, "0%s%s", v.ChunkExtension, eol)
+	}
+	colon := ":"
+	if v.SpaceBeforeColon {
+		colon = " :"
+	}
+	for _, f := range req.Trailer {
+		if v.FoldTrailers {
+			fmt.Fprintf(&b, "%s%s%s %s%s", f.Name, colon, eol, f.Value, eol)
+		} else {
+			fmt.Fprintf(&b, "%s%s %s%s", f.Name, colon, f.Value, eol)
+		}
+	}
+	if !v.Unterminated {
+		b.WriteString(eol)
+	}
+	return b.Bytes()
+} // Bytes() func
+
+// hasHeader reports whether req.Header has a field called name
+func (req *RawRequest) hasHeader(name string) bool {
+	for _, f := range req.Header {
+		if strings.EqualFold(strings.TrimSpace(f.Name), name) {
+			return true
+		}
+	}
+	return false
+} // hasHeader() func
+
+// SendRaw dials addr over TCP, writes req as it is, violations included, and reads the
+// response, skipping interim ones, or whatever the server sent until it closed the
+// connection. A server refusing a malformed request usually answers 400 and closes; one
+// that never answers runs into ctx. The RawResponse is returned even with an error, with
+// what was sent and received so far.
+func SendRaw(ctx context.Context, addr string, req *RawRequest) (*RawResponse, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	raw := &RawResponse{Sent: req.Bytes(addr)}
+	var received bytes.Buffer
+	defer func() {
+		raw.Received = received.Bytes()
+		raw.Framing = CheckHTTP1Framing(raw.Received)
+	}()
+	// The server may answer before the request is written, e.g. with 400 at the first
+	// malformed line, and closing the connection then fails the rest of the write
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- writeRaw(conn, raw.Sent, req) }()
+
+	br := bufio.NewReader(io.TeeReader(conn, &received))
+	for {
+		resp, err := http.ReadResponse(br, &http.Request{Method: cmp.Or(req.Method, http.MethodPost)})
+		if err != nil {
+			err = errors.Join(fmt.Errorf("reading the response: %w", err), <-writeErr)
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return raw, err
+		}
+		if resp.StatusCode/100 == 1 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		raw.Response = resp
+		raw.Body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return raw, fmt.Errorf("reading the response body: %w", err)
+		}
+		return raw, nil
+	}
+} // SendRaw() func
+
+// writeRaw writes data, the bytes of req, to conn in pieces of req.WriteSize, and half-closes
+// conn after a trailer section left unterminated, so the server sees it end
+func writeRaw(conn net.Conn, data []byte, req *RawRequest) error {
+	piece := req.WriteSize
+	if piece <= 0 {
+		piece = len(data)
+	}
+	for len(data) > 0 {
+		n, err := conn.Write(data[:min(piece, len(data))])
+		if err != nil {
+			return fmt.Errorf("writing the request: %w", err)
+		}
+		if data = data[n:]; len(data) > 0 && req.WriteDelay > 0 {
+			time.Sleep(req.WriteDelay)
+		}
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok && req.Violations.Unterminated {
+		return tcp.CloseWrite()
+	}
+	return nil
+} // writeRaw() func
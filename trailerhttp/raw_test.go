@@ -0,0 +1,72 @@
+package trailerhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRawRequestBytes(t *testing.T) {
+	req := &RawRequest{Target: "/upload", Body: []byte("hello world"), ChunkSize: 5, Trailer: []RawField{{"x-body-byte-length", "11"}}}
+	want := "POST /upload HTTP/1.1\r\nHost: example\r\nTransfer-Encoding: chunked\r\nTrailer: x-body-byte-length\r\n\r\n" +
+		"5\r\nhello\r\n5\r\n worl\r\n1\r\nd\r\n0\r\nx-body-byte-length: 11\r\n\r\n"
+	if got := string(req.Bytes("example")); got != want {
+		t.Errorf("Bytes = %q, want %q", got, want)
+	}
+	req.Violations = RawViolations{BareLF: true, Unannounced: true, SpaceBeforeColon: true, OmitLastChunk: true}
+	want = "POST /upload HTTP/1.1\nHost: example\nTransfer-Encoding: chunked\n\n5\nhello\n5\n worl\n1\nd\nx-body-byte-length : 11\n\n"
+	if got := string(req.Bytes("example")); got != want {
+		t.Errorf("Bytes with violations = %q, want %q", got, want)
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+	body := []byte(strings.Repeat("raw bytes ", 10))
+	trailer := []RawField{{"X-Body-Byte-Length", "100"}}
+	for _, tc := range []struct {
+		name   string
+		req    RawRequest
+		status int
+	}{
+		{"well formed", RawRequest{Body: body, Trailer: trailer, ChunkSize: 7}, http.StatusOK},
+		{"written a byte at a time", RawRequest{Body: body, Trailer: trailer, WriteSize: 1}, http.StatusOK},
+		{"wrong chunk size", RawRequest{Body: body, Trailer: trailer, Violations: RawViolations{ChunkSizeDelta: -1}}, http.StatusBadRequest},
+		{"forbidden trailer", RawRequest{Body: body, Trailer: append(trailer, RawField{"Content-Length", "100"})}, http.StatusBadRequest},
+		{"unterminated trailer section", RawRequest{Body: body, Trailer: trailer, Violations: RawViolations{Unterminated: true}}, http.StatusBadRequest},
+	} {
+		resp, err := SendRaw(t.Context(), addr, &tc.req)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if resp.Response.StatusCode != tc.status {
+			t.Errorf("%s: status %d, body %q; want %d", tc.name, resp.Response.StatusCode, resp.Body, tc.status)
+		}
+		if tc.status == http.StatusOK {
+			var result UploadResult
+			if err := json.Unmarshal(resp.Body, &result); err != nil || !result.Matched {
+				t.Errorf("%s: result %s, %v; want it verified", tc.name, resp.Body, err)
+			}
+		}
+	}
+}
+
+func TestSendRawContext(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	req := &RawRequest{Body: []byte("never ends"), Violations: RawViolations{ChunkSizeDelta: 100}} // the server waits for the rest of the chunk
+	start := time.Now()
+	resp, err := SendRaw(ctx, srv.Listener.Addr().String(), req)
+	if err == nil || resp == nil || len(resp.Sent) == 0 || time.Since(start) > 2*time.Second {
+		t.Errorf("response %+v, error %v after %s; want the sent bytes and the context's error", resp, err, time.Since(start))
+	}
+}
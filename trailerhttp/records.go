@@ -0,0 +1,104 @@
+package trailerhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// RecordCountTrailer carries the number of records in an NDJSON or CSV body; see Client.Ingest
+const RecordCountTrailer = "X-Record-Count"
+
+// RecordFormat is the media type of a record-oriented body
+type RecordFormat string
+
+// Record formats Client.Ingest streams and Handler and VerifiedBody count records in
+const (
+	RecordsNDJSON RecordFormat = "application/x-ndjson" // one JSON value per line
+	RecordsCSV    RecordFormat = "text/csv"             // one row per line; quoted fields may span lines
+)
+
+// ErrRecordCountMismatch reports a body whose records disagree with its X-Record-Count trailer,
+// such as an upload cut short at a record boundary, which a length trailer alone would
+// only catch if the sender knew its length
+var ErrRecordCountMismatch = errors.New("record count does not match trailer")
+
+// recordFormat returns the record format of a body with the Content-Type of header, if it has one
+func recordFormat(header http.Header) (RecordFormat, bool) {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch mediaType {
+	case string(RecordsNDJSON), "application/jsonl", "application/x-jsonlines":
+		return RecordsNDJSON, true
+	case string(RecordsCSV):
+		return RecordsCSV, true
+	}
+	return "", false
+} // recordFormat() func
+
+// recordVerifier returns the "records" check of a body in format. It is not registered:
+// receivers pick it by the Content-Type of the body, senders through Client.Ingest.
+func recordVerifier(format RecordFormat) trailerVerifier {
+	return trailerVerifier{
+		Algorithm:   "records",
+		TrailerName: RecordCountTrailer,
+		NewDigest:   func([]byte) bodyDigest { return &recordDigest{csv: format == RecordsCSV} },
+	}
+} // recordVerifier() func
+
+// recordDigest counts the records of the body: non-empty lines, where a CSV line break inside
+// a quoted field does not end the row. A last record without a line break counts too.
+type recordDigest struct {
+	csv      bool
+	n        int64
+	inQuotes bool
+	pending  bool // the current record has content
+}
+
+func (d *recordDigest) Write(p []byte) (int, error) {
+	for _, c := range p {
+		switch {
+		case c == '"' && d.csv:
+			d.inQuotes = !d.inQuotes // an escaped "" toggles twice
+			d.pending = true
+		case c == '\n' && !d.inQuotes:
+			if d.pending {
+				d.n++
+				d.pending = false
+			}
+		case c != '\r':
+			d.pending = true
+		}
+	}
+	return len(p), nil
+}
+
+func (d *recordDigest) count() int64 {
+	if d.pending {
+		return d.n + 1
+	}
+	return d.n
+} // count() func
+
+func (d *recordDigest) Value() string { return strconv.FormatInt(d.count(), 10) }
+
+func (d *recordDigest) Matches(reported string) (bool, error) {
+	reportedCount, err := strconv.ParseInt(reported, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return reportedCount == d.count(), nil
+}
+
+// Ingest streams src, an NDJSON or CSV body, to url as SendStream does, counting its records
+// on the way and sending the count in the X-Record-Count trailer next to Client.Algorithms.
+// The Handler, and a VerifiedBody on any server, count the records again, the CSV header row
+// included, and reject a body whose count disagrees as ErrRecordCountMismatch.
+func (c *Client) Ingest(ctx context.Context, url string, src io.Reader, format RecordFormat) (*UploadResult, error) {
+	ingest := *c
+	ingest.records = format
+	ingest.debug(ingest.logger(), "Ingesting records", "format", format)
+	return ingest.SendStream(ctx, url, src)
+} // Ingest() func
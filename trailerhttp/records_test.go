@@ -0,0 +1,42 @@
+package trailerhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIngestRecordCount(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Policy: PolicyStrict, Logger: discardLogger()}))
+	defer srv.Close()
+	records := "{\"id\":1}\n{\"id\":2}\n\n{\"id\":3}" // a blank line is no record, a last one without a line break is
+	c := &Client{Logger: discardLogger()}
+	result, err := c.Ingest(t.Context(), srv.URL, strings.NewReader(records), RecordsNDJSON)
+	if err != nil || !result.Matched {
+		t.Fatalf("%+v, %v; want the exact count verified", result, err)
+	}
+	if !slices.ContainsFunc(result.Checks, func(c CheckSummary) bool { return c.Algorithm == "records" && c.Matched && c.Reported == "3" }) {
+		t.Errorf("checks %+v, want a matched records check of 3", result.Checks)
+	}
+
+	for _, count := range []string{"2", "4"} {
+		req := &RawRequest{Header: []RawField{{"Content-Type", string(RecordsNDJSON)}}, Body: []byte(records), Trailer: []RawField{{RecordCountTrailer, count}}}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw.Response.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(raw.Body), ErrRecordCountMismatch.Error()) {
+			t.Errorf("count %s of 3: status %d, body %s; want 422 with ErrRecordCountMismatch", count, raw.Response.StatusCode, raw.Body)
+		}
+	}
+}
+
+func TestRecordDigestCSV(t *testing.T) {
+	d := recordVerifier(RecordsCSV).NewDigest(nil)
+	d.Write([]byte("name,note\r\nalice,\"two\nlines\"\r\nbob,\"\"\"quoted\"\"\"\r\n"))
+	if ok, err := d.Matches("3"); !ok || err != nil {
+		t.Errorf("counted %s records, want 3: header row and two rows, one spanning two lines", d.Value())
+	}
+}
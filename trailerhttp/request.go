@@ -0,0 +1,63 @@
+package trailerhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// NewRequest returns a request streaming body to url with the integrity trailers for algos
+// (the length trailer is always included), ready for any http.Client, e.g.
+//
+//	req, err := trailerhttp.NewRequest(ctx, http.MethodPut, url, src, trailerhttp.AlgoSHA256)
+//	resp, err := http.DefaultClient.Do(req)
+//
+// It does what a streamed upload with trailers takes by hand: the body goes through an
+// io.Pipe, so it is sent chunked, the trailers are announced in the Trailer header, and a
+// goroutine copies body into the pipe through a TrailerWriter, which sets the trailer
+// values once body is exhausted and before the body ends on the wire. The goroutine only
+// starts when the transport first reads the body, and stops when ctx is done or the
+// transport closes the body, so an unsent request leaks nothing. An error reading body
+// fails the request rather than sending trailers for a partial body. Trailers of fixed value
+// may be added to req.Trailer before the request is sent; it cannot be sent twice.
+func NewRequest(ctx context.Context, method, url string, body io.Reader, algos ...TrailerAlgo) (*http.Request, error) {
+	if body == nil {
+		body = http.NoBody // still sent chunked, with the trailers of an empty body
+	}
+	pr, pw := io.Pipe()
+	pb := &pipedBody{pr: pr}
+	req, err := http.NewRequestWithContext(ctx, method, url, pb)
+	if err != nil {
+		return nil, err
+	}
+	tw := NewTrailerWriter(req, pw, algos...)
+	pb.copy = func() {
+		// Cancelling ctx closes the pipe with ctx.Err(), which fails a pending write
+		stop := context.AfterFunc(ctx, func() { pw.CloseWithError(ctx.Err()) })
+		defer stop()
+		if _, err := io.Copy(tw, contextReader{ctx: ctx, r: body}); err != nil {
+			tw.CloseWithError(err)
+			return
+		}
+		tw.Close()
+	}
+	return req, nil
+} // NewRequest() func
+
+// pipedBody is the body of a NewRequest request: the read end of its pipe, whose writer
+// starts copying the source on the first Read
+type pipedBody struct {
+	pr    *io.PipeReader
+	start sync.Once
+	copy  func()
+}
+
+func (b *pipedBody) Read(p []byte) (int, error) {
+	b.start.Do(func() { go b.copy() })
+	return b.pr.Read(p)
+}
+
+func (b *pipedBody) Close() error {
+	return b.pr.Close()
+}
@@ -0,0 +1,30 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNewRequestEmptyBody(t *testing.T) {
+	rs := newRecordingServer(t)
+	for _, method := range []string{http.MethodGet, http.MethodDelete, http.MethodPut} {
+		req, err := NewRequest(t.Context(), method, rs.URL, nil, AlgoSHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result UploadResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if !rs.chunked || rs.trailer == "" {
+			t.Errorf("%s: chunked %v, Trailer %q; want the nil body sent chunked with its trailers", method, rs.chunked, rs.trailer)
+		}
+		if !result.Matched || result.Outcome != "trailer-verified-ok" || result.ReportedLength == nil || *result.ReportedLength != 0 {
+			t.Errorf("%s: result %+v; want the trailers of an empty body verified, not no-trailer", method, result)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package trailerhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// TrailerFallbackHeader names, on a response to a client that cannot receive trailers, the
+// trailer fields the server left out; see FallbackHeader
+const TrailerFallbackHeader = "X-Trailer-Fallback"
+
+// TrailerFallback is what a server does with the response trailers of a client that did not
+// send "TE: trailers" over HTTP/1.x, since it or an intermediary may drop or choke on them
+type TrailerFallback int
+
+// Trailer fallbacks
+const (
+	FallbackOmit   TrailerFallback = iota // send the response without its trailers
+	FallbackHeader                        // likewise, but name them in an X-Trailer-Fallback header
+)
+
+// fallbackNames are the names accepted by UnmarshalText, indexed by fallback
+var fallbackNames = []string{FallbackOmit: "omit", FallbackHeader: "header"}
+
+func (f TrailerFallback) String() string {
+	if f < 0 || int(f) >= len(fallbackNames) {
+		return fmt.Sprintf("TrailerFallback(%d)", int(f))
+	}
+	return fallbackNames[f]
+}
+
+// MarshalText returns the fallback name, so a fallback can be used with flag.TextVar or in JSON
+func (f TrailerFallback) MarshalText() ([]byte, error) {
+	if f < 0 || int(f) >= len(fallbackNames) {
+		return nil, fmt.Errorf("unknown trailer fallback %d", int(f))
+	}
+	return []byte(fallbackNames[f]), nil
+} // MarshalText() func
+
+// UnmarshalText parses a fallback name: "omit" or "header"
+func (f *TrailerFallback) UnmarshalText(text []byte) error {
+	for fallback, name := range fallbackNames {
+		if string(text) == name {
+			*f = TrailerFallback(fallback)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown trailer fallback %q (want omit or header)", text)
+} // UnmarshalText() func
+
+// omit applies the fallback for the trailers names to the response header
+func (f TrailerFallback) omit(header http.Header, names []string) {
+	if f == FallbackHeader && len(names) > 0 {
+		header.Set(TrailerFallbackHeader, strings.Join(names, ","))
+	}
+} // omit() func
+
+// TrailerResponseWriter wraps an http.ResponseWriter, computing integrity trailers over the
+// response body written through it and sending them once Finish is called. The trailers are
+// announced only to clients whose request carried "TE: trailers", or came over HTTP/2 or later;
+// for others it just passes the body through. A handler must not set Content-Length: the body has to be streamed
+// (chunked under HTTP/1.1) for a trailer section to follow it.
+type TrailerResponseWriter struct {
+	w   http.ResponseWriter
+	set *trailerSet // nil when the client does not accept trailers
+
+	coding  *contentCoding // the body's content coding, for NewCompressedResponseWriter
+	enc     contentEncoder // compresses into w, from the first Write on
+	status  int
+	started bool // the header was written
+}
+
+// NewTrailerResponseWriter announces the trailers for algos (the length trailer is always
+// included) in the response header of w. Call it before the handler writes the header or body.
+func NewTrailerResponseWriter(w http.ResponseWriter, r *http.Request, algos ...TrailerAlgo) *TrailerResponseWriter {
+	return NewTrailerResponseWriterFallback(w, r, FallbackOmit, algos...)
+} // NewTrailerResponseWriter() func
+
+// NewTrailerResponseWriterFallback is NewTrailerResponseWriter with fallback applied when the
+// client cannot receive the trailers
+func NewTrailerResponseWriterFallback(w http.ResponseWriter, r *http.Request, fallback TrailerFallback, algos ...TrailerAlgo) *TrailerResponseWriter {
+	tw := &TrailerResponseWriter{w: w}
+	set := newTrailerSet(algos)
+	if !acceptsTrailers(r) {
+		fallback.omit(w.Header(), set.names())
+		return tw
+	}
+	tw.set = set
+	w.Header().Add("Trailer", strings.Join(tw.set.names(), ","))
+	return tw
+} // NewTrailerResponseWriterFallback() func
+
+func (tw *TrailerResponseWriter) Header() http.Header {
+	return tw.w.Header()
+}
+
+func (tw *TrailerResponseWriter) WriteHeader(status int) {
+	if !tw.started && status >= http.StatusOK {
+		tw.started, tw.status = true, status
+		if tw.coding != nil {
+			tw.w.Header().Del("Content-Length") // the length of the uncompressed body
+		}
+	}
+	tw.w.WriteHeader(status)
+} // WriteHeader() func
+
+// Write writes p to the response and adds the bytes it accepted to the digests
+func (tw *TrailerResponseWriter) Write(p []byte) (int, error) {
+	if tw.coding == nil {
+		n, err := tw.w.Write(p)
+		if tw.set != nil {
+			tw.set.Write(p[:n])
+		}
+		return n, err
+	}
+	if !tw.started {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.enc == nil {
+		tw.startEncoder()
+	}
+	n, err := tw.enc.Write(p)
+	if tw.set != nil {
+		tw.set.writeDecoded(p[:n])
+	}
+	return n, err
+} // Write() func
+
+// startEncoder starts compressing the body into the response, its Encoded digests hashing the
+// compressed bytes
+func (tw *TrailerResponseWriter) startEncoder() {
+	var dst io.Writer = tw.w
+	if tw.set != nil {
+		dst = io.MultiWriter(tw.w, wireDigests{tw.set})
+	}
+	tw.enc = tw.coding.newWriter(dst)
+} // startEncoder() func
+
+// Flush sends any buffered body bytes to the client, compressed ones included, if the
+// underlying writer supports it
+func (tw *TrailerResponseWriter) Flush() {
+	if tw.enc != nil {
+		tw.enc.Flush()
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+} // Flush() func
+
+// Encoding returns the content coding the body is compressed with, or "" when it is not
+func (tw *TrailerResponseWriter) Encoding() string {
+	if tw.coding == nil {
+		return ""
+	}
+	return tw.coding.name
+} // Encoding() func
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (tw *TrailerResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// Finish sets the trailer values for the body written so far, ending a compressed body
+// first. net/http sends them after the body when the handler returns, so call Finish last.
+func (tw *TrailerResponseWriter) Finish() {
+	if tw.coding != nil && tw.enc == nil && bodyAllowed(tw.status) {
+		tw.Write(nil) // an empty body still needs its compressed stream
+	}
+	if tw.enc != nil {
+		tw.enc.Close()
+	}
+	if tw.set != nil {
+		tw.set.setValues(tw.w.Header())
+	}
+} // Finish() func
+
+// ResponseTrailers wraps next so that every response body it writes carries integrity
+// trailers for algos, verifiable on the client with NewVerifiedResponse,
+// Transport.VerifyResponses or Client.Download.
+func ResponseTrailers(next http.Handler, algos ...TrailerAlgo) http.Handler {
+	return ResponseTrailersFallback(next, FallbackOmit, algos...)
+} // ResponseTrailers() func
+
+// ResponseTrailersFallback is ResponseTrailers with fallback applied to the responses of
+// clients that cannot receive the trailers
+func ResponseTrailersFallback(next http.Handler, fallback TrailerFallback, algos ...TrailerAlgo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := NewTrailerResponseWriterFallback(w, r, fallback, algos...)
+		next.ServeHTTP(tw, r)
+		tw.Finish()
+	})
+} // ResponseTrailersFallback() func
+
+// errNoResponseTrailers reports a download whose response announced no integrity trailer
+var errNoResponseTrailers = errors.New("response announced no integrity trailer")
+
+// watchTrailers applies c.TrailerTimeout to the body of resp, when it can carry trailers
+func (c *Client) watchTrailers(resp *http.Response) {
+	if c.TrailerTimeout <= 0 || (resp.ContentLength >= 0 && resp.ProtoMajor < 2) {
+		return
+	}
+	body := resp.Body
+	resp.Body = newTrailerWatchdog(body, c.TrailerTimeout, func() { body.Close() })
+} // watchTrailers() func
+
+// Download fetches url into dst and verifies the integrity trailers of the response against
+// the bytes received, decompressing a gzip (or, with the zstd tag, zstd) response first, as
+// NewCompressedResponseWriter sends it for the Accept-Encoding Download asks with. The error is a *VerificationError when a check failed, a *StatusError when
+// the server reported a failure in its status trailers (see SetStatus), and an error also when the
+// server announced no trailer this package can check.
+func (c *Client) Download(ctx context.Context, url string, dst io.Writer) ([]VerificationResult, error) {
+	return c.fetch(ctx, url, nil, func(resp *http.Response) (io.Writer, error) {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return dst, nil
+	})
+} // Download() func
+
+// fetch GETs url with the extra header fields and copies the response body into the writer
+// open returns for the response, verifying its trailers; an error from open rejects the
+// response. open may remove trailers from resp.Trailer before the body is read, to check
+// them itself: their values still arrive.
+func (c *Client) fetch(ctx context.Context, url string, extra http.Header, open func(resp *http.Response) (io.Writer, error)) ([]VerificationResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+	// Servers send response trailers only to clients that accept them (RFC 9110, Section 10.1.4)
+	req.Header.Set("TE", "trailers")
+	// Asking for compression ourselves keeps net/http from decompressing the body before the
+	// RFC 9530 digests, which cover it as sent, can hash it
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding())
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainBody(resp)
+	dst, err := open(resp)
+	if err != nil {
+		return nil, err
+	}
+	c.watchTrailers(resp)
+
+	vb, err := verifyDecodedResponse(resp, c.HMACKey, c.TrailerNames)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(dst, vb)
+	c.debug(c.requestLogger(req), "Downloaded", "bytes", n, "trailer", resp.Trailer)
+	if err != nil {
+		return vb.Results(), err
+	}
+	gotResponseTrailers(ctx, resp.Trailer)
+	if err := c.StatusTrailers.Response(resp); err != nil {
+		return vb.Results(), err
+	}
+	if !vb.Verified() {
+		return nil, errNoResponseTrailers
+	}
+	return vb.Results(), nil
+} // fetch() func
@@ -0,0 +1,463 @@
+package trailerhttp
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Header fields of the resumable upload protocol, named after tus (tus.io)
+const (
+	UploadOffsetHeader = "Upload-Offset" // bytes the server has committed, or the offset a PATCH continues from
+	UploadLengthHeader = "Upload-Length" // total size of the upload, fixed when it is created
+)
+
+// DefaultResumeChunkSize is how many bytes ResumeUpload sends per PATCH request
+const DefaultResumeChunkSize = 8 << 20
+
+// ErrUploadRejected reports a resumable upload request the server refused
+var ErrUploadRejected = errors.New("resumable upload rejected")
+
+// errUnverifiedPatch reports a PATCH body that arrived whole but that no trailer check covered
+var errUnverifiedPatch = errors.New("no trailer verified the body")
+
+// ResumableOptions configures a ResumableHandler
+type ResumableOptions struct {
+	Dir       string // directory holding the uploads; required
+	MaxLength int64  // largest Upload-Length accepted; 0 means no limit
+
+	// Algorithms are the trailers every PATCH must carry and verify before its bytes count;
+	// nil means "length" and "sha256"
+	Algorithms []string
+	HMACKey    []byte // shared secret for the "hmac-sha256" algorithm
+
+	// OnComplete, when set, is called once the last byte of an upload is committed,
+	// with the upload's id and the path of the finished file
+	OnComplete func(id, path string)
+
+	Logger *slog.Logger // nil means slog.Default()
+}
+
+// ResumableHandler serves resumable uploads, in the manner of tus, whose progress only ever
+// advances by verified bytes:
+//
+//	POST   /        with Upload-Length: N, creates an upload: 201 Created, Location: /<id>
+//	HEAD   /<id>    reports Upload-Offset, the bytes committed, and Upload-Length
+//	PATCH  /<id>    with Upload-Offset: the committed offset, appends a body carrying integrity trailers
+//	DELETE /<id>    abandons an upload
+//
+// A PATCH commits its bytes only once its trailers verified them; otherwise, including when
+// the connection dies mid-body, the upload is cut back to the previous offset. The committed
+// offset is therefore always trustworthy for a client to resume from. Mount it under a prefix
+// with http.StripPrefix, e.g. mux.Handle("/uploads/", http.StripPrefix("/uploads", h)).
+// Uploads live in Dir as <id>.part, the bytes, and <id>.info, the committed offset and the
+// length, until complete, then as <id>.
+type ResumableHandler struct {
+	opts     ResumableOptions
+	required []trailerVerifier
+	logger   *slog.Logger
+	mux      *http.ServeMux
+
+	mu     sync.Mutex
+	active map[string]bool // uploads with a PATCH in progress
+}
+
+// NewResumableHandler resolves opts into a handler. It panics if opts.Dir is empty or
+// opts.Algorithms names an unknown verifier, like NewHandler.
+func NewResumableHandler(opts ResumableOptions) *ResumableHandler {
+	if opts.Dir == "" {
+		panic("ResumableOptions.Dir: no upload directory")
+	}
+	algorithms := opts.Algorithms
+	if algorithms == nil {
+		algorithms = defaultFileAlgorithms
+	}
+	h := &ResumableHandler{opts: opts, logger: orDefaultLogger(opts.Logger).With("component", "resumable"), active: map[string]bool{}}
+	for _, name := range algorithms {
+		v, err := lookupVerifier(strings.TrimSpace(name))
+		if err != nil {
+			panic("ResumableOptions.Algorithms: " + err.Error())
+		}
+		h.required = append(h.required, v)
+	}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("POST /{$}", h.create)
+	h.mux.HandleFunc("HEAD /{id}", h.withUpload(h.status))
+	h.mux.HandleFunc("PATCH /{id}", h.withUpload(h.patch))
+	h.mux.HandleFunc("DELETE /{id}", h.withUpload(h.remove))
+	return h
+} // NewResumableHandler() func
+
+func (h *ResumableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store") // offsets change with every PATCH
+	h.mux.ServeHTTP(w, r)
+} // ServeHTTP() func
+
+// path returns the file of upload id with the given suffix
+func (h *ResumableHandler) path(id, suffix string) string {
+	return filepath.Join(h.opts.Dir, id+suffix)
+} // path() func
+
+// withUpload resolves the {id} of the request path, refusing anything that is not an upload id
+func (h *ResumableHandler) withUpload(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if _, err := hex.DecodeString(id); err != nil || len(id) != 16 {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r, id)
+	}
+} // withUpload() func
+
+// offsets returns the committed offset and the length of upload id; complete uploads have both equal
+func (h *ResumableHandler) offsets(id string) (offset, length int64, complete bool, err error) {
+	if info, err := os.Stat(h.path(id, "")); err == nil {
+		return info.Size(), info.Size(), true, nil
+	}
+	// The part file may hold bytes of a PATCH still being verified: only the info file counts
+	raw, err := os.ReadFile(h.path(id, ".info"))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	var info uploadInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return 0, 0, false, fmt.Errorf("upload %s: corrupt info file: %w", id, err)
+	}
+	return info.Offset, info.Length, false, nil
+} // offsets() func
+
+// uploadInfo is the content of the info file of an unfinished upload
+type uploadInfo struct {
+	Offset int64 `json:"offset"` // bytes committed, all verified
+	Length int64 `json:"length"`
+}
+
+// writeInfo replaces the info file of upload id, atomically
+func (h *ResumableHandler) writeInfo(id string, offset, length int64) error {
+	raw, err := json.Marshal(uploadInfo{Offset: offset, Length: length})
+	if err != nil {
+		return err
+	}
+	tmp := h.path(id, ".info.tmp")
+	if err := os.WriteFile(tmp, raw, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path(id, ".info"))
+} // writeInfo() func
+
+// writeOffsets sets the Upload-Offset and Upload-Length response headers
+func writeOffsets(w http.ResponseWriter, offset, length int64) {
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+	w.Header().Set(UploadLengthHeader, strconv.FormatInt(length, 10))
+} // writeOffsets() func
+
+// create starts an upload of Upload-Length bytes
+func (h *ResumableHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get(UploadLengthHeader), 10, 64)
+	switch {
+	case err != nil || length < 0:
+		http.Error(w, "missing or invalid "+UploadLengthHeader, http.StatusBadRequest)
+		return
+	case h.opts.MaxLength > 0 && length > h.opts.MaxLength:
+		http.Error(w, fmt.Sprintf("upload exceeds the %d byte limit", h.opts.MaxLength), http.StatusRequestEntityTooLarge)
+		return
+	}
+	id := newRequestID()
+	if err := h.createFiles(id, length); err != nil {
+		h.logger.Error("Could not create upload", "err", err)
+		http.Error(w, "could not create upload", http.StatusInternalServerError)
+		return
+	}
+	// Location is resolved against the request as the client sent it, before any StripPrefix
+	base := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		base = u.Path
+	}
+	w.Header().Set("Location", strings.TrimSuffix(base, "/")+"/"+id)
+	writeOffsets(w, 0, length)
+	w.WriteHeader(http.StatusCreated)
+	h.logger.Info("Created upload", "upload_id", id, "length", length)
+	if length == 0 {
+		h.complete(h.logger.With("upload_id", id), id) // no PATCH will ever come
+	}
+} // create() func
+
+// createFiles writes the empty part file and the info file of a new upload
+func (h *ResumableHandler) createFiles(id string, length int64) error {
+	if err := os.MkdirAll(h.opts.Dir, 0o750); err != nil {
+		return err
+	}
+	part, err := os.OpenFile(h.path(id, ".part"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	if err := part.Close(); err != nil {
+		return err
+	}
+	return h.writeInfo(id, 0, length)
+} // createFiles() func
+
+// status reports the committed offset of an upload
+func (h *ResumableHandler) status(w http.ResponseWriter, r *http.Request, id string) {
+	offset, length, _, err := h.offsets(id)
+	if err != nil {
+		h.notFoundOrError(w, r, err)
+		return
+	}
+	writeOffsets(w, offset, length)
+	w.WriteHeader(http.StatusOK)
+} // status() func
+
+// notFoundOrError answers 404 for an unknown upload, 500 otherwise
+func (h *ResumableHandler) notFoundOrError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	h.logger.Error("Could not read upload", "path", r.URL.Path, "err", err)
+	http.Error(w, "could not read upload", http.StatusInternalServerError)
+} // notFoundOrError() func
+
+// lock marks upload id as being patched; false if another PATCH already is
+func (h *ResumableHandler) lock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active[id] {
+		return false
+	}
+	h.active[id] = true
+	return true
+} // lock() func
+
+func (h *ResumableHandler) unlock(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.active, id)
+} // unlock() func
+
+// patch appends the request body at the committed offset, keeping it only if its trailers verify
+func (h *ResumableHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.lock(id) {
+		http.Error(w, "another request is appending to this upload", http.StatusConflict)
+		return
+	}
+	defer h.unlock(id)
+	log := h.logger.With("upload_id", id)
+
+	offset, length, complete, err := h.offsets(id)
+	if err != nil {
+		h.notFoundOrError(w, r, err)
+		return
+	}
+	writeOffsets(w, offset, length)
+	if from, err := strconv.ParseInt(r.Header.Get(UploadOffsetHeader), 10, 64); err != nil || from != offset || complete {
+		http.Error(w, fmt.Sprintf("%s must be the committed offset %d of %d", UploadOffsetHeader, offset, length), http.StatusConflict)
+		return
+	}
+	for _, v := range h.required {
+		if _, announced := lookupField(r.Trailer, v.TrailerName); !announced {
+			http.Error(w, fmt.Sprintf("every PATCH must announce the %s trailer", v.TrailerName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	part, err := os.OpenFile(h.path(id, ".part"), os.O_WRONLY, 0)
+	if err == nil {
+		err = part.Truncate(offset) // drops what a crash mid-PATCH may have left
+	}
+	if err == nil {
+		_, err = part.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		log.Error("Could not open upload", "err", err)
+		http.Error(w, "could not open upload", http.StatusInternalServerError)
+		return
+	}
+	// Nothing before the verdict may survive: a failed or interrupted body is cut off again
+	vb := NewVerifiedBody(r, h.opts.HMACKey)
+	n, copyErr := io.Copy(part, http.MaxBytesReader(w, vb, length-offset))
+	if copyErr == nil && !vb.Verified() {
+		copyErr = errUnverifiedPatch
+	}
+	if copyErr != nil {
+		err := errors.Join(part.Truncate(offset), part.Close())
+		log.Warn("Discarded unverified bytes", "offset", offset, "bytes", n, "err", copyErr, "truncate_err", err)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(copyErr, &tooLarge):
+			http.Error(w, fmt.Sprintf("body runs past the upload length %d", length), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, copyErr.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if err = errors.Join(part.Sync(), part.Close()); err == nil {
+		err = h.writeInfo(id, offset+n, length)
+	}
+	if err != nil {
+		log.Error("Could not commit upload", "err", err)
+		http.Error(w, "could not commit upload", http.StatusInternalServerError)
+		return
+	}
+	offset += n
+	writeOffsets(w, offset, length)
+	log.Info("Committed verified bytes", "offset", offset, "length", length, "bytes", n)
+	if offset == length && !h.complete(log, id) {
+		http.Error(w, "could not finish upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+} // patch() func
+
+// complete turns a fully committed part file into the finished upload and reports it
+func (h *ResumableHandler) complete(log *slog.Logger, id string) bool {
+	err := os.Rename(h.path(id, ".part"), h.path(id, ""))
+	if err == nil {
+		err = os.Remove(h.path(id, ".info"))
+	}
+	if err != nil {
+		log.Error("Could not finish upload", "err", err)
+		return false
+	}
+	log.Info("Upload complete")
+	if h.opts.OnComplete != nil {
+		h.opts.OnComplete(id, h.path(id, ""))
+	}
+	return true
+} // complete() func
+
+// remove abandons an upload, or deletes a finished one
+func (h *ResumableHandler) remove(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.lock(id) {
+		http.Error(w, "another request is appending to this upload", http.StatusConflict)
+		return
+	}
+	defer h.unlock(id)
+	removed := false
+	for _, suffix := range []string{"", ".part", ".info"} {
+		if err := os.Remove(h.path(id, suffix)); err == nil {
+			removed = true
+		}
+	}
+	if !removed {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+} // remove() func
+
+// rejected turns an unexpected resumable upload response into an ErrUploadRejected error
+func rejected(resp *http.Response) error {
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%w: %s: %s", ErrUploadRejected, resp.Status, strings.TrimSpace(string(snippet)))
+} // rejected() func
+
+// CreateUpload creates a resumable upload of length bytes on the ResumableHandler at url,
+// and returns the URL of the upload, for UploadOffset and ResumeUpload
+func (c *Client) CreateUpload(ctx context.Context, url string, length int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(UploadLengthHeader, strconv.FormatInt(length, 10))
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return "", rejected(resp)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s without a Location: %w", ErrUploadRejected, resp.Status, err)
+	}
+	return location.String(), nil
+} // CreateUpload() func
+
+// UploadOffset returns how many bytes of a resumable upload the server has committed, all
+// of them verified, and the upload's total length
+func (c *Client) UploadOffset(ctx context.Context, uploadURL string) (offset, length int64, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, uploadURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%w: %s", ErrUploadRejected, resp.Status)
+	}
+	return responseOffsets(resp)
+} // UploadOffset() func
+
+// responseOffsets parses the Upload-Offset and Upload-Length headers of resp
+func responseOffsets(resp *http.Response) (offset, length int64, err error) {
+	offset, err = strconv.ParseInt(resp.Header.Get(UploadOffsetHeader), 10, 64)
+	if err == nil {
+		length, err = strconv.ParseInt(resp.Header.Get(UploadLengthHeader), 10, 64)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s: missing or invalid offsets: %w", ErrUploadRejected, resp.Status, err)
+	}
+	return offset, length, nil
+} // responseOffsets() func
+
+// ResumeUpload sends the rest of a resumable upload, from the offset the server has committed,
+// in PATCH requests of chunkSize bytes (0 means DefaultResumeChunkSize), each carrying the
+// trailers of Client.Algorithms, or length and SHA-256 when it names none. src holds the whole
+// upload; only its uncommitted bytes are read. It returns the committed offset: after an error,
+// calling ResumeUpload again continues from there, even from another process.
+func (c *Client) ResumeUpload(ctx context.Context, uploadURL string, src io.ReaderAt, chunkSize int64) (int64, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultResumeChunkSize
+	}
+	upload := *c
+	upload.PrePass = false // a PATCH carries its integrity fields as trailers, which ResumableHandler requires
+	if len(upload.Algorithms) == 0 {
+		upload.Algorithms = defaultFileAlgorithms
+	}
+	offset, length, err := upload.UploadOffset(ctx, uploadURL)
+	if err != nil {
+		return 0, err
+	}
+	resynced := false
+	for offset < length {
+		n := min(chunkSize, length-offset)
+		header := http.Header{UploadOffsetHeader: {strconv.FormatInt(offset, 10)}}
+		resp, err := upload.stream(ctx, http.MethodPatch, uploadURL, header, io.NewSectionReader(src, offset, n))
+		if err != nil {
+			return offset, err
+		}
+		committed, _, offsetErr := responseOffsets(resp)
+		switch {
+		case resp.StatusCode == http.StatusNoContent && offsetErr == nil:
+			upload.debug(upload.logger(), "Committed upload chunk", "url", uploadURL, "offset", committed, "length", length)
+			offset, resynced = committed, false
+		case resp.StatusCode == http.StatusConflict && offsetErr == nil && committed != offset && !resynced:
+			// The server committed a different offset than we knew of: continue from its
+			offset, resynced = committed, true
+		default:
+			err := rejected(resp)
+			drainBody(resp)
+			return offset, err
+		}
+		drainBody(resp)
+	}
+	return offset, nil
+} // ResumeUpload() func
@@ -0,0 +1,33 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeUpload(t *testing.T) {
+	dir := t.TempDir()
+	var completed string
+	h := NewResumableHandler(ResumableOptions{Dir: dir, Logger: discardLogger(), OnComplete: func(id, path string) { completed = path }})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	data := bytes.Repeat([]byte("resumable "), 3000)
+	for _, prePass := range []bool{false, true} {
+		c := &Client{PrePass: prePass, Logger: discardLogger()}
+		uploadURL, err := c.CreateUpload(t.Context(), srv.URL+"/", int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, err := c.ResumeUpload(t.Context(), uploadURL, bytes.NewReader(data), 4096)
+		if err != nil || offset != int64(len(data)) {
+			t.Fatalf("PrePass %v: ResumeUpload = %d, %v; want all %d bytes committed", prePass, offset, err, len(data))
+		}
+		got, err := os.ReadFile(completed)
+		if err != nil || !bytes.Equal(got, data) || filepath.Dir(completed) != dir {
+			t.Fatalf("PrePass %v: completed file %s holds %d bytes, %v", prePass, completed, len(got), err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// maxDrainBytes bounds what drainBody reads of a response nobody wants, to reach its trailers
+const maxDrainBytes = 64 << 10
+
+// drainBody reads what is left of resp.Body, up to maxDrainBytes, and closes it. A response
+// body closed before EOF abandons its connection, since its end and its trailer section
+// would otherwise be read as the start of the next response; read to EOF, the connection
+// goes back to the keep-alive pool.
+func drainBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+	resp.Body.Close()
+} // drainBody() func
+
+// ReuseResult is the outcome of one scenario of CheckConnectionReuse: an upload with trailers
+// to a handler leaving its body as Scenario says, followed by a verified upload that should
+// go over the same connection exactly when WantReused is set
+type ReuseResult struct {
+	Scenario   string `json:"scenario"`
+	WantReused bool   `json:"want_reused"` // net/http should keep the connection; false when the rest of the body is too long to discard
+	Reused     bool   `json:"reused"`      // the follow-up went over the connection of the first upload
+	Verified   bool   `json:"verified"`    // the follow-up's trailers verified, so nothing of the first upload leaked into it
+	Error      string `json:"error,omitempty"`
+}
+
+// OK reports whether the connection was reused as it should be and the follow-up verified
+func (r ReuseResult) OK() bool {
+	return r.Error == "" && r.Verified && r.Reused == r.WantReused
+} // OK() func
+
+// reuseScenario is a handler treating the first upload's body in one particular way
+type reuseScenario struct {
+	name       string
+	size       int64 // body size of the first upload
+	wantReused bool
+	handler    http.HandlerFunc
+}
+
+// reuseScenarios are the ways a handler can leave a keep-alive connection after an upload.
+// net/http discards up to 256 KiB of a body a handler left unread, trailer section included,
+// before reusing the connection, and closes it when more remains.
+var reuseScenarios = []reuseScenario{
+	{name: "read to EOF", size: 64 << 10, wantReused: true, handler: func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		answerReuse(w, nil)
+	}},
+	{name: "body read, trailers not", size: 64 << 10, wantReused: true, handler: func(w http.ResponseWriter, r *http.Request) {
+		// Exactly the body bytes: the final chunk and the trailer section stay on the wire
+		io.CopyN(io.Discard, r.Body, 64<<10)
+		answerReuse(w, nil)
+	}},
+	{name: "never read", size: 16 << 10, wantReused: true, handler: func(w http.ResponseWriter, r *http.Request) {
+		answerReuse(w, nil)
+	}},
+	{name: "never read, too long to discard", size: 1 << 20, wantReused: false, handler: func(w http.ResponseWriter, r *http.Request) {
+		answerReuse(w, nil)
+	}},
+	{name: "response trailers", size: 16 << 10, wantReused: true, handler: func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		answerReuse(w, http.Header{"X-Reuse-Check": {"done"}})
+	}},
+}
+
+// answerReuse answers a scenario's upload with an empty verification result and trailer
+func answerReuse(w http.ResponseWriter, trailer http.Header) {
+	for name := range trailer {
+		w.Header().Add("Trailer", name)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}")
+	for name, values := range trailer {
+		w.Header()[name] = values
+	}
+} // answerReuse() func
+
+// CheckConnectionReuse checks that trailers never corrupt the next request on a keep-alive
+// connection: over HTTP/1.1 the trailer section follows the last chunk of the body, so a
+// connection returned to the pool before it was read would hand its bytes to the next
+// request as its start. Against a server on a loopback port, every scenario sends an upload
+// with trailers to a handler that reads its body fully, stops before the trailers or never
+// reads it, then a verified upload, and reports whether the second went over the same
+// connection and verified. A result that is not OK is a bug in the pooling of net/http or of
+// this package's wrappers.
+func CheckConnectionReuse(ctx context.Context) ([]ReuseResult, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	quiet := slog.New(slog.DiscardHandler)
+	mux := http.NewServeMux()
+	mux.Handle("/verify", NewHandler(ServerOptions{Policy: PolicyStrict, Logger: quiet}))
+	for i, scenario := range reuseScenarios {
+		mux.Handle(fmt.Sprintf("/scenario/%d", i), scenario.handler)
+	}
+	server := &http.Server{Handler: mux, ErrorLog: slog.NewLogLogger(quiet.Handler(), slog.LevelError), ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	defer server.Close()
+	base := "http://" + listener.Addr().String()
+
+	var results []ReuseResult
+	for i, scenario := range reuseScenarios {
+		// A pool of one connection per scenario: the follow-up can only reuse the first's
+		transport := &http.Transport{Protocols: new(http.Protocols), MaxIdleConnsPerHost: 1}
+		transport.Protocols.SetHTTP1(true)
+		client := &Client{HTTPClient: &http.Client{Transport: transport}, Algorithms: []string{"length", "sha256"}, Logger: quiet}
+		result := ReuseResult{Scenario: scenario.name, WantReused: scenario.wantReused}
+
+		var first net.Conn
+		firstCtx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { first = info.Conn }})
+		body := bytes.NewReader(bytes.Repeat([]byte{'r'}, int(scenario.size)))
+		// Answered before its body was sent, the first upload may fail writing the rest once the
+		// server has closed the connection; only the follow-up's fate matters then
+		if _, err := client.SendStream(firstCtx, fmt.Sprintf("%s/scenario/%d", base, i), body); err != nil && scenario.wantReused {
+			result.Error = fmt.Sprintf("first upload: %v", err)
+		}
+
+		followCtx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+			result.Reused = info.Reused && info.Conn == first
+		}})
+		verified, err := client.Send(followCtx, base+"/verify", []byte("the follow-up upload"))
+		switch {
+		case err != nil && result.Error == "":
+			result.Error = fmt.Sprintf("follow-up upload: %v", err)
+		case err == nil:
+			result.Verified = verified.StatusCode == http.StatusOK && verified.Matched
+			if !result.Verified && result.Error == "" {
+				result.Error = fmt.Sprintf("follow-up upload: %d %s", verified.StatusCode, verified.Error)
+			}
+		}
+		transport.CloseIdleConnections()
+		results = append(results, result)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+} // CheckConnectionReuse() func
@@ -0,0 +1,63 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckConnectionReuse(t *testing.T) {
+	results, err := CheckConnectionReuse(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(reuseScenarios) {
+		t.Fatalf("%d results for %d scenarios", len(results), len(reuseScenarios))
+	}
+	for _, result := range results {
+		if !result.OK() {
+			t.Errorf("%s: %+v", result.Scenario, result)
+		}
+	}
+}
+
+// A handler that never reads its body leaves the last chunk and the trailer section on the
+// connection; the next request on it must still parse and verify
+func TestUndrainedBodyDoesNotPoisonNextRequest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ignore", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ignored") })
+	mux.Handle("/verify", NewHandler(ServerOptions{Policy: PolicyStrict, Logger: discardLogger()}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	for _, path := range []string{"/ignore", "/verify"} {
+		io.WriteString(conn, "POST "+path+" HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\nTrailer: X-Body-Byte-Length\r\nTE: trailers\r\n\r\n"+
+			"5\r\nhello\r\n0\r\nX-Body-Byte-Length: 5\r\n\r\n")
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Close {
+			t.Fatalf("%s: status %d, close %v, body %q", path, resp.StatusCode, resp.Close, body)
+		}
+		if path == "/verify" {
+			var result UploadResult
+			if err := json.Unmarshal(body, &result); err != nil || !result.Matched || result.BodyLength != 5 {
+				t.Errorf("second request on the connection: %+v, %v; want its own 5 bytes verified", result, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 headers an S3Handler reads or answers with, besides those of aws-chunked bodies
+const (
+	s3RequestIDHeader    = "X-Amz-Request-Id"
+	s3SDKChecksumHeader  = "X-Amz-Sdk-Checksum-Algorithm"
+	s3ChecksumModeHeader = "X-Amz-Checksum-Mode"
+	s3ChecksumTypeHeader = "X-Amz-Checksum-Type"
+)
+
+// S3Options configures an S3Handler
+type S3Options struct {
+	MaxBytes int64 // largest object accepted; 0 means no limit
+
+	Logger *slog.Logger // nil means slog.Default()
+}
+
+// S3Handler is an in-memory test double for the object endpoints of S3, for checking that S3
+// clients send the checksums they should and handle S3's answers to them, without S3:
+//
+//	PUT    /<bucket>/<key>  stores the body once every checksum it carries verified it: 200 OK
+//	                        with the ETag and an x-amz-checksum-<algorithm> header per checksum
+//	GET    /<bucket>/<key>  returns the object; with x-amz-checksum-mode: ENABLED, its checksums too
+//	HEAD   /<bucket>/<key>  the same, without the body
+//	DELETE /<bucket>/<key>  removes the object: 204 No Content
+//
+// A PUT carries its checksums, x-amz-checksum-crc32, -crc32c, -sha1 or -sha256, as headers or
+// as trailers declared in x-amz-trailer: at the end of an aws-chunked body, as the AWS SDKs and
+// NewAWSChunkedRequest send it, or of a chunked HTTP/1.1 or an HTTP/2 body. A Content-MD5 header
+// is checked too. A checksum that does not match fails the PUT with S3's BadDigest error, as
+// an XML error document, and a declared one that never arrives, a malformed one or an
+// x-amz-sdk-checksum-algorithm without its checksum with InvalidRequest; nothing is stored
+// then. Requests are not authenticated and chunk signatures not checked. Mount it under a
+// prefix with http.StripPrefix and point an S3 client at it with path-style addressing.
+type S3Handler struct {
+	opts   S3Options
+	logger *slog.Logger
+	mux    *http.ServeMux
+
+	mu      sync.Mutex
+	objects map[string]*s3Object // by "<bucket>/<key>"
+}
+
+// s3Object is an object an S3Handler holds
+type s3Object struct {
+	data        []byte
+	contentType string
+	etag        string      // the quoted hex MD5 of data, as S3 gives for a single-part upload
+	checksums   http.Header // the x-amz-checksum-<algorithm> fields its PUT verified
+	modified    time.Time
+}
+
+// s3Error is the XML error document of an S3 error response
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Key       string `xml:",omitempty"`
+	RequestID string `xml:"RequestId"`
+}
+
+// NewS3Handler returns an empty S3Handler for opts
+func NewS3Handler(opts S3Options) *S3Handler {
+	h := &S3Handler{opts: opts, logger: orDefaultLogger(opts.Logger).With("component", "s3"), objects: map[string]*s3Object{}}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("PUT /{bucket}/{key...}", h.put)
+	h.mux.HandleFunc("GET /{bucket}/{key...}", h.get)
+	h.mux.HandleFunc("DELETE /{bucket}/{key...}", h.delete)
+	return h
+} // NewS3Handler() func
+
+func (h *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(s3RequestIDHeader, requestID(r))
+	h.mux.ServeHTTP(w, r)
+} // ServeHTTP() func
+
+// s3ChecksumAlgorithm returns the algorithm of an x-amz-checksum-<algorithm> field name, if
+// it is one an S3Handler verifies
+func s3ChecksumAlgorithm(name string) (string, bool) {
+	algorithm, ok := strings.CutPrefix(strings.ToLower(name), strings.ToLower(awsChecksumFieldPrefix))
+	if _, known := awsChecksums[algorithm]; !ok || !known {
+		return "", false
+	}
+	return algorithm, true
+} // s3ChecksumAlgorithm() func
+
+// put stores the request body under its bucket and key, if its checksums verify
+func (h *S3Handler) put(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	key := r.PathValue("bucket") + "/" + r.PathValue("key")
+	log := h.logger.With("request_id", w.Header().Get(s3RequestIDHeader), "key", key)
+
+	// The checksums to compute, by algorithm: those declared as trailers and those sent as headers
+	checksums := map[string]hash.Hash{}
+	for _, name := range strings.Split(r.Header.Get(awsTrailerHeader), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		algorithm, ok := s3ChecksumAlgorithm(name)
+		if !ok {
+			h.fail(w, r, http.StatusBadRequest, "InvalidRequest", "The value specified in the x-amz-trailer header is not supported")
+			return
+		}
+		checksums[algorithm] = awsChecksums[algorithm]()
+	}
+	for name := range r.Header {
+		if algorithm, ok := s3ChecksumAlgorithm(name); ok {
+			checksums[algorithm] = awsChecksums[algorithm]()
+		}
+	}
+	if algorithm := r.Header.Get(s3SDKChecksumHeader); algorithm != "" && checksums[strings.ToLower(algorithm)] == nil {
+		h.fail(w, r, http.StatusBadRequest, "InvalidRequest", "x-amz-sdk-checksum-algorithm specified, but no corresponding x-amz-checksum-* or x-amz-trailer headers were found.")
+		return
+	}
+	var wantMD5 []byte
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			h.fail(w, r, http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified was invalid.")
+			return
+		}
+		wantMD5 = sum
+	}
+
+	body := io.Reader(r.Body)
+	if isAWSChunked(r) {
+		body = newAWSChunkedReader(r, r.Body)
+	}
+	if h.opts.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, io.NopCloser(body), h.opts.MaxBytes)
+	}
+	md5Sum := md5.New()
+	hashes := []io.Writer{md5Sum}
+	for _, sum := range checksums {
+		hashes = append(hashes, sum)
+	}
+	data, err := io.ReadAll(io.TeeReader(body, io.MultiWriter(hashes...)))
+	if err != nil {
+		log.Warn("Rejected object", "bytes", len(data), "err", err)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			h.fail(w, r, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size")
+		case errors.Is(err, errMalformedAWSChunked):
+			h.fail(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		default:
+			h.fail(w, r, http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header")
+		}
+		return
+	}
+
+	if wantMD5 != nil && !bytes.Equal(wantMD5, md5Sum.Sum(nil)) {
+		log.Warn("Rejected object", "bytes", len(data), "err", "Content-MD5 mismatch")
+		h.fail(w, r, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		return
+	}
+	verified := http.Header{}
+	for _, algorithm := range slices.Sorted(maps.Keys(checksums)) {
+		name := http.CanonicalHeaderKey(awsChecksumFieldPrefix + algorithm)
+		reported := cmp.Or(r.Trailer.Get(name), r.Header.Get(name))
+		computed := checksums[algorithm].Sum(nil)
+		sum, err := base64.StdEncoding.DecodeString(reported)
+		switch {
+		case reported == "":
+			h.fail(w, r, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("The %s trailer declared in x-amz-trailer was not sent", strings.ToLower(name)))
+			return
+		case err != nil || len(sum) != len(computed):
+			h.fail(w, r, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("Value for %s header is invalid.", strings.ToLower(name)))
+			return
+		case !bytes.Equal(sum, computed):
+			log.Warn("Rejected object", "bytes", len(data), "err", "checksum mismatch", "checksum", algorithm)
+			h.fail(w, r, http.StatusBadRequest, "BadDigest", fmt.Sprintf("The %s you specified did not match the calculated checksum.", strings.ToUpper(algorithm)))
+			return
+		}
+		verified.Set(name, reported)
+	}
+
+	obj := &s3Object{
+		data:        data,
+		contentType: cmp.Or(r.Header.Get("Content-Type"), "binary/octet-stream"),
+		etag:        `"` + hex.EncodeToString(md5Sum.Sum(nil)) + `"`,
+		checksums:   verified,
+		modified:    time.Now().UTC().Truncate(time.Second),
+	}
+	h.mu.Lock()
+	h.objects[key] = obj
+	h.mu.Unlock()
+	log.Info("Stored object", "bytes", len(data), "checksums", slices.Sorted(maps.Keys(checksums)), "aws_chunked", isAWSChunked(r))
+	w.Header().Set("ETag", obj.etag)
+	obj.writeChecksums(w.Header())
+	w.WriteHeader(http.StatusOK)
+} // put() func
+
+// get returns the object of the path's bucket and key
+func (h *S3Handler) get(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	obj := h.objects[r.PathValue("bucket")+"/"+r.PathValue("key")]
+	h.mu.Unlock()
+	if obj == nil {
+		h.fail(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	w.Header().Set("Content-Type", obj.contentType)
+	w.Header().Set("ETag", obj.etag)
+	// The checksums cover the whole object, so a range gets none
+	if strings.EqualFold(r.Header.Get(s3ChecksumModeHeader), "ENABLED") && r.Header.Get("Range") == "" {
+		obj.writeChecksums(w.Header())
+	}
+	http.ServeContent(w, r, "", obj.modified, bytes.NewReader(obj.data))
+} // get() func
+
+// delete removes the object of the path's bucket and key; like S3, it succeeds for one that
+// does not exist
+func (h *S3Handler) delete(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	delete(h.objects, r.PathValue("bucket")+"/"+r.PathValue("key"))
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+} // delete() func
+
+// writeChecksums sets the checksum headers S3 answers with for obj
+func (obj *s3Object) writeChecksums(header http.Header) {
+	maps.Copy(header, obj.checksums)
+	if len(obj.checksums) > 0 {
+		header.Set(s3ChecksumTypeHeader, "FULL_OBJECT")
+	}
+} // writeChecksums() func
+
+// fail answers with an S3 error document
+func (h *S3Handler) fail(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	doc := s3Error{Code: code, Message: message, Key: r.PathValue("key"), RequestID: w.Header().Get(s3RequestIDHeader)}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(doc); err != nil {
+		h.logger.Error("Error writing response", "request_id", doc.RequestID, "err", err)
+	}
+} // fail() func
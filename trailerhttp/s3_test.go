@@ -0,0 +1,103 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// s3Do sends req and returns the response with its body read
+func s3Do(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+} // s3Do() func
+
+// s3ErrorCode returns the Code of an S3 XML error document
+func s3ErrorCode(body []byte) string {
+	var doc s3Error
+	xml.Unmarshal(body, &doc)
+	return doc.Code
+} // s3ErrorCode() func
+
+func TestS3HandlerAWSChunked(t *testing.T) {
+	srv := httptest.NewServer(NewS3Handler(S3Options{Logger: discardLogger()}))
+	defer srv.Close()
+	data := bytes.Repeat([]byte("object data "), 20000) // several aws-chunked chunks
+	sha := sha256.Sum256(data)
+	crc := crc32.ChecksumIEEE(data)
+	want := map[string]string{
+		"sha256": base64.StdEncoding.EncodeToString(sha[:]),
+		"crc32":  base64.StdEncoding.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}),
+	}
+	for _, checksum := range []string{"crc32", "crc32c", "sha1", "sha256"} {
+		url := srv.URL + "/bucket/" + checksum + ".bin"
+		req, err := NewAWSChunkedRequest(t.Context(), http.MethodPut, url, bytes.NewReader(data), int64(len(data)), checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, body := s3Do(t, req)
+		field := "X-Amz-Checksum-" + checksum
+		if resp.StatusCode != http.StatusOK || resp.Header.Get(field) == "" || resp.Header.Get("ETag") == "" {
+			t.Errorf("%s: status %d, %s %q, ETag %q, body %s", checksum, resp.StatusCode, field, resp.Header.Get(field), resp.Header.Get("ETag"), body)
+		}
+		if w, ok := want[checksum]; ok && resp.Header.Get(field) != w {
+			t.Errorf("%s: %s %q, want %q", checksum, field, resp.Header.Get(field), w)
+		}
+
+		get, _ := http.NewRequest(http.MethodGet, url, nil)
+		get.Header.Set(s3ChecksumModeHeader, "ENABLED")
+		resp, body = s3Do(t, get)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) || resp.Header.Get(field) == "" {
+			t.Errorf("%s: GET status %d, %d bytes, %s %q; want the object and its checksum", checksum, resp.StatusCode, len(body), field, resp.Header.Get(field))
+		}
+	}
+}
+
+func TestS3HandlerRejects(t *testing.T) {
+	srv := httptest.NewServer(NewS3Handler(S3Options{Logger: discardLogger()}))
+	defer srv.Close()
+	wrong := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	for _, tc := range []struct {
+		name    string
+		header  http.Header
+		trailer http.Header
+		code    string
+	}{
+		{"bad trailer checksum", http.Header{"X-Amz-Trailer": {"x-amz-checksum-sha256"}}, http.Header{"X-Amz-Checksum-Sha256": {wrong}}, "BadDigest"},
+		{"bad header checksum", http.Header{"X-Amz-Checksum-Sha256": {wrong}}, nil, "BadDigest"},
+		{"bad Content-MD5", http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(make([]byte, 16))}}, nil, "BadDigest"},
+		{"declared checksum never sent", http.Header{"X-Amz-Trailer": {"x-amz-checksum-sha256"}}, http.Header{"X-Amz-Checksum-Sha256": nil}, "InvalidRequest"},
+		{"SDK algorithm without checksum", http.Header{"X-Amz-Sdk-Checksum-Algorithm": {"SHA256"}}, nil, "InvalidRequest"},
+	} {
+		url := srv.URL + "/bucket/rejected"
+		req, _ := http.NewRequest(http.MethodPut, url, io.MultiReader(strings.NewReader("object data")))
+		for name, values := range tc.header {
+			req.Header[name] = values
+		}
+		req.Trailer = tc.trailer
+		resp, body := s3Do(t, req)
+		if resp.StatusCode != http.StatusBadRequest || s3ErrorCode(body) != tc.code {
+			t.Errorf("%s: status %d, body %s; want 400 %s", tc.name, resp.StatusCode, body, tc.code)
+		}
+		get, _ := http.NewRequest(http.MethodGet, url, nil)
+		if resp, _ := s3Do(t, get); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: GET status %d, want nothing stored", tc.name, resp.StatusCode)
+		}
+	}
+}
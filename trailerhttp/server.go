@@ -0,0 +1,1648 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults applied by NewServer to zero-valued ServerOptions fields
+const (
+	DefaultAddr        = "localhost:8080"
+	DefaultReadTimeout = 10 * time.Second
+
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// ServerOptions configures the handlers mounted by RegisterHandlers and the server built by NewServer.
+// The zero value is usable: every field has a default.
+type ServerOptions struct {
+	Addr        string // address NewServer listens on; "" means "localhost:8080"
+	Network     string // network of Addr: "tcp" (the default), "tcp4", "tcp6", or "unix" with Addr a socket path
+	Path        string // pattern the trailer-verifying handler is mounted at; "" means "/"
+	MetricsPath string // pattern the expvar counters are served at; "" means "/debug/vars"
+	DebugPath   string // pattern TrailerLog is served at, when set; "" means "/debug/trailers"
+	EchoPath    string // pattern EchoHandler is served at; "" means "/echo"
+
+	// PrometheusPath is the pattern the Prometheus metrics are served at when the package is
+	// built with the prometheus tag; "" means "/metrics". It is ignored without the tag.
+	PrometheusPath string
+
+	// ReadTimeout bounds how long NewServer's server waits for an entire request, body and trailers included.
+	// Trailers only arrive after the last body byte, so without it a stalled client holds the handler forever.
+	// 0 means 10s; a negative value means no limit.
+	ReadTimeout time.Duration
+
+	ReadHeaderTimeout time.Duration // bounds reading the request headers; 0 means ReadTimeout, as in http.Server
+	IdleTimeout       time.Duration // bounds the wait for the next request on a keep-alive connection; 0 means ReadTimeout
+
+	// CertFile and KeyFile hold the PEM certificate and key a Server serves TLS with,
+	// HTTP/2 negotiated through ALPN; both empty means cleartext (with h2c).
+	CertFile, KeyFile string
+
+	// ClientCAs turns on mutual TLS: a Server over TLS then requires every client to present a
+	// certificate chaining to one of these CAs (see LoadCertPool), and the Handler records the
+	// verified identity in UploadResult.ClientIdentity. Handlers read it with ClientCertificate.
+	ClientCAs *x509.CertPool
+
+	// ShutdownTimeout bounds how long a Server started with Start drains in-flight requests
+	// once its context is cancelled; 0 means 30s. See Server.Shutdown.
+	ShutdownTimeout time.Duration
+
+	// TrailerTimeout aborts with 408 Request Timeout a request whose trailer section does not
+	// arrive within this long of the last body bytes, so a client cannot send the terminating
+	// chunk and then hold the handler by stalling before its trailers. net/http reads the
+	// terminating chunk and the trailer section in one body Read, so the clock cannot start
+	// exactly at that chunk: it restarts whenever body bytes arrive, and the timeout must
+	// exceed the longest pause expected between them. It only applies to bodies that can
+	// carry trailers. 0 means no limit beyond ReadTimeout.
+	TrailerTimeout time.Duration
+
+	// Admit, when set, inspects every request before any body byte is read.
+	// A non-zero status rejects the upload with that status and reason. net/http only
+	// sends "100 Continue" on the first read of the body, so a client that sent
+	// "Expect: 100-continue" gets the rejection without ever transmitting its body.
+	// RequireTrailers builds one that insists on announced trailer names.
+	Admit func(r *http.Request) (status int, reason string)
+
+	// MaxBodyBytes aborts uploads larger than this with 413 Payload Too Large as soon as
+	// the limit is crossed, rather than after reading everything. For gzip bodies the limit
+	// applies to the decompressed bytes as well. 0 means no limit.
+	MaxBodyBytes int64
+
+	// DrainOversizedBytes keeps reading, and discarding, up to this many bytes of an upload past
+	// MaxBodyBytes, as LimitBody does, so that its trailer section still arrives when the rest
+	// is short enough: the 413 report then gives the real body length and the trailers, such as
+	// the length the client claimed. Stopping at the limit, as with 0, leaves r.Trailer empty,
+	// since net/http only parses trailers at the end of the body. A gzip body decompressing past
+	// the limit is not drained.
+	DrainOversizedBytes int64
+
+	// MaxTrailerFields, MaxTrailerBytes and MaxTrailerValueBytes bound the trailer section a
+	// client may send after the body: the number of fields, the total size of their names and
+	// values, and the size of any one value. A request over a limit is rejected with
+	// 431 Request Header Fields Too Large; too many announced fields are refused before the
+	// body is read. 0 means no limit.
+	MaxTrailerFields     int
+	MaxTrailerBytes      int
+	MaxTrailerValueBytes int
+
+	// Algorithms lists the verifiers the handler checks, by name ("length", "crc32", ...).
+	// Announced trailers of other verifiers are accepted but not checked. nil means all of them.
+	// The X-Record-Count of an NDJSON or CSV body is checked either way; see Client.Ingest.
+	Algorithms []string
+
+	// TrailerNames renames the trailer field a verifier reads, keyed by algorithm name,
+	// e.g. {"length": "X-Content-Length"}, as WithTrailerNames does for Integrity.
+	// Algorithms not listed keep their default field.
+	TrailerNames map[string]string
+
+	// StatusTrailers renames the X-Status-Code and X-Status-Message response trailers that
+	// report the outcome to clients accepting trailers; the zero value keeps those names
+	StatusTrailers StatusTrailers
+
+	// BodySink, when set, receives the body as it streams in, in the same pass as the digests,
+	// so uploads are stored and verified without buffering them. If it implements BodyCommitter,
+	// Commit is called before responding when every check matched, and Discard otherwise.
+	// The sink is shared by all requests: give each handler its own when uploads can be concurrent.
+	BodySink io.Writer
+
+	// Policy decides whether a request whose trailers did not verify is rejected; the zero value is PolicyWarn
+	Policy VerificationPolicy
+
+	// RejectStatus is the status PolicyStrict rejects an unverified upload with; 0 means 422 Unprocessable Content,
+	// or 400 Bad Request for a message signature over a Content-Digest the body was never checked against
+	RejectStatus int
+
+	// ProblemDetails answers uploads rejected for failing verification, under PolicyStrict or for
+	// announced trailers that never arrived, with an RFC 9457 application/problem+json Problem
+	// instead of the JSON UploadResult: a machine-readable code, and every failing trailer field
+	// with the value it carried and the value computed over the body. Client decodes either.
+	ProblemDetails bool
+
+	// TrailerFallback decides what happens to the response trailers, the received ones echoed
+	// and the status trailers, for a client that did not send "TE: trailers" over HTTP/1.x:
+	// FallbackOmit (the default) leaves them out, FallbackHeader also names them in an
+	// X-Trailer-Fallback header.
+	TrailerFallback TrailerFallback
+
+	// ServerTiming adds a Server-Timing response trailer with the time spent reading the body,
+	// verifying its trailers, and in the handler as a whole, to clients that accept trailers
+	ServerTiming bool
+
+	// TraceResponse adds a Traceresponse response trailer naming the span that handled the
+	// upload, to clients that accept trailers, so a caller can link its trace to the server's.
+	// With the otel build tag that is the recording server span; otherwise a span ID is drawn per request,
+	// in the trace of the request's traceparent header or in a new one.
+	TraceResponse bool
+
+	// HMACKey is the shared secret for the hmac-sha256 verifier; without it that check always fails
+	HMACKey []byte
+
+	// RequireHMAC rejects with 401 Unauthorized, before any body byte is read, every request that
+	// does not announce the keyed X-Body-HMAC trailer. Without it anyone tampering with a request
+	// on the way can drop the HMAC along with the body and send a matching length, which proves
+	// nothing. It needs HMACKey.
+	RequireHMAC bool
+
+	// SignatureKeys are the public keys, by keyid, that RFC 9421 message signatures sent as
+	// Signature and Signature-Input trailers are checked with (ed25519.PublicKey or a P-256
+	// *ecdsa.PublicKey); a delivered signature counts as one more check, which fails unless the
+	// Content-Digest it covers was announced and matched the body. nil means signatures are not
+	// checked. NewHandler panics if Algorithms leaves out content-digest.
+	SignatureKeys map[string]crypto.PublicKey
+
+	// SignatureMaxAge is how old, by its created parameter, a message signature may be once the
+	// body has arrived; 0 means DefaultSignatureMaxAge. A signature without created, created
+	// more than DefaultTimestampSkew in the future, or past its expires parameter fails, so a
+	// signature captured on the way cannot be replayed past that age.
+	SignatureMaxAge time.Duration
+
+	// TokenKeys, when set, puts uploads behind a JWT authorization trailer, X-Body-Token
+	// (BodyTokenTrailer), checked with these public keys by kid: a request that does not announce
+	// it is rejected with 401 Unauthorized before any body byte is read, and one whose token does
+	// not verify, has expired, or binds a Content-Digest the body does not match is rejected with
+	// 401 once the trailers arrive, whatever the Policy, before anything is committed to BodySink.
+	// It needs the content-digest verifier.
+	TokenKeys map[string]crypto.PublicKey
+
+	// TimestampSkew is how far the time of an X-Body-Timestamp trailer (TimestampTrailer) may be
+	// off the server's clock once the body has arrived; 0 means DefaultTimestampSkew. A delivered
+	// timestamp counts as one more check, of its HMAC with HMACKey over the time and the
+	// Content-Digest trailer, and the result's computed value is the verified completion time.
+	// The check fails unless that Content-Digest trailer matched the body, so setting
+	// TimestampSkew needs the content-digest verifier.
+	TimestampSkew time.Duration
+
+	// Nonces, when set, refuses replays: a request that does not announce an X-Body-Nonce
+	// trailer (NonceTrailer) is rejected with 401 Unauthorized before any body byte is read, one
+	// whose nonce does not verify with HMACKey over the Content-Digest trailer with 401 once the
+	// trailers arrive, and one whose nonce the store already holds with 409 Conflict, whatever
+	// the Policy. The nonce of an upload that verified is remembered for NonceTTL, unless
+	// committing BodySink fails and the store is a NonceForgetter, which then forgets it.
+	// A nonce only verifies once the Content-Digest trailer it binds matched the body, so it
+	// needs HMACKey and the content-digest verifier.
+	Nonces NonceStore
+
+	// NonceTTL is how long Nonces remembers a nonce; 0 means DefaultNonceTTL. Pair it with
+	// Client.Timestamp and a TimestampSkew below it, so a replay older than the nonces
+	// remembered fails the timestamp check instead.
+	NonceTTL time.Duration
+
+	// OnTrailerReceived, when set, is called with every field line of the trailer section once
+	// the body has been read, fields in name order, before any of them is checked; fields not
+	// allowed in a trailer section never reach it.
+	OnTrailerReceived func(field, value string)
+
+	// OnIntegrityFailure, when set, is called once the response is written for an upload that
+	// failed verification: err is a *VerificationError of the failed checks and the announced
+	// trailers that never arrived, and report the upload's summary, to record an audit, quarantine
+	// the payload or raise an alert. It runs on the request's goroutine, so it should not block.
+	OnIntegrityFailure func(err error, report *UploadResult)
+
+	// ETags remembers the ETag of every upload kept: verified, and committed when there is a
+	// BodySink. An upload whose If-None-Match header lists one of them is answered with 412
+	// Precondition Failed before its body is read, as Client.SendIfNoneMatch expects; with or
+	// without it, a verified upload gets the ETag derived from its SHA-256 digest (ContentETag)
+	// in the ETag header and UploadResult.ETag.
+	ETags ETagIndex
+
+	// StripForbiddenTrailers drops, with a log line, the fields RFC 9110 does not allow in a
+	// trailer section (Content-Length, Host, Authorization, ...) instead of rejecting the request
+	// with 400 Bad Request. Either way their values are never acted on.
+	StripForbiddenTrailers bool
+
+	// RejectUnannouncedTrailers rejects with 400 Bad Request a request whose trailer section
+	// delivers a field its Trailer header did not list, as senders should announce trailers
+	// up front (RFC 9110, Section 6.6.2); otherwise they are accepted and reported in
+	// UploadResult.DeliveredTrailers. Fields in AllowedUnannouncedTrailers are tolerated all the same.
+	RejectUnannouncedTrailers  bool
+	AllowedUnannouncedTrailers []string
+
+	// MaxMetadataBytes bounds the JSON value of the X-Body-Metadata trailer (MetadataTrailer);
+	// 0 means DefaultMaxMetadataBytes. A larger or malformed value is rejected with 400 Bad Request,
+	// and a valid one is returned in UploadResult.Metadata.
+	MaxMetadataBytes int
+
+	// MetadataSchema is a JSON Schema the X-Body-Metadata trailer must satisfy when it is sent,
+	// else the request is rejected with 422 Unprocessable Content; nil means any JSON is accepted.
+	// Only common validation keywords are supported (type, enum, const, properties, required,
+	// additionalProperties, items, lengths, pattern and numeric bounds); others are ignored.
+	MetadataSchema json.RawMessage
+
+	// Throttle, when set, reads every request body as slowly as it says, as if it came over a
+	// slow or flaky link, to try out ReadTimeout and TrailerTimeout; it is meant for testing
+	Throttle *Throttle
+
+	Logger        *slog.Logger // receives the handler's output, with the request's fields; nil means slog.Default()
+	Verbose       bool         // log every step at slog.LevelDebug, dumping headers, trailers and request bodies
+	LogReads      bool         // log the size of every read from the request body, to see how it was chunked
+	SummaryOutput io.Writer    // receives a JSON summary line per request; nil means no summaries
+
+	// TrailerLog, when set, keeps the last requests handled with the trailers they delivered
+	// and the result of every check, for RegisterHandlers to serve at DebugPath
+	TrailerLog *TrailerLog
+}
+
+// Handler verifies the integrity trailers of the requests it serves; create it with NewHandler
+type Handler struct {
+	opts      ServerOptions
+	verifiers []trailerVerifier
+	schema    *jsonSchema // opts.MetadataSchema, compiled
+	logger    *slog.Logger
+}
+
+// NewHandler resolves opts into a handler. It panics if opts.Algorithms names an unknown verifier,
+// if opts.RequireHMAC, opts.TokenKeys, opts.TimestampSkew or opts.Nonces cannot be met, or if opts.MetadataSchema
+// does not compile, as http.ServeMux.Handle panics on a bad pattern.
+func NewHandler(opts ServerOptions) *Handler {
+	h := &Handler{opts: opts, logger: orDefaultLogger(opts.Logger).With("component", "server")}
+	for _, name := range opts.Algorithms {
+		v, err := lookupVerifier(strings.TrimSpace(name))
+		if err != nil {
+			panic("ServerOptions.Algorithms: " + err.Error())
+		}
+		h.verifiers = append(h.verifiers, v)
+	}
+	if opts.RequireHMAC {
+		keyed := func(v trailerVerifier) bool { return v.Keyed }
+		switch {
+		case len(opts.HMACKey) == 0:
+			panic("ServerOptions.RequireHMAC: no HMACKey")
+		case opts.Algorithms != nil && !slices.ContainsFunc(h.verifiers, keyed):
+			panic("ServerOptions.RequireHMAC: ServerOptions.Algorithms has no keyed verifier")
+		}
+	}
+	contentDigest := opts.Algorithms == nil || slices.ContainsFunc(h.verifiers, func(v trailerVerifier) bool { return v.Algorithm == "content-digest" })
+	if opts.SignatureKeys != nil && !contentDigest {
+		panic("ServerOptions.SignatureKeys: ServerOptions.Algorithms has no content-digest verifier")
+	}
+	if opts.TokenKeys != nil && !contentDigest {
+		panic("ServerOptions.TokenKeys: ServerOptions.Algorithms has no content-digest verifier")
+	}
+	if opts.TimestampSkew != 0 && !contentDigest {
+		panic("ServerOptions.TimestampSkew: ServerOptions.Algorithms has no content-digest verifier")
+	}
+	switch {
+	case opts.Nonces != nil && len(opts.HMACKey) == 0:
+		panic("ServerOptions.Nonces: no HMACKey")
+	case opts.Nonces != nil && !contentDigest:
+		panic("ServerOptions.Nonces: ServerOptions.Algorithms has no content-digest verifier")
+	}
+	if opts.MetadataSchema != nil {
+		schema, err := compileSchema(opts.MetadataSchema)
+		if err != nil {
+			panic("ServerOptions.MetadataSchema: " + err.Error())
+		}
+		h.schema = schema
+	}
+	return h
+} // NewHandler() func
+
+// activeVerifiers returns the checks for one request, with the trailer fields renamed per opts.TrailerNames.
+// Without explicit opts.Algorithms they are read from the registry, so later registrations apply.
+func (h *Handler) activeVerifiers() []trailerVerifier {
+	verifiers := slices.Clone(h.verifiers)
+	if h.opts.Algorithms == nil {
+		verifiers = trailerVerifiers.all()
+	}
+	for i, v := range verifiers {
+		verifiers[i] = v.renamed(h.opts.TrailerNames)
+	}
+	return verifiers
+} // activeVerifiers() func
+
+// requestLogger returns the handler's logger with the fields identifying the request summarized by s
+func (h *Handler) requestLogger(s *UploadResult) *slog.Logger {
+	log := h.logger.With("request_id", s.RequestID, "method", s.Method, "proto", s.Proto)
+	if s.ClientIdentity != "" {
+		log = log.With("client", s.ClientIdentity)
+	}
+	return log
+} // requestLogger() func
+
+// debug logs at slog.LevelDebug, only when the handler is verbose
+func (h *Handler) debug(log *slog.Logger, msg string, args ...any) {
+	if h.opts.Verbose {
+		log.Debug(msg, args...)
+	}
+} // debug() func
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close() // Ensure the request body is closed
+	if r.Method == http.MethodOptions {
+		h.serveOptions(w) // the client asks which trailers to send; see Client.Negotiate
+		return
+	}
+	summary := &UploadResult{RequestID: requestID(r), Method: r.Method, Proto: r.Proto, HeaderCount: len(r.Header)}
+	summary.timing.start = time.Now()
+	if cert := ClientCertificate(r); cert != nil {
+		summary.ClientIdentity = cert.Subject.String()
+	}
+	log := h.requestLogger(summary)
+	w.Header().Set(requestIDHeader, summary.RequestID)
+	defer h.writeSummary(log, summary)
+	if h.opts.OnIntegrityFailure != nil {
+		defer func() {
+			if err := summary.verificationError(); err != nil {
+				h.opts.OnIntegrityFailure(err, summary)
+			}
+		}()
+	}
+	defer recordMetrics(summary)
+	if h.opts.TrailerLog != nil {
+		defer h.opts.TrailerLog.record(r, summary.timing.start, summary)
+	}
+	if traceRequest != nil {
+		var endSpan func(*UploadResult)
+		summary.trace, endSpan = traceRequest(r)
+		defer endSpan(summary)
+	}
+	if h.opts.TraceResponse && !summary.trace.IsValid() {
+		summary.trace = newServerTrace(r) // no tracing, or a no-op TracerProvider
+	}
+
+	// 1. Log initial request headers
+	h.debug(log, "Received request", "header", r.Header)
+
+	// An aws-chunked body announces its trailers in x-amz-trailer instead
+	if isAWSChunked(r) {
+		announceAWSTrailers(r)
+	}
+
+	// Check if the client announced a trailer header
+	announced := AnnouncedTrailers(r)
+	h.debug(log, "Announced trailers", "trailers", announced)
+
+	if h.opts.StripForbiddenTrailers {
+		var ignored []string
+		announced = slices.DeleteFunc(announced, func(name string) bool {
+			if isForbiddenTrailer(name) {
+				ignored = append(ignored, name)
+				return true
+			}
+			return false
+		})
+		if len(ignored) > 0 {
+			stripForbiddenTrailers(r.Trailer)
+			log.Warn("Ignoring announced trailers not allowed in a trailer section", "trailers", ignored)
+		}
+	}
+	summary.AnnouncedTrailers = announced
+
+	// Some fields must never be sent as trailers; refuse the upload if any were announced
+	if err := validateTrailerNames(announced); err != nil {
+		log.Warn("Rejected request announcing an illegal trailer", "err", err)
+		summary.Error = err.Error()
+		h.respond(w, http.StatusBadRequest, summary)
+		return
+	}
+
+	if h.opts.MaxTrailerFields > 0 && len(announced) > h.opts.MaxTrailerFields {
+		err := fmt.Errorf("%w: request announces %d trailer fields, more than the %d allowed", ErrTrailerTooLarge, len(announced), h.opts.MaxTrailerFields)
+		log.Warn("Rejected request announcing too many trailers", "err", err)
+		summary.Error = err.Error()
+		h.respond(w, http.StatusRequestHeaderFieldsTooLarge, summary)
+		return
+	}
+
+	verifiers := h.activeVerifiers()
+	if format, ok := recordFormat(r.Header); ok {
+		verifiers = append(verifiers, recordVerifier(format))
+	}
+
+	// A request without the keyed trailer cannot show it was not tampered with
+	if h.opts.RequireHMAC && !slices.ContainsFunc(verifiers, func(v trailerVerifier) bool {
+		_, announced := lookupField(r.Trailer, v.TrailerName)
+		_, inHeader := lookupField(r.Header, v.TrailerName)
+		return v.Keyed && (announced || inHeader)
+	}) {
+		log.Warn("Rejected request without an HMAC trailer")
+		summary.Error = "request does not announce an HMAC trailer"
+		h.respond(w, http.StatusUnauthorized, summary)
+		return
+	}
+
+	// Nor can a request that will not send a body token be authorized
+	if _, announced := lookupField(r.Trailer, BodyTokenTrailer); h.opts.TokenKeys != nil && !announced {
+		log.Warn("Rejected request without a body token")
+		summary.Error = "request does not announce a body token"
+		h.respond(w, http.StatusUnauthorized, summary)
+		return
+	}
+	if _, announced := lookupField(r.Trailer, NonceTrailer); h.opts.Nonces != nil && !announced {
+		log.Warn("Rejected request without a nonce")
+		summary.Error = "request does not announce a nonce"
+		h.respond(w, http.StatusUnauthorized, summary)
+		return
+	}
+
+	// Decide on the initial headers alone whether the body is worth receiving
+	if h.opts.Admit != nil {
+		if status, reason := h.opts.Admit(r); status != 0 {
+			log.Info("Rejected upload before reading the body", "expect", r.Header.Get("Expect"), "status", status, "reason", reason)
+			summary.Error = reason
+			h.respond(w, status, summary)
+			return
+		}
+	}
+
+	// Nor is a body the server already holds
+	if h.opts.ETags != nil {
+		if etag := matchingETag(r, h.opts.ETags); etag != "" {
+			h.debug(log, "Upload matches stored content; not reading the body", "etag", etag)
+			summary.ETag, summary.Error = etag, "content already stored"
+			w.Header().Set("ETag", etag)
+			h.respond(w, http.StatusPreconditionFailed, summary)
+			return
+		}
+	}
+
+	// Under the strict policy, trailers announced on a body that cannot carry them fail the upload
+	// from the headers alone, unless its fields came as headers too; the client can resend them so
+	if h.opts.Policy == PolicyStrict && len(announced) > 0 && !canCarryTrailers(r) && len(integrityHeaders(r, verifiers)) == 0 {
+		err := trailerFramingError(r)
+		log.Warn("Rejected request announcing trailers it cannot carry", "err", err)
+		summary.Inconclusive, summary.Error = true, err.Error()
+		status := h.opts.RejectStatus
+		if status == 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		h.reject(w, status, summary, nil)
+		return
+	}
+
+	// Start a digest for every verifier whose trailer was announced, or whose field came in the
+	// headers from a client that cannot send trailers, so the checks are computed while the body streams in.
+	digests := make(map[string]bodyDigest)
+	digestWriters := []io.Writer{}
+	encodedWriters := []io.Writer{} // digests over the body as sent, before any gzip decoding
+	headerFields := integrityHeaders(r, verifiers)
+	for _, v := range verifiers {
+		_, inHeader := lookupField(headerFields, v.TrailerName)
+		if _, announced := lookupField(r.Trailer, v.TrailerName); announced || inHeader {
+			d := v.NewDigest(h.opts.HMACKey)
+			digests[v.TrailerName] = d
+			if v.Encoded {
+				encodedWriters = append(encodedWriters, d)
+			} else {
+				digestWriters = append(digestWriters, d)
+			}
+		}
+	}
+
+	// The sink stores the body in the same pass; unless it is committed below, it is told to discard it
+	committed := false
+	if h.opts.BodySink != nil {
+		digestWriters = append(digestWriters, sinkWriter{w: h.opts.BodySink})
+		defer func() {
+			if !committed {
+				if err := discardSink(h.opts.BodySink); err != nil {
+					log.Error("Error discarding stored request body", "err", err)
+				}
+			}
+		}()
+	}
+
+	// Only verbose mode keeps a copy of the body, for logging
+	var bodyCopy bytes.Buffer
+	if h.opts.Verbose {
+		digestWriters = append(digestWriters, &bodyCopy)
+	}
+
+	// A gzip-encoded body is decompressed while it streams, so the length and
+	// digests are checked against the original (uncompressed) bytes.
+	reqBody := io.ReadCloser(&requestBody{ReadCloser: r.Body})
+	if h.opts.Throttle != nil {
+		reqBody = newThrottledBody(r.Context(), reqBody, *h.opts.Throttle)
+	}
+	if h.opts.LogReads {
+		reqBody = &readLogger{ReadCloser: reqBody, logger: log, count: &summary.Reads}
+	}
+	if canCarryTrailers(r) {
+		reqBody = &trailerClock{ReadCloser: reqBody, wait: &summary.TrailerWait}
+	}
+	if h.opts.TrailerTimeout > 0 && canCarryTrailers(r) {
+		watchdog := newRequestWatchdog(w, reqBody, h.opts.TrailerTimeout)
+		defer watchdog.stop()
+		reqBody = watchdog
+	}
+	body := io.Reader(reqBody)
+	if h.opts.MaxBodyBytes > 0 {
+		body = newLimitedBody(w, reqBody, &r.Trailer, h.opts.MaxBodyBytes, h.opts.DrainOversizedBytes)
+	}
+	if len(encodedWriters) > 0 {
+		body = io.TeeReader(body, io.MultiWriter(encodedWriters...))
+	}
+	if isAWSChunked(r) {
+		body = newAWSChunkedReader(r, body)
+	}
+	gzipped := strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip")
+	rawBody := body // what the gzip decoder consumes, drained to check a corrupt body
+	if gzipped {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			log.Warn("Invalid gzip request body", "err", err)
+			summary.Error = "Invalid gzip request body"
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+		defer zr.Close()
+		body = zr
+		if h.opts.MaxBodyBytes > 0 {
+			body = http.MaxBytesReader(w, zr, h.opts.MaxBodyBytes) // guards against decompression bombs
+		}
+	}
+
+	// 2. Read the request body completely.
+	// Trailer headers are only available *after* the body is fully read.
+	// A multipart body is read part by part, but the trailers still cover the raw bytes.
+	var bodyLength int64
+	var err error
+	readStart := time.Now()
+	if boundary := multipartBoundary(r); boundary != "" {
+		bodyLength, summary.Parts, err = streamMultipart(io.MultiWriter(digestWriters...), body, boundary, announcesPartDigests(r.Trailer))
+	} else {
+		bodyLength, err = streamBody(io.MultiWriter(digestWriters...), body)
+	}
+	summary.timing.read = time.Since(readStart)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			summary.Error = fmt.Sprintf("Request body exceeds the %d byte limit", tooLarge.Limit)
+			var limitErr *BodyLimitError
+			if errors.As(err, &limitErr) && limitErr.Complete {
+				// DrainOversizedBytes let the rest of the body, and its trailers, arrive
+				summary.BodyLength = limitErr.Size
+				summary.DeliveredTrailers = deliveredTrailers(r.Trailer)
+				lengthField := trailerVerifier{Algorithm: "length", TrailerName: trailerHeaderName}.renamed(h.opts.TrailerNames).TrailerName
+				if reportedLength, err := Trailers(r.Trailer).GetInt64(lengthField); err == nil {
+					summary.ReportedLength = &reportedLength
+				}
+				summary.Error = fmt.Sprintf("Request body of %d bytes exceeds the %d byte limit", limitErr.Size, limitErr.Limit)
+			}
+			log.Warn("Request body exceeds the limit", "limit", tooLarge.Limit, "bytes", summary.BodyLength, "trailers", summary.DeliveredTrailers)
+			h.respond(w, http.StatusRequestEntityTooLarge, summary)
+			return
+		}
+		if isCorruptGzip(err) {
+			// A digest of the bytes as sent tells whether they were damaged or sent that way
+			results := wireChecks(rawBody, r.Trailer, verifiers, digests)
+			for _, result := range results {
+				h.logVerificationResult(log, result)
+				summary.addCheck(result)
+			}
+			err = corruptCodingError(err, results)
+			log.Warn("Corrupt gzip request body", "bytes", bodyLength, "err", err)
+			summary.Error = "Corrupt gzip request body"
+			switch {
+			case errors.Is(err, ErrTransportCorruption):
+				summary.Error += ": corrupted in transit"
+			case errors.Is(err, ErrEncodingMismatch):
+				summary.Error += ": it arrived intact, so the sender's gzip stream is malformed"
+			}
+			summary.Matched = false
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+		if errors.Is(err, errMalformedMultipart) {
+			log.Warn("Malformed multipart request body", "bytes", bodyLength, "err", err)
+			summary.Error = "Malformed multipart request body"
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+		if errors.Is(err, errMalformedAWSChunked) {
+			log.Warn("Malformed aws-chunked request body", "bytes", bodyLength, "err", err)
+			summary.Error = "Malformed aws-chunked request body"
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+		if errors.Is(err, ErrTrailerTimeout) {
+			log.Warn("No trailer section in time after the last body bytes", "timeout", h.opts.TrailerTimeout, "bytes", bodyLength, "err", err)
+			summary.Error = "Timed out waiting for the trailer section"
+			h.respond(w, http.StatusRequestTimeout, summary)
+			return
+		}
+		if isTimeout(err) {
+			log.Warn("Timed out reading request body", "bytes", bodyLength, "err", err)
+			summary.Error = "Timed out reading request body"
+			h.respond(w, http.StatusRequestTimeout, summary)
+			return
+		}
+		if isTruncated(r, err) {
+			log.Warn("Truncated upload: the body ended before its trailers", "bytes", bodyLength, "err", err)
+			summary.BodyLength = bodyLength
+			summary.Error = fmt.Sprintf("Truncated upload: the body ended after %d bytes, before its trailers", bodyLength)
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+		if errors.Is(err, errBodySink) {
+			log.Error("Error storing request body", "bytes", bodyLength, "err", err)
+			summary.Error = "Error storing request body"
+			h.respond(w, http.StatusInternalServerError, summary)
+			return
+		}
+		if isMalformedChunked(r, err) {
+			log.Warn("Malformed chunked request body", "bytes", bodyLength, "err", err)
+			summary.BodyLength = bodyLength
+			summary.Error = "Malformed chunked request body"
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+		log.Error("Error reading request body", "err", err)
+		summary.Error = "Error reading request body"
+		h.respond(w, http.StatusInternalServerError, summary)
+		return
+	}
+
+	h.debug(log, "Read request body", "bytes", bodyLength, "body", bodyCopy.String())
+	for _, part := range summary.Parts {
+		h.debug(log, "Multipart part", "name", part.Name, "file", part.FileName, "bytes", part.Length)
+	}
+	summary.BodyLength = bodyLength
+
+	// An HTTP/1.1 trailer section may also carry fields that were never announced
+	if h.opts.StripForbiddenTrailers {
+		if stripped := stripForbiddenTrailers(r.Trailer); len(stripped) > 0 {
+			log.Warn("Dropped delivered trailers not allowed in a trailer section", "trailers", stripped)
+		}
+	} else if err := validateTrailerNames(slices.Sorted(maps.Keys(r.Trailer))); err != nil {
+		log.Warn("Rejected request delivering an illegal trailer", "err", err)
+		summary.Error = err.Error()
+		h.respond(w, http.StatusBadRequest, summary)
+		return
+	}
+	if h.opts.OnTrailerReceived != nil {
+		receivedTrailers(r.Trailer, h.opts.OnTrailerReceived)
+	}
+
+	if h.opts.RejectUnannouncedTrailers {
+		if err := unannouncedTrailerError(announced, r.Trailer, h.opts.AllowedUnannouncedTrailers); err != nil {
+			log.Warn("Rejected request delivering unannounced trailers", "err", err)
+			summary.Error = err.Error()
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+	}
+
+	if err := h.checkTrailerLimits(r.Trailer); err != nil {
+		log.Warn("Rejected oversized trailer section", "err", err)
+		summary.Error = err.Error()
+		h.respond(w, http.StatusRequestHeaderFieldsTooLarge, summary)
+		return
+	}
+
+	if values, _ := lookupField(r.Trailer, MetadataTrailer); len(values) > 0 {
+		metadata, err := h.checkMetadata(values[0])
+		if err != nil {
+			log.Warn("Rejected invalid metadata trailer", "err", err)
+			summary.Error = err.Error()
+			status := http.StatusBadRequest
+			if errors.Is(err, errMetadataSchema) {
+				status = http.StatusUnprocessableEntity
+			}
+			h.respond(w, status, summary)
+			return
+		}
+		summary.Metadata = metadata
+	}
+
+	// 3. Access the trailer headers from the request object.
+	// This map is populated by the server *after* the body is read.
+	h.debug(log, "Received trailers", "trailer", r.Trailer)
+	verifyStart := time.Now()
+	if len(r.Trailer) > 0 || len(headerFields) > 0 {
+		summary.DeliveredTrailers = deliveredTrailers(r.Trailer)
+		// Process the trailer headers we know how to verify, and those that came as headers
+		var results []VerificationResult
+		for _, v := range verifiers {
+			fields := r.Trailer
+			if _, inHeader := lookupField(headerFields, v.TrailerName); inHeader {
+				fields = headerFields
+			}
+			d, computed := digests[v.TrailerName]
+			if reported, ok := v.reported(fields); computed && ok {
+				results = append(results, v.verify(d, reported))
+				if v.Algorithm == "length" {
+					if reportedLength, err := Trailers(fields).GetInt64(v.TrailerName); err == nil {
+						summary.ReportedLength = &reportedLength
+					}
+				}
+			}
+		}
+		if gzipped {
+			diagnoseCoding(results) // tell transport corruption from a gzip encoding bug
+		}
+		if summary.Parts != nil {
+			results = append(results, verifyParts(summary.Parts, r.Trailer)...)
+		}
+		for _, result := range results {
+			h.logVerificationResult(log, result)
+			summary.addCheck(result)
+		}
+		if values, _ := lookupField(r.Trailer, signatureTrailer); h.opts.SignatureKeys != nil && len(values) > 0 {
+			result := verifyMessageSignature(r, h.opts.SignatureKeys, h.opts.SignatureMaxAge, summary.digestBound())
+			h.logVerificationResult(log, result)
+			summary.addCheck(result)
+		}
+		if h.opts.TokenKeys != nil {
+			result := checkBodyToken(r.Trailer, h.opts.TokenKeys)
+			h.logVerificationResult(log, result)
+			summary.addCheck(result)
+		}
+		if values, _ := lookupField(r.Trailer, TimestampTrailer); len(values) > 0 {
+			result := checkTimestamp(r.Trailer, h.opts.HMACKey, h.opts.TimestampSkew, summary.digestBound())
+			h.logVerificationResult(log, result)
+			summary.addCheck(result)
+		}
+	} else if len(announced) == 0 {
+		log.Info("No trailers received")
+	}
+	summary.timing.verify = time.Since(verifyStart)
+
+	// Trailers can only follow a chunked HTTP/1.1 body. If a proxy buffered the request
+	// and re-sent it with a Content-Length, any trailers were stripped on the way and
+	// there is nothing to compare, so the integrity check must not count as a pass.
+	if len(announced) > 0 && !canCarryTrailers(r) {
+		err := trailerFramingError(r)
+		summary.Inconclusive, summary.Matched, summary.Error = true, false, err.Error()
+		log.Warn("Trailers were announced but the request is not chunked; verification inconclusive",
+			"trailers", announced, "err", err)
+	}
+
+	// Announcing a trailer and then never sending it is a protocol violation,
+	// not the same thing as a request without trailers.
+	if missing := missingTrailers(announced, r.Trailer); len(missing) > 0 && !summary.Inconclusive {
+		summary.MissingTrailers, summary.Matched = missing, false
+		err := missingTrailerError(missing)
+		if h.opts.Policy != PolicyIgnore {
+			log.Warn("Announced trailers were never sent", "err", err)
+			summary.Error = err.Error()
+			h.reject(w, http.StatusBadRequest, summary, h.responseTrailers(w, log, r))
+			return
+		}
+		h.debug(log, "Announced trailers were never sent (ignored by policy)", "err", err)
+	}
+
+	// The body token authorizes the upload only once the body has been seen to match it
+	if h.opts.TokenKeys != nil {
+		if reason := tokenAuthorization(summary.Checks); reason != "" {
+			log.Warn("Rejected unauthorized upload", "reason", reason)
+			summary.Error = reason
+			h.reject(w, http.StatusUnauthorized, summary, h.responseTrailers(w, log, r))
+			return
+		}
+	}
+
+	// A nonce is spent by a body that verified, and refuses it a second time, whatever the Policy
+	var spentNonce string
+	if h.opts.Nonces != nil {
+		result := checkNonce(r.Trailer, h.opts.HMACKey, h.opts.Nonces, h.opts.NonceTTL, summary.Matched, summary.digestBound())
+		h.logVerificationResult(log, result)
+		summary.addCheck(result)
+		if result.Err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(result.Err, ErrReplayedNonce) {
+				status = http.StatusConflict
+			}
+			log.Warn("Rejected upload by its nonce", "err", result.Err)
+			summary.Error = result.Err.Error()
+			h.reject(w, status, summary, h.responseTrailers(w, log, r))
+			return
+		}
+		if summary.Matched {
+			spentNonce = result.Computed
+		}
+	}
+
+	// Under the strict policy only a verified body is accepted
+	if h.opts.Policy == PolicyStrict && !summary.Matched {
+		switch {
+		case summary.Inconclusive: // summary.Error says why
+		case len(summary.Checks) == 0:
+			summary.Error = "request carries no integrity trailer"
+		default:
+			summary.Error = "integrity check failed"
+		}
+		log.Warn("Rejected unverified upload", "reason", summary.Error)
+		status := h.opts.RejectStatus
+		switch {
+		case status != 0:
+		case !summary.digestBound() && slices.ContainsFunc(summary.Checks, func(c CheckSummary) bool { return c.Algorithm == "message-signature" }):
+			status = http.StatusBadRequest // the signature covers a Content-Digest never checked against the body
+		default:
+			status = http.StatusUnprocessableEntity
+		}
+		h.reject(w, status, summary, h.responseTrailers(w, log, r))
+		return
+	}
+
+	// Only a body that verified is kept
+	if h.opts.BodySink != nil && summary.Matched {
+		if err := commitSink(h.opts.BodySink); err != nil {
+			log.Error("Error committing stored request body", "err", err)
+			if spentNonce != "" {
+				forgetNonce(h.opts.Nonces, spentNonce) // the upload was not kept, so it may be sent again
+			}
+			summary.Error = "Error storing request body"
+			h.respondWithTrailer(w, http.StatusInternalServerError, summary, h.responseTrailers(w, log, r))
+			return
+		}
+		committed, summary.Stored = true, true
+	}
+
+	// A verified body is identified by its digest
+	if summary.Matched {
+		if summary.ETag = uploadETag(summary.Checks, gzipped); summary.ETag != "" {
+			w.Header().Set("ETag", summary.ETag)
+			if h.opts.ETags != nil && (h.opts.BodySink == nil || summary.Stored) {
+				h.opts.ETags.AddETag(summary.ETag)
+			}
+		}
+	}
+
+	// 4. Send the verification result back to the client,
+	// reflecting the trailers as they arrived (after any proxies) in the response trailers
+	h.respondWithTrailer(w, http.StatusOK, summary, h.responseTrailers(w, log, r))
+} // ServeHTTP() func
+
+// writeSummary logs the outcome of the request summarized by s and emits s to the configured
+// summary output, if any
+func (h *Handler) writeSummary(log *slog.Logger, s *UploadResult) {
+	log.Info("Handled request", "bytes", s.BodyLength, "outcome", s.Outcome, "matched", s.Matched, "checks", len(s.Checks))
+	if h.opts.SummaryOutput == nil {
+		return
+	}
+	if err := writeSummary(h.opts.SummaryOutput, s); err != nil {
+		log.Error("Error writing JSON summary", "err", err)
+	}
+} // writeSummary() func
+
+// respond sends the verification result to the client as JSON
+func (h *Handler) respond(w http.ResponseWriter, status int, result *UploadResult) {
+	h.respondWithTrailer(w, status, result, nil)
+} // respond() func
+
+// reject answers an upload that did not verify, with a Problem under opts.ProblemDetails
+func (h *Handler) reject(w http.ResponseWriter, status int, result *UploadResult, trailer http.Header) {
+	if !h.opts.ProblemDetails {
+		h.respondWithTrailer(w, status, result, trailer)
+		return
+	}
+	result.Outcome = classifyOutcome(result)
+	h.writeResponse(w, status, "application/problem+json", newProblem(status, result), result, trailer)
+} // reject() func
+
+// respondWithTrailer sends the verification result followed by response trailers
+func (h *Handler) respondWithTrailer(w http.ResponseWriter, status int, result *UploadResult, trailer http.Header) {
+	result.Outcome = classifyOutcome(result)
+	h.writeResponse(w, status, "application/json", result, result, trailer)
+} // respondWithTrailer() func
+
+// writeResponse sends body as JSON followed by response trailers. The trailer names are
+// declared in the Trailer header before the status line is written; their values are only
+// set after the body, as net/http requires.
+func (h *Handler) writeResponse(w http.ResponseWriter, status int, contentType string, body any, result *UploadResult, trailer http.Header) {
+	// A client that accepts trailers also gets the outcome in the status trailers
+	if trailer != nil {
+		h.opts.StatusTrailers.Set(trailer, status, result.Error)
+	}
+	if trailer != nil && h.opts.ServerTiming {
+		trailer[ServerTimingTrailer] = nil // set once the body is written, when the handler is done
+	}
+	if trailer != nil && h.opts.TraceResponse && result.trace.IsValid() {
+		trailer.Set(TraceResponseTrailer, result.trace.String())
+	}
+	for name := range trailer {
+		w.Header().Add("Trailer", name)
+	}
+	log := h.requestLogger(result)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error("Error writing response", "err", err)
+		return
+	}
+	if _, ok := trailer[ServerTimingTrailer]; ok {
+		trailer.Set(ServerTimingTrailer, result.timing.value())
+	}
+	for name, values := range trailer {
+		w.Header()[name] = values
+	}
+	h.debug(log, "Sent response", "status", status, "trailer", trailer)
+} // writeResponse() func
+
+// logVerificationResult reports the outcome of a single trailer check
+func (h *Handler) logVerificationResult(log *slog.Logger, result VerificationResult) {
+	log = log.With("algorithm", result.Algorithm, "trailer", result.TrailerName)
+	h.debug(log, "Checked trailer", "reported", result.Reported, "computed", result.Computed)
+	info, warn := log.Info, log.Warn
+	if h.opts.Policy == PolicyIgnore {
+		info, warn = log.Debug, log.Debug
+	}
+	switch {
+	case result.Matched:
+		info("Body matches trailer. Integrity check successful!")
+	case errors.Is(result.Err, ErrLengthMismatch), errors.Is(result.Err, ErrHashMismatch):
+		warn("Body DOES NOT match trailer. Data integrity issue!", "err", result.Err)
+	case errors.Is(result.Err, ErrMalformedTrailer):
+		warn("Could not parse trailer", "reported", result.Reported, "err", result.Err)
+	default:
+		warn("Could not verify trailer", "err", result.Err)
+	}
+} // logVerificationResult() func
+
+// bodyReadError is an error net/http returned reading the request body itself, as opposed to
+// one from a reader or writer this package stacks on top of it
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string { return e.err.Error() }
+
+func (e *bodyReadError) Unwrap() error { return e.err }
+
+// requestBody marks the errors of r.Body as bodyReadError, at the bottom of the readers over it
+type requestBody struct {
+	io.ReadCloser
+}
+
+func (rb *requestBody) Read(p []byte) (int, error) {
+	n, err := rb.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = &bodyReadError{err: err}
+	}
+	return n, err
+}
+
+// readLogger logs the size of every read from a request body. A chunked HTTP/1.1 body
+// yields at most one chunk per read unless chunks were already buffered together, so the
+// sizes show how the client (or a proxy that recombines chunks) framed the body.
+type readLogger struct {
+	io.ReadCloser
+	logger *slog.Logger
+	count  *int  // reads that returned data
+	total  int64 // bytes read so far
+}
+
+func (rl *readLogger) Read(p []byte) (int, error) {
+	n, err := rl.ReadCloser.Read(p)
+	if n > 0 {
+		*rl.count++
+		rl.total += int64(n)
+		rl.logger.Info("Body read", "read", *rl.count, "bytes", n, "total", rl.total)
+	}
+	if err != nil && err != io.EOF {
+		rl.logger.Warn("Body read failed", "total", rl.total, "err", err)
+	}
+	return n, err
+}
+
+// trailerClock measures the trailer phase of a request body: the time from the read that
+// returned the last body bytes to the read that returned io.EOF, once the trailers were in
+type trailerClock struct {
+	io.ReadCloser
+	wait     *time.Duration
+	lastData time.Time
+}
+
+func (tc *trailerClock) Read(p []byte) (int, error) {
+	n, err := tc.ReadCloser.Read(p)
+	if n > 0 {
+		tc.lastData = time.Now()
+	}
+	if err == io.EOF && !tc.lastData.IsZero() {
+		*tc.wait = time.Since(tc.lastData)
+	}
+	return n, err
+}
+
+// trailerWatchdog fails a body read that has waited longer than timeout since the last body
+// bytes arrived, by calling abort to make the pending read return: for a request body, an
+// expired read deadline on the connection or stream; for a response body, closing it
+type trailerWatchdog struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+// newTrailerWatchdog starts the clock on body
+func newTrailerWatchdog(body io.ReadCloser, timeout time.Duration, abort func()) *trailerWatchdog {
+	wd := &trailerWatchdog{ReadCloser: body, timeout: timeout}
+	wd.timer = time.AfterFunc(timeout, func() {
+		wd.fired.Store(true)
+		abort()
+	})
+	return wd
+} // newTrailerWatchdog() func
+
+// newRequestWatchdog starts the clock on body, the request body of w
+func newRequestWatchdog(w http.ResponseWriter, body io.ReadCloser, timeout time.Duration) *trailerWatchdog {
+	rc := http.NewResponseController(w)
+	return newTrailerWatchdog(body, timeout, func() { rc.SetReadDeadline(time.Now()) })
+} // newRequestWatchdog() func
+
+func (wd *trailerWatchdog) Read(p []byte) (int, error) {
+	n, err := wd.ReadCloser.Read(p)
+	switch {
+	case err != nil:
+		wd.stop()
+		if wd.fired.Load() && err != io.EOF { // net/http may report the expired deadline as a truncated body
+			err = fmt.Errorf("%w: %w", ErrTrailerTimeout, err)
+		}
+	case n > 0:
+		wd.timer.Reset(wd.timeout)
+	}
+	return n, err
+}
+
+func (wd *trailerWatchdog) Close() error {
+	wd.stop()
+	return wd.ReadCloser.Close()
+}
+
+// stop stops the clock, once the body has ended or the handler returns
+func (wd *trailerWatchdog) stop() {
+	wd.timer.Stop()
+}
+
+// responseTrailers returns the trailers to send back: the received ones echoed, but only to a
+// client that announced it accepts trailers with "TE: trailers" (RFC 9110, Section 10.1.4).
+// Other clients, and intermediaries in front of them, may drop or choke on a trailer section;
+// for them the TrailerFallback applies to w.
+func (h *Handler) responseTrailers(w http.ResponseWriter, log *slog.Logger, r *http.Request) http.Header {
+	echo := echoTrailers(r.Trailer)
+	if !acceptsTrailers(r) {
+		h.debug(log, "Client did not send \"TE: trailers\"; omitting response trailers", "fallback", h.opts.TrailerFallback)
+		code, _ := h.opts.StatusTrailers.names()
+		h.opts.TrailerFallback.omit(w.Header(), append(slices.Sorted(maps.Keys(echo)), code))
+		return nil
+	}
+	return echo
+} // responseTrailers() func
+
+// requestIDHeader carries the ID that ties the client's and the server's log lines for one request together
+const requestIDHeader = "X-Request-Id"
+
+// requestID returns the X-Request-Id of r if it has a usable one, and a new random ID otherwise
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 128 && !strings.ContainsFunc(id, func(c rune) bool { return c < ' ' || c > '~' }) {
+		return id
+	}
+	return newRequestID()
+} // requestID() func
+
+// newRequestID returns a random 16 hex digit request ID
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+} // newRequestID() func
+
+// acceptsTrailers reports whether the TE header of r lists "trailers", or r came over HTTP/2 or
+// HTTP/3, whose framing always carries trailers and whose TE can say nothing else
+func acceptsTrailers(r *http.Request) bool {
+	if r.ProtoMajor >= 2 {
+		return true
+	}
+	for _, value := range r.Header.Values("TE") {
+		for _, coding := range strings.Split(value, ",") {
+			coding, _, _ = strings.Cut(coding, ";")
+			if strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+} // acceptsTrailers() func
+
+// deliveredTrailers returns the sorted names of the trailer fields that carried a value
+func deliveredTrailers(trailer http.Header) []string {
+	var names []string
+	for name, values := range trailer {
+		if len(values) > 0 {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+} // deliveredTrailers() func
+
+// echoTrailers copies every received trailer field under an "X-Received-" prefix
+func echoTrailers(received http.Header) http.Header {
+	echo := http.Header{}
+	for name, values := range received {
+		if len(values) > 0 {
+			echo[http.CanonicalHeaderKey("X-Received-"+name)] = slices.Clone(values)
+		}
+	}
+	return echo
+} // echoTrailers() func
+
+// canCarryTrailers reports whether the request framing allows a trailer section:
+// a chunked body under HTTP/1.1, an aws-chunked body, or any HTTP/2 (or later) request.
+func canCarryTrailers(r *http.Request) bool {
+	return r.ProtoMajor >= 2 || slices.Contains(r.TransferEncoding, "chunked") || isAWSChunked(r)
+} // canCarryTrailers() func
+
+// trailerFramingError describes why a request whose framing canCarryTrailers rejects has no
+// trailer section, wrapping ErrTrailersNotCarried
+func trailerFramingError(r *http.Request) error {
+	if !r.ProtoAtLeast(1, 1) {
+		return fmt.Errorf("%w: the request came over %s, which has no chunked encoding; a hop on the way may have downgraded it", ErrTrailersNotCarried, r.Proto)
+	}
+	return fmt.Errorf("%w: the body came with a Content-Length of %d instead of chunked; an intermediary may have buffered it and dropped the trailers", ErrTrailersNotCarried, r.ContentLength)
+} // trailerFramingError() func
+
+// AnnouncedTrailers returns the canonical trailer names the client announced in its Trailer
+// header, sorted; an Admit hook can call it to refuse a request before its body.
+// net/http removes the "Trailer" header and instead pre-populates r.Trailer with the
+// announced names (with nil values) before the body is read; the header itself is
+// still consulted for requests that were not parsed by net/http.
+func AnnouncedTrailers(r *http.Request) []string {
+	var names []string
+	for name := range r.Trailer {
+		names = append(names, name)
+	}
+	for _, value := range r.Header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+} // AnnouncedTrailers() func
+
+// forbiddenTrailers lists fields that must not be sent in a trailer section (RFC 9110, Section 6.5.1):
+// message framing, routing, request modifiers, authentication, response control data,
+// content processing information, and hop-by-hop fields.
+var forbiddenTrailers = map[string]bool{
+	"Content-Length": true, "Transfer-Encoding": true, "Trailer": true, "Te": true,
+	"Host":       true,
+	"Connection": true, "Keep-Alive": true, "Proxy-Connection": true, "Upgrade": true,
+	"Cache-Control": true, "Expect": true, "Max-Forwards": true, "Pragma": true, "Range": true,
+	"If-Match": true, "If-None-Match": true, "If-Modified-Since": true, "If-Unmodified-Since": true, "If-Range": true,
+	"Authorization": true, "Proxy-Authorization": true, "Proxy-Authenticate": true, "Www-Authenticate": true,
+	"Cookie": true, "Set-Cookie": true,
+	"Age": true, "Date": true, "Expires": true, "Location": true, "Retry-After": true, "Vary": true,
+	"Content-Type": true, "Content-Encoding": true, "Content-Range": true,
+}
+
+// checkTrailerLimits returns an ErrTrailerTooLarge error describing the first of the ServerOptions
+// trailer limits trailer exceeds
+func (h *Handler) checkTrailerLimits(trailer http.Header) error {
+	fields, total := 0, 0
+	for name, values := range trailer {
+		for _, value := range values {
+			fields++
+			total += len(name) + len(value)
+			if h.opts.MaxTrailerValueBytes > 0 && len(value) > h.opts.MaxTrailerValueBytes {
+				return &TrailerError{Field: name, Expected: fmt.Sprintf("at most %d bytes", h.opts.MaxTrailerValueBytes),
+					Actual: fmt.Sprintf("%d bytes", len(value)), Err: ErrTrailerTooLarge}
+			}
+		}
+	}
+	switch {
+	case h.opts.MaxTrailerFields > 0 && fields > h.opts.MaxTrailerFields:
+		return fmt.Errorf("%w: %d trailer fields sent, more than the %d allowed", ErrTrailerTooLarge, fields, h.opts.MaxTrailerFields)
+	case h.opts.MaxTrailerBytes > 0 && total > h.opts.MaxTrailerBytes:
+		return fmt.Errorf("%w: %d bytes sent, more than the %d allowed", ErrTrailerTooLarge, total, h.opts.MaxTrailerBytes)
+	}
+	return nil
+} // checkTrailerLimits() func
+
+// isForbiddenTrailer reports whether the field name must not be sent in a trailer section
+func isForbiddenTrailer(name string) bool {
+	return forbiddenTrailers[http.CanonicalHeaderKey(name)]
+} // isForbiddenTrailer() func
+
+// validateTrailerNames returns an ErrForbiddenTrailer error naming the first trailer field that is not allowed in a trailer section
+func validateTrailerNames(names []string) error {
+	for _, name := range names {
+		if isForbiddenTrailer(name) {
+			return fmt.Errorf("%w: field %q (RFC 9110, Section 6.5.1)", ErrForbiddenTrailer, name)
+		}
+	}
+	return nil
+} // validateTrailerNames() func
+
+// stripForbiddenTrailers deletes the fields not allowed in a trailer section from trailer
+// and returns their names, sorted
+func stripForbiddenTrailers(trailer http.Header) []string {
+	var stripped []string
+	for name := range trailer {
+		if isForbiddenTrailer(name) {
+			stripped = append(stripped, name)
+			delete(trailer, name)
+		}
+	}
+	slices.Sort(stripped)
+	return stripped
+} // stripForbiddenTrailers() func
+
+// unannouncedTrailerError returns a *TrailerError for ErrUnannouncedTrailer naming the delivered trailer
+// fields that are neither in announced nor in allowed, or nil if there are none
+func unannouncedTrailerError(announced []string, delivered http.Header, allowed []string) error {
+	var extra []string
+	for name, values := range delivered {
+		name = http.CanonicalHeaderKey(name)
+		if len(values) > 0 && !slices.Contains(announced, name) &&
+			!slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(a, name) }) {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	slices.Sort(extra)
+	return &TrailerError{Field: strings.Join(extra, ", "), Err: ErrUnannouncedTrailer}
+} // unannouncedTrailerError() func
+
+// missingTrailers returns the announced trailer names that carried no value once the body was read.
+// announced must be taken before the body is read: an HTTP/3 server replaces r.Trailer with the
+// trailers actually received, dropping the announced keys.
+func missingTrailers(announced []string, trailer http.Header) []string {
+	var missing []string
+	for _, name := range announced {
+		if values, _ := lookupField(trailer, name); len(values) == 0 {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+} // missingTrailers() func
+
+// missingTrailerError returns a *TrailerError for ErrMissingTrailer naming the missing fields
+func missingTrailerError(missing []string) error {
+	return &TrailerError{Field: strings.Join(missing, ", "), Err: ErrMissingTrailer}
+} // missingTrailerError() func
+
+// lookupField finds a header or trailer field regardless of the case of its name.
+// Field names are case-insensitive (RFC 9110, Section 5.1); http.Header canonicalizes
+// the keys it parses, but maps built by hand or rewritten by intermediaries may not be.
+func lookupField(h http.Header, name string) ([]string, bool) {
+	if values, ok := h[http.CanonicalHeaderKey(name)]; ok {
+		return values, true
+	}
+	for key, values := range h {
+		if strings.EqualFold(key, name) {
+			return values, true
+		}
+	}
+	return nil, false
+} // lookupField() func
+
+// copyBufferPool recycles the buffers streamBody and VerifiedBody.WriteTo stream bodies through
+var copyBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// streamBody copies the body into dst through a pooled buffer and returns the number of bytes read.
+// Unlike io.ReadAll it allocates nothing per request, however large the body; every digest of
+// an upload is a writer of the one dst, so a single pass over the bytes feeds them all.
+func streamBody(dst io.Writer, body io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	// Hide any WriterTo/ReaderFrom so io.CopyBuffer really uses the pooled buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{body}, *buf)
+} // streamBody() func
+
+// isCorruptGzip reports whether err comes from decoding a malformed gzip stream
+func isCorruptGzip(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.As(err, &corrupt) || errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader)
+} // isCorruptGzip() func
+
+// isTimeout reports whether err was caused by an expired read deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+} // isTimeout() func
+
+// isTruncated reports whether a body read failed because the client stopped sending mid-body:
+// an HTTP/1.1 chunked body cut off before its last chunk, or an HTTP/2 stream the client reset,
+// which also cancels the request context.
+func isTruncated(r *http.Request, err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || (r.Context().Err() != nil && !isTimeout(err))
+} // isTruncated() func
+
+// isMalformedChunked reports whether a body read failed on the framing the client sent. net/http
+// decodes a chunked HTTP/1.1 body itself: a chunk size line over its limit is http.ErrLineTooLong
+// and a malformed trailer line a textproto.ProtocolError, but a bad chunk size or chunk data of
+// the wrong length is an error it does not export, so any other error requestBody saw is taken
+// as framing unless it is the network, a timeout (ErrTrailerTimeout included) or a truncation
+func isMalformedChunked(r *http.Request, err error) bool {
+	if r.ProtoMajor != 1 || !slices.Contains(r.TransferEncoding, "chunked") {
+		return false
+	}
+	var protoErr textproto.ProtocolError
+	if errors.Is(err, http.ErrLineTooLong) || errors.As(err, &protoErr) {
+		return true
+	}
+	var readErr *bodyReadError
+	var netErr net.Error
+	return errors.As(err, &readErr) && !errors.As(err, &netErr) &&
+		!errors.Is(err, ErrTrailerTimeout) && !isTimeout(err) && !isTruncated(r, err)
+} // isMalformedChunked() func
+
+// serverProtocols accepts HTTP/1.1 and HTTP/2: over TLS via ALPN, and in cleartext (h2c) on a plain listener.
+// Under HTTP/1.1 trailers follow the zero-length chunk of a chunked body;
+// under HTTP/2 they arrive in a trailing HEADERS frame, but r.Trailer is populated the same way.
+func serverProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+} // serverProtocols() func
+
+// RegisterHandlers mounts the trailer-verifying handler, EchoHandler and the metrics endpoint on mux,
+// and the TrailerLog when there is one.
+// EchoHandler is left out when EchoPath is the pattern of the trailer-verifying handler.
+// Using a caller-supplied mux instead of http.DefaultServeMux lets the handler live
+// alongside an application's own routes without global-state collisions.
+func RegisterHandlers(mux *http.ServeMux, opts ServerOptions) {
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.MetricsPath == "" {
+		opts.MetricsPath = "/debug/vars"
+	}
+	if opts.EchoPath == "" {
+		opts.EchoPath = "/echo"
+	}
+	mux.Handle(opts.Path, NewHandler(opts))
+	if opts.EchoPath != opts.Path {
+		mux.Handle(opts.EchoPath, EchoHandler())
+	}
+	mux.Handle(opts.MetricsPath, expvar.Handler())
+	if opts.TrailerLog != nil {
+		if opts.DebugPath == "" {
+			opts.DebugPath = "/debug/trailers"
+		}
+		mux.Handle(opts.DebugPath, opts.TrailerLog)
+	}
+	if prometheusHandler != nil {
+		if opts.PrometheusPath == "" {
+			opts.PrometheusPath = "/metrics"
+		}
+		mux.Handle(opts.PrometheusPath, prometheusHandler)
+	}
+} // RegisterHandlers() func
+
+// Server is an http.Server serving the handlers of RegisterHandlers on its own mux, built by NewServer.
+// It embeds the http.Server, so any of its fields can still be adjusted before serving.
+type Server struct {
+	*http.Server
+	Mux *http.ServeMux // the server's mux, for mounting an application's routes next to the trailer handler
+
+	Network           string         // network of Addr; see ServerOptions.Network
+	CertFile, KeyFile string         // TLS certificate and key files; see ServerOptions.CertFile
+	ClientCAs         *x509.CertPool // CAs client certificates must chain to; see ServerOptions.ClientCAs
+	ShutdownTimeout   time.Duration  // see ServerOptions.ShutdownTimeout
+
+	inflight  sync.WaitGroup // handlers running, waited for by Shutdown
+	closeMu   sync.Mutex     // orders inflight.Add before the inflight.Wait of Shutdown
+	closed    bool           // set by Shutdown, under closeMu, before it waits for inflight
+	started   atomic.Bool    // set by Start, which serves only once
+	stopped   chan struct{}  // closed once a server started with Start has stopped
+	stopErr   error          // why it failed to serve or to shut down, read after stopped is closed
+	ready     chan struct{}  // closed once the server listens, by Start or Serve
+	readyOnce sync.Once
+	bound     net.Addr // the address listened on, set before ready is closed
+}
+
+// NewServer serves the handlers on their own mux in a Server configured by opts.
+// Tests can mount the same server on an httptest.Server through its Config field.
+func NewServer(opts ServerOptions) *Server {
+	if opts.Addr == "" {
+		opts.Addr = DefaultAddr
+	}
+	switch {
+	case opts.ReadTimeout == 0:
+		opts.ReadTimeout = DefaultReadTimeout
+	case opts.ReadTimeout < 0:
+		opts.ReadTimeout = 0 // http.Server reads without a deadline
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	errorLog := slog.NewLogLogger(orDefaultLogger(opts.Logger).Handler(), slog.LevelError)
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, opts)
+	s := &Server{
+		Mux:             mux,
+		Network:         opts.Network,
+		CertFile:        opts.CertFile,
+		KeyFile:         opts.KeyFile,
+		ClientCAs:       opts.ClientCAs,
+		ShutdownTimeout: opts.ShutdownTimeout,
+		ready:           make(chan struct{}),
+		stopped:         make(chan struct{}),
+	}
+	s.Server = &http.Server{
+		Addr: opts.Addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.enter() {
+				w.Header().Set("Connection", "close")
+				http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			defer s.inflight.Done()
+			mux.ServeHTTP(w, r)
+		}),
+		ReadTimeout:       opts.ReadTimeout, // also aborts bodies (and trailers) that never finish arriving
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		Protocols:         serverProtocols(),
+		ErrorLog:          errorLog,
+	}
+	return s
+} // NewServer() func
+
+// usesTLS reports whether s serves TLS: with its certificate files, or a TLSConfig set by the caller
+func (s *Server) usesTLS() bool {
+	return s.CertFile != "" || s.KeyFile != "" || s.TLSConfig != nil || s.ClientCAs != nil
+} // usesTLS() func
+
+// configureTLS applies ClientCAs to the TLSConfig, right before serving
+func (s *Server) configureTLS() {
+	if s.ClientCAs != nil {
+		s.TLSConfig = requireClientCerts(s.TLSConfig, s.ClientCAs)
+	}
+} // configureTLS() func
+
+// listen listens on s.Addr over s.Network. A Unix socket file is removed when the listener
+// closes, and one left over by a process that died is replaced. Windows named pipes need
+// a third-party listener, such as go-winio's, passed to Serve.
+func (s *Server) listen() (net.Listener, error) {
+	network := s.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if network == "unix" {
+		removeStaleSocket(s.Addr)
+	}
+	return net.Listen(network, s.Addr)
+} // listen() func
+
+// removeStaleSocket removes the Unix socket file at path if no process accepts connections on it
+func removeStaleSocket(path string) {
+	if info, err := os.Stat(path); err != nil || info.Mode()&os.ModeSocket == 0 {
+		return // not a socket: let net.Listen report the conflict
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close() // in use: net.Listen fails with "address already in use"
+		return
+	}
+	os.Remove(path)
+} // removeStaleSocket() func
+
+// ListenAndServe listens on s.Addr and serves, over TLS when a certificate is configured
+func (s *Server) ListenAndServe() error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+} // ListenAndServe() func
+
+// Serve accepts connections on l, over TLS when a certificate is configured.
+// With a TLSConfig holding the certificates, CertFile and KeyFile may be empty.
+func (s *Server) Serve(l net.Listener) error {
+	s.listening(l)
+	if s.usesTLS() {
+		s.configureTLS()
+		return s.Server.ServeTLS(l, s.CertFile, s.KeyFile)
+	}
+	return s.Server.Serve(l)
+} // Serve() func
+
+// listening records l as the listener of s and signals Ready
+func (s *Server) listening(l net.Listener) {
+	s.readyOnce.Do(func() {
+		s.bound = l.Addr()
+		close(s.ready)
+	})
+} // listening() func
+
+// Ready returns a channel closed once the server listens, by Start, ListenAndServe or Serve,
+// for a caller serving in another goroutine to wait on before sending requests; BoundAddr
+// then returns the address listened on
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+} // Ready() func
+
+// BoundAddr returns the address the server listens on, with the port picked for an Addr of port
+// 0 (":0"), or nil before Ready is closed
+func (s *Server) BoundAddr() net.Addr {
+	select {
+	case <-s.ready:
+		return s.bound
+	default:
+		return nil
+	}
+} // BoundAddr() func
+
+// Start listens on s.Addr and serves in a goroutine, returning the address listened on once the
+// server accepts connections, so tests can listen on ":0" and dial the port picked; s.Addr
+// becomes that address too. Cancelling ctx shuts the server down as Shutdown does, within
+// s.ShutdownTimeout; Wait returns once it has. A server starts at most once, even if it
+// fails to listen.
+func (s *Server) Start(ctx context.Context) (net.Addr, error) {
+	if !s.started.CompareAndSwap(false, true) {
+		return nil, errors.New("server already started")
+	}
+	listener, err := s.listen()
+	if err != nil {
+		s.stopErr = err
+		close(s.stopped)
+		return nil, err
+	}
+	s.Addr = listener.Addr().String()
+	s.listening(listener)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+	go func() {
+		defer close(s.stopped)
+		var err error
+		select {
+		case err = <-served: // Serve failed, or Shutdown was called directly
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+			defer cancel()
+			s.stopErr = s.Shutdown(shutdownCtx)
+			err = <-served
+		}
+		if s.stopErr == nil && !errors.Is(err, http.ErrServerClosed) {
+			s.stopErr = err
+		}
+	}()
+	return listener.Addr(), nil
+} // Start() func
+
+// Wait blocks until a server started with Start has stopped, and returns why it failed
+// to serve or to shut down cleanly, if it did. It returns an error at once for a server
+// not started, which would never stop.
+func (s *Server) Wait() error {
+	if !s.started.Load() {
+		return errors.New("server not started with Start")
+	}
+	<-s.stopped
+	return s.stopErr
+} // Wait() func
+
+// Shutdown stops accepting connections and waits for in-flight requests to finish, so
+// streamed bodies still arriving are read to the end and their trailers verified. If ctx
+// expires first, the remaining connections are closed: the handlers' body reads fail, so
+// those uploads end as truncated, and Shutdown waits for the handlers to return before
+// returning ctx's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		s.Server.Close()
+	}
+	s.closeMu.Lock()
+	s.closed = true
+	s.closeMu.Unlock()
+	s.inflight.Wait()
+	return err
+} // Shutdown() func
+
+// enter counts a handler in inflight, unless Shutdown is already waiting for them:
+// a WaitGroup must not grow from zero while Wait is running
+func (s *Server) enter() bool {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+} // enter() func
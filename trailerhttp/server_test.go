@@ -0,0 +1,776 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandlerThrottleWithLogReads(t *testing.T) {
+	for _, logReads := range []bool{false, true} {
+		h := NewHandler(ServerOptions{Throttle: &Throttle{BytesPerSecond: 100_000}, LogReads: logReads, Logger: discardLogger()})
+		srv := httptest.NewServer(h)
+		start := time.Now()
+		result, err := (&Client{Logger: discardLogger()}).SendStream(t.Context(), srv.URL, bytes.NewReader(make([]byte, 40_000)))
+		elapsed := time.Since(start)
+		srv.Close()
+		if err != nil || !result.Matched {
+			t.Fatalf("LogReads %v: %+v, %v", logReads, result, err)
+		}
+		if elapsed < 250*time.Millisecond {
+			t.Errorf("LogReads %v: 40 kB read in %s at 100 kB/s; the throttle was bypassed", logReads, elapsed)
+		}
+		if logReads && result.Reads == 0 {
+			t.Errorf("LogReads: no reads counted")
+		}
+	}
+}
+
+// startServer starts a NewServer for opts on a free loopback port, stopped when the test ends,
+// and returns its base URL
+func startServer(t *testing.T, opts ServerOptions) string {
+	t.Helper()
+	opts.Addr = "127.0.0.1:0"
+	if opts.Logger == nil {
+		opts.Logger = discardLogger()
+	}
+	srv := NewServer(opts)
+	addr, err := srv.Start(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return "http://" + addr.String()
+} // startServer() func
+
+func TestServerReadTimeout(t *testing.T) {
+	url := startServer(t, ServerOptions{ReadTimeout: 300 * time.Millisecond, ShutdownTimeout: time.Second})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("the start of a body that never ends")) // and never closes
+	start := time.Now()
+	result, err := (&Client{Logger: discardLogger()}).SendStream(t.Context(), url, pr)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("upload returned after %s, want the server to give up after its 300ms ReadTimeout", elapsed)
+	}
+	if err != nil {
+		t.Fatalf("upload failed with %v, want the server's 408 response", err)
+	}
+	if result.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("status %d, want 408 Request Timeout", result.StatusCode)
+	}
+}
+
+func TestHandlerLowercaseTrailer(t *testing.T) {
+	r := chunkedRequest("hello", http.Header{"x-body-byte-length": {"5"}}) // as a map key no server would canonicalize
+	w := httptest.NewRecorder()
+	NewHandler(ServerOptions{Logger: discardLogger()}).ServeHTTP(w, r)
+	var result UploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matched || len(result.Checks) != 1 || result.Checks[0].Reported != "5" {
+		t.Errorf("status %d, matched %v, checks %+v; want the lowercase length trailer verified", w.Code, result.Matched, result.Checks)
+	}
+}
+
+func TestHandlerVerboseLogging(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		var out bytes.Buffer
+		log := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		srv := httptest.NewServer(NewHandler(ServerOptions{Verbose: verbose, Logger: log}))
+		_, err := (&Client{Logger: discardLogger()}).Send(t.Context(), srv.URL, []byte("secret body bytes"))
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logged := out.String()
+		if !strings.Contains(logged, "outcome=trailer-verified-ok") {
+			t.Errorf("verbose %v: no verification outcome logged:\n%s", verbose, logged)
+		}
+		if dumped := strings.Contains(logged, "secret body bytes"); dumped != verbose {
+			t.Errorf("verbose %v: body logged %v:\n%s", verbose, dumped, logged)
+		}
+	}
+}
+
+// chunkedRequest returns a chunked POST of body whose trailer section will hold trailer, as
+// the server fills it in: announced fields that never arrived have no values
+func chunkedRequest(body string, trailer http.Header) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.TransferEncoding = []string{"chunked"}
+	r.ContentLength = -1
+	r.Trailer = trailer
+	return r
+} // chunkedRequest() func
+
+func TestHandlerMissingTrailers(t *testing.T) {
+	trailer := http.Header{"X-Body-Byte-Length": {"5"}, "X-Body-Sha256": nil}
+	if missing := missingTrailers([]string{"X-Body-Byte-Length", "X-Body-Sha256"}, trailer); !slices.Equal(missing, []string{"X-Body-Sha256"}) {
+		t.Errorf("missingTrailers = %q, want the SHA-256 trailer alone", missing)
+	}
+	if missing := missingTrailers([]string{"X-Body-Byte-Length"}, trailer); missing != nil {
+		t.Errorf("missingTrailers = %q with every announced trailer delivered", missing)
+	}
+
+	w := httptest.NewRecorder()
+	NewHandler(ServerOptions{Logger: discardLogger()}).ServeHTTP(w, chunkedRequest("hello", trailer))
+	var result UploadResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusBadRequest || result.Matched || !slices.Equal(result.MissingTrailers, []string{"X-Body-Sha256"}) {
+		t.Errorf("status %d, matched %v, missing %q; want 400 naming the trailer that never arrived", w.Code, result.Matched, result.MissingTrailers)
+	}
+}
+
+func TestServerHTTP1AndH2C(t *testing.T) {
+	url := startServer(t, ServerOptions{Algorithms: []string{"length", "sha256"}})
+	body := bytes.Repeat([]byte("over either protocol "), 3000)
+	results := map[string]*UploadResult{}
+	for _, hc := range []*http.Client{http.DefaultClient, NewH2CClient()} {
+		c := &Client{HTTPClient: hc, Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}
+		result, err := c.Send(t.Context(), url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[result.Proto] = result
+	}
+	h1, h2 := results["HTTP/1.1"], results["HTTP/2.0"]
+	if h1 == nil || h2 == nil {
+		t.Fatalf("results over %v, want one over HTTP/1.1 and one over HTTP/2.0", slices.Collect(maps.Keys(results)))
+	}
+	if !h1.Matched || h1.Outcome != h2.Outcome || h1.Matched != h2.Matched || h1.BodyLength != h2.BodyLength || *h1.ReportedLength != *h2.ReportedLength || !slices.Equal(h1.DeliveredTrailers, h2.DeliveredTrailers) || len(h1.Checks) != len(h2.Checks) {
+		t.Fatalf("HTTP/1.1 %+v\nHTTP/2.0 %+v\nwant the same verified result", h1, h2)
+	}
+	for i := range h1.Checks {
+		if h1.Checks[i] != h2.Checks[i] {
+			t.Errorf("check %d: HTTP/1.1 %+v, HTTP/2.0 %+v", i, h1.Checks[i], h2.Checks[i])
+		}
+	}
+}
+
+func TestHandlerSummaryOutput(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler(ServerOptions{SummaryOutput: &out, Logger: discardLogger()})
+	r := chunkedRequest("twelve bytes", http.Header{"X-Body-Byte-Length": {"12"}, "X-Extra": {"1"}})
+	r.Header.Set("X-Request-Id", "summary-test")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var summary UploadResult
+	if err := json.Unmarshal(out.Bytes(), &summary); err != nil {
+		t.Fatalf("summary %q: %v", out.String(), err)
+	}
+	want := []string{"X-Body-Byte-Length", "X-Extra"}
+	switch {
+	case summary.RequestID != "summary-test" || summary.Method != http.MethodPost || summary.HeaderCount != len(r.Header):
+		t.Errorf("request ID %q, method %q, header count %d; want those of the request", summary.RequestID, summary.Method, summary.HeaderCount)
+	case !slices.Equal(summary.AnnouncedTrailers, want) || !slices.Equal(summary.DeliveredTrailers, want):
+		t.Errorf("announced %q, delivered %q; want %q", summary.AnnouncedTrailers, summary.DeliveredTrailers, want)
+	case summary.BodyLength != 12 || summary.ReportedLength == nil || *summary.ReportedLength != 12:
+		t.Errorf("body length %d, reported %v; want 12", summary.BodyLength, summary.ReportedLength)
+	case !summary.Matched || summary.Outcome != "trailer-verified-ok":
+		t.Errorf("matched %v, outcome %q; want the upload verified", summary.Matched, summary.Outcome)
+	}
+}
+
+func BenchmarkServerHandler(b *testing.B) {
+	h := NewHandler(ServerOptions{Logger: discardLogger()})
+	body := strings.Repeat("x", 64<<10)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, chunkedRequest(body, http.Header{"X-Body-Byte-Length": {"65536"}}))
+		if w.Code != http.StatusOK {
+			b.Fatalf("status %d", w.Code)
+		}
+	}
+}
+
+func TestHandlerAnnouncedTrailerNotChunked(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	r.Header.Set("Trailer", "X-Body-Byte-Length") // as a proxy that buffered the body would pass it on
+	w := httptest.NewRecorder()
+	NewHandler(ServerOptions{Logger: discardLogger()}).ServeHTTP(w, r)
+	var result UploadResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if !result.Inconclusive || result.Matched {
+		t.Errorf("status %d, inconclusive %v, matched %v; want the upload inconclusive, not verified", w.Code, result.Inconclusive, result.Matched)
+	}
+}
+
+func TestRegisterHandlersCustomMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) { io.WriteString(w, "ok") })
+	RegisterHandlers(mux, ServerOptions{Path: "/upload/", Logger: discardLogger()})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	result, err := SendWithTrailer(t.Context(), srv.URL+"/upload/file", []byte("mounted elsewhere"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matched {
+		t.Errorf("status %d, matched %v; want the upload at /upload/ verified", result.StatusCode, result.Matched)
+	}
+	resp, err := http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(health) != "ok" {
+		t.Errorf("/health answered %q; the application's own route was lost", health)
+	}
+	if result, _ := SendWithTrailer(t.Context(), srv.URL+"/elsewhere", []byte("x")); result == nil || result.StatusCode != http.StatusNotFound {
+		t.Errorf("result %+v outside /upload/, want 404", result)
+	}
+}
+
+func TestValidateTrailerNames(t *testing.T) {
+	for _, names := range [][]string{nil, {"X-Body-Byte-Length"}, {"x-body-sha256", "Content-Digest", "X-Custom"}} {
+		if err := validateTrailerNames(names); err != nil {
+			t.Errorf("validateTrailerNames(%q) = %v, want nil", names, err)
+		}
+	}
+	for _, forbidden := range []string{"Content-Length", "transfer-encoding", "Host", "Connection", "Te", "Authorization", "Trailer"} {
+		err := validateTrailerNames([]string{"X-Body-Byte-Length", forbidden})
+		if !errors.Is(err, ErrForbiddenTrailer) || !strings.Contains(err.Error(), forbidden) {
+			t.Errorf("validateTrailerNames with %q = %v, want an ErrForbiddenTrailer naming it", forbidden, err)
+		}
+	}
+
+	r := chunkedRequest("hello", http.Header{"X-Body-Byte-Length": {"5"}})
+	r.Header.Set("Trailer", "X-Body-Byte-Length, Host")
+	w := httptest.NewRecorder()
+	NewHandler(ServerOptions{Logger: discardLogger()}).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Host") {
+		t.Errorf("status %d, body %q; want 400 naming the forbidden trailer", w.Code, w.Body)
+	}
+}
+
+func TestHandlerOverTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	for _, proto := range []string{"HTTP/1.1", "HTTP/2.0"} {
+		hc := srv.Client()
+		if proto == "HTTP/1.1" {
+			transport := hc.Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.NextProtos = nil
+			transport.Protocols = new(http.Protocols)
+			transport.Protocols.SetHTTP1(true)
+			hc = &http.Client{Transport: transport}
+		}
+		result, err := (&Client{HTTPClient: hc, Logger: discardLogger()}).Send(t.Context(), srv.URL, []byte("encrypted on the way"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Proto != proto || !result.Matched || result.ReportedLength == nil || *result.ReportedLength != 20 {
+			t.Errorf("over %s: proto %s, matched %v, reported %v; want the length trailer verified", proto, result.Proto, result.Matched, result.ReportedLength)
+		}
+	}
+}
+
+func TestHandlerEchoesTrailers(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	body := []byte("echo my trailers")
+	c := &Client{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}
+	result, err := c.Send(t.Context(), srv.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(body)
+	for name, want := range map[string]string{"X-Received-X-Body-Byte-Length": "16", "X-Received-X-Body-Sha256": hex.EncodeToString(sum[:])} {
+		if got := result.ResponseTrailer.Get(name); !strings.EqualFold(got, want) {
+			t.Errorf("response trailer %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNewServerOptions(t *testing.T) {
+	url := startServer(t, ServerOptions{
+		Algorithms:   []string{"length", "crc32"},
+		TrailerNames: map[string]string{"length": "X-Content-Length"},
+		MaxBodyBytes: 100,
+		Policy:       PolicyStrict,
+	})
+	c := &Client{Algorithms: []string{"length", "crc32"}, TrailerNames: map[string]string{"length": "X-Content-Length"}, Logger: discardLogger()}
+	result, err := c.Send(t.Context(), url, []byte("configured"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matched || len(result.Checks) != 2 || result.Checks[0].TrailerName != "X-Content-Length" {
+		t.Errorf("matched %v, checks %+v; want length, in X-Content-Length, and crc32 verified", result.Matched, result.Checks)
+	}
+	if result, _ := c.Send(t.Context(), url, make([]byte, 101)); result == nil || result.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("result %+v, want 413 past MaxBodyBytes", result)
+	}
+	c.TrailerNames = nil // the length goes in X-Body-Byte-Length, which the server does not read
+	if result, err := c.Send(t.Context(), url, []byte("configured")); err != nil || len(result.Checks) != 1 || result.Checks[0].Algorithm != "crc32" {
+		t.Errorf("result %+v, %v; want the crc32 check alone", result, err)
+	}
+}
+
+func TestTrailerNames(t *testing.T) {
+	names := map[string]string{"length": "X-Content-Length"}
+	trailer := ComputeTrailers([]byte("hello"), AlgoLength.WithName("X-Content-Length"), AlgoSHA256)
+	if trailer.Get("X-Content-Length") != "5" || trailer.Get(trailerHeaderName) != "" || trailer.Get("X-Body-Sha256") == "" {
+		t.Errorf("ComputeTrailers = %v, want the length in X-Content-Length alone, and the SHA-256", trailer)
+	}
+
+	// The 413 summary of a drained body reports the renamed length
+	h := NewHandler(ServerOptions{TrailerNames: names, MaxBodyBytes: 10, DrainOversizedBytes: 100, Logger: discardLogger()})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, chunkedRequest(strings.Repeat("x", 20), http.Header{"X-Content-Length": {"20"}}))
+	var result UploadResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusRequestEntityTooLarge || result.ReportedLength == nil || *result.ReportedLength != 20 {
+		t.Errorf("status %d, reported length %v; want 413 with the length from X-Content-Length", w.Code, result.ReportedLength)
+	}
+
+	// Integrity reads the renamed field
+	mw := Integrity(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), WithTrailerNames(names), RejectUnverified(0))
+	for length, status := range map[string]int{"5": http.StatusOK, "6": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, chunkedRequest("hello", http.Header{"X-Content-Length": {length}}))
+		if w.Code != status {
+			t.Errorf("Integrity, X-Content-Length %s: status %d, want %d", length, w.Code, status)
+		}
+	}
+
+	// A TrailerWriter and a TrailerResponseWriter send it, and Client and Transport verify the response
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if received := r.Trailer.Get("X-Content-Length"); r.Method == http.MethodPost && received != "5" {
+			http.Error(w, "length trailer "+received, http.StatusBadRequest)
+			return
+		}
+		tw := NewTrailerResponseWriter(w, r, AlgoLength.WithName("X-Content-Length"))
+		tw.Write([]byte("hello"))
+		tw.Finish()
+	}))
+	defer srv.Close()
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, pr)
+	tw := NewTrailerWriter(req, pw, AlgoLength.WithName("X-Content-Length"))
+	go func() {
+		tw.Write([]byte("hello"))
+		tw.Close()
+	}()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("TrailerWriter: status %d, want the renamed length received", resp.StatusCode)
+	}
+	if _, err := (&Client{TrailerNames: names, Logger: discardLogger()}).Download(t.Context(), srv.URL, io.Discard); err != nil {
+		t.Errorf("Client.Download: %v", err)
+	}
+	hc := &http.Client{Transport: &Transport{Algorithms: []TrailerAlgo{AlgoLength.WithName("X-Content-Length")}, VerifyResponses: true}}
+	resp, err = hc.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if vb, ok := resp.Body.(*VerifiedBody); err != nil || !ok || !vb.Verified() {
+		t.Errorf("Transport: error %v, verified body %v; want the renamed length verified", err, ok)
+	}
+}
+
+func TestHandlerTruncatedUpload(t *testing.T) {
+	h := NewHandler(ServerOptions{Logger: discardLogger()})
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("the first part of the body"))
+		pw.CloseWithError(io.ErrUnexpectedEOF) // what a chunked body cut off before its last chunk reads as
+	}()
+	r := chunkedRequest("", http.Header{"X-Body-Byte-Length": nil})
+	r.Body = pr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Truncated upload") {
+		t.Errorf("status %d, body %q; want 400 reporting a truncated upload", w.Code, w.Body)
+	}
+
+	// The same over a connection the client stops writing to mid-chunk
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\nTrailer: X-Body-Byte-Length\r\n\r\n20\r\nonly part of the chunk")
+	conn.(*net.TCPConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "Truncated upload") {
+		t.Errorf("status %d, body %q; want 400 reporting a truncated upload", resp.StatusCode, body)
+	}
+}
+
+func TestHandlerMalformedChunkedBody(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	for _, chunks := range []string{
+		"4\r\nhello\r\n0\r\n\r\n",                // chunk data longer than its size
+		"5\r\nhello\r\nzz\r\n",                   // a bad chunk size line
+		"5\r\nhello\r\n0\r\nnot a field\r\n\r\n", // a malformed trailer line
+	} {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		io.WriteString(conn, "POST / HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\nTrailer: X-Body-Byte-Length\r\n\r\n"+chunks)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		conn.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "Malformed chunked") {
+			t.Errorf("chunks %q: status %d, body %q; want 400 blaming the framing", chunks, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestIsMalformedChunked(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.TransferEncoding = []string{"chunked"}
+	badSize := &bodyReadError{err: errors.New("invalid byte in chunk length")}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad chunk size", badSize, true},
+		{"chunk size line too long", &bodyReadError{err: http.ErrLineTooLong}, true},
+		{"malformed trailer line", &bodyReadError{err: textproto.ProtocolError("malformed MIME header line: bad trailer")}, true},
+		{"trailer timeout", fmt.Errorf("%w: %w", ErrTrailerTimeout, &bodyReadError{err: errors.New("http: unexpected EOF reading trailer")}), false},
+		{"deadline", &bodyReadError{err: os.ErrDeadlineExceeded}, false},
+		{"truncated", &bodyReadError{err: io.ErrUnexpectedEOF}, false},
+		{"connection reset", &bodyReadError{err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}, false},
+		{"not from the body", fmt.Errorf("%w: bad chunk in trailer", errBodySink), false},
+	}
+	for _, tt := range tests {
+		if got := isMalformedChunked(r, tt.err); got != tt.want {
+			t.Errorf("%s: isMalformedChunked(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+	if isMalformedChunked(httptest.NewRequest(http.MethodPost, "/", nil), badSize) {
+		t.Error("isMalformedChunked blamed the chunked framing of a request that was not chunked")
+	}
+}
+
+func TestHandlerLogReads(t *testing.T) {
+	var out bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&out, nil))
+	srv := httptest.NewServer(NewHandler(ServerOptions{LogReads: true, Logger: log}))
+	defer srv.Close()
+	pr, pw := io.Pipe()
+	go func() {
+		for range 5 {
+			pw.Write([]byte("one separate write "))
+			time.Sleep(20 * time.Millisecond) // keeps the chunks apart on the wire
+		}
+		pw.Close()
+	}()
+	result, err := (&Client{Logger: discardLogger()}).SendStream(t.Context(), srv.URL, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matched || result.Reads < 2 {
+		t.Errorf("matched %v, %d reads; want the five writes seen in several reads", result.Matched, result.Reads)
+	}
+	if logged := strings.Count(out.String(), "bytes="); logged < result.Reads {
+		t.Errorf("%d read sizes logged for %d reads:\n%s", logged, result.Reads, out.String())
+	}
+}
+
+func TestHandlerResponseTrailersNeedTE(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	for _, te := range []bool{false, true} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, io.MultiReader(strings.NewReader("hello")))
+		req.Trailer = http.Header{"X-Body-Byte-Length": {"5"}}
+		if te {
+			req.Header.Set("TE", "trailers")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("TE %v: status %d", te, resp.StatusCode)
+		}
+		if got := len(resp.Trailer) > 0 || resp.Header.Get("Trailer") != ""; got != te {
+			t.Errorf("TE %v: Trailer header %q, trailers %v; want trailers only with \"TE: trailers\"", te, resp.Header.Get("Trailer"), resp.Trailer)
+		}
+	}
+}
+
+func TestTrailersOverHTTP2(t *testing.T) {
+	h2c := startServer(t, ServerOptions{})
+	tlsSrv := httptest.NewUnstartedServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	for _, tc := range []struct {
+		name, url, proto string
+		hc               *http.Client
+	}{
+		{"HTTP/1.1", h2c, "HTTP/1.1", http.DefaultClient},
+		{"h2c", h2c, "HTTP/2.0", NewH2CClient()},
+		{"HTTP/2 over TLS", tlsSrv.URL, "HTTP/2.0", tlsSrv.Client()},
+	} {
+		c := &Client{HTTPClient: tc.hc, Algorithms: []string{"length", "sha256", "content-digest"}, Logger: discardLogger()}
+		result, err := c.Send(t.Context(), tc.url, bytes.Repeat([]byte("trailing HEADERS frame "), 1000))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if result.Proto != tc.proto || !result.Matched || len(result.Checks) != 3 {
+			t.Errorf("%s: proto %s, matched %v, checks %+v; want every request trailer verified over %s", tc.name, result.Proto, result.Matched, result.Checks, tc.proto)
+		}
+		if result.ResponseTrailer.Get("X-Status-Code") != "200" || result.ResponseTrailer.Get("X-Received-Content-Digest") == "" {
+			t.Errorf("%s: response trailers %v, want the status and the echo", tc.name, result.ResponseTrailer)
+		}
+	}
+}
+
+func TestServerStart(t *testing.T) {
+	srv := NewServer(ServerOptions{Addr: "127.0.0.1:0", Logger: discardLogger()})
+	if srv.BoundAddr() != nil {
+		t.Fatalf("BoundAddr %v before the server listens, want nil", srv.BoundAddr())
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	addr, err := srv.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-srv.Ready():
+	default:
+		t.Fatal("Ready not closed once Start returned")
+	}
+	if tcp, ok := addr.(*net.TCPAddr); !ok || tcp.Port == 0 || srv.BoundAddr() != addr || srv.Addr != addr.String() {
+		t.Errorf("Start returned %v, BoundAddr %v, Addr %q; want the port picked for :0 in all three", addr, srv.BoundAddr(), srv.Addr)
+	}
+	result, err := (&Client{Logger: discardLogger()}).Send(t.Context(), "http://"+addr.String(), []byte("ready"))
+	if err != nil || !result.Matched {
+		t.Fatalf("result %+v, %v; want an upload right after Start verified, without waiting", result, err)
+	}
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait returned %v after ctx was cancelled, want a clean shutdown", err)
+	}
+	if conn, err := net.Dial("tcp", addr.String()); err == nil {
+		conn.Close()
+		t.Error("server still accepts connections once Wait returned")
+	}
+	restarted := NewServer(ServerOptions{Addr: addr.String(), Logger: discardLogger()})
+	if _, err := restarted.Start(t.Context()); err != nil {
+		t.Fatalf("restart on the freed address failed: %v", err)
+	}
+	t.Cleanup(func() { restarted.Shutdown(context.Background()) })
+}
+
+func TestServerStartAddressInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	srv := NewServer(ServerOptions{Addr: l.Addr().String(), Logger: discardLogger()})
+	if addr, err := srv.Start(t.Context()); err == nil {
+		t.Fatalf("Start on a busy address returned %v, want the listen error", addr)
+	}
+	if srv.BoundAddr() != nil {
+		t.Errorf("BoundAddr %v after Start failed, want nil", srv.BoundAddr())
+	}
+	if err := srv.Wait(); err == nil {
+		t.Error("Wait returned nil after Start failed, want the listen error")
+	}
+}
+
+func TestServerWait(t *testing.T) {
+	if err := NewServer(ServerOptions{Logger: discardLogger()}).Wait(); err == nil {
+		t.Error("Wait returned nil on a server never started, want an error rather than blocking")
+	}
+
+	srv := NewServer(ServerOptions{Addr: "127.0.0.1:0", Logger: discardLogger()})
+	ctx, cancel := context.WithCancel(t.Context())
+	started := make(chan struct{})
+	var waitErr error
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		<-started
+		waitErr = srv.Wait()
+	})
+	if _, err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(started)
+	if _, err := srv.Start(ctx); err == nil {
+		t.Error("second Start succeeded, want an error")
+	}
+	cancel()
+	wg.Wait()
+	if waitErr != nil {
+		t.Errorf("Wait returned %v, want a clean shutdown", waitErr)
+	}
+}
+
+func TestServerShutdownRacesHandlers(t *testing.T) {
+	srv := NewServer(ServerOptions{Logger: discardLogger()})
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			rec := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Connection") != "close" {
+		t.Errorf("request after Shutdown: status %d, Connection %q; want 503 closing the connection", rec.Code, rec.Header().Get("Connection"))
+	}
+}
+
+// stallTrailers writes the headers, body and last chunk of a chunked upload to a server at addr
+// and then stalls before the trailer section, returning the response the server answers with
+func stallTrailers(t *testing.T, addr string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: example\r\nTransfer-Encoding: chunked\r\nTrailer: X-Body-Byte-Length\r\n\r\n5\r\nhello\r\n0\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+} // stallTrailers() func
+
+func TestHandlerTrailerTimeout(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{TrailerTimeout: 200 * time.Millisecond, Logger: discardLogger()}))
+	defer srv.Close()
+	start := time.Now()
+	resp := stallTrailers(t, srv.Listener.Addr().String())
+	var result UploadResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusRequestTimeout || !strings.Contains(result.Error, "trailer section") {
+		t.Errorf("Handler: status %d, error %q; want 408 for the trailer section", resp.StatusCode, result.Error)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Handler answered after %s, want about its 200ms TrailerTimeout", elapsed)
+	}
+}
+
+func TestIntegrityTrailerTimeout(t *testing.T) {
+	readErr := make(chan error, 1)
+	mw := httptest.NewServer(Integrity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		readErr <- err
+	}), WithTrailerTimeout(200*time.Millisecond)))
+	defer mw.Close()
+	stallTrailers(t, mw.Listener.Addr().String())
+	if err := <-readErr; !errors.Is(err, ErrTrailerTimeout) {
+		t.Errorf("Integrity: the handler read %v, want ErrTrailerTimeout", err)
+	}
+	rejecting := httptest.NewServer(Integrity(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), WithTrailerTimeout(200*time.Millisecond), RejectUnverified(0)))
+	defer rejecting.Close()
+	if resp := stallTrailers(t, rejecting.Listener.Addr().String()); resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("Integrity with RejectUnverified: status %d, want 408", resp.StatusCode)
+	}
+}
+
+func TestClientTrailerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Body-Byte-Length")
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		<-release // the body is out, the trailer section is not
+		w.Header().Set("X-Body-Byte-Length", "5")
+	}))
+	defer srv.Close()
+	defer close(release)
+	start := time.Now()
+	_, err := (&Client{TrailerTimeout: 200 * time.Millisecond, Logger: discardLogger()}).Download(t.Context(), srv.URL, io.Discard)
+	if !errors.Is(err, ErrTrailerTimeout) {
+		t.Errorf("Download: %v, want ErrTrailerTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Download returned after %s, want about its 200ms TrailerTimeout", elapsed)
+	}
+}
+
+func TestHandlerRejectUnannouncedTrailers(t *testing.T) {
+	req := &RawRequest{Header: []RawField{{"Trailer", "X-Body-Byte-Length"}}, Body: []byte("hello"),
+		Trailer: []RawField{{"X-Body-Byte-Length", "5"}, {"X-Note", "unannounced"}}}
+	for _, tc := range []struct {
+		name   string
+		opts   ServerOptions
+		status int
+	}{
+		{"accepted by default", ServerOptions{}, http.StatusOK},
+		{"rejected", ServerOptions{RejectUnannouncedTrailers: true}, http.StatusBadRequest},
+		{"rejected but allowed", ServerOptions{RejectUnannouncedTrailers: true, AllowedUnannouncedTrailers: []string{"x-note"}}, http.StatusOK},
+		{"rejected, another allowed", ServerOptions{RejectUnannouncedTrailers: true, AllowedUnannouncedTrailers: []string{"X-Other"}}, http.StatusBadRequest},
+	} {
+		tc.opts.Logger = discardLogger()
+		srv := httptest.NewServer(NewHandler(tc.opts))
+		resp, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var result UploadResult
+		json.Unmarshal(resp.Body, &result)
+		if resp.Response.StatusCode != tc.status {
+			t.Errorf("%s: status %d, error %q; want %d", tc.name, resp.Response.StatusCode, result.Error, tc.status)
+		}
+		if tc.status == http.StatusOK && !slices.Contains(result.DeliveredTrailers, "X-Note") {
+			t.Errorf("%s: delivered trailers %v, want X-Note reported", tc.name, result.DeliveredTrailers)
+		}
+	}
+}
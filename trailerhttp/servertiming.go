@@ -0,0 +1,31 @@
+package trailerhttp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerTimingTrailer carries the W3C Server-Timing metrics of ServerOptions.ServerTiming. As a
+// trailer it can report how long the handler took as a whole, which no header could.
+const ServerTimingTrailer = "Server-Timing"
+
+// serverTiming times the phases of one request
+type serverTiming struct {
+	start  time.Time     // when the handler started
+	read   time.Duration // reading the body, up to the end of its trailer section
+	verify time.Duration // checking the trailers
+}
+
+// value formats the metrics as a Server-Timing field value, durations in milliseconds;
+// the handler metric runs up to now
+func (t *serverTiming) value() string {
+	metric := func(name string, d time.Duration, desc string) string {
+		return name + ";dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64) + `;desc="` + desc + `"`
+	}
+	return strings.Join([]string{
+		metric("read", t.read, "Body read"),
+		metric("verify", t.verify, "Trailer verification"),
+		metric("handler", time.Since(t.start), "Handler"),
+	}, ", ")
+} // value() func
@@ -0,0 +1,277 @@
+package trailerhttp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 9421 signature fields. They are sent as trailers, after the Content-Digest trailer they cover.
+const (
+	signatureTrailer      = "Signature"
+	signatureInputTrailer = "Signature-Input"
+	signatureLabel        = "sig1"
+)
+
+// signatureComponents are the components a Signer covers: the request line and the body digest trailer
+var signatureComponents = []string{`"@method"`, `"@authority"`, `"@path"`, `"content-digest";tr`}
+
+// ErrBadSignature reports an RFC 9421 message signature that does not verify
+var ErrBadSignature = errors.New("message signature does not verify")
+
+// DefaultSignatureMaxAge is how old a message signature may be when ServerOptions.SignatureMaxAge is 0
+const DefaultSignatureMaxAge = 5 * time.Minute
+
+// Signer produces RFC 9421 HTTP Message Signatures over the request method, authority, path
+// and the Content-Digest trailer, which binds the signature to the streamed body without
+// buffering it. The Signature and Signature-Input fields travel as trailers too.
+type Signer struct {
+	KeyID string        // identifies the key to the verifier, see ServerOptions.SignatureKeys
+	Key   crypto.Signer // an ed25519.PrivateKey or a P-256 *ecdsa.PrivateKey
+}
+
+// signatureAlgorithm returns the RFC 9421 algorithm name of a private or public key
+func signatureAlgorithm(key any) (string, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "ed25519", nil
+	case *ecdsa.PrivateKey:
+		return signatureAlgorithm(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return "ecdsa-p256-sha256", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported signature key type %T (want Ed25519 or ECDSA P-256)", key)
+} // signatureAlgorithm() func
+
+// announce declares the signature trailers on req
+func (s *Signer) announce(req *http.Request) {
+	req.Trailer[signatureTrailer] = nil
+	req.Trailer[signatureInputTrailer] = nil
+} // announce() func
+
+// sign sets the Signature and Signature-Input trailers of req; its Content-Digest trailer must be set
+func (s *Signer) sign(req *http.Request) error {
+	alg, err := signatureAlgorithm(s.Key)
+	if err != nil {
+		return err
+	}
+	params := "(" + strings.Join(signatureComponents, " ") + ")" +
+		";created=" + strconv.FormatInt(time.Now().Unix(), 10) +
+		";keyid=" + strconv.Quote(s.KeyID) + `;alg="` + alg + `"`
+	base, err := signatureBase(req, signatureComponents, params)
+	if err != nil {
+		return err
+	}
+	sig, err := signBytes(s.Key, []byte(base))
+	if err != nil {
+		return err
+	}
+	req.Trailer.Set(signatureInputTrailer, signatureLabel+"="+params)
+	req.Trailer.Set(signatureTrailer, signatureLabel+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+} // sign() func
+
+// signBytes signs data with an Ed25519 or P-256 key. An ECDSA signature is r and s as
+// fixed-size big-endian integers, not ASN.1, as RFC 9421, Section 3.3.4 and JWS (RFC 7518) want.
+func signBytes(key crypto.Signer, data []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, data), nil
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256(data)
+		sigR, sigS, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		return append(sigR.FillBytes(make([]byte, 32)), sigS.FillBytes(make([]byte, 32))...), nil
+	}
+	_, err := signatureAlgorithm(key)
+	return nil, err
+} // signBytes() func
+
+// verifyBytes checks a signature made by signBytes with the matching public key
+func verifyBytes(key crypto.PublicKey, data, sig []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, sig)
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return len(sig) == 64 &&
+			ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	return false
+} // verifyBytes() func
+
+// signatureBase builds the RFC 9421 signature base of r over components
+func signatureBase(r *http.Request, components []string, params string) (string, error) {
+	var b strings.Builder
+	for _, component := range components {
+		name, param, _ := strings.Cut(component, ";")
+		name, err := strconv.Unquote(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid component identifier %s", component)
+		}
+		var value string
+		switch {
+		case name == "@method":
+			value = r.Method
+		case name == "@authority":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+			value = strings.ToLower(value)
+		case name == "@path":
+			if value = r.URL.EscapedPath(); value == "" {
+				value = "/"
+			}
+		case name == "@query":
+			value = "?" + r.URL.RawQuery
+		case strings.HasPrefix(name, "@"):
+			return "", fmt.Errorf("unsupported derived component %q", name)
+		default:
+			fields := r.Header
+			if param == "tr" {
+				fields = r.Trailer
+			} else if param != "" {
+				return "", fmt.Errorf("unsupported component parameter in %s", component)
+			}
+			values, ok := lookupField(fields, name)
+			if !ok || len(values) == 0 {
+				return "", fmt.Errorf("covered field %s is missing", component)
+			}
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.TrimSpace(v)
+			}
+			value = strings.Join(trimmed, ", ")
+		}
+		fmt.Fprintf(&b, "%s: %s\n", component, value)
+	}
+	fmt.Fprintf(&b, "\"@signature-params\": %s", params)
+	return b.String(), nil
+} // signatureBase() func
+
+// verifyMessageSignature checks the RFC 9421 signature trailers of r once its body has been read.
+// The signature must be made with a key in keys and cover the Content-Digest trailer, which the
+// content-digest verifier checks against the body itself, and be fresh: at most maxAge old (0
+// means DefaultSignatureMaxAge) and not expired. bound tells that check ran and matched; without
+// it the signature vouches for a Content-Digest nothing compared with the body, and fails.
+func verifyMessageSignature(r *http.Request, keys map[string]crypto.PublicKey, maxAge time.Duration, bound bool) VerificationResult {
+	result := VerificationResult{Algorithm: "message-signature", TrailerName: signatureTrailer}
+	fail := func(err error) VerificationResult {
+		result.Err = err
+		return result
+	}
+	inputs, _ := lookupField(r.Trailer, signatureInputTrailer)
+	sigs, _ := lookupField(r.Trailer, signatureTrailer)
+	if len(inputs) == 0 || len(sigs) == 0 {
+		return fail(missingTrailerError([]string{signatureInputTrailer, signatureTrailer}))
+	}
+	result.Reported = sigs[0]
+	label, params, ok := strings.Cut(strings.TrimSpace(inputs[0]), "=")
+	if !ok || !strings.HasPrefix(params, "(") || !strings.Contains(params, ")") {
+		return fail(fmt.Errorf("%w in %s: %q", ErrMalformedTrailer, signatureInputTrailer, inputs[0]))
+	}
+	signatures, err := parseDigestDictionary(sigs[0])
+	if err != nil {
+		return fail(fmt.Errorf("%w in %s: %w", ErrMalformedTrailer, signatureTrailer, err))
+	}
+	sig, ok := signatures[label]
+	if !ok {
+		return fail(fmt.Errorf("%w: no signature labelled %q", ErrBadSignature, label))
+	}
+
+	list, paramList, _ := strings.Cut(params[1:], ")")
+	components := strings.Fields(list)
+	keyID := signatureParam(paramList, "keyid")
+	result.Computed = "keyid=" + keyID
+	if !slices.ContainsFunc(components, func(c string) bool { return strings.EqualFold(c, `"content-digest";tr`) }) {
+		return fail(fmt.Errorf("%w: it does not cover the Content-Digest trailer", ErrBadSignature))
+	}
+	key, ok := keys[keyID]
+	if !ok {
+		return fail(fmt.Errorf("%w: unknown keyid %q", ErrBadSignature, keyID))
+	}
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return fail(err)
+	}
+	if claimed := signatureParam(paramList, "alg"); claimed != "" && claimed != alg {
+		return fail(fmt.Errorf("%w: alg %q does not match the %s key", ErrBadSignature, claimed, alg))
+	}
+	base, err := signatureBase(r, components, params)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %w", ErrBadSignature, err))
+	}
+
+	if !verifyBytes(key, []byte(base), sig) {
+		return fail(ErrBadSignature)
+	}
+	// The parameters are covered by the signature, so only now can its times be trusted
+	if err := checkSignatureTimes(paramList, maxAge, time.Now()); err != nil {
+		return fail(err)
+	}
+	if !bound {
+		return fail(fmt.Errorf("%w: the body does not verify against the Content-Digest it covers", ErrBadSignature))
+	}
+	result.Matched = true
+	return result
+} // verifyMessageSignature() func
+
+// checkSignatureTimes checks the created and expires parameters of a verified signature at
+// now: created is required, at most maxAge old and at most DefaultTimestampSkew ahead, and
+// expires, if any, must not have passed, give or take that skew
+func checkSignatureTimes(params string, maxAge time.Duration, now time.Time) error {
+	if maxAge == 0 {
+		maxAge = DefaultSignatureMaxAge
+	}
+	created, err := strconv.ParseInt(signatureParam(params, "created"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: no valid created parameter", ErrBadSignature)
+	}
+	switch signed := time.Unix(created, 0); {
+	case signed.After(now.Add(DefaultTimestampSkew)):
+		return fmt.Errorf("%w: created %s, in the future", ErrBadSignature, signed.UTC().Format(time.RFC3339))
+	case now.Sub(signed) > maxAge:
+		return fmt.Errorf("%w: created %s, more than %s ago", ErrBadSignature, signed.UTC().Format(time.RFC3339), maxAge)
+	}
+	if value := signatureParam(params, "expires"); value != "" {
+		expires, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid expires parameter %q", ErrBadSignature, value)
+		}
+		if until := time.Unix(expires, 0); now.After(until.Add(DefaultTimestampSkew)) {
+			return fmt.Errorf("%w: expired %s", ErrBadSignature, until.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+} // checkSignatureTimes() func
+
+// signatureParam returns the value of a parameter such as ;keyid="k1" after the component list
+func signatureParam(params, name string) string {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key == name {
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+			return value
+		}
+	}
+	return ""
+} // signatureParam() func
@@ -0,0 +1,138 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest returns a request whose signature trailers sign params with key
+func signedRequest(t *testing.T, key crypto.Signer, params string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/upload", nil)
+	r.Trailer = http.Header{"Content-Digest": {"sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:"}}
+	base, err := signatureBase(r, signatureComponents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signBytes(key, []byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Trailer.Set(signatureInputTrailer, signatureLabel+"="+params)
+	r.Trailer.Set(signatureTrailer, signatureLabel+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return r
+} // signedRequest() func
+
+func TestVerifyMessageSignatureTimes(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	keys := map[string]crypto.PublicKey{"k1": key.Public()}
+	now := time.Now().Unix()
+	params := func(extra string) string {
+		return "(" + strings.Join(signatureComponents, " ") + ")" + extra + `;keyid="k1";alg="ed25519"`
+	}
+	created := func(at int64) string { return ";created=" + strconv.FormatInt(at, 10) }
+	for _, tc := range []struct {
+		name   string
+		params string
+		ok     bool
+	}{
+		{"fresh", params(created(now)), true},
+		{"slightly ahead", params(created(now + 10)), true},
+		{"unexpired", params(created(now) + ";expires=" + strconv.FormatInt(now+60, 10)), true},
+		{"no created", params(""), false},
+		{"too old", params(created(now - int64(DefaultSignatureMaxAge/time.Second) - 60)), false},
+		{"in the future", params(created(now + 3600)), false},
+		{"expired", params(created(now-600) + ";expires=" + strconv.FormatInt(now-300, 10)), false},
+	} {
+		result := verifyMessageSignature(signedRequest(t, key, tc.params), keys, 0, true)
+		if result.Matched != tc.ok || !tc.ok && !errors.Is(result.Err, ErrBadSignature) {
+			t.Errorf("%s: matched %v, err %v; want matched %v", tc.name, result.Matched, result.Err, tc.ok)
+		}
+	}
+	old := params(created(now - 3600))
+	if result := verifyMessageSignature(signedRequest(t, key, old), keys, 2*time.Hour, true); !result.Matched {
+		t.Errorf("hour-old signature under a 2h SignatureMaxAge: %v", result.Err)
+	}
+}
+
+func TestVerifyMessageSignatureTampered(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := map[string]crypto.PublicKey{"k1": &key.PublicKey}
+	params := "(" + strings.Join(signatureComponents, " ") + ");created=" + strconv.FormatInt(time.Now().Unix(), 10) + `;keyid="k1";alg="ecdsa-p256-sha256"`
+	r := signedRequest(t, key, params)
+	if result := verifyMessageSignature(r, keys, 0, true); !result.Matched {
+		t.Fatalf("untouched request: %v", result.Err)
+	}
+	r.Trailer.Set("Content-Digest", "sha-256=:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=:")
+	if result := verifyMessageSignature(r, keys, 0, true); result.Matched || !errors.Is(result.Err, ErrBadSignature) {
+		t.Errorf("changed Content-Digest: matched %v, err %v", result.Matched, result.Err)
+	}
+}
+
+func TestHandlerChecksSignature(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	h := NewHandler(ServerOptions{SignatureKeys: map[string]crypto.PublicKey{"k1": key.Public()}, Policy: PolicyStrict, Logger: discardLogger()})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	c := &Client{Algorithms: []string{"length", "content-digest"}, Signer: &Signer{KeyID: "k1", Key: key}, Logger: discardLogger()}
+	result, err := c.SendStream(t.Context(), srv.URL, bytes.NewReader([]byte("signed body")))
+	if err != nil || !result.Matched {
+		t.Fatalf("%+v, %v; want a verified, signed upload", result, err)
+	}
+	if !slices.ContainsFunc(result.Checks, func(c CheckSummary) bool { return c.Algorithm == "message-signature" && c.Matched }) {
+		t.Errorf("checks %+v, want a matched message-signature", result.Checks)
+	}
+}
+
+func TestHandlerSignatureBindsTheBody(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	srv := httptest.NewServer(NewHandler(ServerOptions{SignatureKeys: map[string]crypto.PublicKey{"k1": key.Public()}, Policy: PolicyStrict, Logger: discardLogger()}))
+	defer srv.Close()
+	sum := sha256.Sum256([]byte("original"))
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	params := "(" + strings.Join(signatureComponents, " ") + ");created=" + strconv.FormatInt(time.Now().Unix(), 10) + `;keyid="k1";alg="ed25519"`
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/upload", nil)
+	r.Trailer = http.Header{"Content-Digest": {digest}}
+	base, err := signatureBase(r, signatureComponents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := signBytes(key, []byte(base))
+	trailer := []RawField{
+		{"Content-Digest", digest},
+		{signatureInputTrailer, signatureLabel + "=" + params},
+		{signatureTrailer, signatureLabel + "=:" + base64.StdEncoding.EncodeToString(sig) + ":"},
+	}
+	for _, tc := range []struct {
+		name      string
+		announced string
+		body      string
+		status    int
+	}{
+		{"signed body", "Content-Digest, Signature, Signature-Input", "original", http.StatusOK},
+		{"unannounced Content-Digest, tampered body", "Signature, Signature-Input", "TAMPERED", http.StatusBadRequest},
+	} {
+		req := &RawRequest{Target: "/upload", Host: "example.com", Header: []RawField{{"Trailer", tc.announced}}, Body: []byte(tc.body), Trailer: trailer}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if raw.Response.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d:\n%s", tc.name, raw.Response.StatusCode, tc.status, raw.Body)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BodyCommitter is implemented by a ServerOptions.BodySink that wants to know the verdict
+// on the upload it received, e.g. to rename a temporary file or finish an object-store upload.
+type BodyCommitter interface {
+	Commit() error  // the body verified; keep what was written
+	Discard() error // the body failed verification or the request was rejected; drop it
+}
+
+// errBodySink marks a failure writing the body to ServerOptions.BodySink
+var errBodySink = errors.New("writing request body to sink failed")
+
+// sinkWriter tags errors from the sink, so they are not taken for errors reading the request
+type sinkWriter struct {
+	w io.Writer
+}
+
+func (s sinkWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errBodySink, err)
+	}
+	return n, err
+}
+
+// commitSink tells the sink to keep the body, if it cares
+func commitSink(sink io.Writer) error {
+	if c, ok := sink.(BodyCommitter); ok {
+		return c.Commit()
+	}
+	return nil
+} // commitSink() func
+
+// discardSink tells the sink to drop the body, if it cares
+func discardSink(sink io.Writer) error {
+	if c, ok := sink.(BodyCommitter); ok {
+		return c.Discard()
+	}
+	return nil
+} // discardSink() func
@@ -0,0 +1,48 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// bufferSink keeps the bodies it is told to commit in a bytes.Buffer and drops the others
+type bufferSink struct {
+	bytes.Buffer
+	pending int // bytes written since the last verdict
+}
+
+func (s *bufferSink) Write(p []byte) (int, error) {
+	s.pending += len(p)
+	return s.Buffer.Write(p)
+}
+
+func (s *bufferSink) Commit() error {
+	s.pending = 0
+	return nil
+}
+
+func (s *bufferSink) Discard() error {
+	s.Truncate(s.Len() - s.pending)
+	s.pending = 0
+	return nil
+}
+
+func TestHandlerBodySink(t *testing.T) {
+	sink := new(bufferSink)
+	h := NewHandler(ServerOptions{BodySink: sink, Logger: discardLogger()})
+	for _, tc := range []struct {
+		length string
+		want   string
+	}{
+		{"6", ""},      // fails: discarded
+		{"5", "hello"}, // passes: kept
+		{"4", "hello"}, // fails: the kept body stays, the new one is dropped
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), chunkedRequest("hello", http.Header{"X-Body-Byte-Length": {tc.length}}))
+		if sink.String() != tc.want {
+			t.Errorf("length trailer %s: sink holds %q, want %q", tc.length, sink.String(), tc.want)
+		}
+	}
+}
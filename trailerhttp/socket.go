@@ -0,0 +1,55 @@
+package trailerhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// socketClients caches the HTTP clients of Client.Network and Client.Addr, one per socket,
+// so uploads to the same socket reuse their connections
+var socketClients sync.Map // network + " " + addr -> *http.Client
+
+// NewSocketClient returns an HTTP client that dials addr over network for every request,
+// whatever the host of the URL, e.g. ("unix", "/run/app.sock") for a Server listening on
+// a Unix domain socket with ServerOptions.Network "unix". The URL then only names the
+// server in the Host header, as in "http://localhost/upload".
+func NewSocketClient(network, addr string) *http.Client {
+	var dialer net.Dialer
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ExpectContinueTimeout: time.Second,
+	}}
+} // NewSocketClient() func
+
+// httpClient returns the HTTP client c sends with, damaging the uploads with c.Fault
+func (c *Client) httpClient() *http.Client {
+	hc := c.baseHTTPClient()
+	if c.Fault != nil {
+		return faultClient(hc, *c.Fault)
+	}
+	return hc
+} // httpClient() func
+
+// baseHTTPClient returns HTTPClient, or the default client for c.TransportOptions and c.Network
+func (c *Client) baseHTTPClient() *http.Client {
+	switch {
+	case c.HTTPClient != nil:
+		return c.HTTPClient
+	case c.TransportOptions != nil:
+		return c.transportClient()
+	case c.Network != "":
+		key := c.Network + " " + c.Addr
+		if hc, ok := socketClients.Load(key); ok {
+			return hc.(*http.Client)
+		}
+		hc, _ := socketClients.LoadOrStore(key, NewSocketClient(c.Network, c.Addr))
+		return hc.(*http.Client)
+	default:
+		return http.DefaultClient
+	}
+} // baseHTTPClient() func
@@ -0,0 +1,146 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSpoolMemoryBytes is how much of a body SpoolBody keeps in memory when told 0
+const DefaultSpoolMemoryBytes = 1 << 20
+
+// errSpoolFailed reports a body that could not be written to its temporary file
+var errSpoolFailed = errors.New("spooling body to disk failed")
+
+// SpooledBody is a body read to its end and held for reading again: its first bytes in memory,
+// the rest, if any, in a temporary file. It is an io.ReadSeekCloser, at offset 0 when returned by
+// SpoolBody; Close removes the file.
+type SpooledBody struct {
+	mem  []byte
+	file *os.File // nil while the body fits in mem
+	size int64
+	off  int64
+}
+
+// SpoolBody reads src to its end, keeping up to memBytes in memory (0 means DefaultSpoolMemoryBytes)
+// and spilling the rest to a temporary file in dir ("" means os.TempDir()). Spooling a VerifiedBody
+// verifies it: its error, such as a *VerificationError, is returned after the spooled bytes are
+// discarded, so nothing unverified outlives the call.
+func SpoolBody(src io.Reader, memBytes int64, dir string) (*SpooledBody, error) {
+	if memBytes <= 0 {
+		memBytes = DefaultSpoolMemoryBytes
+	}
+	sw := &spoolWriter{body: &SpooledBody{}, memBytes: memBytes, dir: dir}
+	if _, err := streamBody(sw, src); err != nil {
+		sw.body.Close()
+		return nil, err
+	}
+	return sw.body, nil
+} // SpoolBody() func
+
+// Size returns the length of the body
+func (sb *SpooledBody) Size() int64 {
+	return sb.size
+} // Size() func
+
+// OnDisk reports whether the body spilled to a temporary file
+func (sb *SpooledBody) OnDisk() bool {
+	return sb.file != nil
+} // OnDisk() func
+
+func (sb *SpooledBody) Read(p []byte) (int, error) {
+	n, err := sb.ReadAt(p, sb.off)
+	sb.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads from the memory part of the body and then from its file, as one
+func (sb *SpooledBody) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("trailerhttp: negative offset")
+	}
+	if off >= sb.size {
+		return 0, io.EOF
+	}
+	n := 0
+	if off < int64(len(sb.mem)) {
+		n = copy(p, sb.mem[off:])
+	}
+	if n < len(p) && sb.file != nil {
+		m, err := sb.file.ReadAt(p[n:], off+int64(n)-int64(len(sb.mem)))
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if off+int64(n) >= sb.size && n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (sb *SpooledBody) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sb.off
+	case io.SeekEnd:
+		offset += sb.size
+	default:
+		return sb.off, errors.New("trailerhttp: invalid whence")
+	}
+	if offset < 0 {
+		return sb.off, errors.New("trailerhttp: negative position")
+	}
+	sb.off = offset
+	return offset, nil
+}
+
+// Close drops the body, removing its temporary file
+func (sb *SpooledBody) Close() error {
+	sb.mem = nil
+	if sb.file == nil {
+		return nil
+	}
+	err := errors.Join(sb.file.Close(), os.Remove(sb.file.Name()))
+	sb.file = nil
+	return err
+}
+
+// spoolWriter fills a SpooledBody, opening its file once memBytes are in memory
+type spoolWriter struct {
+	body     *SpooledBody
+	memBytes int64
+	dir      string
+}
+
+func (sw *spoolWriter) Write(p []byte) (int, error) {
+	sb := sw.body
+	written := 0
+	if room := sw.memBytes - int64(len(sb.mem)); room > 0 && sb.file == nil {
+		n := int(min(room, int64(len(p))))
+		sb.mem = append(sb.mem, p[:n]...)
+		sb.size += int64(n)
+		written, p = n, p[n:]
+	}
+	if len(p) == 0 {
+		return written, nil
+	}
+	if sb.file == nil {
+		f, err := os.CreateTemp(sw.dir, "trailerhttp-spool-*")
+		if err != nil {
+			return written, fmt.Errorf("%w: %w", errSpoolFailed, err)
+		}
+		sb.file = f
+	}
+	n, err := sb.file.Write(p)
+	sb.size += int64(n)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", errSpoolFailed, err)
+	}
+	return written + n, err
+}
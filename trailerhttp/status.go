@@ -0,0 +1,130 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Status trailers report the final outcome of processing a streamed body, like grpc-status and
+// grpc-message: the status line goes out before the body, when the outcome is often not known yet.
+const (
+	statusCodeTrailer    = "X-Status-Code"
+	statusMessageTrailer = "X-Status-Message"
+)
+
+// StatusTrailers names the status trailer fields, for peers that standardized on other names
+// than X-Status-Code and X-Status-Message; an empty name means the default one.
+// ServerOptions.StatusTrailers and Client.StatusTrailers take it, and its methods work like
+// AnnounceStatus, SetStatus and ResponseStatus.
+type StatusTrailers struct {
+	Code    string // carries the HTTP status code
+	Message string // carries the percent-encoded message
+}
+
+// names returns the field names of t, the defaults filled in
+func (t StatusTrailers) names() (code, message string) {
+	code, message = statusCodeTrailer, statusMessageTrailer
+	if t.Code != "" {
+		code = http.CanonicalHeaderKey(t.Code)
+	}
+	if t.Message != "" {
+		message = http.CanonicalHeaderKey(t.Message)
+	}
+	return code, message
+} // names() func
+
+// StatusError is a failure a server reported in the X-Status-Code and X-Status-Message
+// response trailers, after the response status line had already been sent
+type StatusError struct {
+	Code    int    // an HTTP status code
+	Message string // may be empty
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server reported status %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("server reported status %d: %s", e.Code, e.Message)
+} // Error() func
+
+// AnnounceStatus declares the status trailers in the response header of w.
+// Call it before the handler writes the header or body, and SetStatus once the outcome is known.
+func AnnounceStatus(w http.ResponseWriter) {
+	StatusTrailers{}.Announce(w)
+} // AnnounceStatus() func
+
+// Announce declares the status trailers of t in the response header of w, as AnnounceStatus does
+func (t StatusTrailers) Announce(w http.ResponseWriter) {
+	code, message := t.names()
+	w.Header().Add("Trailer", code+","+message)
+} // Announce() func
+
+// SetStatus sets the status trailers of w to code, an HTTP status code, and message.
+// net/http sends them after the body when the handler returns.
+func SetStatus(w http.ResponseWriter, code int, message string) {
+	StatusTrailers{}.Set(w.Header(), code, message)
+} // SetStatus() func
+
+// Set sets the status trailers of t in header, the header of a response writer or a trailer
+// section, to code and message, as SetStatus does
+func (t StatusTrailers) Set(header http.Header, code int, message string) {
+	codeField, messageField := t.names()
+	header.Set(codeField, strconv.Itoa(code))
+	if message != "" {
+		header.Set(messageField, encodeStatusMessage(message))
+	}
+} // Set() func
+
+// ResponseStatus returns the outcome reported in the status trailers of resp, whose body must
+// have been read to EOF: nil for a 2xx code or when no status trailer was sent, a *StatusError
+// otherwise. A malformed X-Status-Code is reported with ErrMalformedTrailer.
+func ResponseStatus(resp *http.Response) error {
+	return StatusTrailers{}.Response(resp)
+} // ResponseStatus() func
+
+// Response returns the outcome reported in the status trailers of t of resp, as ResponseStatus does
+func (t StatusTrailers) Response(resp *http.Response) error {
+	codeField, messageField := t.names()
+	trailers := Trailers(resp.Trailer)
+	code, err := trailers.GetInt64(codeField)
+	switch {
+	case errors.Is(err, ErrMissingTrailer):
+		return nil
+	case err != nil:
+		return err
+	case code < 100 || code > 999:
+		return malformedTrailerError(codeField, strconv.FormatInt(code, 10), errors.New("not an HTTP status code"))
+	case code >= 200 && code < 300:
+		return nil
+	}
+	statusErr := &StatusError{Code: int(code)}
+	if message, err := trailers.Get(messageField); err == nil {
+		statusErr.Message = decodeStatusMessage(message)
+	}
+	return statusErr
+} // Response() func
+
+// encodeStatusMessage percent-encodes the bytes a field value cannot carry, as grpc-message does
+func encodeStatusMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+} // encodeStatusMessage() func
+
+// decodeStatusMessage reverses encodeStatusMessage, keeping a value it cannot decode as it is
+func decodeStatusMessage(value string) string {
+	if decoded, err := url.PathUnescape(value); err == nil {
+		return decoded
+	}
+	return value
+} // decodeStatusMessage() func
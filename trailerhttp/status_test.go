@@ -0,0 +1,63 @@
+package trailerhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// statusServer answers with a body and then the outcome code and message in the status trailers names
+func statusServer(t *testing.T, names StatusTrailers, code int, message string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names.Announce(w)
+		io.WriteString(w, "streamed before the outcome was known")
+		names.Set(w.Header(), code, message)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+} // statusServer() func
+
+func TestResponseStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		code    int
+		message string
+		want    *StatusError // nil for success
+	}{
+		{"success", http.StatusOK, "", nil},
+		{"failure", http.StatusInsufficientStorage, "disk full: 100%\n", &StatusError{Code: http.StatusInsufficientStorage, Message: "disk full: 100%\n"}},
+		{"failure without message", http.StatusInternalServerError, "", &StatusError{Code: http.StatusInternalServerError}},
+	} {
+		resp, err := http.Get(statusServer(t, StatusTrailers{}, tc.code, tc.message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Trailer.Get("X-Status-Code") == "" {
+			t.Errorf("%s: status %d, trailer %v; want 200 with the outcome in X-Status-Code", tc.name, resp.StatusCode, resp.Trailer)
+		}
+		err = ResponseStatus(resp)
+		var statusErr *StatusError
+		switch {
+		case tc.want == nil && err != nil:
+			t.Errorf("%s: %v, want nil", tc.name, err)
+		case tc.want != nil && (!errors.As(err, &statusErr) || *statusErr != *tc.want):
+			t.Errorf("%s: %v, want %+v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestDownloadStatusTrailers(t *testing.T) {
+	names := StatusTrailers{Code: "X-Outcome", Message: "X-Outcome-Message"}
+	url := statusServer(t, names, http.StatusServiceUnavailable, "backend went away")
+	c := &Client{StatusTrailers: names, Logger: discardLogger()}
+	_, err := c.Download(t.Context(), url, io.Discard)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable || statusErr.Message != "backend went away" {
+		t.Errorf("Download: %v, want the 503 reported in X-Outcome", err)
+	}
+}
@@ -0,0 +1,327 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Store keeps blobs under their digest, the lowercase hex SHA-256 of their content
+type Store interface {
+	// Create stages a new blob; the bytes written to it are kept only once it is committed
+	Create() (StagedBlob, error)
+	// Open returns the blob stored under digest and its size, or an error wrapping fs.ErrNotExist
+	Open(digest string) (io.ReadCloser, int64, error)
+}
+
+// StagedBlob is a blob being written to a Store
+type StagedBlob interface {
+	io.Writer
+	// Commit keeps the bytes written under their digest, which the store computes itself.
+	// duplicate reports that the store already held them; the new copy is dropped then.
+	Commit() (digest string, duplicate bool, err error)
+	// Discard drops the bytes written
+	Discard() error
+}
+
+// errUnverifiedBlob reports a blob upload that arrived whole but that no trailer check covered
+var errUnverifiedBlob = errors.New("no trailer verified the body")
+
+// FSStore is a Store in a directory: blobs live in <dir>/<first two digits>/<digest>, staged
+// ones in <dir>/tmp. It is also an ETagIndex of the blobs it holds, for ServerOptions.ETags.
+type FSStore struct {
+	dir string
+}
+
+// NewFSStore returns a store in dir, creating the directory if needed
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o750); err != nil {
+		return nil, err
+	}
+	return &FSStore{dir: dir}, nil
+} // NewFSStore() func
+
+// path returns the file of the blob with digest, or "" for anything that is not a digest
+func (s *FSStore) path(digest string) string {
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha256.Size || strings.ToLower(digest) != digest {
+		return ""
+	}
+	return filepath.Join(s.dir, digest[:2], digest)
+} // path() func
+
+func (s *FSStore) Create() (StagedBlob, error) {
+	f, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "blob-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fsStagedBlob{store: s, f: f, sum: sha256.New()}, nil
+} // Create() func
+
+func (s *FSStore) Open(digest string) (io.ReadCloser, int64, error) {
+	path := s.path(digest)
+	if path == "" {
+		return nil, 0, fmt.Errorf("blob %q: %w", digest, fs.ErrNotExist)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+} // Open() func
+
+// HasETag reports whether the store holds the blob of an ETag from ContentETag
+func (s *FSStore) HasETag(etag string) bool {
+	digest, ok := strings.CutPrefix(strings.Trim(etag, `"`), "sha256-")
+	if path := s.path(digest); ok && path != "" {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	return false
+} // HasETag() func
+
+// AddETag does nothing: a blob is indexed by being stored
+func (s *FSStore) AddETag(string) {}
+
+// fsStagedBlob is a blob of an FSStore being written, hashed on the way
+type fsStagedBlob struct {
+	store *FSStore
+	f     *os.File
+	sum   hash.Hash
+}
+
+func (b *fsStagedBlob) Write(p []byte) (int, error) {
+	n, err := b.f.Write(p)
+	b.sum.Write(p[:n])
+	return n, err
+}
+
+func (b *fsStagedBlob) Commit() (string, bool, error) {
+	if err := errors.Join(b.f.Sync(), b.f.Close()); err != nil {
+		os.Remove(b.f.Name())
+		return "", false, err
+	}
+	defer os.Remove(b.f.Name())
+	digest := hex.EncodeToString(b.sum.Sum(nil))
+	path := b.store.path(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", false, err
+	}
+	// Linking fails if the blob exists, so of two identical uploads at once only one stores it
+	if err := os.Link(b.f.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return digest, true, nil
+		}
+		return "", false, err
+	}
+	return digest, false, nil
+} // Commit() func
+
+func (b *fsStagedBlob) Discard() error {
+	return errors.Join(b.f.Close(), os.Remove(b.f.Name()))
+}
+
+// BlobOptions configures a BlobHandler
+type BlobOptions struct {
+	Store    Store // where verified uploads are kept; required
+	MaxBytes int64 // largest blob accepted; 0 means no limit
+
+	// Algorithms are the trailers every upload must carry and verify before it is stored;
+	// nil means "sha256", the digest the blob is stored under
+	Algorithms []string
+	HMACKey    []byte // shared secret for the "hmac-sha256" algorithm
+
+	Logger *slog.Logger // nil means slog.Default()
+}
+
+// BlobHandler serves a content-addressable store of integrity-checked blobs:
+//
+//	POST /          stores the body, once its trailers verified it: 201 Created, or 200 OK when
+//	                the store already held it, with Location: /<digest> and the ETag
+//	GET  /<digest>  streams the blob back with ETag and sha256, Content-Digest and length trailers
+//
+// An upload answers with the JSON UploadResult the Client decodes, with Stored, Duplicate and
+// ETag set, and one whose If-None-Match lists the ETag of a stored blob is answered 412 before
+// its body is read when the Store is an ETagIndex, as FSStore is, so Client.SendIfNoneMatch
+// skips content already stored. Mount it under a prefix with http.StripPrefix, as ResumableHandler.
+type BlobHandler struct {
+	opts     BlobOptions
+	required []trailerVerifier
+	logger   *slog.Logger
+	mux      *http.ServeMux
+}
+
+// NewBlobHandler resolves opts into a handler. It panics if opts.Store is nil or
+// opts.Algorithms names an unknown verifier, like NewHandler.
+func NewBlobHandler(opts BlobOptions) *BlobHandler {
+	if opts.Store == nil {
+		panic("BlobOptions.Store: no store")
+	}
+	algorithms := opts.Algorithms
+	if algorithms == nil {
+		algorithms = []string{"sha256"}
+	}
+	h := &BlobHandler{opts: opts, logger: orDefaultLogger(opts.Logger).With("component", "blobs")}
+	for _, name := range algorithms {
+		v, err := lookupVerifier(strings.TrimSpace(name))
+		if err != nil {
+			panic("BlobOptions.Algorithms: " + err.Error())
+		}
+		h.required = append(h.required, v)
+	}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("POST /{$}", h.put)
+	h.mux.HandleFunc("GET /{digest}", h.get)
+	return h
+} // NewBlobHandler() func
+
+func (h *BlobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+} // ServeHTTP() func
+
+// put stores the request body under its digest, if its trailers verify
+func (h *BlobHandler) put(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	summary := &UploadResult{RequestID: requestID(r), Method: r.Method, Proto: r.Proto, HeaderCount: len(r.Header), AnnouncedTrailers: AnnouncedTrailers(r)}
+	w.Header().Set(requestIDHeader, summary.RequestID)
+	log := h.logger.With("request_id", summary.RequestID)
+	for _, v := range h.required {
+		if _, announced := lookupField(r.Trailer, v.TrailerName); !announced {
+			summary.Error = fmt.Sprintf("every upload must announce the %s trailer", v.TrailerName)
+			h.respond(w, http.StatusBadRequest, summary)
+			return
+		}
+	}
+	if index, ok := h.opts.Store.(ETagIndex); ok {
+		if etag := matchingETag(r, index); etag != "" {
+			summary.ETag, summary.Error = etag, "content already stored"
+			w.Header().Set("ETag", etag)
+			h.respond(w, http.StatusPreconditionFailed, summary)
+			return
+		}
+	}
+
+	blob, err := h.opts.Store.Create()
+	if err != nil {
+		log.Error("Could not stage blob", "err", err)
+		summary.Error = "could not stage blob"
+		h.respond(w, http.StatusInternalServerError, summary)
+		return
+	}
+	// Nothing is stored before the verdict
+	vb := NewVerifiedBody(r, h.opts.HMACKey)
+	body := io.Reader(vb)
+	if h.opts.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, vb, h.opts.MaxBytes)
+	}
+	n, copyErr := io.Copy(blob, body)
+	if copyErr == nil && !vb.Verified() {
+		copyErr = errUnverifiedBlob
+	}
+	summary.BodyLength, summary.DeliveredTrailers = n, deliveredTrailers(r.Trailer)
+	for _, result := range vb.Results() {
+		summary.addCheck(result)
+	}
+	if copyErr != nil {
+		err := blob.Discard()
+		log.Warn("Discarded unverified blob", "bytes", n, "err", copyErr, "discard_err", err)
+		summary.Error = copyErr.Error()
+		var tooLarge *http.MaxBytesError
+		var failed *VerificationError
+		status := http.StatusBadRequest
+		switch {
+		case errors.As(copyErr, &tooLarge):
+			summary.Error = fmt.Sprintf("blob exceeds the %d byte limit", tooLarge.Limit)
+			status = http.StatusRequestEntityTooLarge
+		case errors.As(copyErr, &failed):
+			summary.MissingTrailers = failed.Missing
+		}
+		h.respond(w, status, summary)
+		return
+	}
+
+	digest, duplicate, err := blob.Commit()
+	if err != nil {
+		log.Error("Could not store blob", "err", err)
+		summary.Error = "could not store blob"
+		h.respond(w, http.StatusInternalServerError, summary)
+		return
+	}
+	sum, _ := hex.DecodeString(digest)
+	summary.Stored, summary.Duplicate, summary.ETag = true, duplicate, sha256ETag(sum)
+	// Location is resolved against the request as the client sent it, before any StripPrefix
+	base := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		base = u.Path
+	}
+	w.Header().Set("Location", strings.TrimSuffix(base, "/")+"/"+digest)
+	w.Header().Set("ETag", summary.ETag)
+	log.Info("Stored blob", "digest", digest, "bytes", n, "duplicate", duplicate)
+	status := http.StatusCreated
+	if duplicate {
+		status = http.StatusOK
+	}
+	h.respond(w, status, summary)
+} // put() func
+
+// get streams the blob of the path's digest, followed by its integrity trailers
+func (h *BlobHandler) get(w http.ResponseWriter, r *http.Request) {
+	digest := r.PathValue("digest")
+	blob, size, err := h.opts.Store.Open(digest)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		h.logger.Error("Could not open blob", "digest", digest, "err", err)
+		http.Error(w, "could not open blob", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+	sum, _ := hex.DecodeString(digest)
+	etag := sha256ETag(sum)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable") // the content is its name
+	if slices.Contains(ifNoneMatch(r), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	tw := NewTrailerResponseWriter(w, r, AlgoSHA256, AlgoContentDigest)
+	if tw.set == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10)) // no trailers follow the body
+	}
+	w.WriteHeader(http.StatusOK)
+	if n, err := io.Copy(tw, blob); err != nil {
+		h.logger.Warn("Error streaming blob", "digest", digest, "bytes", n, "err", err)
+		return
+	}
+	tw.Finish()
+} // get() func
+
+// respond sends the upload's summary as JSON
+func (h *BlobHandler) respond(w http.ResponseWriter, status int, summary *UploadResult) {
+	summary.Outcome = classifyOutcome(summary)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		h.logger.Error("Error writing response", "request_id", summary.RequestID, "err", err)
+	}
+} // respond() func
@@ -0,0 +1,104 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFSStorePath(t *testing.T) {
+	s, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("blob"))
+	digest := hex.EncodeToString(sum[:])
+	if got, want := s.path(digest), filepath.Join(s.dir, digest[:2], digest); got != want {
+		t.Errorf("path(%s) = %q, want %q", digest, got, want)
+	}
+	for _, bad := range []string{"", "../../etc/passwd", "..", digest[:62] + "/.", strings.ToUpper(digest), digest[:62], digest + "00", strings.Repeat("g", 64)} {
+		if got := s.path(bad); got != "" {
+			t.Errorf("path(%q) = %q, want it refused", bad, got)
+		}
+		if _, _, err := s.Open(bad); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%q): %v, want fs.ErrNotExist", bad, err)
+		}
+	}
+}
+
+func TestBlobHandler(t *testing.T) {
+	store, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.StripPrefix("/blobs", NewBlobHandler(BlobOptions{Store: store, Logger: discardLogger()})))
+	defer srv.Close()
+	body := []byte("content-addressed bytes")
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+
+	resp := postWithTrailer(t, srv.URL+"/blobs/", body, ComputeTrailers(body, AlgoSHA256))
+	location, etag := resp.Header.Get("Location"), resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusCreated || location != "/blobs/"+digest || etag != sha256ETag(sum[:]) {
+		t.Errorf("first upload: status %d, Location %q, ETag %q; want 201 at /blobs/%s with its ETag", resp.StatusCode, location, etag, digest)
+	}
+	if resp := postWithTrailer(t, srv.URL+"/blobs/", body, ComputeTrailers(body, AlgoSHA256)); resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != location {
+		t.Errorf("duplicate upload: status %d, Location %q; want 200 at %s", resp.StatusCode, resp.Header.Get("Location"), location)
+	}
+
+	// A body that does not verify is discarded, staged file included
+	other := []byte("tampered bytes")
+	for name, trailer := range map[string]http.Header{
+		"digest mismatch": ComputeTrailers(body, AlgoSHA256),
+		"no sha256":       ComputeTrailers(other),
+	} {
+		if resp := postWithTrailer(t, srv.URL+"/blobs/", other, trailer); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, resp.StatusCode)
+		}
+	}
+	otherSum := sha256.Sum256(other)
+	if _, _, err := store.Open(hex.EncodeToString(otherSum[:])); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unverified blob: Open = %v, want it never stored", err)
+	}
+	if staged, _ := os.ReadDir(filepath.Join(store.dir, "tmp")); len(staged) != 0 {
+		t.Errorf("%d staged files left behind, want none", len(staged))
+	}
+
+	// GET streams it back with its trailers, or 304 for its ETag
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+location, nil)
+	req.Header.Set("TE", "trailers")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vb := NewVerifiedResponse(resp, nil)
+	got, err := io.ReadAll(vb)
+	resp.Body.Close()
+	if string(got) != string(body) || err != nil || !vb.Verified() || resp.Trailer.Get("X-Body-Sha256") == "" || resp.Trailer.Get("Content-Digest") == "" {
+		t.Errorf("GET: body %q, error %v, verified %v, trailers %v; want the blob with verifying sha256 and Content-Digest trailers", got, err, vb.Verified(), resp.Trailer)
+	}
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified || resp.Header.Get("ETag") != etag {
+		t.Errorf("GET If-None-Match: status %d, ETag %q; want 304 with %s", resp.StatusCode, resp.Header.Get("ETag"), etag)
+	}
+	if resp, err = http.Get(srv.URL + "/blobs/" + strings.Repeat("0", 64)); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a blob not stored: status %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,594 @@
+package trailerhttp
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Token is an RFC 8941 token, such as sha-256, told apart from a String when encoded
+type Token string
+
+// Item is an RFC 8941 item with its parameters. Value is an int64, a float64 (a Decimal),
+// a string, a Token, a []byte (a Byte Sequence) or a bool; as a member of a list or
+// dictionary it may also be an InnerList. EncodeItem also accepts an int for an Integer.
+type Item struct {
+	Value  any
+	Params Params
+}
+
+// InnerList is a parenthesized list of items, the value of a list or dictionary member
+type InnerList []Item
+
+// Param is one parameter of an item; a parameter without a value is the Boolean true
+type Param struct {
+	Key   string
+	Value any
+}
+
+// Params are the parameters of an item, in the order they appeared
+type Params []Param
+
+// Get returns the value of the parameter key
+func (p Params) Get(key string) (any, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+} // Get() func
+
+// DictMember is one member of a Dictionary
+type DictMember struct {
+	Key string
+	Item
+}
+
+// Dictionary is an RFC 8941 dictionary, such as
+//
+//	len=5;alg="sha-256", root=:base64:
+//
+// in the order its keys first appeared; a repeated key keeps the last value
+type Dictionary []DictMember
+
+// Get returns the member key
+func (d Dictionary) Get(key string) (Item, bool) {
+	for _, member := range d {
+		if member.Key == key {
+			return member.Item, true
+		}
+	}
+	return Item{}, false
+} // Get() func
+
+// Integer returns the member key if it is an Integer
+func (d Dictionary) Integer(key string) (int64, bool) {
+	item, _ := d.Get(key)
+	n, ok := item.Value.(int64)
+	return n, ok
+} // Integer() func
+
+// Bytes returns the member key if it is a Byte Sequence
+func (d Dictionary) Bytes(key string) ([]byte, bool) {
+	item, _ := d.Get(key)
+	b, ok := item.Value.([]byte)
+	return b, ok
+} // Bytes() func
+
+// errStructuredField is wrapped by the parse errors of structured field values
+var errStructuredField = errors.New("invalid structured field")
+
+// sfParser parses one field value from the left (RFC 8941, Section 4.2)
+type sfParser struct {
+	s   string
+	pos int
+}
+
+func (p *sfParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w at offset %d: %s", errStructuredField, p.pos, fmt.Sprintf(format, args...))
+} // errorf() func
+
+func (p *sfParser) eof() bool { return p.pos >= len(p.s) }
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+} // peek() func
+
+func (p *sfParser) skipSP() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+} // skipSP() func
+
+func (p *sfParser) skipOWS() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.pos++
+	}
+} // skipOWS() func
+
+// parseField runs parse over the whole of s, discarding surrounding spaces
+func parseField[T any](s string, parse func(*sfParser) (T, error)) (T, error) {
+	p := &sfParser{s: strings.Trim(s, " ")}
+	v, err := parse(p)
+	if err == nil && !p.eof() {
+		err = p.errorf("unexpected %q", p.peek())
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+} // parseField() func
+
+// ParseItem parses an RFC 8941 item field value, such as 5;unit=bytes
+func ParseItem(s string) (Item, error) {
+	return parseField(s, (*sfParser).parseItem)
+} // ParseItem() func
+
+// ParseList parses an RFC 8941 list field value, such as sha-256, (a b);q=1
+func ParseList(s string) ([]Item, error) {
+	return parseField(s, (*sfParser).parseList)
+} // ParseList() func
+
+// ParseDictionary parses an RFC 8941 dictionary field value, such as len=5;alg="sha-256"
+func ParseDictionary(s string) (Dictionary, error) {
+	return parseField(s, (*sfParser).parseDictionary)
+} // ParseDictionary() func
+
+func (p *sfParser) parseList() ([]Item, error) {
+	var list []Item
+	for !p.eof() {
+		member, err := p.parseMember()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, member)
+		if !p.nextMember() {
+			break
+		}
+	}
+	return list, nil
+} // parseList() func
+
+// parseDictionary parses a dictionary; a repeated key keeps its first position and its last value
+func (p *sfParser) parseDictionary() (Dictionary, error) {
+	members, err := p.parseDictionaryMembers()
+	if err != nil {
+		return nil, err
+	}
+	var dict Dictionary
+	for _, member := range members {
+		if i := dictIndex(dict, member.Key); i >= 0 {
+			dict[i].Item = member.Item
+		} else {
+			dict = append(dict, member)
+		}
+	}
+	return dict, nil
+} // parseDictionary() func
+
+// parseDictionaryMembers parses the members of a dictionary in order, repeated keys included
+func (p *sfParser) parseDictionaryMembers() (Dictionary, error) {
+	var members Dictionary
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		member := Item{Value: true}
+		if p.peek() == '=' {
+			p.pos++
+			if member, err = p.parseMember(); err != nil {
+				return nil, err
+			}
+		} else if member.Params, err = p.parseParams(); err != nil {
+			return nil, err
+		}
+		members = append(members, DictMember{Key: key, Item: member})
+		if !p.nextMember() {
+			break
+		}
+	}
+	return members, nil
+} // parseDictionaryMembers() func
+
+func dictIndex(dict Dictionary, key string) int {
+	for i, member := range dict {
+		if member.Key == key {
+			return i
+		}
+	}
+	return -1
+} // dictIndex() func
+
+// nextMember consumes the comma between list or dictionary members, reporting whether one follows.
+// A trailing comma is left unconsumed, for parseField to reject.
+func (p *sfParser) nextMember() bool {
+	p.skipOWS()
+	if p.peek() != ',' {
+		return false
+	}
+	start := p.pos
+	p.pos++
+	p.skipOWS()
+	if p.eof() {
+		p.pos = start
+		return false
+	}
+	return true
+} // nextMember() func
+
+// parseMember parses an item or an inner list
+func (p *sfParser) parseMember() (Item, error) {
+	if p.peek() != '(' {
+		return p.parseItem()
+	}
+	p.pos++
+	var inner InnerList
+	for {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.parseParams()
+			return Item{Value: inner, Params: params}, err
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return Item{}, err
+		}
+		inner = append(inner, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return Item{}, p.errorf("inner list not closed")
+		}
+	}
+} // parseMember() func
+
+func (p *sfParser) parseItem() (Item, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.parseParams()
+	return Item{Value: value, Params: params}, err
+} // parseItem() func
+
+func (p *sfParser) parseParams() (Params, error) {
+	var params Params
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value any = true
+		if p.peek() == '=' {
+			p.pos++
+			if value, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		if i := paramIndex(params, key); i >= 0 {
+			params[i].Value = value
+		} else {
+			params = append(params, Param{Key: key, Value: value})
+		}
+	}
+	return params, nil
+} // parseParams() func
+
+func paramIndex(params Params, key string) int {
+	for i, param := range params {
+		if param.Key == key {
+			return i
+		}
+	}
+	return -1
+} // paramIndex() func
+
+func isLCAlpha(c byte) bool { return 'a' <= c && c <= 'z' }
+func isDigit(c byte) bool   { return '0' <= c && c <= '9' }
+func isAlpha(c byte) bool   { return isLCAlpha(c) || 'A' <= c && c <= 'Z' }
+
+// isTChar reports whether c is an RFC 9110 token character
+func isTChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+} // isTChar() func
+
+func isBase64Char(c byte) bool { return isAlpha(c) || isDigit(c) || c == '+' || c == '/' || c == '=' }
+
+func isKeyChar(c byte) bool { return isLCAlpha(c) || isDigit(c) || strings.IndexByte("_-.*", c) >= 0 }
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.errorf("key must start with a lowercase letter or *")
+	}
+	for !p.eof() && isKeyChar(p.peek()) {
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+} // parseKey() func
+
+func (p *sfParser) parseBareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == '*' || isAlpha(c):
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	case p.eof():
+		return nil, p.errorf("missing item")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+} // parseBareItem() func
+
+func (p *sfParser) parseNumber() (any, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.errorf("number without digits")
+	}
+	digits, point := 0, -1
+	for ; !p.eof(); p.pos++ {
+		if c := p.peek(); isDigit(c) {
+			digits++
+		} else if c == '.' && point < 0 && digits <= 12 {
+			point = digits
+		} else {
+			break
+		}
+	}
+	text := p.s[start:p.pos]
+	if point < 0 {
+		if digits > 15 {
+			return nil, p.errorf("integer %s has more than 15 digits", text)
+		}
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, p.errorf("integer %s: %v", text, err)
+		}
+		return n, nil
+	}
+	if fraction := digits - point; fraction < 1 || fraction > 3 {
+		return nil, p.errorf("decimal %s must have 1 to 3 fractional digits", text)
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorf("decimal %s: %v", text, err)
+	}
+	return f, nil
+} // parseNumber() func
+
+func (p *sfParser) parseString() (string, error) {
+	p.pos++ // the opening quote
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if next := p.peek(); next != '"' && next != '\\' {
+				return "", p.errorf("invalid escape in string")
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("string byte %#x is not printable ASCII", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("string not closed")
+} // parseString() func
+
+func (p *sfParser) parseToken() Token {
+	start := p.pos
+	for p.pos++; !p.eof(); p.pos++ {
+		if c := p.peek(); !isTChar(c) && c != ':' && c != '/' {
+			break
+		}
+	}
+	return Token(p.s[start:p.pos])
+} // parseToken() func
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.pos++ // the opening colon
+	end := strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, p.errorf("byte sequence not closed")
+	}
+	encoded := p.s[p.pos : p.pos+end]
+	if strings.ContainsFunc(encoded, func(r rune) bool { return r > 0x7e || !isBase64Char(byte(r)) }) {
+		return nil, p.errorf("byte sequence is not base64")
+	}
+	p.pos += end + 1
+	// Senders should pad, but RFC 8941 lets recipients accept unpadded base64
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, p.errorf("byte sequence: %v", err)
+	}
+	return b, nil
+} // parseByteSequence() func
+
+func (p *sfParser) parseBoolean() (bool, error) {
+	p.pos++ // the question mark
+	switch p.peek() {
+	case '1':
+		p.pos++
+		return true, nil
+	case '0':
+		p.pos++
+		return false, nil
+	}
+	return false, p.errorf("boolean must be ?1 or ?0")
+} // parseBoolean() func
+
+// EncodeItem serializes an item as an RFC 8941 field value (Section 4.1.3)
+func EncodeItem(item Item) (string, error) {
+	var b strings.Builder
+	err := writeItem(&b, item)
+	return b.String(), err
+} // EncodeItem() func
+
+// EncodeList serializes items, inner lists among them, as an RFC 8941 list field value
+func EncodeList(list []Item) (string, error) {
+	var b strings.Builder
+	for i, member := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeMember(&b, member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+} // EncodeList() func
+
+// EncodeDictionary serializes dict as an RFC 8941 dictionary field value;
+// a member whose value is true is written as its key and parameters alone
+func EncodeDictionary(dict Dictionary) (string, error) {
+	var b strings.Builder
+	for i, member := range dict {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeKey(&b, member.Key); err != nil {
+			return "", err
+		}
+		if member.Value == true {
+			if err := writeParams(&b, member.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeMember(&b, member.Item); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+} // EncodeDictionary() func
+
+func writeMember(b *strings.Builder, member Item) error {
+	inner, ok := member.Value.(InnerList)
+	if !ok {
+		return writeItem(b, member)
+	}
+	b.WriteByte('(')
+	for i, item := range inner {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if err := writeItem(b, item); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return writeParams(b, member.Params)
+} // writeMember() func
+
+func writeItem(b *strings.Builder, item Item) error {
+	if err := writeBareItem(b, item.Value); err != nil {
+		return err
+	}
+	return writeParams(b, item.Params)
+} // writeItem() func
+
+func writeParams(b *strings.Builder, params Params) error {
+	for _, param := range params {
+		b.WriteByte(';')
+		if err := writeKey(b, param.Key); err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeBareItem(b, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+} // writeParams() func
+
+func writeKey(b *strings.Builder, key string) error {
+	if key == "" || !isLCAlpha(key[0]) && key[0] != '*' || strings.ContainsFunc(key, func(r rune) bool { return r > 0x7e || !isKeyChar(byte(r)) }) {
+		return fmt.Errorf("%w: key %q", errStructuredField, key)
+	}
+	b.WriteString(key)
+	return nil
+} // writeKey() func
+
+// maxSFInteger is the largest magnitude of an RFC 8941 Integer
+const maxSFInteger = 999_999_999_999_999
+
+func writeBareItem(b *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case int:
+		return writeBareItem(b, int64(v))
+	case int64:
+		if v > maxSFInteger || v < -maxSFInteger {
+			return fmt.Errorf("%w: integer %d out of range", errStructuredField, v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		rounded := math.RoundToEven(v*1000) / 1000
+		if math.IsNaN(v) || math.Abs(rounded) >= 1e12 {
+			return fmt.Errorf("%w: decimal %v out of range", errStructuredField, v)
+		}
+		text := strconv.FormatFloat(rounded, 'f', -1, 64)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		b.WriteString(text)
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("%w: string byte %#x is not printable ASCII", errStructuredField, c)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case Token:
+		if v == "" || !isAlpha(v[0]) && v[0] != '*' || strings.ContainsFunc(string(v), func(r rune) bool { return r > 0x7e || !isTChar(byte(r)) && r != ':' && r != '/' }) {
+			return fmt.Errorf("%w: token %q", errStructuredField, v)
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf("%w: cannot encode %T", errStructuredField, value)
+	}
+	return nil
+} // writeBareItem() func
@@ -0,0 +1,161 @@
+package trailerhttp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseStructuredFields(t *testing.T) {
+	for _, tc := range []struct {
+		kind, field string
+		want        any
+		canonical   string // the encoding of want; "" means field itself
+	}{
+		{"item", "999999999999999", Item{Value: int64(999999999999999)}, ""},
+		{"item", "-999999999999999", Item{Value: int64(-999999999999999)}, ""},
+		{"item", "123456789012.123", Item{Value: 123456789012.123}, ""},
+		{"item", "1.5;unit=s", Item{Value: 1.5, Params: Params{{"unit", Token("s")}}}, ""},
+		{"item", "1.50", Item{Value: 1.5}, "1.5"},
+		{"item", `"say \"hi\" \\ bye"`, Item{Value: `say "hi" \ bye`}, ""},
+		{"item", "sha-256", Item{Value: Token("sha-256")}, ""},
+		{"item", "*foo/bar:baz", Item{Value: Token("*foo/bar:baz")}, ""},
+		{"item", ":aGVsbG8=:", Item{Value: []byte("hello")}, ""},
+		{"item", ":aGVsbG8:", Item{Value: []byte("hello")}, ":aGVsbG8=:"},
+		{"item", "::", Item{Value: []byte{}}, ""},
+		{"item", "?1;a;b=?0", Item{Value: true, Params: Params{{"a", true}, {"b", false}}}, ""},
+		{"item", "  5;a=1;a=2  ", Item{Value: int64(5), Params: Params{{"a", int64(2)}}}, "5;a=2"},
+		{"list", "sha-256, (a b);q=1, ()", []Item{
+			{Value: Token("sha-256")},
+			{Value: InnerList{{Value: Token("a")}, {Value: Token("b")}}, Params: Params{{"q", int64(1)}}},
+			{Value: InnerList(nil)},
+		}, ""},
+		{"list", `("x";p=1 2;q)`, []Item{{Value: InnerList{{Value: "x", Params: Params{{"p", int64(1)}}}, {Value: int64(2), Params: Params{{"q", true}}}}}}, ""},
+		{"list", "a,b", []Item{{Value: Token("a")}, {Value: Token("b")}}, "a, b"},
+		{"list", "", []Item(nil), ""},
+		{"dict", `len=5;alg="sha-256", root=:AAEC:`, Dictionary{
+			{"len", Item{Value: int64(5), Params: Params{{"alg", "sha-256"}}}},
+			{"root", Item{Value: []byte{0, 1, 2}}},
+		}, ""},
+		{"dict", "a=1, b, a=3", Dictionary{{"a", Item{Value: int64(3)}}, {"b", Item{Value: true}}}, "a=3, b"},
+		{"dict", "flag;x=1, set=(1 2)", Dictionary{
+			{"flag", Item{Value: true, Params: Params{{"x", int64(1)}}}},
+			{"set", Item{Value: InnerList{{Value: int64(1)}, {Value: int64(2)}}}},
+		}, ""},
+	} {
+		var got any
+		var err error
+		switch tc.kind {
+		case "item":
+			got, err = ParseItem(tc.field)
+		case "list":
+			got, err = ParseList(tc.field)
+		case "dict":
+			got, err = ParseDictionary(tc.field)
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %q: %#v, %v; want %#v", tc.kind, tc.field, got, err, tc.want)
+			continue
+		}
+		var encoded string
+		switch v := got.(type) {
+		case Item:
+			encoded, err = EncodeItem(v)
+		case []Item:
+			encoded, err = EncodeList(v)
+		case Dictionary:
+			encoded, err = EncodeDictionary(v)
+		}
+		canonical := tc.canonical
+		if canonical == "" {
+			canonical = tc.field
+		}
+		if err != nil || encoded != canonical {
+			t.Errorf("%s %q encodes to %q, %v; want %q", tc.kind, tc.field, encoded, err, canonical)
+		}
+	}
+}
+
+func TestParseStructuredFieldErrors(t *testing.T) {
+	for _, tc := range []struct{ kind, field string }{
+		{"item", "1234567890123456"},   // 16 digits
+		{"item", "1234567890123.1"},    // 13 integer digits in a decimal
+		{"item", "1.1234"},             // 4 fractional digits
+		{"item", "1."},                 // no fractional digit
+		{"item", "-"},                  // no digits
+		{"item", `"unclosed`},          // string not closed
+		{"item", `"bad \n escape"`},    // escape other than \" and \\
+		{"item", "\"tab\tin string\""}, // not printable ASCII
+		{"item", ":not base64!:"},
+		{"item", ":aGVsbG8="},
+		{"item", "?2"},
+		{"item", "5;A=1"}, // uppercase key
+		{"item", "5 ;a"},  // space before ;
+		{"item", "a b"},
+		{"item", ""},
+		{"list", "a,"}, // trailing comma
+		{"list", "a, ,b"},
+		{"list", "(a b"},
+		{"list", "a ;q=1"}, // space before ;
+		{"dict", "a=1,"},   // trailing comma
+		{"dict", "A=1"},
+		{"dict", "a=1 b=2"},
+	} {
+		var err error
+		switch tc.kind {
+		case "item":
+			_, err = ParseItem(tc.field)
+		case "list":
+			_, err = ParseList(tc.field)
+		case "dict":
+			_, err = ParseDictionary(tc.field)
+		}
+		if !errors.Is(err, errStructuredField) {
+			t.Errorf("%s %q: error %v, want errStructuredField", tc.kind, tc.field, err)
+		}
+	}
+}
+
+func TestEncodeStructuredFieldErrors(t *testing.T) {
+	for _, item := range []Item{
+		{Value: int64(1_000_000_000_000_000)},
+		{Value: 1e12},
+		{Value: "line\nbreak"},
+		{Value: Token("1starts-with-a-digit")},
+		{Value: struct{}{}},
+		{Value: int64(1), Params: Params{{"Upper", true}}},
+	} {
+		if encoded, err := EncodeItem(item); err == nil {
+			t.Errorf("%#v encoded to %q, want an error", item, encoded)
+		}
+	}
+	if encoded, err := EncodeItem(Item{Value: 2, Params: Params{{"d", 0.0005}}}); err != nil || encoded != "2;d=0.0" {
+		t.Errorf("int and a decimal rounded to even: %q, %v; want 2;d=0.0", encoded, err)
+	}
+}
+
+func TestDictionaryAccessors(t *testing.T) {
+	dict, err := ParseDictionary(`len=5;alg="sha-256", root=:AAEC:, name="x"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := dict.Integer("len"); !ok || n != 5 {
+		t.Errorf("Integer(len) = %d, %v; want 5", n, ok)
+	}
+	if b, ok := dict.Bytes("root"); !ok || string(b) != "\x00\x01\x02" {
+		t.Errorf("Bytes(root) = %v, %v", b, ok)
+	}
+	if _, ok := dict.Integer("name"); ok {
+		t.Error("Integer(name) of a String member reported ok")
+	}
+	if _, ok := dict.Bytes("missing"); ok {
+		t.Error("Bytes(missing) reported ok")
+	}
+	item, _ := dict.Get("len")
+	if alg, ok := item.Params.Get("alg"); !ok || alg != "sha-256" {
+		t.Errorf("Params.Get(alg) = %v, %v", alg, ok)
+	}
+	if _, ok := item.Params.Get("other"); ok {
+		t.Error("Params.Get(other) reported ok")
+	}
+}
@@ -0,0 +1,79 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// summaryMu keeps summaries of concurrent requests from interleaving
+var summaryMu sync.Mutex
+
+// UploadResult is the machine-readable record of one handled request.
+// The server sends it to the client as the JSON response body and writes it to ServerOptions.SummaryOutput, if set.
+type UploadResult struct {
+	RequestID         string          `json:"request_id"` // the client's X-Request-Id, or one the server generated
+	Method            string          `json:"method"`
+	Proto             string          `json:"proto"`                     // protocol the request arrived over, e.g. "HTTP/1.1" or "HTTP/2.0"
+	ClientIdentity    string          `json:"client_identity,omitempty"` // subject of the verified TLS client certificate, under mutual TLS
+	HeaderCount       int             `json:"header_count"`
+	AnnouncedTrailers []string        `json:"announced_trailers"`
+	DeliveredTrailers []string        `json:"delivered_trailers"`
+	MissingTrailers   []string        `json:"missing_trailers,omitempty"`
+	BodyLength        int64           `json:"body_length"`
+	Parts             []PartSummary   `json:"parts,omitempty"`           // parts of a multipart body, in order
+	Reads             int             `json:"reads,omitempty"`           // body reads that returned data, counted with ServerOptions.LogReads
+	ReportedLength    *int64          `json:"reported_length,omitempty"` // nil when no length trailer was sent
+	Checks            []CheckSummary  `json:"checks"`
+	Inconclusive      bool            `json:"inconclusive,omitempty"` // trailers were announced on a body that cannot carry them
+	Matched           bool            `json:"matched"`                // at least one check ran, all matched, nothing was missing
+	Stored            bool            `json:"stored,omitempty"`       // the body was committed to the server's BodySink or a BlobHandler's Store
+	Duplicate         bool            `json:"duplicate,omitempty"`    // the BlobHandler's Store already held the body, which was not stored again
+	ETag              string          `json:"etag,omitempty"`         // the strong ETag derived from a verified SHA-256 digest of the body, see ContentETag
+	Metadata          json.RawMessage `json:"metadata,omitempty"`     // the X-Body-Metadata trailer, validated
+	TrailerWait       time.Duration   `json:"trailer_wait,omitempty"` // from the last body bytes to the end of the trailer section, in nanoseconds
+	Outcome           string          `json:"outcome"`                // no-trailer, trailer-verified-ok, trailer-failed or trailer-announced-missing
+	Error             string          `json:"error,omitempty"`        // why the request was rejected, if it was
+
+	StatusCode      int          `json:"-"` // HTTP status of the response carrying the result (client side only)
+	ResponseTrailer http.Header  `json:"-"` // trailers of that response, e.g. the "X-Received-" echo (client side only)
+	Problem         *Problem     `json:"-"` // the problem details the server rejected the upload with, if any (client side only)
+	BodyWithheld    bool         `json:"-"` // the server answered an Expect: 100-continue upload before its body was sent (client side only)
+	ServerTrace     TraceContext `json:"-"` // the server's span, from its Traceresponse trailer (client side only)
+	Unchanged       bool         `json:"-"` // the server already holds the content of a SendIfNoneMatch upload, which was not sent (client side only)
+
+	timing serverTiming // phase durations for the Server-Timing trailer (server side only)
+	trace  TraceContext // the span handling the request, for the Traceresponse trailer (server side only)
+}
+
+// CheckSummary is the JSON form of a VerificationResult
+type CheckSummary struct {
+	VerificationResult
+	Error string `json:"error,omitempty"`
+}
+
+// addCheck records a verification result and keeps Matched up to date
+func (s *UploadResult) addCheck(result VerificationResult) {
+	check := CheckSummary{VerificationResult: result}
+	if result.Err != nil {
+		check.Error = result.Err.Error()
+	}
+	s.Checks = append(s.Checks, check)
+	s.Matched = (len(s.Checks) == 1 || s.Matched) && result.Matched
+} // addCheck() func
+
+// digestBound reports whether the checks include a matching content-digest check, as
+// digestBound does for VerificationResults
+func (s *UploadResult) digestBound() bool {
+	return slices.ContainsFunc(s.Checks, func(c CheckSummary) bool { return c.Algorithm == "content-digest" && c.Matched })
+} // digestBound() func
+
+// writeSummary emits s to w as a single JSON line
+func writeSummary(w io.Writer, s *UploadResult) error {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	return json.NewEncoder(w).Encode(s)
+} // writeSummary() func
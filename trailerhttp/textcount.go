@@ -0,0 +1,91 @@
+package trailerhttp
+
+import (
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Trailers counting the text of a body, for ingestion pipelines that check characters and
+// lines rather than bytes; see AlgoCharCount and AlgoLineCount
+const (
+	CharCountTrailer = "X-Body-Char-Count"
+	LineCountTrailer = "X-Body-Line-Count"
+)
+
+// charCountDigest counts the UTF-8 characters of the body as utf8.RuneCount does, each byte of
+// an invalid sequence counting as one. A character split between two writes counts once.
+type charCountDigest struct {
+	n       int64
+	partial []byte // the start of a character the last write cut off
+}
+
+func (d *charCountDigest) Write(p []byte) (int, error) {
+	b := p
+	if len(d.partial) > 0 {
+		b = append(slices.Clip(d.partial), p...) // a copy, as d.partial is written over below
+		d.partial = d.partial[:0]
+	}
+	// Hold back a trailing incomplete sequence until the next write completes it
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				d.partial = append(d.partial, b[i:]...)
+				b = b[:i]
+			}
+			break
+		}
+	}
+	d.n += int64(utf8.RuneCount(b))
+	return len(p), nil
+} // Write() func
+
+func (d *charCountDigest) count() int64 {
+	return d.n + int64(utf8.RuneCount(d.partial))
+}
+
+func (d *charCountDigest) Value() string { return strconv.FormatInt(d.count(), 10) }
+
+func (d *charCountDigest) Matches(reported string) (bool, error) {
+	return matchCount(reported, d.count())
+}
+
+// lineCountDigest counts the lines of the body: its line feeds, and a last line without one
+type lineCountDigest struct {
+	n       int64
+	pending bool // the current line has content
+}
+
+func (d *lineCountDigest) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c == '\n' {
+			d.n++
+		}
+	}
+	if len(p) > 0 {
+		d.pending = p[len(p)-1] != '\n'
+	}
+	return len(p), nil
+}
+
+func (d *lineCountDigest) count() int64 {
+	if d.pending {
+		return d.n + 1
+	}
+	return d.n
+}
+
+func (d *lineCountDigest) Value() string { return strconv.FormatInt(d.count(), 10) }
+
+func (d *lineCountDigest) Matches(reported string) (bool, error) {
+	return matchCount(reported, d.count())
+}
+
+// matchCount compares a decimal count reported in a trailer with the one computed
+func matchCount(reported string, computed int64) (bool, error) {
+	reportedCount, err := strconv.ParseInt(reported, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return reportedCount == computed, nil
+} // matchCount() func
@@ -0,0 +1,125 @@
+package trailerhttp
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"time"
+)
+
+// throttleChunk is the most a throttled stream passes at once, so pauses stay short and even
+const throttleChunk = 16 << 10
+
+// Throttle simulates a slow or flaky link on a stream, to watch how trailers, which only
+// travel after the last body byte, fare on one, and to try out timeout settings such as
+// ServerOptions.ReadTimeout and TrailerTimeout. Every field is optional; the zero value
+// passes bytes through at full speed.
+type Throttle struct {
+	BytesPerSecond int64   // bandwidth of the link; 0 means unlimited
+	Jitter         float64 // each pause varies at random by up to this fraction of itself, from 0 to 1
+
+	// StallProbability is the chance, from 0 to 1, that the link stalls for StallDuration
+	// before passing each chunk of up to 16 KiB, as a congested or lossy network does
+	StallProbability float64
+	StallDuration    time.Duration
+}
+
+// throttle paces the bytes of one stream according to its Throttle
+type throttle struct {
+	Throttle
+	ctx context.Context
+	due time.Time // when the bytes passed so far are due at the configured rate
+}
+
+// chunk returns the part of p to pass at once: up to throttleChunk, and up to 50ms at the rate
+func (t *throttle) chunk(p []byte) []byte {
+	limit := int64(throttleChunk)
+	if t.BytesPerSecond > 0 {
+		limit = max(min(limit, t.BytesPerSecond/20), 1)
+	}
+	return p[:min(int64(len(p)), limit)]
+} // chunk() func
+
+// wait pauses until n more bytes are due, or the stream's context is done
+func (t *throttle) wait(n int) error {
+	var pause time.Duration
+	if t.BytesPerSecond > 0 {
+		pause = time.Duration(float64(n) / float64(t.BytesPerSecond) * float64(time.Second))
+		if t.Jitter > 0 {
+			pause = time.Duration(float64(pause) * (1 + t.Jitter*(2*rand.Float64()-1)))
+		}
+	}
+	if t.StallProbability > 0 && rand.Float64() < t.StallProbability {
+		pause += t.StallDuration
+	}
+	// An idle link earns no credit for a later burst
+	if now := time.Now(); t.due.Before(now) {
+		t.due = now
+	}
+	t.due = t.due.Add(pause)
+	timer := time.NewTimer(time.Until(t.due))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+} // wait() func
+
+// NewThrottledReader returns a reader passing on the bytes of r as slowly as t says
+func NewThrottledReader(r io.Reader, t Throttle) io.Reader {
+	return &throttledReader{r: r, t: &throttle{Throttle: t, ctx: context.Background()}}
+} // NewThrottledReader() func
+
+// NewThrottledWriter returns a writer passing on its bytes to w as slowly as t says
+func NewThrottledWriter(w io.Writer, t Throttle) io.Writer {
+	return &throttledWriter{w: w, t: &throttle{Throttle: t, ctx: context.Background()}}
+} // NewThrottledWriter() func
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(tr.t.chunk(p))
+	if n > 0 {
+		if waitErr := tr.t.wait(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledBody throttles a request body, stopping with the request's context
+type throttledBody struct {
+	throttledReader
+	io.Closer
+}
+
+// newThrottledBody throttles a request body as t says, for ServerOptions.Throttle
+func newThrottledBody(ctx context.Context, body io.ReadCloser, t Throttle) io.ReadCloser {
+	return &throttledBody{throttledReader{r: body, t: &throttle{Throttle: t, ctx: ctx}}, body}
+} // newThrottledBody() func
+
+type throttledWriter struct {
+	w io.Writer
+	t *throttle
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := tw.w.Write(tw.t.chunk(p))
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := tw.t.wait(n); err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
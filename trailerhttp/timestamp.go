@@ -0,0 +1,99 @@
+package trailerhttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimestampTrailer carries the time an upload finished streaming, HMAC-SHA256'd with the shared
+// key over that time and the Content-Digest trailer, so the completion time of the body is on
+// record and cannot be changed or moved to another body; see Client.Timestamp. Its value is an
+// RFC 8941 dictionary, t the Unix time in milliseconds and sig the MAC:
+//
+//	X-Body-Timestamp: t=1760443200123, sig=:cGJ2...Zw==:
+const TimestampTrailer = "X-Body-Timestamp"
+
+// DefaultTimestampSkew is how far a body timestamp may be off the server's clock when
+// ServerOptions.TimestampSkew is 0
+const DefaultTimestampSkew = time.Minute
+
+// ErrBadTimestamp reports a body timestamp whose MAC does not verify, or too far off the clock
+var ErrBadTimestamp = errors.New("body timestamp does not verify")
+
+// timestampMAC returns the MAC of a body timestamp of t milliseconds binding contentDigest,
+// the value of the Content-Digest trailer
+func timestampMAC(key []byte, t int64, contentDigest string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.FormatInt(t, 10) + "\n" + contentDigest))
+	return mac.Sum(nil)
+} // timestampMAC() func
+
+// timestampValue returns the TimestampTrailer value for a body completed at t
+func timestampValue(key []byte, t time.Time, contentDigest string) string {
+	ms := t.UnixMilli()
+	return fmt.Sprintf("t=%d, sig=:%s:", ms, base64.StdEncoding.EncodeToString(timestampMAC(key, ms, contentDigest)))
+} // timestampValue() func
+
+// VerifyTimestamp checks the X-Body-Timestamp trailer of a request whose body has been read: its
+// MAC with key over its time and the Content-Digest trailer delivered, and that the time is within
+// skew of now (0 means DefaultTimestampSkew). It returns the time the body finished streaming.
+// It does not check the Content-Digest trailer against the body; the content-digest verifier of
+// Handler and NewVerifiedBody does.
+func VerifyTimestamp(trailer http.Header, key []byte, skew time.Duration) (time.Time, error) {
+	values, _ := lookupField(trailer, TimestampTrailer)
+	if len(values) == 0 {
+		return time.Time{}, missingTrailerError([]string{TimestampTrailer})
+	}
+	if len(key) == 0 {
+		return time.Time{}, fmt.Errorf("%s: %w", TimestampTrailer, errNoHMACKey)
+	}
+	dict, err := ParseDictionary(CombineFieldValues(values))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w in %s: %w", ErrMalformedTrailer, TimestampTrailer, err)
+	}
+	ms, okT := dict.Integer("t")
+	sig, okSig := dict.Bytes("sig")
+	if !okT || !okSig {
+		return time.Time{}, fmt.Errorf("%w in %s: it needs an integer t and a byte sequence sig", ErrMalformedTrailer, TimestampTrailer)
+	}
+	digests, _ := lookupField(trailer, "Content-Digest")
+	if !hmac.Equal(sig, timestampMAC(key, ms, CombineFieldValues(digests))) {
+		return time.Time{}, ErrBadTimestamp
+	}
+	if skew == 0 {
+		skew = DefaultTimestampSkew
+	}
+	completed := time.UnixMilli(ms)
+	if offset := time.Since(completed).Abs(); offset > skew {
+		return completed, fmt.Errorf("%w: %s is %s off the server's clock, more than the %s allowed",
+			ErrBadTimestamp, completed.UTC().Format(time.RFC3339Nano), offset.Round(time.Millisecond), skew)
+	}
+	return completed, nil
+} // VerifyTimestamp() func
+
+// checkTimestamp verifies the body timestamp of a request as one more check. bound says whether
+// the Content-Digest trailer its MAC covers matched the body; without that the time is not shown
+// to be the completion time of this body, and the check fails.
+func checkTimestamp(trailer http.Header, key []byte, skew time.Duration, bound bool) VerificationResult {
+	result := VerificationResult{Algorithm: "timestamp", TrailerName: TimestampTrailer}
+	if values, _ := lookupField(trailer, TimestampTrailer); len(values) > 0 {
+		result.Reported = values[0]
+	}
+	completed, err := VerifyTimestamp(trailer, key, skew)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if !bound {
+		result.Err = fmt.Errorf("%w: the body does not verify against the Content-Digest it binds", ErrBadTimestamp)
+		return result
+	}
+	result.Matched, result.Computed = true, completed.UTC().Format(time.RFC3339Nano)
+	return result
+} // checkTimestamp() func
@@ -0,0 +1,54 @@
+package trailerhttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerTimestampBindsTheBody(t *testing.T) {
+	key := []byte("timestamp key")
+	sum := sha256.Sum256([]byte("hello"))
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("NewHandler accepted TimestampSkew without a content-digest verifier")
+			}
+		}()
+		NewHandler(ServerOptions{Algorithms: []string{"length"}, HMACKey: key, TimestampSkew: time.Minute})
+	}()
+
+	h := NewHandler(ServerOptions{HMACKey: key, Logger: discardLogger()})
+	for _, tc := range []struct {
+		name    string
+		body    string
+		trailer http.Header
+		matched bool
+	}{
+		{"genuine body", "hello", http.Header{"Content-Digest": {digest}, TimestampTrailer: {timestampValue(key, time.Now(), digest)}}, true},
+		{"another body of the same length", "world", http.Header{"X-Body-Byte-Length": {"5"}, "Content-Digest": {digest}, TimestampTrailer: {timestampValue(key, time.Now(), digest)}}, false},
+		{"Content-Digest not sent", "world", http.Header{"X-Body-Byte-Length": {"5"}, TimestampTrailer: {timestampValue(key, time.Now(), "")}}, false},
+		{"too old", "hello", http.Header{"Content-Digest": {digest}, TimestampTrailer: {timestampValue(key, time.Now().Add(-time.Hour), digest)}}, false},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, chunkedRequest(tc.body, tc.trailer))
+		var result UploadResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var check *CheckSummary
+		for i := range result.Checks {
+			if result.Checks[i].Algorithm == "timestamp" {
+				check = &result.Checks[i]
+			}
+		}
+		if check == nil || check.Matched != tc.matched || result.Matched != tc.matched {
+			t.Errorf("%s: timestamp check %+v, upload matched %v; want both %v", tc.name, check, result.Matched, tc.matched)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package trailerhttp
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BodyTokenTrailer carries a JWT the uploader signs once the body has streamed. Its
+// content_digest claim binds the Content-Digest trailer, which the content-digest verifier
+// checks against the body, so the token authorizes this content and not just its sender.
+const BodyTokenTrailer = "X-Body-Token"
+
+// DefaultTokenTTL is how long a body token is valid when TokenSigner.TTL is 0
+const DefaultTokenTTL = 5 * time.Minute
+
+// tokenLeeway tolerates clock skew between uploader and server in the iat and exp checks
+const tokenLeeway = time.Minute
+
+// ErrBadToken reports a body token that does not verify, has expired, or binds another body
+var ErrBadToken = errors.New("body token does not verify")
+
+// TokenSigner signs the body tokens of Client.BodyToken as compact JWS (RFC 7515), with EdDSA
+// for an Ed25519 key and ES256 for a P-256 one
+type TokenSigner struct {
+	KeyID   string        // the kid header, identifying the key to the verifier; see ServerOptions.TokenKeys
+	Key     crypto.Signer // an ed25519.PrivateKey or a P-256 *ecdsa.PrivateKey
+	Issuer  string        // the iss claim, if set
+	Subject string        // the sub claim, if set: the identity the upload is authorized for
+	TTL     time.Duration // from iat to exp; 0 means DefaultTokenTTL
+}
+
+// BodyTokenClaims are the JWT claims of a body token
+type BodyTokenClaims struct {
+	Issuer        string `json:"iss,omitempty"`
+	Subject       string `json:"sub,omitempty"`
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+	ContentDigest string `json:"content_digest"` // the Content-Digest trailer sent with the body
+}
+
+// tokenHeader is the JOSE header of a body token
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// tokenAlgorithm returns the JWS algorithm name of a private or public key
+func tokenAlgorithm(key any) (string, error) {
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	if alg == "ed25519" {
+		return "EdDSA", nil
+	}
+	return "ES256", nil
+} // tokenAlgorithm() func
+
+// sign returns a body token binding contentDigest, the value of the Content-Digest trailer
+func (s *TokenSigner) sign(contentDigest string) (string, error) {
+	alg, err := tokenAlgorithm(s.Key)
+	if err != nil {
+		return "", err
+	}
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = DefaultTokenTTL
+	}
+	now := time.Now()
+	header, err := json.Marshal(tokenHeader{Alg: alg, Typ: "JWT", Kid: s.KeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(BodyTokenClaims{
+		Issuer:        s.Issuer,
+		Subject:       s.Subject,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(ttl).Unix(),
+		ContentDigest: contentDigest,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig, err := signBytes(s.Key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+} // sign() func
+
+// VerifyBodyToken checks the X-Body-Token trailer of a request whose body has been read: its
+// signature with the key in keys named by its kid, its iat and exp, and that its content_digest
+// claim is the Content-Digest trailer delivered. It does not check that trailer against the body;
+// the content-digest verifier of Handler and NewVerifiedBody does.
+func VerifyBodyToken(trailer http.Header, keys map[string]crypto.PublicKey) (*BodyTokenClaims, error) {
+	values, _ := lookupField(trailer, BodyTokenTrailer)
+	if len(values) == 0 {
+		return nil, missingTrailerError([]string{BodyTokenTrailer})
+	}
+	malformed := func(err error) error {
+		return fmt.Errorf("%w in %s: %w", ErrMalformedTrailer, BodyTokenTrailer, err)
+	}
+	parts := strings.Split(strings.TrimSpace(values[0]), ".")
+	if len(parts) != 3 {
+		return nil, malformed(errors.New("not a compact JWS"))
+	}
+	var segments [3][]byte
+	for i, part := range parts {
+		var err error
+		if segments[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, malformed(err)
+		}
+	}
+	var header tokenHeader
+	if err := json.Unmarshal(segments[0], &header); err != nil {
+		return nil, malformed(err)
+	}
+	key, ok := keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown kid %q", ErrBadToken, header.Kid)
+	}
+	alg, err := tokenAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	// The key decides the algorithm, so a token cannot downgrade it, to "none" or anything else
+	if header.Alg != alg {
+		return nil, fmt.Errorf("%w: alg %q does not match the %s key", ErrBadToken, header.Alg, alg)
+	}
+	if !verifyBytes(key, []byte(parts[0]+"."+parts[1]), segments[2]) {
+		return nil, ErrBadToken
+	}
+
+	var claims BodyTokenClaims
+	if err := json.Unmarshal(segments[1], &claims); err != nil {
+		return nil, malformed(err)
+	}
+	now := time.Now()
+	switch expires := time.Unix(claims.ExpiresAt, 0); {
+	case claims.ExpiresAt == 0:
+		return nil, fmt.Errorf("%w: it has no exp claim", ErrBadToken)
+	case now.After(expires.Add(tokenLeeway)):
+		return nil, fmt.Errorf("%w: it expired at %s", ErrBadToken, expires.UTC().Format(time.RFC3339))
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(tokenLeeway)):
+		return nil, fmt.Errorf("%w: it is issued in the future", ErrBadToken)
+	}
+	digests, _ := lookupField(trailer, "Content-Digest")
+	if claims.ContentDigest == "" || claims.ContentDigest != CombineFieldValues(digests) {
+		return nil, fmt.Errorf("%w: it does not bind the Content-Digest trailer", ErrBadToken)
+	}
+	return &claims, nil
+} // VerifyBodyToken() func
+
+// checkBodyToken verifies the body token of a request as one more check
+func checkBodyToken(trailer http.Header, keys map[string]crypto.PublicKey) VerificationResult {
+	result := VerificationResult{Algorithm: "body-token", TrailerName: BodyTokenTrailer}
+	if values, _ := lookupField(trailer, BodyTokenTrailer); len(values) > 0 {
+		result.Reported = values[0]
+	}
+	claims, err := VerifyBodyToken(trailer, keys)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Matched, result.Computed = true, "sub="+claims.Subject
+	return result
+} // checkBodyToken() func
+
+// tokenAuthorization explains why the checks of a request do not authorize its body, or
+// returns "": the body token must verify and so must the Content-Digest trailer it binds
+func tokenAuthorization(checks []CheckSummary) string {
+	var token, digest bool
+	for _, check := range checks {
+		switch check.Algorithm {
+		case "body-token":
+			if !check.Matched {
+				return check.Error
+			}
+			token = true
+		case "content-digest":
+			if !check.Matched {
+				return "the body does not match the Content-Digest its token binds"
+			}
+			digest = true
+		}
+	}
+	switch {
+	case !token:
+		return "request carries no body token"
+	case !digest:
+		return "the Content-Digest its body token binds was not checked"
+	}
+	return ""
+} // tokenAuthorization() func
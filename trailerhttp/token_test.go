@@ -0,0 +1,165 @@
+package trailerhttp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenDigest is the Content-Digest trailer the test tokens bind
+const tokenDigest = "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:"
+
+// forgeToken signs any header and claims with key, to build tokens TokenSigner would not
+func forgeToken(t *testing.T, key crypto.Signer, header tokenHeader, claims any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := signBytes(key, []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+} // forgeToken() func
+
+// tokenTrailer returns the trailers of a request carrying token and tokenDigest
+func tokenTrailer(token string) http.Header {
+	return http.Header{BodyTokenTrailer: {token}, "Content-Digest": {tokenDigest}}
+} // tokenTrailer() func
+
+func TestBodyTokenRoundTrip(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for alg, key := range map[string]crypto.Signer{"EdDSA": edKey, "ES256": ecKey} {
+		signer := &TokenSigner{KeyID: "k1", Key: key, Issuer: "uploader", Subject: "alice", TTL: time.Minute}
+		token, err := signer.sign(tokenDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _ := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+		if !strings.Contains(string(header), `"alg":"`+alg+`"`) {
+			t.Errorf("%s: header %s, want alg %s", alg, header, alg)
+		}
+		claims, err := VerifyBodyToken(tokenTrailer(token), map[string]crypto.PublicKey{"k1": key.Public()})
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if claims.Issuer != "uploader" || claims.Subject != "alice" || claims.ContentDigest != tokenDigest || claims.ExpiresAt-claims.IssuedAt != 60 {
+			t.Errorf("%s: claims %+v, want the signer's, valid for its TTL", alg, claims)
+		}
+	}
+}
+
+func TestVerifyBodyTokenRejects(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := map[string]crypto.PublicKey{"ed": edKey.Public(), "ec": ecKey.Public()}
+	now := time.Now().Unix()
+	valid := BodyTokenClaims{IssuedAt: now, ExpiresAt: now + 60, ContentDigest: tokenDigest}
+	with := func(edit func(*BodyTokenClaims)) BodyTokenClaims {
+		claims := valid
+		edit(&claims)
+		return claims
+	}
+	ecToken := forgeToken(t, ecKey, tokenHeader{Alg: "ES256", Kid: "ec"}, valid)
+	ecParts := strings.Split(ecToken, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(ecParts[2])
+	noneHeader, _ := json.Marshal(tokenHeader{Alg: "none", Kid: "ed"})
+	noneClaims, _ := json.Marshal(valid)
+
+	for _, tc := range []struct {
+		name    string
+		trailer http.Header
+		want    error // nil: the token verifies
+	}{
+		{"valid EdDSA", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, valid)), nil},
+		{"valid ES256", tokenTrailer(ecToken), nil},
+		{"not announced", http.Header{"Content-Digest": {tokenDigest}}, ErrMissingTrailer},
+		{"two segments", tokenTrailer("a.b"), ErrMalformedTrailer},
+		{"not base64", tokenTrailer("a.b.!"), ErrMalformedTrailer},
+		{"unknown kid", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "other"}, valid)), ErrBadToken},
+		{"alg of another key type", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "ES256", Kid: "ed"}, valid)), ErrBadToken},
+		{"alg none", tokenTrailer(base64.RawURLEncoding.EncodeToString(noneHeader) + "." + base64.RawURLEncoding.EncodeToString(noneClaims) + "."), ErrBadToken},
+		{"signed by another key", tokenTrailer(forgeToken(t, ecKey, tokenHeader{Alg: "ES256", Kid: "ed"}, valid)), ErrBadToken},
+		{"ES256 signature one byte long", tokenTrailer(ecParts[0] + "." + ecParts[1] + "." + base64.RawURLEncoding.EncodeToString(append(sig, 0))), ErrBadToken},
+		{"ES256 signature one byte short", tokenTrailer(ecParts[0] + "." + ecParts[1] + "." + base64.RawURLEncoding.EncodeToString(sig[:63])), ErrBadToken},
+		{"claims swapped", tokenTrailer(ecParts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":9999999999,"content_digest":"`+tokenDigest+`"}`)) + "." + ecParts[2]), ErrBadToken},
+		{"no exp", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.ExpiresAt = 0 }))), ErrBadToken},
+		{"expired within the leeway", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.ExpiresAt = now - 30 }))), nil},
+		{"expired past the leeway", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.ExpiresAt = now - 90 }))), ErrBadToken},
+		{"issued ahead within the leeway", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.IssuedAt = now + 30 }))), nil},
+		{"issued in the future", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.IssuedAt = now + 90 }))), ErrBadToken},
+		{"binds another digest", tokenTrailer(forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.ContentDigest = "sha-256=:AAAA:" }))), ErrBadToken},
+		{"binds no digest", http.Header{BodyTokenTrailer: {forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, with(func(c *BodyTokenClaims) { c.ContentDigest = "" }))}}, ErrBadToken},
+		{"Content-Digest not sent", http.Header{BodyTokenTrailer: {forgeToken(t, edKey, tokenHeader{Alg: "EdDSA", Kid: "ed"}, valid)}}, ErrBadToken},
+	} {
+		_, err := VerifyBodyToken(tc.trailer, keys)
+		if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestHandlerBodyToken(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	url := startServer(t, ServerOptions{Algorithms: []string{"length", "content-digest"}, TokenKeys: map[string]crypto.PublicKey{"k1": key.Public()}})
+	body := []byte("authorized by its token")
+	for _, tc := range []struct {
+		name   string
+		signer *TokenSigner
+		status int
+	}{
+		{"signed", &TokenSigner{KeyID: "k1", Key: key, Subject: "alice"}, http.StatusOK},
+		{"unknown key", &TokenSigner{KeyID: "k2", Key: key}, http.StatusUnauthorized},
+		{"no token", nil, http.StatusUnauthorized},
+	} {
+		c := &Client{Algorithms: []string{"length", "content-digest"}, BodyToken: tc.signer, Logger: discardLogger()}
+		result, _ := c.Send(t.Context(), url, body)
+		if result == nil || result.StatusCode != tc.status {
+			t.Errorf("%s: result %+v, want status %d", tc.name, result, tc.status)
+		}
+	}
+}
+
+func TestIntegrityBodyTokenBindsTheBody(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	token, err := (&TokenSigner{KeyID: "k1", Key: key}).sign(tokenDigest) // of the empty body
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reached bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	srv := httptest.NewServer(Integrity(next, WithTokenKeys(map[string]crypto.PublicKey{"k1": key.Public()}), RejectUnverified(1<<20)))
+	defer srv.Close()
+	trailer := []RawField{{BodyTokenTrailer, token}, {"Content-Digest", tokenDigest}}
+	for _, tc := range []struct {
+		name     string
+		announce string
+		body     string
+		status   int
+	}{
+		{"signed body", BodyTokenTrailer + ", Content-Digest", "", http.StatusOK},
+		{"other body, Content-Digest announced", BodyTokenTrailer + ", Content-Digest", "attacker body", http.StatusUnauthorized},
+		{"other body, Content-Digest not announced", BodyTokenTrailer, "attacker body", http.StatusUnauthorized},
+	} {
+		reached = false
+		req := &RawRequest{Header: []RawField{{"Trailer", tc.announce}}, Body: []byte(tc.body), Trailer: trailer}
+		resp, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if resp.Response.StatusCode != tc.status || reached != (tc.status == http.StatusOK) {
+			t.Errorf("%s: status %d, handler reached %v; want %d", tc.name, resp.Response.StatusCode, reached, tc.status)
+		}
+	}
+}
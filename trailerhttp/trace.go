@@ -0,0 +1,15 @@
+package trailerhttp
+
+import (
+	"context"
+	"net/http"
+)
+
+// Set by the otel build tag (see otel.go). traceRequest starts the server span of a request
+// and returns its context and the function ending it with the request's summary; traceUpload starts the client
+// span of an upload, and injectTrace propagates its context in the request headers.
+var (
+	traceRequest func(r *http.Request) (TraceContext, func(*UploadResult))
+	traceUpload  func(ctx context.Context, c *Client, url string) (context.Context, func(*UploadResult, error))
+	injectTrace  func(ctx context.Context, header http.Header)
+)
@@ -0,0 +1,103 @@
+package trailerhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceResponseTrailer carries the W3C Trace Context of the server's span as a response trailer,
+// in traceparent form, like the traceresponse header of Trace Context Level 2. As a trailer it
+// can name a span or an ID the server only settles on after processing the body.
+const TraceResponseTrailer = "Traceresponse"
+
+// traceparentHeader carries the caller's trace context in a request
+const traceparentHeader = "Traceparent"
+
+// TraceContext identifies a span: the trace it belongs to, its own ID, and the trace flags
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte // bit 0 is "sampled"
+}
+
+// errTraceContext reports a malformed traceparent value
+var errTraceContext = errors.New("invalid trace context")
+
+// ParseTraceContext parses a traceparent value, such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceContext(s string) (TraceContext, error) {
+	var tc TraceContext
+	fields := strings.Split(strings.TrimSpace(s), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || fields[0] == "00" && len(fields) != 4 {
+		return tc, fmt.Errorf("%w: %q", errTraceContext, s)
+	}
+	var flags [1]byte
+	for _, field := range []struct {
+		dst []byte
+		hex string
+	}{{tc.TraceID[:], fields[1]}, {tc.SpanID[:], fields[2]}, {flags[:], fields[3]}} {
+		if len(field.hex) != 2*len(field.dst) || strings.ToLower(field.hex) != field.hex {
+			return TraceContext{}, fmt.Errorf("%w: %q", errTraceContext, s)
+		}
+		if _, err := hex.Decode(field.dst, []byte(field.hex)); err != nil {
+			return TraceContext{}, fmt.Errorf("%w: %q: %w", errTraceContext, s, err)
+		}
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return TraceContext{}, fmt.Errorf("%w: %q: all-zero ID", errTraceContext, s)
+	}
+	return tc, nil
+} // ParseTraceContext() func
+
+// IsValid reports whether both IDs are set, as the W3C format requires
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+} // IsValid() func
+
+// String formats tc as a version 00 traceparent value
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+} // String() func
+
+// newServerTrace returns a span context for the server's handling of r: a child of the caller's
+// traceparent if it sent one, or the root of a new trace
+func newServerTrace(r *http.Request) TraceContext {
+	tc, err := ParseTraceContext(r.Header.Get(traceparentHeader))
+	if err != nil {
+		rand.Read(tc.TraceID[:])
+	}
+	rand.Read(tc.SpanID[:])
+	return tc
+} // newServerTrace() func
+
+// AnnounceTraceResponse declares the Traceresponse trailer in the response header of w.
+// Call it before the handler writes the header or body, and SetTraceResponse once the span is known.
+func AnnounceTraceResponse(w http.ResponseWriter) {
+	w.Header().Add("Trailer", TraceResponseTrailer)
+} // AnnounceTraceResponse() func
+
+// SetTraceResponse sets the Traceresponse trailer of w to tc.
+// net/http sends it after the body when the handler returns.
+func SetTraceResponse(w http.ResponseWriter, tc TraceContext) {
+	w.Header().Set(TraceResponseTrailer, tc.String())
+} // SetTraceResponse() func
+
+// ResponseTrace returns the server's span from the Traceresponse trailer of resp, whose body
+// must have been read to EOF, to link the caller's trace to it. It fails with ErrMissingTrailer
+// when the server sent none and with ErrMalformedTrailer when the value does not parse.
+func ResponseTrace(resp *http.Response) (TraceContext, error) {
+	value, err := Trailers(resp.Trailer).Get(TraceResponseTrailer)
+	if err != nil {
+		return TraceContext{}, err
+	}
+	tc, err := ParseTraceContext(value)
+	if err != nil {
+		return TraceContext{}, malformedTrailerError(TraceResponseTrailer, value, err)
+	}
+	return tc, nil
+} // ResponseTrace() func
@@ -0,0 +1,232 @@
+package trailerhttp
+
+import (
+	"compress/gzip"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// trailerSet computes the trailers selected by TrailerAlgo values over the bytes written to it
+type trailerSet struct {
+	verifiers []trailerVerifier
+	digests   []bodyDigest
+	n         int64
+}
+
+// newTrailerSet resolves algos, always including the length trailer, first,
+// and skipping duplicates and names that are not registered.
+func newTrailerSet(algos []TrailerAlgo) *trailerSet {
+	ts := &trailerSet{}
+	length := AlgoLength
+	if i := slices.IndexFunc(algos, func(a TrailerAlgo) bool { return a.algorithm == length.algorithm }); i >= 0 {
+		length = algos[i] // renamed, perhaps
+	}
+	for _, algo := range append([]TrailerAlgo{length}, algos...) {
+		v, err := lookupVerifier(algo.algorithm)
+		if err != nil || slices.ContainsFunc(ts.verifiers, func(seen trailerVerifier) bool { return seen.Algorithm == v.Algorithm }) {
+			continue
+		}
+		v = v.renamed(map[string]string{v.Algorithm: algo.name})
+		ts.verifiers = append(ts.verifiers, v)
+		ts.digests = append(ts.digests, withEncoding(v.NewDigest(algo.key), algo.encoding))
+	}
+	return ts
+} // newTrailerSet() func
+
+func (ts *trailerSet) Write(p []byte) (int, error) {
+	for _, d := range ts.digests {
+		d.Write(p)
+	}
+	ts.n += int64(len(p))
+	return len(p), nil
+}
+
+// writeDecoded adds bytes of a body sent with a content coding, as written before encoding,
+// to all digests but the Encoded ones, which wireDigests feeds instead
+func (ts *trailerSet) writeDecoded(p []byte) {
+	for i, d := range ts.digests {
+		if !ts.verifiers[i].Encoded {
+			d.Write(p)
+		}
+	}
+	ts.n += int64(len(p))
+} // writeDecoded() func
+
+// wireDigests feeds the Encoded digests of a set the body bytes as sent, content coding applied
+type wireDigests struct {
+	set *trailerSet
+}
+
+func (wd wireDigests) Write(p []byte) (int, error) {
+	for i, d := range wd.set.digests {
+		if wd.set.verifiers[i].Encoded {
+			d.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// announce declares the trailer names on req, before it is sent
+func (ts *trailerSet) announce(req *http.Request) {
+	if req.Trailer == nil {
+		req.Trailer = http.Header{}
+	}
+	for _, v := range ts.verifiers {
+		req.Trailer[http.CanonicalHeaderKey(v.TrailerName)] = nil
+	}
+	req.Header.Set("Trailer", strings.Join(ts.names(), ","))
+	forceChunked(req)
+} // announce() func
+
+// forceChunked makes the transport send the streamed body of req chunked, the only HTTP/1.1
+// framing with a trailer section, even when it turns out empty. For methods that usually have
+// no body, such as GET and DELETE, net/http otherwise reads ahead and sends a body it finds
+// empty without any framing, dropping the trailers, length 0 and digest of no bytes alike.
+func forceChunked(req *http.Request) {
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength <= 0 && len(req.TransferEncoding) == 0 {
+		req.TransferEncoding = []string{"chunked"}
+	}
+} // forceChunked() func
+
+// startedBody closes started on the first Read or Close of its body, once the transport has
+// checked the request headers, trailer names included, or given up on the request. Trailer
+// values set before that race with the check when the body is empty.
+type startedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	started chan struct{}
+}
+
+func newStartedBody(body io.ReadCloser) *startedBody {
+	return &startedBody{ReadCloser: body, started: make(chan struct{})}
+} // newStartedBody() func
+
+func (b *startedBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { close(b.started) })
+	return b.ReadCloser.Read(p)
+}
+
+func (b *startedBody) Close() error {
+	b.once.Do(func() { close(b.started) })
+	return b.ReadCloser.Close()
+}
+
+// renames returns the trailer field names of the set, keyed by algorithm name, to verify
+// trailers sent under the same names
+func (ts *trailerSet) renames() map[string]string {
+	names := make(map[string]string, len(ts.verifiers))
+	for _, v := range ts.verifiers {
+		names[v.Algorithm] = v.TrailerName
+	}
+	return names
+} // renames() func
+
+// names returns the trailer field names, in the order they are computed
+func (ts *trailerSet) names() []string {
+	names := make([]string, len(ts.verifiers))
+	for i, v := range ts.verifiers {
+		names[i] = v.TrailerName
+	}
+	return names
+} // names() func
+
+// setValues stores the trailer values for the bytes written so far in trailer
+func (ts *trailerSet) setValues(trailer http.Header) {
+	for i, v := range ts.verifiers {
+		if value := ts.digests[i].Value(); value != "" {
+			trailer.Set(v.TrailerName, value)
+		}
+	}
+} // setValues() func
+
+// TrailerWriter is the write end of a streamed request body that computes the integrity
+// trailers from the bytes written through it and sets them on the request when closed.
+// It suits callers producing the body themselves; Client does the same for an io.Reader.
+type TrailerWriter struct {
+	w       io.WriteCloser
+	trailer http.Header
+	set     *trailerSet
+	gz      *gzip.Writer  // compresses into w, for NewGzipTrailerWriter
+	started chan struct{} // closed once the transport reads req.Body; nil if req had no body
+}
+
+// NewTrailerWriter announces the trailers for algos (the length trailer is always included)
+// on req and returns a writer that feeds w, typically the *io.PipeWriter whose reader is req.Body.
+// Call it before the request is sent, with req.Body set: the Trailer header goes out with the
+// initial headers, and req.Body is wrapped to tell Close when the transport starts reading it.
+func NewTrailerWriter(req *http.Request, w io.WriteCloser, algos ...TrailerAlgo) *TrailerWriter {
+	set := newTrailerSet(algos)
+	set.announce(req)
+	tw := &TrailerWriter{w: w, trailer: req.Trailer, set: set}
+	if req.Body != nil && req.Body != http.NoBody {
+		body := newStartedBody(req.Body)
+		req.Body, tw.started = body, body.started
+	}
+	return tw
+} // NewTrailerWriter() func
+
+// NewGzipTrailerWriter is NewTrailerWriter for a body gzip-compressed on the wire: it sets
+// "Content-Encoding: gzip" on req and compresses what is written before it reaches w, while
+// the trailers describe the uncompressed bytes, so the receiver checks the content it gets after
+// decompression (Handler does, and Integrity with WithGzip). Only the RFC 9530 digests
+// cover the compressed bytes as sent.
+func NewGzipTrailerWriter(req *http.Request, w io.WriteCloser, algos ...TrailerAlgo) *TrailerWriter {
+	tw := NewTrailerWriter(req, w, algos...)
+	req.Header.Set("Content-Encoding", "gzip")
+	tw.gz = gzip.NewWriter(io.MultiWriter(w, wireDigests{tw.set}))
+	return tw
+} // NewGzipTrailerWriter() func
+
+// Write writes p to the underlying writer and adds the bytes it accepted to the digests
+func (tw *TrailerWriter) Write(p []byte) (int, error) {
+	if tw.gz != nil {
+		n, err := tw.gz.Write(p)
+		tw.set.writeDecoded(p[:n])
+		return n, err
+	}
+	n, err := tw.w.Write(p)
+	tw.set.Write(p[:n])
+	return n, err
+} // Write() func
+
+// Written returns the number of body bytes written so far, before any compression
+func (tw *TrailerWriter) Written() int64 {
+	return tw.set.n
+} // Written() func
+
+// Close sets the trailer values on the request and then closes the underlying writer.
+// The order matters: the transport sends req.Trailer as soon as it reads the end of the body.
+// It first waits for the transport to start reading req.Body, or to close it, which any body
+// written to has done already; so it blocks on an empty body until the request is sent.
+// If req.Trailer declares a field not allowed in a trailer section, the body is aborted
+// instead and Close returns the ErrForbiddenTrailer error.
+func (tw *TrailerWriter) Close() error {
+	if err := validateTrailerNames(slices.Sorted(maps.Keys(tw.trailer))); err != nil {
+		tw.CloseWithError(err)
+		return err
+	}
+	if tw.gz != nil {
+		if err := tw.gz.Close(); err != nil { // flush the compressed tail before the trailers
+			tw.CloseWithError(err)
+			return err
+		}
+	}
+	if tw.started != nil {
+		<-tw.started
+	}
+	tw.set.setValues(tw.trailer)
+	return tw.w.Close()
+} // Close() func
+
+// CloseWithError aborts the body without setting any trailer, when w is an *io.PipeWriter.
+// The request then fails with err instead of being sent with valid-looking trailers.
+func (tw *TrailerWriter) CloseWithError(err error) error {
+	if pw, ok := tw.w.(*io.PipeWriter); ok {
+		return pw.CloseWithError(err)
+	}
+	return tw.w.Close()
+} // CloseWithError() func
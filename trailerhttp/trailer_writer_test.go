@@ -0,0 +1,49 @@
+package trailerhttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTrailerWriter(t *testing.T) {
+	rs := newRecordingServer(t)
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		for _, body := range []string{"", "written through the trailer writer"} {
+			pr, pw := io.Pipe()
+			req, _ := http.NewRequestWithContext(t.Context(), method, rs.URL, pr)
+			tw := NewTrailerWriter(req, pw, AlgoSHA256)
+			go func() {
+				io.Copy(tw, strings.NewReader(body))
+				tw.Close() // right away on an empty body, while the transport may check the headers
+			}()
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result UploadResult
+			json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			if !rs.chunked || !result.Matched || result.ReportedLength == nil || *result.ReportedLength != int64(len(body)) {
+				t.Errorf("%s of %d bytes: chunked %v, result %+v; want the length and sha256 trailers verified", method, len(body), rs.chunked, result)
+			}
+		}
+	}
+}
+
+func TestTrailerWriterRequestNotSent(t *testing.T) {
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1:1", pr)
+	tw := NewTrailerWriter(req, pw)
+	closed := make(chan error, 1)
+	go func() { closed <- tw.Close() }()
+	req.Body.Close() // as the transport does on a request that fails before its body is read
+	if err := <-closed; err != nil {
+		t.Errorf("Close returned %v, want nil once the transport gave up on the body", err)
+	}
+	if req.Trailer.Get("X-Body-Byte-Length") != "0" {
+		t.Errorf("trailer %v, want the length trailer of the empty body set", req.Trailer)
+	}
+}
@@ -0,0 +1,143 @@
+package trailerhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trailers gives typed access to trailer fields, e.g. Trailers(r.Trailer) once the request
+// body has been read. Names are matched case-insensitively. A getter fails with
+// ErrMissingTrailer when the field carries no value, and with ErrMalformedTrailer when
+// the value does not parse.
+type Trailers http.Header
+
+// Get returns the first value of the trailer name, trimmed of surrounding whitespace
+func (t Trailers) Get(name string) (string, error) {
+	values, _ := lookupField(http.Header(t), name)
+	if len(values) == 0 {
+		return "", missingTrailerError([]string{name})
+	}
+	return strings.TrimSpace(values[0]), nil
+} // Get() func
+
+// malformedTrailerError reports a value of the trailer name that does not parse
+func malformedTrailerError(name, value string, err error) error {
+	return fmt.Errorf("%w in %s: %q: %w", ErrMalformedTrailer, name, value, err)
+} // malformedTrailerError() func
+
+// GetInt64 parses the trailer name as a decimal integer, such as X-Body-Byte-Length
+func (t Trailers) GetInt64(name string) (int64, error) {
+	value, err := t.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, malformedTrailerError(name, value, err)
+	}
+	return n, nil
+} // GetInt64() func
+
+// GetDigest decodes the trailer name as a digest, such as X-Body-SHA256, in any DigestEncoding:
+// hex, base64 or an RFC 8941 byte sequence (base64 between colons). A value valid as both hex and
+// base64 is read as hex.
+func (t Trailers) GetDigest(name string) ([]byte, error) {
+	value, err := t.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := decodeDigest(value, 0)
+	if err != nil {
+		return nil, malformedTrailerError(name, value, err)
+	}
+	return sum, nil
+} // GetDigest() func
+
+// GetTime parses the trailer name as an HTTP-date or an RFC 3339 timestamp
+func (t Trailers) GetTime(name string) (time.Time, error) {
+	value, err := t.Get(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ts, err := http.ParseTime(value); err == nil {
+		return ts, nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, malformedTrailerError(name, value, err)
+	}
+	return ts, nil
+} // GetTime() func
+
+// GetItem parses the trailer name as an RFC 8941 item, such as 5;unit=bytes
+func (t Trailers) GetItem(name string) (Item, error) {
+	value, err := t.Get(name)
+	if err != nil {
+		return Item{}, err
+	}
+	item, err := ParseItem(value)
+	if err != nil {
+		return Item{}, malformedTrailerError(name, value, err)
+	}
+	return item, nil
+} // GetItem() func
+
+// GetCombined returns every field line of the trailer name joined into one value, as
+// CombineFieldValues does, for list-based fields that may be split over several lines
+func (t Trailers) GetCombined(name string) (string, error) {
+	values, _ := lookupField(http.Header(t), name)
+	if value := CombineFieldValues(values); value != "" {
+		return value, nil
+	}
+	return "", missingTrailerError([]string{name})
+} // GetCombined() func
+
+// GetList parses the trailer name, all its field lines combined, as an RFC 8941 list, such as
+// X-Body-Algorithms: sha-256, crc32c
+func (t Trailers) GetList(name string) ([]Item, error) {
+	value, err := t.GetCombined(name)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ParseList(value)
+	if err != nil {
+		return nil, malformedTrailerError(name, value, err)
+	}
+	return list, nil
+} // GetList() func
+
+// GetDictionary parses the trailer name, all its field lines combined, as an RFC 8941
+// dictionary, such as X-Body-Meta: len=5;alg="sha-256"
+func (t Trailers) GetDictionary(name string) (Dictionary, error) {
+	value, err := t.GetCombined(name)
+	if err != nil {
+		return nil, err
+	}
+	dict, err := ParseDictionary(value)
+	if err != nil {
+		return nil, malformedTrailerError(name, value, err)
+	}
+	return dict, nil
+} // GetDictionary() func
+
+// GetBool parses the trailer name as a boolean: an RFC 8941 ?1 or ?0, or any form strconv.ParseBool accepts
+func (t Trailers) GetBool(name string) (bool, error) {
+	value, err := t.Get(name)
+	if err != nil {
+		return false, err
+	}
+	switch value {
+	case "?1":
+		return true, nil
+	case "?0":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, malformedTrailerError(name, value, err)
+	}
+	return b, nil
+} // GetBool() func
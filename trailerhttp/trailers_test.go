@@ -0,0 +1,56 @@
+package trailerhttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrailersAccessors(t *testing.T) {
+	at := time.Date(2026, 10, 14, 15, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		get       func(Trailers, string) (any, error)
+		value     string
+		want      string // fmt.Sprint of the value got
+		malformed string
+	}{
+		{"Get", func(t Trailers, n string) (any, error) { return t.Get(n) }, " plain ", "plain", ""},
+		{"GetInt64", func(t Trailers, n string) (any, error) { return t.GetInt64(n) }, "12345", "12345", "12a"},
+		{"GetDigest", func(t Trailers, n string) (any, error) { return t.GetDigest(n) }, "deadbeef", "[222 173 190 239]", "not a digest!"},
+		{"GetTime HTTP-date", func(t Trailers, n string) (any, error) { return t.GetTime(n) }, at.Format(http.TimeFormat), at.String(), "yesterday"},
+		{"GetTime RFC 3339", func(t Trailers, n string) (any, error) { return t.GetTime(n) }, at.Format(time.RFC3339), at.String(), "2026-13-01T00:00:00Z"},
+		{"GetItem", func(t Trailers, n string) (any, error) {
+			item, err := t.GetItem(n)
+			return item.Value, err
+		}, "5;unit=bytes", "5", "5;"},
+		{"GetCombined", func(t Trailers, n string) (any, error) { return t.GetCombined(n) }, "a, b", "a, b", ""},
+		{"GetList", func(t Trailers, n string) (any, error) {
+			list, err := t.GetList(n)
+			return len(list), err
+		}, "sha-256, crc32c", "2", "sha-256,,"},
+		{"GetDictionary", func(t Trailers, n string) (any, error) {
+			dict, err := t.GetDictionary(n)
+			n64, _ := dict.Integer("len")
+			return n64, err
+		}, `len=5;alg="sha-256"`, "5", "len=="},
+		{"GetBool structured", func(t Trailers, n string) (any, error) { return t.GetBool(n) }, "?1", "true", "?2"},
+		{"GetBool plain", func(t Trailers, n string) (any, error) { return t.GetBool(n) }, "false", "false", "maybe"},
+	} {
+		got, err := tc.get(Trailers{"X-Field": {tc.value}}, "x-field")
+		if err != nil || fmt.Sprint(got) != tc.want {
+			t.Errorf("%s: present %q: got %v, %v; want %s", tc.name, tc.value, got, err, tc.want)
+		}
+		if _, err := tc.get(Trailers{"X-Other": {tc.value}}, "X-Field"); !errors.Is(err, ErrMissingTrailer) {
+			t.Errorf("%s: absent: error %v, want ErrMissingTrailer", tc.name, err)
+		}
+		if tc.malformed == "" {
+			continue // every value is valid
+		}
+		if got, err := tc.get(Trailers{"X-Field": {tc.malformed}}, "X-Field"); !errors.Is(err, ErrMalformedTrailer) {
+			t.Errorf("%s: malformed %q: got %v, %v; want ErrMalformedTrailer", tc.name, tc.malformed, got, err)
+		}
+	}
+}
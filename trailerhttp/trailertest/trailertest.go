@@ -0,0 +1,221 @@
+// Package trailertest provides utilities for testing code that sends or receives HTTP
+// trailers: a test server speaking HTTP/1.1 and HTTP/2, streamed requests carrying
+// trailers, a handler recording the trailers it received, and assertions on them.
+//
+//	srv := trailertest.NewServer(nil) // a trailerhttp.Handler with default options
+//	defer srv.Close()
+//	req := trailertest.NewRequest("POST", srv.URL, strings.NewReader("hello"),
+//		http.Header{"X-Body-Byte-Length": {"5"}})
+//	resp, err := srv.Client().Do(req)
+//	...
+//	trailertest.AssertTrailer(t, resp, "X-Received-X-Body-Byte-Length", "5")
+package trailertest
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"trailer_header/trailerhttp"
+)
+
+// NewServer starts an httptest.Server serving handler over HTTP/1.1, and over HTTP/2 in
+// cleartext (h2c) for clients such as trailerhttp.NewH2CClient. A nil handler means a
+// trailerhttp.Handler with the default ServerOptions. The caller must Close it.
+func NewServer(handler http.Handler) *httptest.Server {
+	if handler == nil {
+		handler = trailerhttp.NewHandler(trailerhttp.ServerOptions{})
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	return srv
+} // NewServer() func
+
+// NewRequest returns a client request streaming body with the given trailer fields: their
+// names are announced in the headers and their values sent after the body, as a real
+// streaming client would, with no io.Pipe to set up. The body is sent chunked over HTTP/1.1,
+// and the request accepts response trailers ("TE: trailers").
+// Like httptest.NewRequest, it panics on an invalid method or target.
+func NewRequest(method, target string, body io.Reader, trailer http.Header) *http.Request {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		panic("trailertest: invalid NewRequest arguments: " + err.Error())
+	}
+	req.Header.Set("TE", "trailers") // servers only send response trailers to clients accepting them
+	req.Trailer = make(http.Header, len(trailer))
+	for name := range trailer {
+		req.Trailer[http.CanonicalHeaderKey(name)] = nil
+	}
+	// Hiding the body's type keeps net/http from learning its length and sending it unchunked
+	req.Body = &trailerBody{r: body, req: req, trailer: trailer}
+	req.ContentLength = -1
+	return req
+} // NewRequest() func
+
+// trailerBody fills in the request's trailer values at EOF, before the transport reads them
+type trailerBody struct {
+	r       io.Reader
+	req     *http.Request
+	trailer http.Header
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		for name, values := range b.trailer {
+			b.req.Trailer[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return n, err
+}
+
+func (b *trailerBody) Close() error {
+	if c, ok := b.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Recording is what a request delivered to a server: its body, the trailer names it
+// announced in the Trailer header, and the trailer fields that arrived after the body
+type Recording struct {
+	Method    string
+	Body      []byte
+	Announced []string
+	Trailer   http.Header
+}
+
+// RecordTrailers reads r's body to the end, which is when net/http fills in r.Trailer,
+// and returns what the request delivered. Call it from a handler under test.
+func RecordTrailers(r *http.Request) (*Recording, error) {
+	// net/http moves the announced names from the Trailer header to the keys of r.Trailer
+	announced := slices.Collect(maps.Keys(r.Trailer))
+	for _, value := range r.Header.Values("Trailer") {
+		for name := range strings.SplitSeq(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && !slices.Contains(announced, name) {
+				announced = append(announced, name)
+			}
+		}
+	}
+	slices.Sort(announced)
+	body, err := io.ReadAll(r.Body)
+	return &Recording{Method: r.Method, Body: body, Announced: announced, Trailer: r.Trailer.Clone()}, err
+} // RecordTrailers() func
+
+// Recorder is an http.Handler recording every request it serves with RecordTrailers,
+// for testing what a client sends; it answers 200 OK, or 400 when a body failed to arrive.
+// Serve it with NewServer.
+type Recorder struct {
+	mu         sync.Mutex
+	recordings []*Recording
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recording, err := RecordTrailers(r)
+	rec.mu.Lock()
+	rec.recordings = append(rec.recordings, recording)
+	rec.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+} // ServeHTTP() func
+
+// Recordings returns the requests served so far, in order
+func (rec *Recorder) Recordings() []*Recording {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return slices.Clone(rec.recordings)
+} // Recordings() func
+
+// Last returns the request served last, or nil
+func (rec *Recorder) Last() *Recording {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.recordings) == 0 {
+		return nil
+	}
+	return rec.recordings[len(rec.recordings)-1]
+} // Last() func
+
+// ReadTrailers reads the rest of resp.Body, which is when net/http fills in resp.Trailer,
+// and returns the response trailers. Reading the body twice returns the same trailers.
+func ReadTrailers(resp *http.Response) (http.Header, error) {
+	_, err := io.Copy(io.Discard, resp.Body)
+	return resp.Trailer, err
+} // ReadTrailers() func
+
+// AssertTrailer reports a test error unless the response trailer field name has the value
+// want. It reads the rest of the response body to get the trailers, see ReadTrailers.
+func AssertTrailer(t testing.TB, resp *http.Response, name, want string) bool {
+	t.Helper()
+	trailer, err := ReadTrailers(resp)
+	if err != nil {
+		t.Errorf("reading the response body for trailer %s: %v", name, err)
+		return false
+	}
+	return assertField(t, "response", trailer, name, want)
+} // AssertTrailer() func
+
+// AssertNoTrailer reports a test error if the response carries the trailer field name
+func AssertNoTrailer(t testing.TB, resp *http.Response, name string) bool {
+	t.Helper()
+	trailer, err := ReadTrailers(resp)
+	if err != nil {
+		t.Errorf("reading the response body for trailer %s: %v", name, err)
+		return false
+	}
+	if values := trailer.Values(name); len(values) > 0 {
+		t.Errorf("response trailer %s = %q, want none", name, values)
+		return false
+	}
+	return true
+} // AssertNoTrailer() func
+
+// AssertRecordedTrailer reports a test error unless the recorded request delivered the
+// trailer field name with the value want
+func AssertRecordedTrailer(t testing.TB, rec *Recording, name, want string) bool {
+	t.Helper()
+	if rec == nil {
+		t.Errorf("request trailer %s: no request was recorded", name)
+		return false
+	}
+	return assertField(t, "request", rec.Trailer, name, want)
+} // AssertRecordedTrailer() func
+
+// AssertBody reports a test error unless the recorded request delivered the body want
+func AssertBody(t testing.TB, rec *Recording, want []byte) bool {
+	t.Helper()
+	if rec == nil {
+		t.Errorf("request body: no request was recorded")
+		return false
+	}
+	if !bytes.Equal(rec.Body, want) {
+		t.Errorf("request body = %q (%d bytes), want %q (%d bytes)", rec.Body, len(rec.Body), want, len(want))
+		return false
+	}
+	return true
+} // AssertBody() func
+
+// assertField checks one trailer field of a request or response
+func assertField(t testing.TB, side string, trailer http.Header, name, want string) bool {
+	t.Helper()
+	values := trailer.Values(name)
+	switch {
+	case len(values) == 0:
+		t.Errorf("%s trailer %s missing, want %q (delivered: %v)", side, name, want, slices.Sorted(maps.Keys(trailer)))
+		return false
+	case strings.Join(values, ", ") != want:
+		t.Errorf("%s trailer %s = %q, want %q", side, name, strings.Join(values, ", "), want)
+		return false
+	}
+	return true
+} // assertField() func
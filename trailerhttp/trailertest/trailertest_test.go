@@ -0,0 +1,59 @@
+package trailertest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"trailer_header/trailerhttp"
+)
+
+// recordingTB captures the errors an assertion reports, without failing the test
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Errorf(format string, args ...any) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func TestNewRequestAgainstNewServer(t *testing.T) {
+	srv := NewServer(nil)
+	defer srv.Close()
+	for _, hc := range []*http.Client{srv.Client(), trailerhttp.NewH2CClient()} {
+		req := NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"), http.Header{"X-Body-Byte-Length": {"5"}})
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertTrailer(t, resp, "X-Received-X-Body-Byte-Length", "5")
+		AssertNoTrailer(t, resp, "X-Received-X-Body-Sha256")
+		resp.Body.Close()
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	rec := new(Recorder)
+	srv := NewServer(rec)
+	defer srv.Close()
+	c := &trailerhttp.Client{Algorithms: []string{"length", "crc32"}}
+	c.Send(t.Context(), srv.URL, []byte("recorded"))
+	last := rec.Last()
+	AssertBody(t, last, []byte("recorded"))
+	AssertRecordedTrailer(t, last, "X-Body-Byte-Length", "8")
+	if len(rec.Recordings()) != 1 || strings.Join(last.Announced, ",") != "X-Body-Byte-Length,X-Body-Crc32" {
+		t.Errorf("%d recordings, announced %q; want one, announcing both trailers", len(rec.Recordings()), last.Announced)
+	}
+
+	tb := &recordingTB{TB: t}
+	if AssertRecordedTrailer(tb, last, "X-Body-Byte-Length", "9") || AssertRecordedTrailer(tb, last, "X-Missing", "1") || AssertBody(tb, nil, nil) {
+		t.Error("a failed assertion returned true")
+	}
+	if len(tb.errors) != 3 {
+		t.Errorf("reported %q, want an error for each failed assertion", tb.errors)
+	}
+}
@@ -0,0 +1,180 @@
+package trailerhttp
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Transport is an http.RoundTripper that adds integrity trailers to streamed request bodies,
+// those of unknown length, so any http.Client can send them without per-request plumbing:
+//
+//	client := &http.Client{Transport: &trailerhttp.Transport{Algorithms: []trailerhttp.TrailerAlgo{trailerhttp.AlgoSHA256}}}
+//
+// Requests with no body or a known ContentLength are sent unchanged, unless PrePass covers
+// them, as are requests that already declare a trailer the Transport would add. A request
+// with no body that declares trailers of its own, which net/http would drop, is sent with an
+// empty chunked body carrying them, along with the trailers of an empty body: length 0 and
+// the digests of no bytes. A request declaring a trailer field that RFC 9110 does not allow
+// in a trailer section fails with ErrForbiddenTrailer.
+type Transport struct {
+	Base       http.RoundTripper // nil = http.DefaultTransport
+	Algorithms []TrailerAlgo     // the length trailer is always included
+
+	// VerifyResponses asks for response trailers ("TE: trailers") and checks the integrity
+	// trailers of every response announcing some, as NewVerifiedResponse does: on a mismatch,
+	// or an announced trailer that never arrives, the final Read of resp.Body fails with a
+	// *VerificationError wrapping ErrHashMismatch, ErrLengthMismatch or ErrMissingTrailer
+	// instead of returning io.EOF, so io.ReadAll alone catches it. Unless the request sets
+	// Accept-Encoding itself, it also asks for a compressed response and decompresses it, as
+	// net/http would, checking the trailers against the decompressed body and the RFC 9530
+	// digests against the bytes as sent. Trailers of Algorithms renamed with TrailerAlgo.WithName
+	// are read from the responses under the same names.
+	VerifyResponses bool
+	HMACKey         []byte // shared secret for keyed response trailers such as X-Body-HMAC
+
+	// PrePass covers the requests whose body can be read again, those with a GetBody as
+	// http.NewRequest sets for a *bytes.Reader, *bytes.Buffer or *strings.Reader, known length
+	// or not: GetBody's copy is read once to compute the integrity fields, which go out as
+	// request headers, with a Content-Length, and the body is sent unchunked. Other streamed
+	// requests still get trailers, the choice being made per request.
+	PrePass bool
+}
+
+// RoundTrip sends req, computing the trailers over its body as the base transport reads it
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if err := validateTrailerNames(slices.Sorted(maps.Keys(req.Trailer))); err != nil {
+		if req.Body != nil {
+			req.Body.Close() // a RoundTripper must close the body, even on errors
+		}
+		return nil, err
+	}
+	// A RoundTripper must not modify the caller's request, so changes go to a copy
+	out := req
+	decode := t.VerifyResponses && req.Header.Get("Accept-Encoding") == ""
+	if t.VerifyResponses && (!acceptsTrailers(req) || decode) {
+		out = req.Clone(req.Context())
+		if !acceptsTrailers(req) {
+			out.Header.Add("TE", "trailers")
+		}
+		if decode {
+			out.Header.Set("Accept-Encoding", acceptEncoding())
+		}
+	}
+	if t.PrePass && t.prePasses(req) {
+		if out == req {
+			out = req.Clone(req.Context())
+		}
+		set, err := t.prePass(req)
+		if err != nil {
+			req.Body.Close()
+			return nil, err
+		}
+		set.setValues(out.Header)
+		out.ContentLength = set.n
+	} else if t.addsTrailers(req) {
+		if out == req {
+			out = req.Clone(req.Context())
+		}
+		set := newTrailerSet(t.Algorithms)
+		out.Trailer = req.Trailer.Clone()
+		body := req.Body
+		if body == nil {
+			body = http.NoBody
+		}
+		tr := &trailerReader{body: body, set: set}
+		out.Body = tr
+		set.announce(out)
+		tr.trailer = out.Trailer // announce makes it when req declares none
+	}
+	resp, err := base.RoundTrip(out)
+	if err != nil || !t.VerifyResponses || req.Method == http.MethodHead || resp.Body == http.NoBody {
+		return resp, err
+	}
+	if !decode {
+		if vb := verifyResponse(resp, t.HMACKey, newTrailerSet(t.Algorithms).renames()); vb.announces() {
+			resp.Body = vb
+		}
+		return resp, nil
+	}
+	vb, err := verifyDecodedResponse(resp, t.HMACKey, newTrailerSet(t.Algorithms).renames())
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if vb.wire != nil || vb.announces() {
+		if vb.wire != nil {
+			uncompressed(resp)
+		}
+		resp.Body = vb
+	}
+	return resp, nil
+} // RoundTrip() func
+
+// addsTrailers reports whether req is streamed and declares none of the trailers t adds
+func (t *Transport) addsTrailers(req *http.Request) bool {
+	if req.ContentLength > 0 || (req.Body == nil || req.Body == http.NoBody) && len(req.Trailer) == 0 {
+		return false
+	}
+	return !t.declares(req.Trailer)
+} // addsTrailers() func
+
+// prePasses reports whether req has a body GetBody can read again and declares none of the
+// integrity fields t adds, as headers or trailers
+func (t *Transport) prePasses(req *http.Request) bool {
+	if req.GetBody == nil || req.Body == nil || req.Body == http.NoBody {
+		return false
+	}
+	return !t.declares(req.Header) && !t.declares(req.Trailer)
+} // prePasses() func
+
+// declares reports whether fields holds one of the integrity fields t adds
+func (t *Transport) declares(fields http.Header) bool {
+	for _, v := range newTrailerSet(t.Algorithms).verifiers {
+		if _, declared := lookupField(fields, v.TrailerName); declared {
+			return true
+		}
+	}
+	return false
+} // declares() func
+
+// prePass reads a copy of the body of req from GetBody through a trailerSet for t's algorithms
+func (t *Transport) prePass(req *http.Request) (*trailerSet, error) {
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBodyStream, err)
+	}
+	defer body.Close()
+	set := newTrailerSet(t.Algorithms)
+	if _, err := io.Copy(set, contextReader{ctx: req.Context(), r: body}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBodyStream, err)
+	}
+	return set, nil
+} // prePass() func
+
+// trailerReader tees a request body into a trailerSet and sets the trailer values at EOF,
+// before the transport reads req.Trailer.
+type trailerReader struct {
+	body    io.ReadCloser
+	set     *trailerSet
+	trailer http.Header
+}
+
+func (tr *trailerReader) Read(p []byte) (int, error) {
+	n, err := tr.body.Read(p)
+	tr.set.Write(p[:n])
+	if err == io.EOF {
+		tr.set.setValues(tr.trailer)
+	}
+	return n, err
+}
+
+func (tr *trailerReader) Close() error {
+	return tr.body.Close()
+}
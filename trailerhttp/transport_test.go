@@ -0,0 +1,110 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingServer serves a Handler checking length and sha256, and records how each request
+// carried its integrity fields
+type recordingServer struct {
+	*httptest.Server
+	chunked  bool
+	trailer  string // the trailers the last request announced
+	shaField string // its X-Body-SHA256 header
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	rs := &recordingServer{}
+	h := NewHandler(ServerOptions{Algorithms: []string{"length", "sha256"}, Policy: PolicyStrict, Logger: discardLogger()})
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.chunked = len(r.TransferEncoding) > 0
+		rs.trailer, rs.shaField = strings.Join(AnnouncedTrailers(r), ","), r.Header.Get("X-Body-SHA256")
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+} // newRecordingServer() func
+
+func TestTransportPrePass(t *testing.T) {
+	rs := newRecordingServer(t)
+	for _, prePass := range []bool{false, true} {
+		hc := &http.Client{Transport: &Transport{Algorithms: []TrailerAlgo{AlgoSHA256}, PrePass: prePass}}
+		for _, body := range []io.Reader{strings.NewReader("hello world"), io.MultiReader(strings.NewReader("hello world"))} {
+			_, rewindable := body.(*strings.Reader)
+			req, _ := http.NewRequest(http.MethodPut, rs.URL, body)
+			resp, err := hc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result UploadResult
+			json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			inHeaders := prePass && rewindable
+			switch {
+			case inHeaders && (rs.chunked || rs.trailer != "" || rs.shaField == "" || !result.Matched):
+				t.Errorf("pre-passed request: chunked %v, Trailer %q, X-Body-SHA256 %q, matched %v; want digest headers that verify", rs.chunked, rs.trailer, rs.shaField, result.Matched)
+			case !inHeaders && !rewindable && (!rs.chunked || rs.trailer == "" || !result.Matched):
+				t.Errorf("PrePass %v, streamed request: chunked %v, Trailer %q, matched %v; want trailers that verify", prePass, rs.chunked, rs.trailer, result.Matched)
+			case !inHeaders && rewindable && (rs.trailer != "" || rs.shaField != ""):
+				t.Errorf("request of known length without PrePass: Trailer %q, X-Body-SHA256 %q; want it sent unchanged", rs.trailer, rs.shaField)
+			}
+		}
+	}
+}
+
+func TestTransportNoDeclaredTrailers(t *testing.T) {
+	rs := newRecordingServer(t)
+	hc := &http.Client{Transport: &Transport{Algorithms: []TrailerAlgo{AlgoSHA256}}}
+	req, _ := http.NewRequest(http.MethodPut, rs.URL, io.MultiReader(bytes.NewReader(make([]byte, 1<<16))))
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want the streamed body, declaring no trailer of its own, to verify", resp.StatusCode)
+	}
+}
+
+func TestTransportEmptyBody(t *testing.T) {
+	rs := newRecordingServer(t)
+	hc := &http.Client{Transport: &Transport{Algorithms: []TrailerAlgo{AlgoSHA256}}}
+	for name, body := range map[string]io.Reader{"empty stream": io.MultiReader(), "NoBody": http.NoBody, "nil": nil} {
+		req, _ := http.NewRequest(http.MethodGet, rs.URL, body)
+		if body == nil || body == http.NoBody {
+			req.Trailer = http.Header{"X-Note": {"empty"}} // trailers of its own, which net/http would drop
+		}
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result UploadResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if !rs.chunked || !strings.Contains(rs.trailer, "X-Body-Sha256") {
+			t.Errorf("%s: chunked %v, Trailer %q; want an empty chunked body with the trailers", name, rs.chunked, rs.trailer)
+		}
+		if !result.Matched || result.Outcome != "trailer-verified-ok" || result.ReportedLength == nil || *result.ReportedLength != 0 {
+			t.Errorf("%s: result %+v; want length 0 and the sha256 of no bytes verified", name, result)
+		}
+	}
+}
+
+func TestTransportNoBodyWithoutTrailers(t *testing.T) {
+	rs := newRecordingServer(t)
+	hc := &http.Client{Transport: &Transport{Algorithms: []TrailerAlgo{AlgoSHA256}}}
+	resp, err := hc.Get(rs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if rs.chunked || rs.trailer != "" {
+		t.Errorf("chunked %v, Trailer %q; want a GET with no body and no trailers sent unchanged", rs.chunked, rs.trailer)
+	}
+}
@@ -0,0 +1,145 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Tx holds the side effects of a Transactional handler, such as database writes or the rename
+// of a staged file, until the body of its request verified
+type Tx struct {
+	mu        sync.Mutex
+	commits   []func() error
+	rollbacks []func()
+}
+
+// txKey is the context key for the *Tx of a request
+type txKey struct{}
+
+// TxFromContext returns the Tx of a request wrapped by Transactional
+func TxFromContext(ctx context.Context) (*Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*Tx)
+	return tx, ok
+} // TxFromContext() func
+
+// OnVerified defers fn until the handler has returned and the body verified; the functions run
+// in the order they were deferred
+func (tx *Tx) OnVerified(fn func() error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.commits = append(tx.commits, fn)
+} // OnVerified() func
+
+// OnRollback defers fn until the upload is known not to commit: the body failed verification,
+// the handler answered with an error status, or an OnVerified function failed. The functions
+// run in the reverse order they were deferred, as deferred calls do.
+func (tx *Tx) OnRollback(fn func()) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.rollbacks = append(tx.rollbacks, fn)
+} // OnRollback() func
+
+// commit runs the OnVerified functions, stopping at the first that fails
+func (tx *Tx) commit() error {
+	tx.mu.Lock()
+	commits := slices.Clone(tx.commits)
+	tx.mu.Unlock()
+	for _, fn := range commits {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+} // commit() func
+
+// rollback runs the OnRollback functions, the last deferred first
+func (tx *Tx) rollback() {
+	tx.mu.Lock()
+	rollbacks := slices.Clone(tx.rollbacks)
+	tx.mu.Unlock()
+	for _, fn := range slices.Backward(rollbacks) {
+		fn()
+	}
+} // rollback() func
+
+// Transactional wraps next in Integrity with opts and gives it a Tx, through TxFromContext, to
+// defer its side effects until the trailers check out:
+//
+//	tx, _ := trailerhttp.TxFromContext(r.Context())
+//	tmp := stage(r.Body)
+//	tx.OnVerified(func() error { return os.Rename(tmp, final) })
+//	tx.OnRollback(func() { os.Remove(tmp) })
+//
+// Once next returns, the rest of the body is read and its trailers checked. Only if it verified
+// and next answered below 400 do the OnVerified functions run; otherwise, or if one of them
+// fails, the OnRollback functions do. The response of next is held in memory meanwhile and is
+// sent only once the upload committed, or as next wrote it with an error status; a body that
+// failed verification gets 400 Bad Request (401 for a bad body token) and one no integrity
+// trailer covered gets 400 with ErrUnverified, whatever next answered, and a failed commit
+// 500 Internal Server Error.
+func Transactional(next http.Handler, opts ...Option) http.Handler {
+	return Integrity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tx := &Tx{}
+		rec := &txResponse{header: http.Header{}}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), txKey{}, tx)))
+		vb, _ := VerificationFromContext(r.Context())
+		_, err := io.Copy(io.Discard, r.Body) // next need not read the body to its end
+		if err == nil && !vb.Verified() {
+			err = ErrUnverified
+		}
+		if err != nil {
+			tx.rollback()
+			rejectBuffered(w, err)
+			return
+		}
+		resp := rec.result()
+		if resp.Status >= http.StatusBadRequest {
+			tx.rollback()
+			resp.write(w)
+			return
+		}
+		if err := tx.commit(); err != nil {
+			tx.rollback()
+			http.Error(w, "could not commit the upload", http.StatusInternalServerError)
+			return
+		}
+		resp.write(w)
+	}), opts...)
+} // Transactional() func
+
+// txResponse holds the response of a Transactional handler until the upload is committed or not
+type txResponse struct {
+	header http.Header
+	status int
+	sent   http.Header // header as it was when the status was written
+	body   bytes.Buffer
+}
+
+func (rec *txResponse) Header() http.Header {
+	return rec.header
+}
+
+func (rec *txResponse) WriteHeader(status int) {
+	if rec.status == 0 && status >= http.StatusOK {
+		rec.status, rec.sent = status, rec.header.Clone()
+	}
+} // WriteHeader() func
+
+func (rec *txResponse) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.body.Write(p)
+}
+
+// result returns the response held, with the trailer values set after the body
+func (rec *txResponse) result() *CachedResponse {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK) // the handler wrote nothing
+	}
+	return &CachedResponse{Status: rec.status, Header: rec.sent, Body: rec.body.Bytes(), Trailer: recordedTrailers(rec.sent, rec.header)}
+} // result() func
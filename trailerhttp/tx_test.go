@@ -0,0 +1,50 @@
+package trailerhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestTransactional(t *testing.T) {
+	var mu sync.Mutex
+	var effects []string
+	record := func(effect string) {
+		mu.Lock()
+		defer mu.Unlock()
+		effects = append(effects, effect)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tx, _ := TxFromContext(r.Context())
+		tx.OnVerified(func() error { record("committed"); return nil })
+		tx.OnRollback(func() { record("rolled back") })
+		w.WriteHeader(http.StatusCreated)
+	})
+	srv := httptest.NewServer(Transactional(next))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name   string
+		length string
+		status int
+		effect string
+	}{
+		{"verified", "5", http.StatusCreated, "committed"},
+		{"length mismatch", "6", http.StatusBadRequest, "rolled back"},
+	} {
+		effects = nil
+		req := &RawRequest{Body: []byte("hello"), Trailer: []RawField{{"X-Body-Byte-Length", tc.length}}}
+		raw, err := SendRaw(t.Context(), srv.Listener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		mu.Lock()
+		got := slices.Clone(effects)
+		mu.Unlock()
+		if raw.Response.StatusCode != tc.status || !slices.Equal(got, []string{tc.effect}) {
+			t.Errorf("%s: status %d, side effects %v; want %d and only %q", tc.name, raw.Response.StatusCode, got, tc.status, tc.effect)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package trailerhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultFileAlgorithms are the trailers UploadFile sends when the Client names none
+var defaultFileAlgorithms = []string{"length", "sha256"}
+
+// UploadOption configures one Client.Upload or Client.UploadFile call
+type UploadOption func(*uploadConfig)
+
+// uploadConfig collects the UploadOptions of one Upload or UploadFile call
+type uploadConfig struct {
+	algorithms []string
+	trailers   []customTrailer
+	retry      *RetryPolicy
+	progress   func(sent, total int64)
+	chunkSize  int
+	flushEvery time.Duration
+}
+
+// customTrailer is a trailer field of WithTrailer, its value produced once the body has been sent
+type customTrailer struct {
+	name  string
+	value func() string
+}
+
+// WithAlgorithms sets the trailer algorithms of the upload, overriding Client.Algorithms
+func WithAlgorithms(algorithms ...string) UploadOption {
+	return func(cfg *uploadConfig) { cfg.algorithms = algorithms }
+} // WithAlgorithms() func
+
+// WithLengthTrailer adds the body length trailer to the upload, as WithAlgorithms("length") does;
+// the trailer options combine, and together override Client.Algorithms
+func WithLengthTrailer() UploadOption {
+	return func(cfg *uploadConfig) { cfg.addAlgorithm("length") }
+} // WithLengthTrailer() func
+
+// WithDigestTrailer adds the integrity trailer of a registered algorithm, such as "sha256",
+// "crc32c" or one of RegisterDigest, to the upload. The RFC 9530 names "sha-256" and "sha-512"
+// select the Content-Digest trailer, which carries both.
+func WithDigestTrailer(algorithm string) UploadOption {
+	switch strings.ToLower(algorithm) {
+	case "sha-256", "sha-512":
+		algorithm = "content-digest"
+	}
+	return func(cfg *uploadConfig) { cfg.addAlgorithm(algorithm) }
+} // WithDigestTrailer() func
+
+// addAlgorithm adds a trailer algorithm to the upload, once
+func (cfg *uploadConfig) addAlgorithm(algorithm string) {
+	if !slices.Contains(cfg.algorithms, algorithm) {
+		cfg.algorithms = append(cfg.algorithms, algorithm)
+	}
+} // addAlgorithm() func
+
+// WithTrailer adds a trailer field of any name to the upload, announced with the integrity
+// trailers; value is called once the body has been sent, so it can report what only the end of
+// the body tells, or return a constant. Client.TrailerOverride still replaces it.
+func WithTrailer(name string, value func() string) UploadOption {
+	return func(cfg *uploadConfig) { cfg.trailers = append(cfg.trailers, customTrailer{name: name, value: value}) }
+} // WithTrailer() func
+
+// WithRetry retries the upload as SendWithRetry does, re-reading the file from the start
+// for every attempt; without it the file is sent once
+func WithRetry(policy RetryPolicy) UploadOption {
+	return func(cfg *uploadConfig) { cfg.retry = &policy }
+} // WithRetry() func
+
+// WithUploadProgress calls report after every write with the body bytes sent so far and the
+// file size, or -1 for a Client.Upload body of unknown size, instead of Client.ProgressFunc
+func WithUploadProgress(report func(sent, total int64)) UploadOption {
+	return func(cfg *uploadConfig) { cfg.progress = report }
+} // WithUploadProgress() func
+
+// WithChunkSize sends the file in chunks of n bytes, overriding Client.ChunkSize
+func WithChunkSize(n int) UploadOption {
+	return func(cfg *uploadConfig) { cfg.chunkSize = n }
+} // WithChunkSize() func
+
+// FlushEvery passes on a partial chunk once it has waited d, overriding Client.FlushInterval
+func FlushEvery(d time.Duration) UploadOption {
+	return func(cfg *uploadConfig) { cfg.flushEvery = d }
+} // FlushEvery() func
+
+// errRetryNeedsSeeker reports WithRetry on a Client.Upload body that cannot be read again
+var errRetryNeedsSeeker = errors.New("trailerhttp: WithRetry needs an io.ReadSeeker body")
+
+// newUploadConfig applies opts
+func newUploadConfig(opts []UploadOption) *uploadConfig {
+	cfg := &uploadConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+} // newUploadConfig() func
+
+// client returns a copy of c configured for the upload, of size bytes or -1 if unknown
+func (cfg *uploadConfig) client(c *Client, size int64) *Client {
+	upload := *c
+	switch {
+	case cfg.algorithms != nil:
+		upload.Algorithms = cfg.algorithms
+	case len(upload.Algorithms) == 0:
+		upload.Algorithms = defaultFileAlgorithms
+	}
+	upload.customTrailers = append(slices.Clip(upload.customTrailers), cfg.trailers...)
+	if cfg.chunkSize != 0 {
+		upload.ChunkSize = cfg.chunkSize
+	}
+	if cfg.flushEvery != 0 {
+		upload.FlushInterval = cfg.flushEvery
+	}
+	if cfg.progress != nil {
+		upload.ProgressFunc = func(sent int64) { cfg.progress(sent, size) }
+	}
+	return &upload
+} // client() func
+
+// Upload streams src to url with the trailers the options declare, e.g.
+//
+//	client.Upload(ctx, url, src, trailerhttp.WithLengthTrailer(), trailerhttp.WithDigestTrailer("sha-256"),
+//		trailerhttp.WithTrailer("X-Upload-Source", func() string { return host }))
+//
+// announcing them all in the Trailer header; without trailer options it sends those of
+// Client.Algorithms, or length and SHA-256. WithRetry needs src to be an io.ReadSeeker.
+// As with SendStream, a rejected upload is not an error: check UploadResult.Matched.
+func (c *Client) Upload(ctx context.Context, url string, src io.Reader, opts ...UploadOption) (*UploadResult, error) {
+	cfg := newUploadConfig(opts)
+	size := int64(-1)
+	if seeker, ok := src.(io.Seeker); ok {
+		if end, err := seeker.Seek(0, io.SeekEnd); err == nil {
+			if start, err := seeker.Seek(0, io.SeekStart); err == nil {
+				size = end - start
+			}
+		}
+	}
+	upload := cfg.client(c, size)
+	if cfg.retry == nil {
+		return upload.SendStream(ctx, url, src)
+	}
+	body, ok := src.(io.ReadSeeker)
+	if !ok {
+		return nil, errRetryNeedsSeeker
+	}
+	return upload.SendSeekerWithRetry(ctx, url, body, *cfg.retry)
+} // Upload() func
+
+// UploadFile streams the file at path to url, reading it from disk as it is sent, with
+// length and SHA-256 trailers computed on the fly (or the algorithms of Client.Algorithms,
+// or those of the trailer options), and returns the server's verification result. Only the
+// part of the file present when the upload starts is sent, even if it grows meanwhile.
+// As with SendStream, a rejected upload is not an error: check UploadResult.Matched.
+func (c *Client) UploadFile(ctx context.Context, url, path string, opts ...UploadOption) (*UploadResult, error) {
+	cfg := newUploadConfig(opts)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("upload %s: not a regular file", path)
+	}
+	size := info.Size()
+
+	upload := cfg.client(c, size)
+	upload.debug(upload.logger(), "Uploading file", "path", path, "bytes", size, "algorithms", upload.Algorithms)
+
+	// A fresh section reader per attempt rewinds without seeking the shared file
+	newBody := func() io.Reader { return io.NewSectionReader(file, 0, size) }
+	if cfg.retry == nil {
+		return upload.SendStream(ctx, url, newBody())
+	}
+	return upload.SendWithRetry(ctx, url, newBody, *cfg.retry)
+} // UploadFile() func
@@ -0,0 +1,308 @@
+package trailerhttp
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// VerificationError reports every check a VerifiedBody failed. errors.Is sees through it
+// to ErrLengthMismatch, ErrHashMismatch, ErrMissingTrailer, ErrMalformedTrailer and ErrBadToken.
+type VerificationError struct {
+	Results []VerificationResult // all checks that ran, the matching ones included
+	Missing []string             // announced trailers that never arrived, integrity trailers or not
+	errs    []error
+}
+
+func (e *VerificationError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return "trailer verification failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *VerificationError) Unwrap() []error { return e.errs }
+
+// VerifiedBody wraps a request or response body and checks its announced integrity trailers
+// when the body reaches EOF, and that every announced trailer arrived. On failure the final Read
+// returns a *VerificationError instead of io.EOF, and so does Close, so a handler that just
+// reads the body to the end cannot miss it:
+//
+//	r.Body = trailerhttp.NewVerifiedBody(r, key)
+//	if _, err := io.Copy(dst, r.Body); err != nil { ... }
+type VerifiedBody struct {
+	trailer   *http.Header // the Trailer field of the request or response, filled in at EOF
+	announced []string     // the trailer names announced before the body was read
+	body      io.ReadCloser
+	verifiers []trailerVerifier
+	digests   []bodyDigest
+	n         int64
+	done      bool // EOF was reached and the trailers were checked
+	results   []VerificationResult
+	err       error                       // the *VerificationError, if a check failed
+	client    *x509.Certificate           // the verified TLS client certificate of the request, if any
+	wire      *wireTap                    // the compressed body a NewGzipVerifiedBody decodes; it feeds the Encoded digests
+	tokenKeys map[string]crypto.PublicKey // check the body token with these keys, under WithTokenKeys
+	nonces    *nonceConfig                // check and remember the body nonce, under WithNonces
+}
+
+// NewVerifiedBody wraps r.Body, computing a digest for every registered verifier whose
+// trailer r announced, and counting the records of an NDJSON or CSV body that announced
+// X-Record-Count. key is the shared secret for keyed verifiers such as hmac-sha256.
+// Call it before reading any of the body.
+func NewVerifiedBody(r *http.Request, key []byte) *VerifiedBody {
+	return verifyRequest(r, key, nil)
+} // NewVerifiedBody() func
+
+// verifyRequest is NewVerifiedBody with the trailer fields renamed per names, keyed by
+// algorithm name as in ServerOptions.TrailerNames
+func verifyRequest(r *http.Request, key []byte, names map[string]string) *VerifiedBody {
+	vb := newVerifiedBody(r.Body, r.Header, &r.Trailer, key, names)
+	vb.client = ClientCertificate(r)
+	return vb
+} // verifyRequest() func
+
+// NewGzipVerifiedBody wraps the gzip-encoded r.Body as NewVerifiedBody does, but reads it
+// decompressed: the trailers are checked against the uncompressed bytes, as NewGzipTrailerWriter
+// and Client.Gzip compute them, and the RFC 9530 digests against the bytes as sent.
+// It fails if the body does not start with a gzip header.
+func NewGzipVerifiedBody(r *http.Request, key []byte) (*VerifiedBody, error) {
+	vb := NewVerifiedBody(r, key)
+	if err := vb.decodeGzip(r.Body); err != nil {
+		return nil, err
+	}
+	return vb, nil
+} // NewGzipVerifiedBody() func
+
+// decodeGzip makes vb read the gzip-encoded request body decompressed, as NewGzipVerifiedBody does
+func (vb *VerifiedBody) decodeGzip(body io.ReadCloser) error {
+	gzipCoding, _ := lookupCoding("gzip")
+	if err := vb.decode(gzipCoding, body); err != nil {
+		return fmt.Errorf("invalid gzip request body: %w", err)
+	}
+	return nil
+} // decodeGzip() func
+
+// decode makes vb read body decompressed with coding, the bytes as sent feeding the Encoded
+// digests. Closing vb closes body, and releases the decoder.
+func (vb *VerifiedBody) decode(coding contentCoding, body io.ReadCloser) error {
+	vb.wire = &wireTap{r: body, vb: vb}
+	decoder, err := coding.newReader(vb.wire)
+	if err != nil {
+		return err
+	}
+	vb.body = &decodedBody{decoder: decoder, body: body}
+	return nil
+} // decode() func
+
+// decodedBody reads a compressed body through its decoder
+type decodedBody struct {
+	decoder io.ReadCloser
+	body    io.Closer
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) { return d.decoder.Read(p) }
+
+func (d *decodedBody) Close() error {
+	d.decoder.Close() // a corrupt stream already failed a Read
+	return d.body.Close()
+}
+
+// wireTap feeds the Encoded digests of vb the compressed bytes read from r
+type wireTap struct {
+	r  io.Reader
+	vb *VerifiedBody
+}
+
+func (t *wireTap) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	for i, d := range t.vb.digests {
+		if t.vb.verifiers[i].Encoded {
+			d.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+// NewVerifiedResponse wraps resp.Body the same way, checking the trailers the server announced.
+// Servers only send them to clients whose request carried "TE: trailers".
+func NewVerifiedResponse(resp *http.Response, key []byte) *VerifiedBody {
+	return verifyResponse(resp, key, nil)
+} // NewVerifiedResponse() func
+
+// verifyResponse is NewVerifiedResponse with the trailer fields renamed per names
+func verifyResponse(resp *http.Response, key []byte, names map[string]string) *VerifiedBody {
+	return newVerifiedBody(resp.Body, resp.Header, &resp.Trailer, key, names)
+} // verifyResponse() func
+
+func newVerifiedBody(body io.ReadCloser, header http.Header, trailer *http.Header, key []byte, names map[string]string) *VerifiedBody {
+	vb := &VerifiedBody{trailer: trailer, body: body, announced: slices.Sorted(maps.Keys(*trailer))}
+	verifiers := trailerVerifiers.all()
+	for i, v := range verifiers {
+		verifiers[i] = v.renamed(names)
+	}
+	if format, ok := recordFormat(header); ok {
+		verifiers = append(verifiers, recordVerifier(format))
+	}
+	for _, v := range verifiers {
+		if _, announced := lookupField(*trailer, v.TrailerName); announced {
+			vb.verifiers = append(vb.verifiers, v)
+			vb.digests = append(vb.digests, v.NewDigest(key))
+		}
+	}
+	return vb
+} // newVerifiedBody() func
+
+// Read reads from the body; at EOF it verifies the trailers, which net/http has populated by then
+func (vb *VerifiedBody) Read(p []byte) (int, error) {
+	if vb.done {
+		return 0, vb.eof()
+	}
+	n, err := vb.body.Read(p)
+	for i, d := range vb.digests {
+		if vb.wire == nil || !vb.verifiers[i].Encoded {
+			d.Write(p[:n])
+		}
+	}
+	vb.n += int64(n)
+	if err == io.EOF {
+		if vb.wire != nil { // a decoder may stop at the end of its stream, before the trailers
+			if _, err := io.Copy(io.Discard, vb.wire); err != nil {
+				return n, err
+			}
+		}
+		vb.verify()
+		return n, vb.eof()
+	}
+	if vb.wire != nil && isCorruptGzip(err) {
+		err = vb.diagnoseCorrupt(err)
+	}
+	return n, err
+} // Read() func
+
+// WriteTo copies the rest of the body into w through a pooled buffer, so io.Copy(dst, vb)
+// allocates nothing whatever the size of the body. It returns the verification error as the
+// final Read does, and nil once every check passed.
+func (vb *VerifiedBody) WriteTo(w io.Writer) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	var written int64
+	for {
+		n, err := vb.Read(*buf)
+		if n > 0 {
+			m, werr := w.Write((*buf)[:n])
+			written += int64(m)
+			if werr == nil && m < n {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+} // WriteTo() func
+
+// diagnoseCorrupt checks the bytes as sent of a gzip body that failed to decode with err,
+// to blame the transfer or the sender's encoder
+func (vb *VerifiedBody) diagnoseCorrupt(err error) error {
+	digests := make(map[string]bodyDigest)
+	for i, v := range vb.verifiers {
+		digests[v.TrailerName] = vb.digests[i]
+	}
+	vb.results = wireChecks(vb.wire, *vb.trailer, vb.verifiers, digests)
+	return corruptCodingError(err, vb.results)
+} // diagnoseCorrupt() func
+
+// eof returns the verification error, or io.EOF if every check passed
+func (vb *VerifiedBody) eof() error {
+	if vb.err != nil {
+		return vb.err
+	}
+	return io.EOF
+} // eof() func
+
+// verify runs the checks once the body is exhausted
+func (vb *VerifiedBody) verify() {
+	vb.done = true
+	var errs []error
+	missing := missingTrailers(vb.announced, *vb.trailer)
+	if len(missing) > 0 {
+		errs = append(errs, missingTrailerError(missing))
+	}
+	for i, v := range vb.verifiers {
+		if reported, ok := v.reported(*vb.trailer); ok {
+			vb.results = append(vb.results, v.verify(vb.digests[i], reported))
+		}
+	}
+	if vb.wire != nil {
+		diagnoseCoding(vb.results)
+	}
+	if vb.tokenKeys != nil {
+		result := checkBodyToken(*vb.trailer, vb.tokenKeys)
+		if result.Err == nil && !digestBound(vb.results) { // an unannounced Content-Digest is never checked
+			result.Matched, result.Err = false, fmt.Errorf("%w: the body does not verify against the Content-Digest it binds", ErrBadToken)
+		}
+		vb.results = append(vb.results, result)
+	}
+	for _, result := range vb.results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	if vb.nonces != nil { // last, so only a body that verified spends its nonce
+		result := checkNonce(*vb.trailer, vb.nonces.key, vb.nonces.store, vb.nonces.ttl, len(errs) == 0, digestBound(vb.results))
+		vb.results = append(vb.results, result)
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	if len(errs) > 0 {
+		vb.err = &VerificationError{Results: vb.results, Missing: missing, errs: errs}
+	}
+} // verify() func
+
+// Close closes the body. After EOF it returns the verification error, if any.
+func (vb *VerifiedBody) Close() error {
+	if err := vb.body.Close(); err != nil {
+		return err
+	}
+	return vb.err
+} // Close() func
+
+// Verified reports whether EOF was reached and every check of at least one passed
+func (vb *VerifiedBody) Verified() bool {
+	return vb.done && vb.err == nil && len(vb.results) > 0
+} // Verified() func
+
+// announces reports whether the body announced any trailer, and so has something to verify
+func (vb *VerifiedBody) announces() bool {
+	return len(vb.announced) > 0
+} // announces() func
+
+// Results returns the checks that ran; it is empty until the body reached EOF
+func (vb *VerifiedBody) Results() []VerificationResult {
+	return vb.results
+} // Results() func
+
+// BytesRead returns the number of body bytes read so far
+func (vb *VerifiedBody) BytesRead() int64 {
+	return vb.n
+} // BytesRead() func
+
+// ClientCertificate returns the verified TLS client certificate of the request, the uploader's
+// identity under mutual TLS, or nil; it is always nil for a response body
+func (vb *VerifiedBody) ClientCertificate() *x509.Certificate {
+	return vb.client
+} // ClientCertificate() func
@@ -0,0 +1,41 @@
+package trailerhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestVerifiedBodyErrors(t *testing.T) {
+	const sha256Hello = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	for _, tc := range []struct {
+		name    string
+		trailer http.Header
+		want    error // nil means the body verifies
+	}{
+		{"verified", http.Header{"X-Body-Byte-Length": {"5"}, "X-Body-Sha256": {sha256Hello}}, nil},
+		{"length mismatch", http.Header{"X-Body-Byte-Length": {"6"}}, ErrLengthMismatch},
+		{"hash mismatch", http.Header{"X-Body-Sha256": {sha256Hello[1:] + "0"}}, ErrHashMismatch},
+		{"missing trailer", http.Header{"X-Body-Byte-Length": {"5"}, "X-Body-Sha256": nil}, ErrMissingTrailer},
+		{"malformed trailer", http.Header{"X-Body-Byte-Length": {"five"}}, ErrMalformedTrailer},
+	} {
+		vb := NewVerifiedBody(chunkedRequest("hello", tc.trailer), nil)
+		_, err := io.ReadAll(vb)
+		if tc.want == nil {
+			if err != nil || !vb.Verified() {
+				t.Errorf("%s: error %v, verified %v; want the body verified", tc.name, err, vb.Verified())
+			}
+			continue
+		}
+		var verr *VerificationError
+		if !errors.Is(err, tc.want) || !errors.As(err, &verr) || !errors.Is(vb.Close(), tc.want) {
+			t.Errorf("%s: error %v, want a *VerificationError matching %v", tc.name, err, tc.want)
+		}
+		for _, other := range []error{ErrLengthMismatch, ErrHashMismatch, ErrMissingTrailer, ErrMalformedTrailer} {
+			if other != tc.want && errors.Is(err, other) {
+				t.Errorf("%s: error %v also matches %v", tc.name, err, other)
+			}
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// trailerHeaderName carries the body length, the one trailer sent by default
+const trailerHeaderName = "X-Body-Byte-Length"
+
+// bodyDigest accumulates a trailer value while the body streams through it
+type bodyDigest interface {
+	io.Writer
+	// Value returns the trailer value for the bytes written so far.
+	Value() string
+	// Matches reports whether a trailer value received from the peer agrees with Value.
+	// A non-nil error means the received value is malformed.
+	Matches(reported string) (bool, error)
+}
+
+// trailerVerifier is an integrity check whose client-computed value travels in a trailer field
+type trailerVerifier struct {
+	Algorithm   string                      // name reported in verification results, e.g. "crc32"
+	TrailerName string                      // trailer field carrying the value
+	Keyed       bool                        // the digest needs the shared secret; its computed value is never reported
+	Encoded     bool                        // the digest covers the body as sent, content coding applied, not the decoded bytes
+	Dictionary  bool                        // the value is an RFC 8941 dictionary, which may be split over several field lines
+	NewDigest   func(key []byte) bodyDigest // key is the shared secret; unkeyed digests ignore it
+}
+
+// verifierRegistry holds the supported checks. Verifiers may be registered while
+// requests are being served, so every access goes through mu.
+type verifierRegistry struct {
+	mu        sync.RWMutex
+	verifiers []trailerVerifier
+}
+
+// trailerVerifiers lists the supported checks. The client sends the ones it is asked for,
+// and the server verifies every one whose trailer the client announced.
+var trailerVerifiers = &verifierRegistry{verifiers: []trailerVerifier{
+	{Algorithm: "length", TrailerName: trailerHeaderName, NewDigest: func([]byte) bodyDigest { return new(lengthDigest) }},
+	{Algorithm: "char-count", TrailerName: CharCountTrailer, NewDigest: func([]byte) bodyDigest { return new(charCountDigest) }}, // see textcount.go
+	{Algorithm: "line-count", TrailerName: LineCountTrailer, NewDigest: func([]byte) bodyDigest { return new(lineCountDigest) }},
+	{Algorithm: "crc32", TrailerName: "X-Body-CRC32", NewDigest: func([]byte) bodyDigest { return &hashDigest{Hash: crc32.NewIEEE()} }},
+	{Algorithm: "crc32c", TrailerName: "X-Body-CRC32C", NewDigest: func([]byte) bodyDigest { return &hashDigest{Hash: crc32.New(crc32.MakeTable(crc32.Castagnoli))} }},
+	{Algorithm: "sha256", TrailerName: "X-Body-SHA256", NewDigest: func([]byte) bodyDigest { return &hashDigest{Hash: sha256.New()} }},
+	{Algorithm: "hmac-sha256", TrailerName: "X-Body-HMAC", Keyed: true, NewDigest: newHMACDigest},
+	{Algorithm: "content-digest", TrailerName: "Content-Digest", Encoded: true, Dictionary: true, NewDigest: newDigestFieldDigest}, // RFC 9530
+	{Algorithm: "repr-digest", TrailerName: "Repr-Digest", Encoded: true, Dictionary: true, NewDigest: newDigestFieldDigest},       // RFC 9530
+	{Algorithm: "merkle-sha256", TrailerName: "X-Body-Merkle-SHA256", Dictionary: true, NewDigest: func([]byte) bodyDigest { return newMerkleDigest(DefaultMerkleSegmentSize) }},
+	// S3 checksums, base64-encoded, as carried by aws-chunked bodies (see awschunked.go)
+	{Algorithm: "amz-crc32", TrailerName: "X-Amz-Checksum-Crc32", NewDigest: func([]byte) bodyDigest { return &base64Digest{Hash: crc32.NewIEEE()} }},
+	{Algorithm: "amz-crc32c", TrailerName: "X-Amz-Checksum-Crc32c", NewDigest: func([]byte) bodyDigest { return &base64Digest{Hash: crc32.New(crc32.MakeTable(crc32.Castagnoli))} }},
+	{Algorithm: "amz-sha1", TrailerName: "X-Amz-Checksum-Sha1", NewDigest: func([]byte) bodyDigest { return &base64Digest{Hash: sha1.New()} }},
+	{Algorithm: "amz-sha256", TrailerName: "X-Amz-Checksum-Sha256", NewDigest: func([]byte) bodyDigest { return &base64Digest{Hash: sha256.New()} }},
+}}
+
+// all returns a snapshot of the registered verifiers, in registration order
+func (reg *verifierRegistry) all() []trailerVerifier {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return slices.Clone(reg.verifiers)
+} // all() func
+
+// lookup returns the verifier for an algorithm name such as "sha256"
+func (reg *verifierRegistry) lookup(algorithm string) (trailerVerifier, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	for _, v := range reg.verifiers {
+		if v.Algorithm == algorithm {
+			return v, nil
+		}
+	}
+	return trailerVerifier{}, fmt.Errorf("unknown trailer algorithm %q", algorithm)
+} // lookup() func
+
+// register adds v, refusing a second verifier for the same algorithm or trailer field
+func (reg *verifierRegistry) register(v trailerVerifier) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, existing := range reg.verifiers {
+		if existing.Algorithm == v.Algorithm {
+			return fmt.Errorf("trailer algorithm %q is already registered", v.Algorithm)
+		}
+		if strings.EqualFold(existing.TrailerName, v.TrailerName) {
+			return fmt.Errorf("trailer field %q is already used by algorithm %q", v.TrailerName, existing.Algorithm)
+		}
+	}
+	reg.verifiers = append(reg.verifiers, v)
+	return nil
+} // register() func
+
+// RegisterTrailerVerifier adds a hash-based integrity check: the client sends the lowercase
+// hex sum of the body in trailerName, and the server checks every announced one.
+// It is safe to call while requests are being served; handlers created with nil
+// ServerOptions.Algorithms pick the new verifier up from their next request on.
+func RegisterTrailerVerifier(algorithm, trailerName string, newHash func() hash.Hash) error {
+	if algorithm == "" || trailerName == "" || newHash == nil {
+		return errors.New("trailer verifier needs an algorithm name, a trailer field and a hash")
+	}
+	if err := validateTrailerNames([]string{trailerName}); err != nil {
+		return err
+	}
+	return trailerVerifiers.register(trailerVerifier{
+		Algorithm:   algorithm,
+		TrailerName: http.CanonicalHeaderKey(trailerName),
+		NewDigest:   func([]byte) bodyDigest { return &hashDigest{Hash: newHash()} },
+	})
+} // RegisterTrailerVerifier() func
+
+// RegisterDigest plugs a checksum into the trailer pipeline under name, e.g. "xxh64",
+// carried in the trailer "X-Body-" + the upper-cased name. Client.Algorithms, Algo and the
+// server then accept name like a built-in algorithm; see RegisterTrailerVerifier.
+func RegisterDigest(name string, newHash func() hash.Hash) error {
+	return RegisterTrailerVerifier(name, "X-Body-"+strings.ToUpper(name), newHash)
+} // RegisterDigest() func
+
+// errNoHMACKey reports an HMAC trailer that cannot be computed or checked for lack of a shared secret
+var errNoHMACKey = errors.New("no HMAC key configured")
+
+// newHMACDigest returns an HMAC-SHA256 digest keyed with the shared secret
+func newHMACDigest(key []byte) bodyDigest {
+	if len(key) == 0 {
+		return unverifiableDigest{err: errNoHMACKey}
+	}
+	return &hmacDigest{hashDigest{Hash: hmac.New(sha256.New, key)}}
+} // newHMACDigest() func
+
+// lookupVerifier returns the verifier for an algorithm name such as "sha256"
+func lookupVerifier(algorithm string) (trailerVerifier, error) {
+	return trailerVerifiers.lookup(algorithm)
+} // lookupVerifier() func
+
+// Verification failures, for errors.Is on VerificationResult.Err, VerificationError and the
+// errors the Handler logs and reports; most come wrapped in a *TrailerError naming the field
+var (
+	ErrLengthMismatch     = errors.New("body length does not match trailer")
+	ErrHashMismatch       = errors.New("body digest does not match trailer")
+	ErrMissingTrailer     = errors.New("announced trailer was never sent")
+	ErrMalformedTrailer   = errors.New("malformed trailer value")
+	ErrForbiddenTrailer   = errors.New("not allowed in a trailer section")
+	ErrUnannouncedTrailer = errors.New("trailer was not announced in the Trailer header")
+	ErrTrailerTooLarge    = errors.New("trailer section exceeds the limit")
+	ErrTrailerTimeout     = errors.New("trailer section did not arrive in time")
+	ErrTrailersNotCarried = errors.New("request framing cannot carry trailers")
+
+	ErrTrailerMissing     = ErrMissingTrailer     // alias of ErrMissingTrailer
+	ErrTrailerUnannounced = ErrUnannouncedTrailer // alias of ErrUnannouncedTrailer
+	ErrDigestMismatch     = ErrHashMismatch       // alias of ErrHashMismatch
+)
+
+// TrailerError is the failure of a trailer field, with the values that disagree; errors.As
+// finds it in VerificationResult.Err and VerificationError, errors.Is its sentinel, such as
+// ErrDigestMismatch or ErrTrailerTooLarge
+type TrailerError struct {
+	Field    string // the trailer field, or a comma-separated list of those failing the same way
+	Expected string // what the check called for: the value the trailer carried, or a limit; may be empty
+	Actual   string // what it found: the value computed over the body, or a size; empty for keyed checks
+	Err      error  // the sentinel
+}
+
+func (e *TrailerError) Error() string {
+	msg := e.Err.Error() + ": " + e.Field
+	if e.Expected != "" && e.Actual != "" {
+		msg += fmt.Sprintf(" (expected %s, got %s)", e.Expected, e.Actual)
+	}
+	return msg
+}
+
+func (e *TrailerError) Unwrap() error { return e.Err }
+
+// VerificationResult records the outcome of one trailer check
+type VerificationResult struct {
+	Algorithm   string `json:"algorithm"`
+	TrailerName string `json:"trailer"`
+	Computed    string `json:"computed"` // value the server computed over the body it read
+	Reported    string `json:"reported"` // value the client sent in the trailer
+	Matched     bool   `json:"matched"`
+	Err         error  `json:"-"` // why the check did not match: a *TrailerError for ErrLengthMismatch or ErrDigestMismatch, ErrMalformedTrailer, ...
+}
+
+// digestBound reports whether results include a matching content-digest check: only then does
+// the Content-Digest trailer a body token, nonce or timestamp binds describe the body
+func digestBound(results []VerificationResult) bool {
+	return slices.ContainsFunc(results, func(r VerificationResult) bool { return r.Algorithm == "content-digest" && r.Matched })
+} // digestBound() func
+
+// reported returns the value of the trailer of v in trailer, and whether there is one: the first
+// field line, or for a dictionary all of them combined, as RFC 8941 parses a split field
+func (v trailerVerifier) reported(trailer http.Header) (string, bool) {
+	values, _ := lookupField(trailer, v.TrailerName)
+	switch {
+	case len(values) == 0:
+		return "", false
+	case v.Dictionary:
+		return CombineFieldValues(values), true
+	default:
+		return values[0], true
+	}
+} // reported() func
+
+// renamed returns v reading and writing the field names gives its algorithm, if any
+func (v trailerVerifier) renamed(names map[string]string) trailerVerifier {
+	if name, ok := names[v.Algorithm]; ok && name != "" {
+		v.TrailerName = http.CanonicalHeaderKey(name)
+	}
+	return v
+} // renamed() func
+
+// verify compares the digest computed over the body with the value reported in the trailer
+func (v trailerVerifier) verify(d bodyDigest, reported string) VerificationResult {
+	matched, err := d.Matches(reported)
+	result := VerificationResult{
+		Algorithm:   v.Algorithm,
+		TrailerName: v.TrailerName,
+		Computed:    d.Value(),
+		Reported:    reported,
+		Matched:     matched,
+	}
+	switch {
+	case errors.Is(err, errNoHMACKey):
+		result.Err = err
+	case err != nil:
+		result.Err = fmt.Errorf("%w in %s: %w", ErrMalformedTrailer, v.TrailerName, err)
+	case !matched:
+		mismatch := &TrailerError{Field: v.TrailerName, Expected: reported, Actual: result.Computed, Err: mismatchError(d)}
+		if v.Keyed {
+			mismatch.Actual = ""
+		}
+		result.Err = mismatch
+		if md, ok := d.(*merkleDigest); ok && md.mismatch != nil && len(md.mismatch.Segments) > 0 {
+			result.Err = fmt.Errorf("%w: %w", result.Err, md.mismatch)
+		}
+	}
+	if v.Keyed {
+		// Echoing the server's MAC would hand a forger the correct value for its body
+		result.Computed = "redacted"
+	}
+	return result
+} // verify() func
+
+// mismatchError returns the sentinel for a value d computed that disagrees with the trailer
+func mismatchError(d bodyDigest) error {
+	switch d.(type) {
+	case *lengthDigest, *charCountDigest, *lineCountDigest:
+		return ErrLengthMismatch
+	case *recordDigest:
+		return ErrRecordCountMismatch
+	}
+	return ErrHashMismatch
+} // mismatchError() func
+
+// lengthDigest counts body bytes; its trailer value is the decimal byte count
+type lengthDigest struct {
+	n int64
+}
+
+func (d *lengthDigest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return len(p), nil
+}
+
+func (d *lengthDigest) Value() string { return strconv.FormatInt(d.n, 10) }
+
+func (d *lengthDigest) Matches(reported string) (bool, error) {
+	reportedLength, err := strconv.ParseInt(reported, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return reportedLength == d.n, nil
+}
+
+// hashDigest wraps a hash.Hash; its trailer value is the sum, in lowercase hex unless encoding
+// says otherwise, and it accepts a reported sum in any DigestEncoding
+type hashDigest struct {
+	hash.Hash
+	encoding DigestEncoding
+}
+
+func (d *hashDigest) Value() string { return d.encoding.encode(d.Sum(nil)) }
+
+func (d *hashDigest) Matches(reported string) (bool, error) {
+	sum, err := decodeDigest(reported, d.Size())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sum, d.Sum(nil)), nil
+}
+
+// hmacDigest is a keyed hashDigest whose comparison runs in constant time
+type hmacDigest struct {
+	hashDigest
+}
+
+func (d *hmacDigest) Matches(reported string) (bool, error) {
+	reportedMAC, err := decodeDigest(reported, d.Size())
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(reportedMAC, d.Sum(nil)), nil
+}
+
+// unverifiableDigest stands in for a digest that cannot be computed, and fails every comparison
+type unverifiableDigest struct {
+	err error
+}
+
+func (d unverifiableDigest) Write(p []byte) (int, error)  { return len(p), nil }
+func (d unverifiableDigest) Value() string                { return "" }
+func (d unverifiableDigest) Matches(string) (bool, error) { return false, d.err }
+
+// TrailerAlgo selects an integrity trailer for ComputeTrailers
+type TrailerAlgo struct {
+	algorithm string
+	key       []byte
+	encoding  DigestEncoding
+	name      string // the trailer field, if not the algorithm's own
+}
+
+// The unkeyed integrity trailers
+var (
+	AlgoLength = TrailerAlgo{algorithm: "length"}
+	AlgoCRC32  = TrailerAlgo{algorithm: "crc32"}
+	AlgoCRC32C = TrailerAlgo{algorithm: "crc32c"}
+	AlgoSHA256 = TrailerAlgo{algorithm: "sha256"}
+
+	AlgoCharCount = TrailerAlgo{algorithm: "char-count"} // UTF-8 characters, in X-Body-Char-Count
+	AlgoLineCount = TrailerAlgo{algorithm: "line-count"} // lines, in X-Body-Line-Count
+
+	AlgoContentDigest = TrailerAlgo{algorithm: "content-digest"} // RFC 9530 Content-Digest with sha-256 and sha-512
+	AlgoReprDigest    = TrailerAlgo{algorithm: "repr-digest"}    // RFC 9530 Repr-Digest with sha-256 and sha-512
+	AlgoMerkleSHA256  = TrailerAlgo{algorithm: "merkle-sha256"}  // Merkle root and segment hashes, locating damage to a segment
+)
+
+// Algo selects an integrity trailer by algorithm name, including those added with RegisterDigest.
+// Names that are not registered when the trailers are computed are skipped.
+func Algo(name string) TrailerAlgo {
+	return TrailerAlgo{algorithm: name}
+} // Algo() func
+
+// Algorithms returns the names of the registered integrity trailers, in registration order
+func Algorithms() []string {
+	var names []string
+	for _, v := range trailerVerifiers.all() {
+		names = append(names, v.Algorithm)
+	}
+	return names
+} // Algorithms() func
+
+// AlgoHMACSHA256 selects the X-Body-HMAC trailer keyed with the shared secret
+func AlgoHMACSHA256(key []byte) TrailerAlgo {
+	return TrailerAlgo{algorithm: "hmac-sha256", key: key}
+} // AlgoHMACSHA256() func
+
+// WithEncoding returns the trailer of a writing its value in enc, e.g.
+// AlgoSHA256.WithEncoding(DigestBase64); it only changes the hex digests
+func (a TrailerAlgo) WithEncoding(enc DigestEncoding) TrailerAlgo {
+	a.encoding = enc
+	return a
+} // WithEncoding() func
+
+// WithName returns the trailer of a sent in the field name instead, e.g.
+// AlgoLength.WithName("X-Content-Length") for a receiver with the same ServerOptions.TrailerNames.
+// Renaming the length trailer replaces the one always included.
+func (a TrailerAlgo) WithName(name string) TrailerAlgo {
+	a.name = name
+	return a
+} // WithName() func
+
+// ComputeTrailers returns the trailers describing body, ready to copy into req.Trailer:
+// always the length trailer, plus one field per requested algorithm. The values are
+// produced by the same digests the server verifies with. An HMAC without a key is omitted.
+func ComputeTrailers(body []byte, algos ...TrailerAlgo) http.Header {
+	set := newTrailerSet(algos)
+	set.Write(body)
+	trailer := http.Header{}
+	set.setValues(trailer)
+	return trailer
+} // ComputeTrailers() func
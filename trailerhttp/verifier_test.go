@@ -0,0 +1,206 @@
+package trailerhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLengthBeyondInt32(t *testing.T) {
+	const n = math.MaxInt32 + 12345
+	d := &lengthDigest{n: n}
+	if d.Value() != strconv.FormatInt(n, 10) {
+		t.Fatalf("value %s, want %d", d.Value(), int64(n))
+	}
+	if ok, err := d.Matches(d.Value()); !ok || err != nil {
+		t.Errorf("Matches(%s) = %v, %v", d.Value(), ok, err)
+	}
+	if ok, _ := d.Matches(strconv.FormatInt(n-math.MaxUint32-1, 10)); ok {
+		t.Error("a length equal modulo 2^32 matched")
+	}
+
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	c := &Client{TrailerOverride: http.Header{trailerHeaderName: {strconv.FormatInt(n, 10)}}, Logger: discardLogger()}
+	result, err := c.SendStream(t.Context(), srv.URL, strings.NewReader("short body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ReportedLength == nil || *result.ReportedLength != n || result.Matched {
+		t.Errorf("reported length %v, matched %v; want %d, unmatched", result.ReportedLength, result.Matched, int64(n))
+	}
+}
+
+func TestCRC32AndSHA256(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	body := bytes.Repeat([]byte("the same body either way\n"), 4000)
+	want := map[string]string{"crc32": strconv.FormatUint(uint64(crc32.ChecksumIEEE(body)), 16)}
+	for _, algorithm := range []string{"crc32", "sha256"} {
+		c := &Client{Algorithms: []string{algorithm}, Logger: discardLogger()}
+		result, err := c.Send(t.Context(), srv.URL, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Matched || len(result.Checks) != 1 || result.Checks[0].Algorithm != algorithm {
+			t.Fatalf("%s: matched %v, checks %+v; want the one %s check, passed", algorithm, result.Matched, result.Checks, algorithm)
+		}
+		if w, ok := want[algorithm]; ok && !strings.EqualFold(strings.TrimLeft(result.Checks[0].Computed, "0"), w) {
+			t.Errorf("crc32 computed %s, want %s", result.Checks[0].Computed, w)
+		}
+
+		v, _ := lookupVerifier(algorithm)
+		c.TrailerOverride = http.Header{v.TrailerName: {"00000000"}}
+		result, err = c.Send(t.Context(), srv.URL, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Matched || len(result.Checks) != 1 || result.Checks[0].Algorithm != algorithm {
+			t.Errorf("%s with a wrong trailer: matched %v, checks %+v; want the %s check to fail", algorithm, result.Matched, result.Checks, algorithm)
+		}
+	}
+}
+
+func TestHMACTrailer(t *testing.T) {
+	h := NewHandler(ServerOptions{HMACKey: []byte("shared secret"), Logger: discardLogger()})
+	var tamper bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tamper { // replaces the first byte, as an attacker on the way would
+			io.CopyN(io.Discard, r.Body, 1)
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(strings.NewReader("X"), r.Body), r.Body}
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	for _, tc := range []struct {
+		name   string
+		key    string
+		tamper bool
+		want   bool
+	}{
+		{"valid", "shared secret", false, true},
+		{"tampered body", "shared secret", true, false},
+		{"wrong key", "another secret", false, false},
+	} {
+		tamper = tc.tamper
+		var logged bytes.Buffer
+		c := &Client{Algorithms: []string{"hmac-sha256"}, HMACKey: []byte(tc.key), Verbose: true, Logger: slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+		result, err := c.Send(t.Context(), srv.URL, []byte("xbody to authenticate"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Matched != tc.want || len(result.Checks) != 1 || result.Checks[0].Algorithm != "hmac-sha256" {
+			t.Errorf("%s: matched %v, checks %+v; want matched %v", tc.name, result.Matched, result.Checks, tc.want)
+		}
+		if strings.Contains(logged.String(), tc.key) {
+			t.Errorf("%s: the key was logged", tc.name)
+		}
+	}
+}
+
+func TestHandlerRequireHMAC(t *testing.T) {
+	for name, opts := range map[string]ServerOptions{
+		"no HMACKey":        {RequireHMAC: true},
+		"no keyed verifier": {RequireHMAC: true, HMACKey: []byte("shared secret"), Algorithms: []string{"length", "sha256"}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: NewHandler did not panic", name)
+				}
+			}()
+			NewHandler(opts)
+		}()
+	}
+
+	srv := httptest.NewServer(NewHandler(ServerOptions{RequireHMAC: true, HMACKey: []byte("shared secret"), Policy: PolicyStrict, Logger: discardLogger()}))
+	defer srv.Close()
+	for _, tc := range []struct {
+		name       string
+		algorithms []string
+		key        string
+		status     int
+	}{
+		{"valid", []string{"length", "hmac-sha256"}, "shared secret", http.StatusOK},
+		{"no HMAC trailer", []string{"length", "sha256"}, "", http.StatusUnauthorized},
+		{"wrong key", []string{"length", "hmac-sha256"}, "another secret", http.StatusUnprocessableEntity},
+	} {
+		c := &Client{Algorithms: tc.algorithms, HMACKey: []byte(tc.key), Logger: discardLogger()}
+		result, _ := c.Send(t.Context(), srv.URL, []byte("body to authenticate"))
+		if result == nil || result.StatusCode != tc.status {
+			t.Errorf("%s: result %+v, want status %d", tc.name, result, tc.status)
+		}
+	}
+}
+
+func TestComputeTrailers(t *testing.T) {
+	body := []byte("computed the way the server checks it\n")
+	key := []byte("shared secret")
+	trailer := ComputeTrailers(body, AlgoCRC32, AlgoCRC32C, AlgoSHA256, AlgoHMACSHA256(key), AlgoContentDigest, AlgoLineCount)
+	if len(trailer) != 7 || trailer.Get("X-Body-Byte-Length") != strconv.Itoa(len(body)) {
+		t.Fatalf("trailer %v, want the length and six more fields", trailer)
+	}
+	if keyless := ComputeTrailers(body, AlgoHMACSHA256(nil)); len(keyless) != 1 {
+		t.Errorf("trailer %v, want the keyless HMAC omitted", keyless)
+	}
+
+	h := NewHandler(ServerOptions{Algorithms: []string{"length", "crc32", "crc32c", "sha256", "hmac-sha256", "content-digest", "line-count"}, HMACKey: key, Logger: discardLogger()})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, chunkedRequest(string(body), trailer))
+	var result UploadResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if !result.Matched || len(result.Checks) != len(trailer) {
+		t.Errorf("matched %v, checks %+v; want every computed trailer verified", result.Matched, result.Checks)
+	}
+}
+
+// registerRuns numbers the runs of TestRegisterTrailerVerifierConcurrent, which cannot
+// register the same names twice under -count
+var registerRuns atomic.Int64
+
+func TestRegisterTrailerVerifierConcurrent(t *testing.T) {
+	prefix := "race" + strconv.FormatInt(registerRuns.Add(1), 10) + "-"
+	srv := httptest.NewServer(NewHandler(ServerOptions{Logger: discardLogger()}))
+	defer srv.Close()
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for i := range 50 {
+			if err := RegisterDigest(prefix+strconv.Itoa(i), sha256.New); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	for range 8 {
+		wg.Go(func() {
+			c := &Client{Algorithms: []string{"length", "sha256"}, Logger: discardLogger()}
+			for range 10 {
+				result, err := c.Send(t.Context(), srv.URL, []byte("read while the registry changes"))
+				if err != nil || !result.Matched {
+					t.Errorf("result %+v, %v", result, err)
+					return
+				}
+				Algorithms()
+				ComputeTrailers([]byte("x"), Algo(prefix+"0"))
+			}
+		})
+	}
+	wg.Wait()
+	c := &Client{Algorithms: []string{prefix + "49"}, Logger: discardLogger()}
+	if result, err := c.Send(t.Context(), srv.URL, []byte("registered")); err != nil || !result.Matched || result.Checks[0].Algorithm != prefix+"49" {
+		t.Errorf("result %+v, %v; want the last registered verifier used", result, err)
+	}
+}
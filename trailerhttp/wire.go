@@ -0,0 +1,336 @@
+package trailerhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WireCapture records the raw bytes of the connections it wraps, one CapturedConn per
+// connection, so the chunked framing and the trailer section can be inspected as they
+// travelled. Wrap a client's connections with Client or DialContext, or a server's with
+// Listener; WriteTo pretty-prints everything captured. It captures whatever goes over the
+// wrapped net.Conn: plain HTTP/1.1 reads as text, TLS and HTTP/2 do not.
+type WireCapture struct {
+	mu    sync.Mutex
+	conns []*CapturedConn
+}
+
+// CapturedConn holds the bytes one connection sent and received
+type CapturedConn struct {
+	Local, Remote string // addresses of the connection
+
+	mu             sync.Mutex
+	sent, received bytes.Buffer
+}
+
+// Sent returns a copy of the bytes written to the connection so far
+func (cc *CapturedConn) Sent() []byte {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return bytes.Clone(cc.sent.Bytes())
+} // Sent() func
+
+// Received returns a copy of the bytes read from the connection so far
+func (cc *CapturedConn) Received() []byte {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return bytes.Clone(cc.received.Bytes())
+} // Received() func
+
+// Conn returns conn, recording everything written to and read from it
+func (wc *WireCapture) Conn(conn net.Conn) net.Conn {
+	cc := &CapturedConn{Local: conn.LocalAddr().String(), Remote: conn.RemoteAddr().String()}
+	wc.mu.Lock()
+	wc.conns = append(wc.conns, cc)
+	wc.mu.Unlock()
+	return &capturingConn{Conn: conn, capture: cc}
+} // Conn() func
+
+// Conns returns the connections captured so far, in the order they were wrapped
+func (wc *WireCapture) Conns() []*CapturedConn {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return append([]*CapturedConn(nil), wc.conns...)
+} // Conns() func
+
+// Listener returns l, recording every connection it accepts, e.g. for Server.Serve
+func (wc *WireCapture) Listener(l net.Listener) net.Listener {
+	return &capturingListener{Listener: l, capture: wc}
+} // Listener() func
+
+// DialContext wraps dial, e.g. (&net.Dialer{}).DialContext, to record the connections it makes,
+// for use as http.Transport.DialContext
+func (wc *WireCapture) DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return wc.Conn(conn), nil
+	}
+} // DialContext() func
+
+// Client returns an HTTP/1.1 client recording its cleartext connections, for use as Client.HTTPClient
+func (wc *WireCapture) Client() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext:           wc.DialContext((&net.Dialer{}).DialContext),
+		ExpectContinueTimeout: time.Second,
+	}}
+} // Client() func
+
+// WriteTo pretty-prints every captured connection with FormatHTTP1: what was sent prefixed
+// with "> ", what was received with "< "
+func (wc *WireCapture) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	for _, cc := range wc.Conns() {
+		fmt.Fprintf(cw, "=== connection %s -> %s\n", cc.Local, cc.Remote)
+		if err := errors.Join(FormatHTTP1(cw, "> ", cc.Sent()), FormatHTTP1(cw, "< ", cc.Received())); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, cw.err
+} // WriteTo() func
+
+// countingWriter counts the bytes written through it and keeps the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// capturingConn records the bytes of a net.Conn into a CapturedConn
+type capturingConn struct {
+	net.Conn
+	capture *CapturedConn
+}
+
+func (c *capturingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.capture.mu.Lock()
+	c.capture.received.Write(p[:n])
+	c.capture.mu.Unlock()
+	return n, err
+}
+
+func (c *capturingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.capture.mu.Lock()
+	c.capture.sent.Write(p[:n])
+	c.capture.mu.Unlock()
+	return n, err
+}
+
+// capturingListener wraps the connections a net.Listener accepts
+type capturingListener struct {
+	net.Listener
+	capture *WireCapture
+}
+
+func (l *capturingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.capture.Conn(conn), nil
+}
+
+// wirePreviewBytes is how much of each chunk or body FormatHTTP1 shows
+const wirePreviewBytes = 64
+
+// credentialFields are the header and trailer fields the dumps of FormatHTTP1 and HARRecorder
+// show as [redacted], as their values would let whoever reads a capture act as the client
+var credentialFields = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", BodyTokenTrailer}
+
+// redactedField reports whether the value of the field name is left out of wire dumps: a
+// credential, or the trailer of a keyed check such as X-Body-HMAC, also as a Handler echoes it
+func redactedField(name string) bool {
+	if echoed, ok := cutPrefixFold(name, "X-Received-"); ok {
+		name = echoed
+	}
+	equal := func(other string) bool { return strings.EqualFold(other, name) }
+	return slices.ContainsFunc(credentialFields, equal) || slices.ContainsFunc(trailerVerifiers.all(), func(v trailerVerifier) bool {
+		return v.Keyed && equal(v.TrailerName)
+	})
+} // redactedField() func
+
+// FormatHTTP1 pretty-prints raw, the bytes one side of an HTTP/1.1 connection sent, to w,
+// every line starting with prefix: the start line and headers of each message, then its body
+// framing: every chunk with its size line, the zero-length last chunk, and the trailer section
+// that follows it. Chunk data is shown up to 64 bytes. A capture ending mid-message is reported
+// as cut off rather than as an error; an error means raw is not HTTP/1.1. The values of
+// credentials, such as Authorization and X-Body-Token, and of keyed trailers, such as
+// X-Body-HMAC, are shown as [redacted].
+func FormatHTTP1(w io.Writer, prefix string, raw []byte) error {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		}
+		if err := formatMessage(w, prefix, br); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				fmt.Fprintf(w, "%s[capture ends mid-message]\n", prefix)
+				return nil
+			}
+			return err
+		}
+	}
+} // FormatHTTP1() func
+
+// formatMessage prints one HTTP/1.1 message read from br
+func formatMessage(w io.Writer, prefix string, br *bufio.Reader) error {
+	startLine, err := readWireLine(br)
+	if err != nil {
+		return err
+	}
+	if startLine == "" {
+		return nil // a stray CRLF between messages
+	}
+	isResponse := strings.HasPrefix(startLine, "HTTP/")
+	if !isResponse && !strings.Contains(startLine, " HTTP/1.") {
+		return fmt.Errorf("not an HTTP/1.x message: %q", startLine)
+	}
+	fmt.Fprintf(w, "%s%s\n", prefix, startLine)
+	header, err := formatFields(w, prefix, br)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s\n", prefix)
+
+	status := 0
+	if isResponse {
+		if fields := strings.Fields(startLine); len(fields) > 1 {
+			status, _ = strconv.Atoi(fields[1])
+		}
+	}
+	switch {
+	case status/100 == 1 || status == http.StatusNoContent || status == http.StatusNotModified:
+		return nil
+	case strings.Contains(strings.ToLower(header.Get("Transfer-Encoding")), "chunked"):
+		return formatChunks(w, prefix, br)
+	case header.Get("Content-Length") != "":
+		n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+		}
+		return formatData(w, prefix, "[body]", br, n)
+	case isResponse:
+		data, err := io.ReadAll(br) // the body runs until the connection closes
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s[body until close] %d bytes %s\n", prefix, len(data), preview(data))
+	}
+	return nil
+} // formatMessage() func
+
+// formatFields prints header or trailer fields up to the empty line ending them, and returns them
+func formatFields(w io.Writer, prefix string, br *bufio.Reader) (http.Header, error) {
+	fields := http.Header{}
+	for {
+		line, err := readWireLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return fields, nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && redactedField(strings.TrimSpace(name)) {
+			line = name + ": [redacted]"
+		}
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+		if ok {
+			fields.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+} // formatFields() func
+
+// formatChunks prints a chunked body: its chunks, the last chunk and the trailer section
+func formatChunks(w io.Writer, prefix string, br *bufio.Reader) error {
+	for {
+		sizeLine, err := readWireLine(br)
+		if err != nil {
+			return err
+		}
+		// The quoted size line shows any chunk extension after the hex size
+		sizeHex, _, _ := strings.Cut(sizeLine, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+		if err != nil {
+			return fmt.Errorf("bad chunk size line %q", sizeLine)
+		}
+		if size == 0 {
+			fmt.Fprintf(w, "%s[last-chunk] %q: size 0\n", prefix, sizeLine)
+			break
+		}
+		if err := formatData(w, prefix, fmt.Sprintf("[chunk] %q:", sizeLine), br, size); err != nil {
+			return err
+		}
+		if crlf, err := readWireLine(br); err != nil || crlf != "" {
+			return errors.Join(err, fmt.Errorf("chunk data not followed by CRLF"))
+		}
+	}
+	fmt.Fprintf(w, "%s[trailer section]\n", prefix)
+	trailer, err := formatFields(w, prefix, br)
+	if err != nil {
+		return err
+	}
+	if len(trailer) == 0 {
+		fmt.Fprintf(w, "%s(no trailer fields)\n", prefix)
+	}
+	fmt.Fprintf(w, "%s[end of message]\n", prefix)
+	return nil
+} // formatChunks() func
+
+// formatData prints n bytes of body data read from br
+func formatData(w io.Writer, prefix, label string, br *bufio.Reader, n int64) error {
+	data, err := io.ReadAll(io.LimitReader(br, n))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < n {
+		return io.ErrUnexpectedEOF
+	}
+	fmt.Fprintf(w, "%s%s %d bytes %s\n", prefix, label, n, preview(data))
+	return nil
+} // formatData() func
+
+// preview quotes the start of data
+func preview(data []byte) string {
+	if len(data) <= wirePreviewBytes {
+		return strconv.Quote(string(data))
+	}
+	return fmt.Sprintf("%q... (%d more bytes)", data[:wirePreviewBytes], len(data)-wirePreviewBytes)
+} // preview() func
+
+// readWireLine reads one CRLF- (or LF-) terminated line without its terminator
+func readWireLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+} // readWireLine() func
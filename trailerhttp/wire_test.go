@@ -0,0 +1,69 @@
+package trailerhttp
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatHTTP1(t *testing.T) {
+	req := &RawRequest{
+		Target:    "/upload",
+		Header:    []RawField{{"Authorization", "Bearer secret-token"}},
+		Body:      []byte("hello, world"),
+		ChunkSize: 5,
+		Trailer: []RawField{
+			{"X-Body-Byte-Length", "12"},
+			{"X-Body-HMAC", "0f1e2d3c4b5a69788796a5b4c3d2e1f0"},
+			{BodyTokenTrailer, "eyJhbGciOiJFZERTQSJ9.e30.c2ln"},
+		},
+	}
+	var out strings.Builder
+	if err := FormatHTTP1(&out, "> ", req.Bytes("example.com")); err != nil {
+		t.Fatal(err)
+	}
+	const want = `> POST /upload HTTP/1.1
+> Host: example.com
+> Authorization: [redacted]
+> Transfer-Encoding: chunked
+> Trailer: X-Body-Byte-Length, X-Body-HMAC, X-Body-Token
+>
+> [chunk] "5": 5 bytes "hello"
+> [chunk] "5": 5 bytes ", wor"
+> [chunk] "2": 2 bytes "ld"
+> [last-chunk] "0": size 0
+> [trailer section]
+> X-Body-Byte-Length: 12
+> X-Body-HMAC: [redacted]
+> X-Body-Token: [redacted]
+> [end of message]
+`
+	if got := strings.ReplaceAll(out.String(), "> \n", ">\n"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWireCapture(t *testing.T) {
+	var capture WireCapture
+	srv := httptest.NewUnstartedServer(NewHandler(ServerOptions{HMACKey: []byte("key"), Logger: discardLogger()}))
+	srv.Listener = capture.Listener(srv.Listener)
+	srv.Start()
+	defer srv.Close()
+	c := &Client{Algorithms: []string{"length", "hmac-sha256"}, HMACKey: []byte("key"), Logger: discardLogger()}
+	if _, err := c.SendStream(t.Context(), srv.URL, strings.NewReader("captured")); err != nil {
+		t.Fatal(err)
+	}
+	srv.CloseClientConnections()
+
+	var out strings.Builder
+	capture.WriteTo(&out)
+	dump := out.String()
+	for _, want := range []string{"< POST / HTTP/1.1", "< [trailer section]", "< X-Body-Byte-Length: 8", "< X-Body-Hmac: [redacted]", "> HTTP/1.1 200 OK", "> X-Received-X-Body-Hmac: [redacted]"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump has no %q:\n%s", want, dump)
+		}
+	}
+	if hmac := ComputeTrailers([]byte("captured"), AlgoHMACSHA256([]byte("key"))).Get("X-Body-HMAC"); strings.Contains(dump, hmac) {
+		t.Errorf("dump shows the HMAC %s:\n%s", hmac, dump)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build zstd
+
+package trailerhttp
+
+import (
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// With the zstd tag, compressed responses prefer zstd to gzip, and clients decode it too
+func init() {
+	contentCodings = slices.Insert(contentCodings, 0, contentCoding{
+		name: "zstd",
+		newWriter: func(w io.Writer) contentEncoder {
+			enc, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1)) // fails only on bad options
+			return enc
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	})
+}